@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tsuru/go-gandalfclient"
 	"github.com/tsuru/tsuru/api/context"
@@ -27,6 +28,7 @@ import (
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/rec"
 	"github.com/tsuru/tsuru/repository"
+	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/service"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -68,7 +70,13 @@ func appDelete(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	context.SetPreventUnlock(r)
-	app.Delete(&a)
+	err = app.Delete(&a)
+	if err == app.ErrRuleTargetRemoval {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
 	fmt.Fprint(w, "success")
 	return nil
 }
@@ -131,6 +139,12 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 				Message: "In order to create an app, you should be member of at least one team",
 			}
 		}
+		if err == provision.ErrPoolNotFound {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("Pool %q not found.", a.Pool)}
+		}
+		if err == provision.ErrPoolNotAllowed {
+			return &errors.HTTP{Code: http.StatusForbidden, Message: fmt.Sprintf("You can't create apps in the pool %q.", a.Pool)}
+		}
 		if e, ok := err.(*app.AppCreationError); ok {
 			if e.Err == app.ErrAppAlreadyExists {
 				return &errors.HTTP{Code: http.StatusConflict, Message: e.Error()}
@@ -216,17 +230,18 @@ func addUnits(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
+	process := r.URL.Query().Get("process")
 	u, err := t.User()
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "add-units", "app="+appName, fmt.Sprintf("units=%d", n))
+	rec.Log(u.Email, "add-units", withProcess(process, "app="+appName, fmt.Sprintf("units=%d", n))...)
 	app, err := getApp(appName, u)
 	if err != nil {
 		return err
 	}
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(w)}
-	err = app.AddUnits(n, writer)
+	err = app.AddUnits(n, process, writer)
 	if err != nil {
 		writer.Encode(tsuruIo.SimpleJsonMessage{Error: err.Error()})
 		return nil
@@ -244,13 +259,14 @@ func removeUnits(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
-	rec.Log(u.Email, "remove-units", "app="+appName, fmt.Sprintf("units=%d", n))
+	process := r.URL.Query().Get("process")
+	rec.Log(u.Email, "remove-units", withProcess(process, "app="+appName, fmt.Sprintf("units=%d", n))...)
 	app, err := getApp(appName, u)
 	if err != nil {
 		return err
 	}
 	context.SetPreventUnlock(r)
-	return app.RemoveUnits(uint(n))
+	return processError(app.RemoveUnits(uint(n), process))
 }
 
 func setUnitStatus(w http.ResponseWriter, r *http.Request, t auth.Token) error {
@@ -606,6 +622,165 @@ func unsetCName(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	return err
 }
 
+func setCertificate(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var params struct {
+		CName       string
+		Certificate string
+		Key         string
+	}
+	if r.Body == nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the cname, the certificate and the key."}
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON in request body."}
+	}
+	if params.CName == "" || params.Certificate == "" || params.Key == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the cname, the certificate and the key."}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "set-certificate", "app="+appName, "cname="+params.CName)
+	a, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	err = a.SetCertificate(params.CName, params.Certificate, params.Key)
+	return certificateError(err)
+}
+
+func unsetCertificate(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	cname := r.URL.Query().Get("cname")
+	if cname == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the cname."}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "unset-certificate", "app="+appName, "cname="+cname)
+	a, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	err = a.RemoveCertificate(cname)
+	return certificateError(err)
+}
+
+func listCertificates(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "list-certificates", "app="+appName)
+	a, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	certificates, err := a.Certificates()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(certificates)
+}
+
+func certificateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(app.CertificateValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	switch err {
+	case app.ErrCertificateNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case router.ErrTLSNotSupported:
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	}
+	return err
+}
+
+func listRules(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "list-rules", "app="+appName)
+	a, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rules := a.Rules
+	if rules == nil {
+		rules = []router.Rule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(rules)
+}
+
+func addRule(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var rule router.Rule
+	if r.Body == nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the rule."}
+	}
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON in request body."}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "add-rule", "app="+appName, "name="+rule.Name, "type="+rule.Type)
+	a, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	return ruleError(a.AddRule(rule))
+}
+
+func removeRule(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	name := r.URL.Query().Get(":name")
+	rec.Log(u.Email, "remove-rule", "app="+appName, "name="+name)
+	a, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	return ruleError(a.RemoveRule(name))
+}
+
+func ruleError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch e := err.(type) {
+	case router.RuleValidationError:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Error()}
+	case *errors.ValidationError:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Error()}
+	}
+	switch err {
+	case app.ErrRuleNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case router.ErrRulesNotSupported, router.ErrRuleNotSupported:
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	}
+	return err
+}
+
 func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	var err error
 	var lines int
@@ -739,13 +914,45 @@ func restart(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
-	rec.Log(u.Email, "restart", appName)
+	process := r.URL.Query().Get("process")
+	rec.Log(u.Email, "restart", withProcess(process, appName)...)
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(w)}
+	err = instance.Restart(writer, process)
+	if err != nil {
+		writer.Encode(tsuruIo.SimpleJsonMessage{Error: err.Error()})
+		return err
+	}
+	return nil
+}
+
+func changeAppProvisioner(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	name := r.FormValue("provisioner")
+	if name == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Please provide the name of the provisioner."}
+	}
+	if _, err := provision.Get(name); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "app-change-provisioner", appName, name)
 	instance, err := getApp(appName, u)
 	if err != nil {
 		return err
 	}
+	w.Header().Set("Content-Type", "text")
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(w)}
-	err = instance.Restart(writer)
+	err = instance.ChangeProvisioner(name, writer)
+	if err == app.ErrSameProvisioner {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	if err != nil {
 		writer.Encode(tsuruIo.SimpleJsonMessage{Error: err.Error()})
 		return err
@@ -753,6 +960,55 @@ func restart(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	return nil
 }
 
+// defaultRouterGracePeriod is the number of seconds the previous router of
+// an app keeps answering its requests after the app is moved to another
+// router.
+const defaultRouterGracePeriod = 300
+
+func changeAppRouter(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	name := r.FormValue("router")
+	if name == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Please provide the name of the router."}
+	}
+	if _, err := router.Get(name); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	gracePeriod := defaultRouterGracePeriod
+	if value := r.FormValue("grace-period"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid grace period, it must be a number of seconds."}
+		}
+		gracePeriod = seconds
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	locked, err := app.AcquireApplicationLock(appName, t.GetUserName(), "/router")
+	if err != nil {
+		return err
+	}
+	defer app.ReleaseApplicationLock(appName)
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return &errors.HTTP{Code: http.StatusConflict, Message: fmt.Sprintf("%s: %s", instance.Name, &instance.Lock)}
+	}
+	rec.Log(u.Email, "app-change-router", appName, name)
+	err = instance.ChangeRouter(name, time.Duration(gracePeriod)*time.Second)
+	switch err {
+	case app.ErrSameRouter, app.ErrRuleTarget, app.ErrPathRules:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case router.ErrRulesNotSupported:
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	}
+	return err
+}
+
 func addLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	queryValues := r.URL.Query()
 	app, err := app.GetByName(queryValues.Get(":app"))
@@ -809,12 +1065,16 @@ func swap(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	if err != nil {
 		return err
 	}
-	defer app.ReleaseApplicationLock(app1Name)
+	if locked1 {
+		defer app.ReleaseApplicationLock(app1Name)
+	}
 	locked2, err := app.AcquireApplicationLock(app2Name, t.GetUserName(), "/swap")
 	if err != nil {
 		return err
 	}
-	defer app.ReleaseApplicationLock(app2Name)
+	if locked2 {
+		defer app.ReleaseApplicationLock(app2Name)
+	}
 	app1, err := getApp(app1Name, u)
 	if err != nil {
 		return err
@@ -845,7 +1105,57 @@ func swap(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		}
 	}
 	rec.Log(u.Email, "swap", app1Name, app2Name)
-	return app.Swap(&app1, &app2)
+	err = app.Swap(&app1, &app2, u.Email, forceSwap == "true")
+	if _, ok := err.(app.SwapValidationError); ok {
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	}
+	return err
+}
+
+func swapLast(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	evt, err := app.LastSwap()
+	if err == app.ErrNoSwap {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(evt)
+}
+
+func swapUndo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	evt, err := app.LastSwap()
+	if err == app.ErrNoSwap {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	for _, appName := range []string{evt.App1, evt.App2} {
+		locked, err := app.AcquireApplicationLock(appName, t.GetUserName(), "/swap/undo")
+		if err != nil {
+			return err
+		}
+		if !locked {
+			a, err := app.GetByName(appName)
+			if err != nil {
+				return err
+			}
+			return &errors.HTTP{Code: http.StatusConflict, Message: fmt.Sprintf("%s: %s", a.Name, &a.Lock)}
+		}
+		defer app.ReleaseApplicationLock(appName)
+	}
+	rec.Log(u.Email, "swap-undo", evt.App1, evt.App2)
+	err = app.UndoSwap(evt, u.Email)
+	if err == app.ErrSwapAlreadyUndone {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
 }
 
 func start(w http.ResponseWriter, r *http.Request, t auth.Token) error {
@@ -855,12 +1165,13 @@ func start(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
-	rec.Log(u.Email, "start", appName)
+	process := r.URL.Query().Get("process")
+	rec.Log(u.Email, "start", withProcess(process, appName)...)
 	app, err := getApp(appName, u)
 	if err != nil {
 		return err
 	}
-	return app.Start(w)
+	return processError(app.Start(w, process))
 }
 
 func stop(w http.ResponseWriter, r *http.Request, t auth.Token) error {
@@ -870,12 +1181,31 @@ func stop(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
-	rec.Log(u.Email, "stop", appName)
+	process := r.URL.Query().Get("process")
+	rec.Log(u.Email, "stop", withProcess(process, appName)...)
 	app, err := getApp(appName, u)
 	if err != nil {
 		return err
 	}
-	return app.Stop(w)
+	return processError(app.Stop(w, process))
+}
+
+// withProcess appends the given process to the arguments of an action, if
+// the process is not empty.
+func withProcess(process string, args ...interface{}) []interface{} {
+	if process != "" {
+		args = append(args, "process="+process)
+	}
+	return args
+}
+
+// processError converts errors caused by invalid process names into bad
+// request errors.
+func processError(err error) error {
+	if provision.IsProcessError(err) {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
 }
 
 func forceDeleteLock(w http.ResponseWriter, r *http.Request, t auth.Token) error {
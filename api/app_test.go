@@ -29,6 +29,9 @@ import (
 	"github.com/tsuru/tsuru/queue"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
+	"github.com/tsuru/tsuru/router"
+	_ "github.com/tsuru/tsuru/router/proxy"
+	rtesting "github.com/tsuru/tsuru/router/testing"
 	"github.com/tsuru/tsuru/service"
 	"github.com/tsuru/tsuru/testing"
 	"gopkg.in/mgo.v2/bson"
@@ -65,7 +68,7 @@ func (s *S) TestAppIsAvailableHandlerShouldReturn200WhenAppUnitStatusIsStarted(c
 	defer s.conn.Logs(a.Name).DropCollection()
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
 	request, err := http.NewRequest("GET", url, nil)
 	c.Assert(err, gocheck.IsNil)
@@ -215,6 +218,31 @@ func (s *S) TestDeleteShouldReturnForbiddenIfTheGivenUserDoesNotHaveAccesToTheAp
 	c.Assert(e, gocheck.ErrorMatches, "^User does not have access to this app$")
 }
 
+func (s *S) TestDeleteRuleTarget(c *gocheck.C) {
+	other := app.App{
+		Name:  "myapp",
+		Rules: []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}},
+	}
+	err := s.conn.Apps().Insert(other)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": other.Name})
+	myApp := app.App{Name: "myapp-api", Platform: "zend", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(myApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": myApp.Name})
+	request, err := http.NewRequest("DELETE", "/apps/"+myApp.Name+"?:app="+myApp.Name, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appDelete(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, app.ErrRuleTargetRemoval.Error())
+	_, err = app.GetByName(myApp.Name)
+	c.Assert(err, gocheck.IsNil)
+}
+
 func (s *S) TestDeleteShouldReturnNotFoundIfTheAppDoesNotExist(c *gocheck.C) {
 	request, err := http.NewRequest("DELETE", "/apps/unkown?:app=unknown", nil)
 	c.Assert(err, gocheck.IsNil)
@@ -496,6 +524,39 @@ func (s *S) TestCreateAppInvalidName(c *gocheck.C) {
 	c.Assert(e.Error(), gocheck.Equals, msg)
 }
 
+func (s *S) TestCreateAppUnknownPool(c *gocheck.C) {
+	b := strings.NewReader(`{"name":"someapp","platform":"zend","pool":"pool1"}`)
+	request, err := http.NewRequest("POST", "/apps", b)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	err = createApp(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `Pool "pool1" not found.`)
+	_, err = app.GetByName("someapp")
+	c.Assert(err, gocheck.Equals, app.ErrAppNotFound)
+}
+
+func (s *S) TestCreateAppPoolOfOtherTeam(c *gocheck.C) {
+	s.provisioner.AddPool("pool1", "otherteam")
+	b := strings.NewReader(`{"name":"someapp","platform":"zend","pool":"pool1"}`)
+	request, err := http.NewRequest("POST", "/apps", b)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	err = createApp(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	c.Assert(e.Message, gocheck.Equals, `You can't create apps in the pool "pool1".`)
+	_, err = app.GetByName("someapp")
+	c.Assert(err, gocheck.Equals, app.ErrAppNotFound)
+}
+
 func (s *S) TestCreateAppReturns400IfTheUserIsNotMemberOfAnyTeam(c *gocheck.C) {
 	u := &auth.User{Email: "thetrees@rush.com", Password: "123456"}
 	_, err := nativeScheme.Create(u)
@@ -570,6 +631,40 @@ func (s *S) TestAddUnits(c *gocheck.C) {
 	c.Assert(recorder.Body.String(), gocheck.Equals, `{"Message":"added 3 units"}`+"\n")
 }
 
+func (s *S) TestAddUnitsProcess(c *gocheck.C) {
+	a := app.App{
+		Name:      "armorandsword",
+		Platform:  "python",
+		Teams:     []string{s.team.Name},
+		Quota:     quota.Unlimited,
+		Processes: map[string]string{"web": "python app.py", "worker": "python worker.py"},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs(a.Name).DropCollection()
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&a)
+	body := strings.NewReader("2")
+	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:app=armorandsword&process=worker", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addUnits(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	units := s.provisioner.GetUnits(&a)
+	c.Assert(units, gocheck.HasLen, 2)
+	for _, u := range units {
+		c.Assert(u.ProcessName, gocheck.Equals, "worker")
+	}
+	action := testing.Action{
+		Action: "add-units",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=armorandsword", "units=2", "process=worker"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
 func (s *S) TestAddUnitsReturns404IfAppDoesNotExist(c *gocheck.C) {
 	body := strings.NewReader("1")
 	request, err := http.NewRequest("PUT", "/apps/armorandsword/units?:app=armorandsword", body)
@@ -670,7 +765,7 @@ func (s *S) TestRemoveUnits(c *gocheck.C) {
 	err = s.provisioner.Provision(&a)
 	c.Assert(err, gocheck.IsNil)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 3, nil)
+	s.provisioner.AddUnits(&a, 3, "", nil)
 	body := strings.NewReader("2")
 	request, err := http.NewRequest("DELETE", "/apps/velha/units?:app=velha", body)
 	c.Assert(err, gocheck.IsNil)
@@ -690,6 +785,32 @@ func (s *S) TestRemoveUnits(c *gocheck.C) {
 	c.Assert(action, testing.IsRecorded)
 }
 
+func (s *S) TestRemoveUnitsInvalidProcess(c *gocheck.C) {
+	a := app.App{
+		Name:     "velha",
+		Platform: "python",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 3, "", nil)
+	body := strings.NewReader("1")
+	request, err := http.NewRequest("DELETE", "/apps/velha/units?:app=velha&process=worker", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removeUnits(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `process "worker" is not declared in the Procfile of the app`)
+	c.Assert(s.provisioner.GetUnits(&a), gocheck.HasLen, 3)
+}
+
 func (s *S) TestRemoveUnitsReturns404IfAppDoesNotExist(c *gocheck.C) {
 	body := strings.NewReader("1")
 	request, err := http.NewRequest("DELETE", "/apps/fetisha/units?:app=fetisha", body)
@@ -768,7 +889,7 @@ func (s *S) TestSetUnitStatus(c *gocheck.C) {
 	err = s.provisioner.Provision(&a)
 	c.Assert(err, gocheck.IsNil)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 3, nil)
+	s.provisioner.AddUnits(&a, 3, "", nil)
 	body := strings.NewReader("status=error")
 	unit := a.Units()[0]
 	request, err := http.NewRequest("POST", "/apps/telegram/units/<unit-name>?:app=telegram&:unit="+unit.Name, body)
@@ -841,7 +962,7 @@ func (s *S) TestSetUnitStatusDoesntRequireLock(c *gocheck.C) {
 	err = s.provisioner.Provision(&a)
 	c.Assert(err, gocheck.IsNil)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	unit := a.Units()[0]
 	body := strings.NewReader("status=error")
 	request, err := http.NewRequest("POST", "/apps/telegram/units/"+unit.Name, body)
@@ -1270,7 +1391,7 @@ func (s *S) TestRunOnceHandler(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	defer s.conn.Logs(a.Name).DropCollection()
 	url := fmt.Sprintf("/apps/%s/run/?:app=%s&once=true", a.Name, a.Name)
@@ -1305,7 +1426,7 @@ func (s *S) TestRunHandler(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	defer s.conn.Logs(a.Name).DropCollection()
 	url := fmt.Sprintf("/apps/%s/run/?:app=%s", a.Name, a.Name)
@@ -1343,7 +1464,7 @@ func (s *S) TestRunHandlerReturnsTheOutputOfTheCommandEvenIfItFails(c *gocheck.C
 	defer s.conn.Logs(a.Name).DropCollection()
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	url := fmt.Sprintf("/apps/%s/run/?:app=%s", a.Name, a.Name)
 	request, err := http.NewRequest("POST", url, strings.NewReader("ls"))
 	c.Assert(err, gocheck.IsNil)
@@ -2111,6 +2232,254 @@ func (s *S) TestRemoveCNameHandler(c *gocheck.C) {
 	c.Assert(action, testing.IsRecorded)
 }
 
+func (s *S) certificateRequestBody(c *gocheck.C, cname string) io.Reader {
+	certificate, err := ioutil.ReadFile("testdata/cert.pem")
+	c.Assert(err, gocheck.IsNil)
+	key, err := ioutil.ReadFile("testdata/key.pem")
+	c.Assert(err, gocheck.IsNil)
+	body, err := json.Marshal(map[string]string{
+		"cname":       cname,
+		"certificate": string(certificate),
+		"key":         string(key),
+	})
+	c.Assert(err, gocheck.IsNil)
+	return strings.NewReader(string(body))
+}
+
+func (s *S) TestSetCertificateHandler(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, CName: []string{"app.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Certificates().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/certificate?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, s.certificateRequestBody(c, "app.example.com"))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setCertificate(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Certificate(&a, "app.example.com"), gocheck.Matches, "(?s)-----BEGIN CERTIFICATE-----.*")
+	action := testing.Action{
+		Action: "set-certificate",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "cname=app.example.com"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestSetCertificateHandlerCNameNotInTheApp(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/certificate?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, s.certificateRequestBody(c, "app.example.com"))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setCertificate(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `invalid certificate: "app.example.com" is not a cname of the app "leper"`)
+}
+
+func (s *S) TestSetCertificateHandlerMissingKey(c *gocheck.C) {
+	b := strings.NewReader(`{"cname": "app.example.com", "certificate": "cert"}`)
+	request, err := http.NewRequest("PUT", "/apps/leper/certificate?:app=leper", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setCertificate(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "You must provide the cname, the certificate and the key.")
+}
+
+func (s *S) TestUnsetCertificateHandler(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, CName: []string{"app.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Certificates().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = s.provisioner.SetCertificate(&a, "app.example.com", "cert", "key")
+	c.Assert(err, gocheck.IsNil)
+	err = s.conn.Certificates().Insert(app.Certificate{CName: "app.example.com", App: a.Name, Certificate: "cert"})
+	c.Assert(err, gocheck.IsNil)
+	url := fmt.Sprintf("/apps/%s/certificate?:app=%s&cname=app.example.com", a.Name, a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = unsetCertificate(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Certificate(&a, "app.example.com"), gocheck.Equals, "")
+	count, err := s.conn.Certificates().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 0)
+	action := testing.Action{
+		Action: "unset-certificate",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "cname=app.example.com"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestUnsetCertificateHandlerNotFound(c *gocheck.C) {
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, CName: []string{"app.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/certificate?:app=%s&cname=app.example.com", a.Name, a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = unsetCertificate(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListCertificatesHandler(c *gocheck.C) {
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, CName: []string{"app.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Certificates().RemoveAll(bson.M{"app": a.Name})
+	err = s.conn.Certificates().Insert(app.Certificate{
+		CName:       "app.example.com",
+		App:         a.Name,
+		Certificate: "cert",
+		Key:         []byte("encrypted key"),
+	})
+	c.Assert(err, gocheck.IsNil)
+	url := fmt.Sprintf("/apps/%s/certificate?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listCertificates(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var certificates []map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &certificates)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificates, gocheck.HasLen, 1)
+	c.Assert(certificates[0]["CName"], gocheck.Equals, "app.example.com")
+	c.Assert(certificates[0]["Certificate"], gocheck.Equals, "cert")
+	_, ok := certificates[0]["Key"]
+	c.Assert(ok, gocheck.Equals, false)
+}
+
+func (s *S) TestAddRuleHandler(c *gocheck.C) {
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, Plan: app.Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	rtesting.FakeRouter.Reset()
+	err = rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	defer router.Remove(a.Name)
+	url := fmt.Sprintf("/apps/%s/rules?:app=%s", a.Name, a.Name)
+	body := strings.NewReader(`{"name":"https","type":"redirect","redirectscheme":"https"}`)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addRule(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	expected := []router.Rule{{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}}
+	c.Assert(rtesting.FakeRouter.Rules(a.Name), gocheck.DeepEquals, expected)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Rules, gocheck.DeepEquals, expected)
+	action := testing.Action{
+		Action: "add-rule",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "name=https", "type=redirect"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestAddRuleHandlerInvalidRule(c *gocheck.C) {
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, Plan: app.Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	var tests = []string{
+		`{"name":"api","type":"path","path":"/api"}`,
+		`{"name":"api","type":"path","path":"/api","app":"unknown"}`,
+		`invalid json`,
+	}
+	for _, t := range tests {
+		url := fmt.Sprintf("/apps/%s/rules?:app=%s", a.Name, a.Name)
+		request, err := http.NewRequest("POST", url, strings.NewReader(t))
+		c.Assert(err, gocheck.IsNil)
+		recorder := httptest.NewRecorder()
+		err = addRule(recorder, request, s.token)
+		c.Assert(err, gocheck.NotNil)
+		e, ok := err.(*errors.HTTP)
+		c.Assert(ok, gocheck.Equals, true)
+		c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	}
+}
+
+func (s *S) TestRemoveRuleHandler(c *gocheck.C) {
+	rule := router.Rule{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, Plan: app.Plan{Router: "fake"}, Rules: []router.Rule{rule}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	rtesting.FakeRouter.Reset()
+	err = rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	defer router.Remove(a.Name)
+	url := fmt.Sprintf("/apps/%s/rules/https?:app=%s&:name=https", a.Name, a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removeRule(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Rules, gocheck.HasLen, 0)
+	err = removeRule(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListRulesHandler(c *gocheck.C) {
+	rule := router.Rule{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}
+	a := app.App{Name: "leper", Teams: []string{s.team.Name}, Rules: []router.Rule{rule}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/rules?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listRules(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var rules []router.Rule
+	err = json.Unmarshal(recorder.Body.Bytes(), &rules)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rules, gocheck.DeepEquals, []router.Rule{rule})
+}
+
 func (s *S) TestUnsetTwoCnames(c *gocheck.C) {
 	a := app.App{Name: "leper", Teams: []string{s.team.Name}, CName: []string{"foo.bar.com", "bar.com"}}
 	err := s.conn.Apps().Insert(a)
@@ -2569,7 +2938,7 @@ func (s *S) TestBindHandlerEndpointIsDown(c *gocheck.C) {
 	defer s.conn.Logs(a.Name).DropCollection()
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	url := fmt.Sprintf("/services/instances/%s/%s?:instance=%s&:app=%s", instance.Name, a.Name, instance.Name, a.Name)
 	request, err := http.NewRequest("PUT", url, nil)
 	c.Assert(err, gocheck.IsNil)
@@ -2606,7 +2975,7 @@ func (s *S) TestBindHandler(c *gocheck.C) {
 	defer s.conn.Logs(a.Name).DropCollection()
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	url := fmt.Sprintf("/services/instances/%s/%s?:instance=%s&:app=%s", instance.Name, a.Name, instance.Name, a.Name)
 	request, err := http.NewRequest("PUT", url, nil)
 	c.Assert(err, gocheck.IsNil)
@@ -2762,7 +3131,7 @@ func (s *S) TestUnbindHandler(c *gocheck.C) {
 	defer app.Delete(&a)
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	otherApp, err := app.GetByName(a.Name)
 	c.Assert(err, gocheck.IsNil)
 	otherApp.Env["DATABASE_HOST"] = bind.EnvVar{
@@ -3065,13 +3434,18 @@ func (s *S) TestSwap(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	err = s.provisioner.Provision(&app1)
 	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app1)
+	s.provisioner.AddUnits(&app1, 1, "", nil)
 	defer s.conn.Apps().Remove(bson.M{"name": app1.Name})
 	app2 := app.App{Name: "app2", Teams: []string{s.team.Name}}
 	err = s.conn.Apps().Insert(&app2)
 	c.Assert(err, gocheck.IsNil)
 	err = s.provisioner.Provision(&app2)
 	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app2)
+	s.provisioner.AddUnits(&app2, 1, "", nil)
 	defer s.conn.Apps().Remove(bson.M{"name": app2.Name})
+	defer s.conn.Swaps().RemoveAll(nil)
 	request, _ := http.NewRequest("PUT", "/swap?app1=app1&app2=app2", nil)
 	recorder := httptest.NewRecorder()
 	err = swap(recorder, request, s.token)
@@ -3102,6 +3476,10 @@ func (s *S) TestSwapApp1Locked(c *gocheck.C) {
 	recorder := httptest.NewRecorder()
 	err = swap(recorder, request, s.token)
 	c.Assert(err, gocheck.ErrorMatches, "app1: App locked by x, running /test. Acquired in .*")
+	dbApp, err := app.GetByName("app1")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Lock.Locked, gocheck.Equals, true)
+	c.Assert(dbApp.Lock.Owner, gocheck.Equals, "x")
 }
 
 func (s *S) TestSwapApp2Locked(c *gocheck.C) {
@@ -3119,6 +3497,10 @@ func (s *S) TestSwapApp2Locked(c *gocheck.C) {
 	recorder := httptest.NewRecorder()
 	err = swap(recorder, request, s.token)
 	c.Assert(err, gocheck.ErrorMatches, "app2: App locked by x, running /test. Acquired in .*")
+	dbApp, err := app.GetByName("app2")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Lock.Locked, gocheck.Equals, true)
+	c.Assert(dbApp.Lock.Owner, gocheck.Equals, "x")
 }
 
 func (s *S) TestSwapIncompatiblePlatforms(c *gocheck.C) {
@@ -3174,19 +3556,175 @@ func (s *S) TestSwapIncompatibleAppsForceSwap(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	err = s.provisioner.Provision(&app1)
 	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app1)
+	s.provisioner.AddUnits(&app1, 1, "", nil)
 	defer s.conn.Apps().Remove(bson.M{"name": app1.Name})
 	app2 := app.App{Name: "app2", Teams: []string{s.team.Name}, Platform: "y"}
 	err = s.conn.Apps().Insert(&app2)
 	c.Assert(err, gocheck.IsNil)
 	err = s.provisioner.Provision(&app2)
 	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app2)
+	s.provisioner.AddUnits(&app2, 1, "", nil)
 	defer s.conn.Apps().Remove(bson.M{"name": app2.Name})
+	defer s.conn.Swaps().RemoveAll(nil)
 	request, _ := http.NewRequest("PUT", "/swap?app1=app1&app2=app2&force=true", nil)
 	recorder := httptest.NewRecorder()
 	err = swap(recorder, request, s.token)
 	c.Assert(err, gocheck.IsNil)
 }
 
+func (s *S) TestSwapUnhealthyApps(c *gocheck.C) {
+	app1 := app.App{Name: "app1", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(&app1)
+	c.Assert(err, gocheck.IsNil)
+	err = s.provisioner.Provision(&app1)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app1)
+	defer s.conn.Apps().Remove(bson.M{"name": app1.Name})
+	app2 := app.App{Name: "app2", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(&app2)
+	c.Assert(err, gocheck.IsNil)
+	err = s.provisioner.Provision(&app2)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app2)
+	defer s.conn.Apps().Remove(bson.M{"name": app2.Name})
+	request, _ := http.NewRequest("PUT", "/swap?app1=app1&app2=app2", nil)
+	recorder := httptest.NewRecorder()
+	err = swap(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, gocheck.Equals, `cannot swap apps: all units of the app "app1" must be started`)
+}
+
+func (s *S) TestSwapUnhealthyAppsForceSwap(c *gocheck.C) {
+	app1 := app.App{Name: "app1", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(&app1)
+	c.Assert(err, gocheck.IsNil)
+	err = s.provisioner.Provision(&app1)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app1)
+	defer s.conn.Apps().Remove(bson.M{"name": app1.Name})
+	app2 := app.App{Name: "app2", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(&app2)
+	c.Assert(err, gocheck.IsNil)
+	err = s.provisioner.Provision(&app2)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&app2)
+	defer s.conn.Apps().Remove(bson.M{"name": app2.Name})
+	defer s.conn.Swaps().RemoveAll(nil)
+	request, _ := http.NewRequest("PUT", "/swap?app1=app1&app2=app2&force=true", nil)
+	recorder := httptest.NewRecorder()
+	err = swap(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) createSwappedApps(c *gocheck.C) (*app.App, *app.App) {
+	app1 := app.App{Name: "app1", Teams: []string{s.team.Name}, CName: []string{"app1.tsuru.io"}}
+	app2 := app.App{Name: "app2", Teams: []string{s.team.Name}}
+	for _, a := range []*app.App{&app1, &app2} {
+		err := s.conn.Apps().Insert(a)
+		c.Assert(err, gocheck.IsNil)
+		err = s.provisioner.Provision(a)
+		c.Assert(err, gocheck.IsNil)
+		s.provisioner.AddUnits(a, 1, "", nil)
+	}
+	err := app.Swap(&app1, &app2, s.user.Email, false)
+	c.Assert(err, gocheck.IsNil)
+	return &app1, &app2
+}
+
+func (s *S) removeSwappedApps(app1, app2 *app.App) {
+	for _, a := range []*app.App{app1, app2} {
+		s.provisioner.Destroy(a)
+		s.conn.Apps().Remove(bson.M{"name": a.Name})
+	}
+	s.conn.Swaps().RemoveAll(nil)
+}
+
+func (s *S) TestSwapLast(c *gocheck.C) {
+	app1, app2 := s.createSwappedApps(c)
+	defer s.removeSwappedApps(app1, app2)
+	request, err := http.NewRequest("GET", "/swap/last", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.admintoken.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var evt app.SwapEvent
+	err = json.Unmarshal(recorder.Body.Bytes(), &evt)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.App1, gocheck.Equals, "app1")
+	c.Assert(evt.App2, gocheck.Equals, "app2")
+	c.Assert(evt.User, gocheck.Equals, s.user.Email)
+	c.Assert(evt.Undone, gocheck.Equals, false)
+}
+
+func (s *S) TestSwapLastWithoutSwaps(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/swap/last", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.admintoken.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "no swap found\n")
+}
+
+func (s *S) TestSwapUndo(c *gocheck.C) {
+	app1, app2 := s.createSwappedApps(c)
+	defer s.removeSwappedApps(app1, app2)
+	request, err := http.NewRequest("POST", "/swap/undo", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.admintoken.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(app1.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.CName, gocheck.DeepEquals, []string{"app1.tsuru.io"})
+	c.Assert(dbApp.Lock, gocheck.Equals, app.AppLock{})
+	evt, err := app.LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.Undone, gocheck.Equals, true)
+	action := testing.Action{Action: "swap-undo", User: s.adminuser.Email, Extra: []interface{}{"app1", "app2"}}
+	c.Assert(action, testing.IsRecorded)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "the last swap was already undone\n")
+}
+
+func (s *S) TestSwapUndoAppLocked(c *gocheck.C) {
+	app1, app2 := s.createSwappedApps(c)
+	defer s.removeSwappedApps(app1, app2)
+	locked, err := app.AcquireApplicationLock(app2.Name, "x", "/test")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(locked, gocheck.Equals, true)
+	request, err := http.NewRequest("POST", "/swap/undo", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.admintoken.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusConflict)
+	dbApp, err := app.GetByName(app2.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Lock.Locked, gocheck.Equals, true)
+	c.Assert(dbApp.Lock.Owner, gocheck.Equals, "x")
+	dbApp, err = app.GetByName(app1.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Lock, gocheck.Equals, app.AppLock{})
+	evt, err := app.LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.Undone, gocheck.Equals, false)
+}
+
 func (s *S) TestStartHandler(c *gocheck.C) {
 	s.provisioner.PrepareOutput(nil) // loadHooks
 	s.provisioner.PrepareOutput([]byte("started"))
@@ -3243,6 +3781,38 @@ func (s *S) TestStopHandler(c *gocheck.C) {
 	c.Assert(action, testing.IsRecorded)
 }
 
+func (s *S) TestStopHandlerProcess(c *gocheck.C) {
+	a := app.App{
+		Name:  "stress",
+		Teams: []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs(a.Name).DropCollection()
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/stop?:app=%s&process=worker", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = stop(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Stops(&a), gocheck.Equals, 1)
+	c.Assert(s.provisioner.LastProcess(&a), gocheck.Equals, "worker")
+	action := testing.Action{
+		Action: "stop",
+		User:   s.user.Email,
+		Extra:  []interface{}{a.Name, "process=worker"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestWithProcess(c *gocheck.C) {
+	c.Assert(withProcess("", "myapp"), gocheck.DeepEquals, []interface{}{"myapp"})
+	c.Assert(withProcess("worker", "myapp"), gocheck.DeepEquals, []interface{}{"myapp", "process=worker"})
+}
+
 func (s *S) TestForceDeleteLock(c *gocheck.C) {
 	a := app.App{
 		Name: "locked",
@@ -3304,7 +3874,7 @@ func (s *S) TestRegisterUnit(c *gocheck.C) {
 	err = s.provisioner.Provision(&a)
 	c.Assert(err, gocheck.IsNil)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	units := a.Units()
 	oldIp := units[0].Ip
 	body := strings.NewReader("hostname=" + units[0].Name)
@@ -3544,3 +4114,152 @@ func (s *S) TestSaveAppCustomData(c *gocheck.C) {
 	}
 	c.Assert(dbApp.CustomData, gocheck.DeepEquals, expected)
 }
+
+func (s *S) TestChangeAppProvisionerHandler(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	a := app.App{Name: "stress", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	s.provisioner.AddUnits(&a, 1, "", nil)
+	body := strings.NewReader("provisioner=fake-other")
+	request, err := http.NewRequest("POST", "/apps/stress/provisioner?:app=stress", body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = changeAppProvisioner(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "text")
+	c.Assert(recorder.Body.String(), gocheck.Matches, `(?s).*moved from \\"docker\\" to \\"fake-other\\" provisioner.*`)
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, false)
+	c.Assert(other.Provisioned(&a), gocheck.Equals, true)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.ProvisionerName, gocheck.Equals, "fake-other")
+	action := testing.Action{
+		Action: "app-change-provisioner",
+		User:   s.user.Email,
+		Extra:  []interface{}{a.Name, "fake-other"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestChangeAppProvisionerHandlerWithoutProvisioner(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/stress/provisioner?:app=stress", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = changeAppProvisioner(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "Please provide the name of the provisioner.")
+}
+
+func (s *S) TestChangeAppProvisionerHandlerUnknownProvisioner(c *gocheck.C) {
+	body := strings.NewReader("provisioner=unknown")
+	request, err := http.NewRequest("POST", "/apps/stress/provisioner?:app=stress", body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = changeAppProvisioner(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `unknown provisioner: "unknown"`)
+}
+
+func (s *S) TestChangeAppProvisionerHandlerSameProvisioner(c *gocheck.C) {
+	a := app.App{Name: "stress", Teams: []string{s.team.Name}, ProvisionerName: "fake"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader("provisioner=fake")
+	request, err := http.NewRequest("POST", "/apps/stress/provisioner?:app=stress", body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = changeAppProvisioner(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, app.ErrSameProvisioner.Error())
+}
+
+func (s *S) changeAppRouter(c *gocheck.C, body string) (*httptest.ResponseRecorder, error) {
+	request, err := http.NewRequest("POST", "/apps/stress/router?:app=stress", strings.NewReader(body))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = changeAppRouter(recorder, request, s.token)
+	return recorder, err
+}
+
+func (s *S) TestChangeAppRouterHandler(c *gocheck.C) {
+	config.Set("proxy:domain", "tsuru.io")
+	defer config.Unset("proxy:domain")
+	a := app.App{Name: "stress", Teams: []string{s.team.Name}, Plan: app.Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	rtesting.FakeRouter.Reset()
+	err = rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	r, err := router.Get("proxy")
+	c.Assert(err, gocheck.IsNil)
+	defer r.RemoveBackend(a.Name)
+	_, err = s.changeAppRouter(c, "router=proxy&grace-period=3600")
+	c.Assert(err, gocheck.IsNil)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Plan.Router, gocheck.Equals, "proxy")
+	c.Assert(dbApp.Lock.Locked, gocheck.Equals, false)
+	routes, err := r.Routes(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+	action := testing.Action{
+		Action: "app-change-router",
+		User:   s.user.Email,
+		Extra:  []interface{}{a.Name, "proxy"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestChangeAppRouterHandlerInvalidParameters(c *gocheck.C) {
+	var tests = []struct {
+		body    string
+		message string
+	}{
+		{"", "Please provide the name of the router."},
+		{"router=unknown", `Unknown router: "unknown".`},
+		{"router=fake&grace-period=soon", "Invalid grace period, it must be a number of seconds."},
+		{"router=fake&grace-period=-1", "Invalid grace period, it must be a number of seconds."},
+	}
+	for _, t := range tests {
+		_, err := s.changeAppRouter(c, t.body)
+		c.Assert(err, gocheck.NotNil)
+		e, ok := err.(*errors.HTTP)
+		c.Assert(ok, gocheck.Equals, true)
+		c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+		c.Assert(e.Message, gocheck.Equals, t.message)
+	}
+}
+
+func (s *S) TestChangeAppRouterHandlerSameRouter(c *gocheck.C) {
+	a := app.App{Name: "stress", Teams: []string{s.team.Name}, Plan: app.Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	_, err = s.changeAppRouter(c, "router=fake")
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, app.ErrSameRouter.Error())
+}
@@ -8,9 +8,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
 )
 
 func autoScaleHistoryHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
@@ -52,5 +54,39 @@ func autoScaleConfig(w http.ResponseWriter, r *http.Request, t auth.Token) error
 	}
 	defer r.Body.Close()
 	err = json.Unmarshal(body, &a.AutoScaleConfig)
-	return app.SetAutoScaleConfig(a, a.AutoScaleConfig)
+	err = app.SetAutoScaleConfig(a, a.AutoScaleConfig)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+func autoScaleSimulate(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":app")
+	a, err := app.GetByName(appName)
+	if err != nil {
+		return err
+	}
+	period := time.Hour
+	if p := r.URL.Query().Get("period"); p != "" {
+		period, err = time.ParseDuration(p)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid period: " + p}
+		}
+	}
+	defer r.Body.Close()
+	var config app.AutoScaleConfig
+	err = json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid auto scale config: " + err.Error()}
+	}
+	simulation, err := app.SimulateAutoScale(a, &config, period)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(simulation)
 }
@@ -165,3 +165,130 @@ func (s *AutoScaleSuite) TestAutoScaleConfig(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(gotApp.AutoScaleConfig, gocheck.DeepEquals, &config)
 }
+
+func (s *AutoScaleSuite) TestAutoScaleConfigUnknownMetricsSource(c *gocheck.C) {
+	a := app.App{Name: "myApp", Platform: "Django"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	recorder := httptest.NewRecorder()
+	config := app.AutoScaleConfig{Enabled: true, MetricsSource: "unknown"}
+	body, err := json.Marshal(&config)
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("PUT", "/autoscale/myApp", bytes.NewReader(body))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Metrics source \"unknown\" is not known.\n")
+	var gotApp app.App
+	err = s.conn.Apps().Find(bson.M{"name": "myApp"}).One(&gotApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(gotApp.AutoScaleConfig, gocheck.IsNil)
+}
+
+func (s *AutoScaleSuite) TestAutoScaleConfigInvalidExpression(c *gocheck.C) {
+	a := app.App{Name: "myApp", Platform: "Django"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	recorder := httptest.NewRecorder()
+	config := app.AutoScaleConfig{
+		Enabled:  true,
+		Increase: app.Action{Units: 1, Expression: "{cpu_max} >> 80"},
+	}
+	body, err := json.Marshal(&config)
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("PUT", "/autoscale/myApp", bytes.NewReader(body))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Invalid increase expression: unexpected \">\" at position 12, expecting a number.\n")
+}
+
+func (s *AutoScaleSuite) TestAutoScaleConfigInvalidSchedule(c *gocheck.C) {
+	a := app.App{Name: "myApp", Platform: "Django"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	recorder := httptest.NewRecorder()
+	config := app.AutoScaleConfig{
+		Enabled:   true,
+		Schedules: []app.ScheduleRule{{Name: "morning", Cron: "0 25 * * *", Units: 5}},
+	}
+	body, err := json.Marshal(&config)
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("PUT", "/autoscale/myApp", bytes.NewReader(body))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Invalid schedule: the schedule \"morning\" has an invalid cron expression \"0 25 * * *\": value 25 out of range [0, 23].\n")
+	var gotApp app.App
+	err = s.conn.Apps().Find(bson.M{"name": "myApp"}).One(&gotApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(gotApp.AutoScaleConfig, gocheck.IsNil)
+}
+
+func (s *AutoScaleSuite) TestAutoScaleSimulate(c *gocheck.C) {
+	metrics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"values": [85, 90]}`))
+	}))
+	defer metrics.Close()
+	config.Set("autoscale:http-metrics-url", metrics.URL)
+	defer config.Unset("autoscale:http-metrics-url")
+	a := app.App{Name: "myApp", Platform: "Django"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	recorder := httptest.NewRecorder()
+	autoScaleConfig := app.AutoScaleConfig{
+		Increase:      app.Action{Units: 1, Expression: "avg({cpu_max}, 5m) > 80"},
+		MaxUnits:      10,
+		MetricsSource: "http",
+	}
+	body, err := json.Marshal(&autoScaleConfig)
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("POST", "/autoscale/myApp/simulate?period=1m", bytes.NewReader(body))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var simulation app.AutoScaleSimulation
+	err = json.NewDecoder(recorder.Body).Decode(&simulation)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(simulation.Until.Sub(simulation.From), gocheck.Equals, time.Minute)
+	c.Assert(simulation.Decisions, gocheck.HasLen, 3)
+	for _, decision := range simulation.Decisions {
+		c.Check(decision.Type, gocheck.Equals, "increase")
+		c.Check(decision.Event, gocheck.NotNil)
+	}
+	count, err := s.conn.AutoScale().Find(nil).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 0)
+	var gotApp app.App
+	err = s.conn.Apps().Find(bson.M{"name": "myApp"}).One(&gotApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(gotApp.AutoScaleConfig, gocheck.IsNil)
+}
+
+func (s *AutoScaleSuite) TestAutoScaleSimulateInvalidPeriod(c *gocheck.C) {
+	a := app.App{Name: "myApp", Platform: "Django"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/autoscale/myApp/simulate?period=48h", bytes.NewReader([]byte("{}")))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "The period of the simulation must be between 0 and 24h0m0s.\n")
+}
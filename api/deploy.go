@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +17,9 @@ import (
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/rec"
+	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/service"
 )
 
@@ -33,10 +37,11 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	}
 	version := r.PostFormValue("version")
 	archiveURL := r.PostFormValue("archive-url")
-	if version == "" && archiveURL == "" && file == nil {
+	image := r.PostFormValue("image")
+	if version == "" && archiveURL == "" && image == "" && file == nil {
 		return &errors.HTTP{
 			Code:    http.StatusBadRequest,
-			Message: "you must specify either the version, the archive-url or upload a file",
+			Message: "you must specify either the version, the archive-url, the image or upload a file",
 		}
 	}
 	if version != "" && archiveURL != "" {
@@ -45,6 +50,12 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 			Message: "you must specify either the version or the archive-url, but not both",
 		}
 	}
+	if image != "" && (version != "" || archiveURL != "" || file != nil) {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: "you must specify either the image or the source of the app, but not both",
+		}
+	}
 	commit := r.PostFormValue("commit")
 	w.Header().Set("Content-Type", "text")
 	appName := r.URL.Query().Get(":appname")
@@ -59,13 +70,224 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		Commit:       commit,
 		File:         file,
 		ArchiveURL:   archiveURL,
+		Image:        image,
 		OutputStream: writer,
 	})
+	if err == app.ErrImageDeployNotSupported {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err == nil {
+		fmt.Fprintln(w, "\nOK")
+	}
+	return err
+
+}
+
+func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	image := r.PostFormValue("image")
+	if image == "" {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: "you must specify the image to roll back to",
+		}
+	}
+	appName := r.URL.Query().Get(":appname")
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "deploy-rollback", "app="+appName, "image="+image)
+	w.Header().Set("Content-Type", "text")
+	writer := io.NewKeepAliveWriter(w, 30*time.Second, "please wait...")
+	err = app.Rollback(&instance, image, writer)
+	if err == app.ErrDeployImageNotFound || err == app.ErrRollbackNotSupported {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err == nil {
+		fmt.Fprintln(w, "\nOK")
+	}
+	return err
+}
+
+func deployPromote(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	sourceName := r.PostFormValue("source")
+	if sourceName == "" {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: "you must specify the source app",
+		}
+	}
+	appName := r.URL.Query().Get(":appname")
+	source, err := getApp(sourceName, u)
+	if err != nil {
+		return err
+	}
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "deploy-promote", "app="+appName, "source="+sourceName)
+	w.Header().Set("Content-Type", "text")
+	writer := io.NewKeepAliveWriter(w, 30*time.Second, "please wait...")
+	err = app.Promote(&source, &instance, writer)
+	if err == app.ErrNoImageToPromote || err == app.ErrImageDeployNotSupported {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	if err == nil {
 		fmt.Fprintln(w, "\nOK")
 	}
 	return err
+}
+
+// canaryError converts the errors caused by invalid canary operations to bad
+// requests.
+func canaryError(err error) error {
+	switch err {
+	case app.ErrCanaryNotSupported, provision.ErrCanaryInProgress, provision.ErrNoCanaryInProgress,
+		router.ErrInvalidWeight, router.ErrWeightNotSupported:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+func canaryWeightValue(r *http.Request) (int, error) {
+	weight, err := strconv.Atoi(r.PostFormValue("weight"))
+	if err != nil || weight < 1 || weight > 99 {
+		return 0, &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: "you must specify the weight of the canary, a percentage between 1 and 99",
+		}
+	}
+	return weight, nil
+}
+
+func canaryStart(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	image := r.PostFormValue("image")
+	if image == "" {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: "you must specify the image of the canary",
+		}
+	}
+	units := 1
+	if value := r.PostFormValue("units"); value != "" {
+		units, err = strconv.Atoi(value)
+		if err != nil || units < 1 {
+			return &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid number of units: the number must be an integer greater than 0.",
+			}
+		}
+	}
+	weight, err := canaryWeightValue(r)
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":appname")
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "canary-start", "app="+appName, "image="+image, "units="+strconv.Itoa(units), "weight="+strconv.Itoa(weight))
+	w.Header().Set("Content-Type", "text")
+	writer := io.NewKeepAliveWriter(w, 30*time.Second, "please wait...")
+	err = app.StartCanary(&instance, image, units, weight, writer)
+	if err == nil {
+		fmt.Fprintln(w, "\nOK")
+	}
+	return canaryError(err)
+}
+
+func canaryWeight(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	weight, err := canaryWeightValue(r)
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":appname")
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "canary-weight", "app="+appName, "weight="+strconv.Itoa(weight))
+	return canaryError(app.SetCanaryWeight(&instance, weight))
+}
+
+func canaryPromote(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":appname")
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "canary-promote", "app="+appName)
+	w.Header().Set("Content-Type", "text")
+	writer := io.NewKeepAliveWriter(w, 30*time.Second, "please wait...")
+	err = app.PromoteCanary(&instance, writer)
+	if err == nil {
+		fmt.Fprintln(w, "\nOK")
+	}
+	return canaryError(err)
+}
+
+func canaryAbort(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":appname")
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "canary-abort", "app="+appName)
+	w.Header().Set("Content-Type", "text")
+	writer := io.NewKeepAliveWriter(w, 30*time.Second, "please wait...")
+	err = app.AbortCanary(&instance, writer)
+	if err == nil {
+		fmt.Fprintln(w, "\nOK")
+	}
+	return canaryError(err)
+}
 
+func deployCancel(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":appname")
+	instance, err := getApp(appName, u)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "deploy-cancel", "app="+appName)
+	err = app.CancelDeploy(&instance)
+	if err == provision.ErrNoDeployInProgress || err == app.ErrDeployCancelNotSupported {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
 func deploysList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
@@ -112,13 +334,38 @@ func deployInfo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	data := map[string]interface{}{
-		"Id":        deploy.ID.Hex(),
-		"App":       deploy.App,
-		"Timestamp": deploy.Timestamp.Format(time.RFC3339),
-		"Duration":  deploy.Duration.Nanoseconds(),
-		"Commit":    deploy.Commit,
-		"Error":     deploy.Error,
-		"Diff":      diff,
+		"Id":           deploy.ID.Hex(),
+		"App":          deploy.App,
+		"Timestamp":    deploy.Timestamp.Format(time.RFC3339),
+		"Duration":     deploy.Duration.Nanoseconds(),
+		"Commit":       deploy.Commit,
+		"Image":        deploy.Image,
+		"Rollback":     deploy.Rollback,
+		"SourceApp":    deploy.SourceApp,
+		"SourceDeploy": deploy.SourceDeploy.Hex(),
+		"Running":      deploy.Running,
+		"Error":        deploy.Error,
+		"Diff":         diff,
 	}
 	return json.NewEncoder(w).Encode(data)
 }
+
+func deployLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	depId := r.URL.Query().Get(":deploy")
+	deploy, err := app.GetDeploy(depId, u)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	follow := r.URL.Query().Get("follow") == "1"
+	extra := []interface{}{"deploy=" + depId}
+	if follow {
+		extra = append(extra, "follow=1")
+	}
+	rec.Log(u.Email, "deploy-log", extra...)
+	w.Header().Set("Content-Type", "text")
+	return app.WriteDeployLog(deploy, w, follow)
+}
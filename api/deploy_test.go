@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -19,6 +20,7 @@ import (
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/auth/native"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/service"
 	"github.com/tsuru/tsuru/testing"
 	"gopkg.in/mgo.v2/bson"
@@ -125,6 +127,44 @@ func (s *DeploySuite) TestDeployArchiveURL(c *gocheck.C) {
 	c.Assert(recorder.Body.String(), gocheck.Equals, "Archive deploy called\nOK\n")
 }
 
+func (s *DeploySuite) TestDeployImage(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image=registry.example.com/otherapp:1.0"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "text")
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Image deploy called\nOK\n")
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, "tsuru/app-otherapp:v1")
+}
+
+func (s *DeploySuite) TestDeployImageAndVersion(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/otherapp/deploy", strings.NewReader("image=registry.example.com/otherapp:1.0&version=a345f3e"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	message := recorder.Body.String()
+	c.Assert(message, gocheck.Equals, "you must specify either the image or the source of the app, but not both\n")
+}
+
 func (s *DeploySuite) TestDeployUploadFile(c *gocheck.C) {
 	a := app.App{
 		Name:     "otherapp",
@@ -246,7 +286,7 @@ func (s *DeploySuite) TestDeployWithoutVersionAndArchiveURL(c *gocheck.C) {
 	server.ServeHTTP(recorder, request)
 	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
 	message := recorder.Body.String()
-	c.Assert(message, gocheck.Equals, "you must specify either the version, the archive-url or upload a file\n")
+	c.Assert(message, gocheck.Equals, "you must specify either the version, the archive-url, the image or upload a file\n")
 }
 
 func (s *DeploySuite) TestDeployWithVersionAndArchiveURL(c *gocheck.C) {
@@ -271,6 +311,392 @@ func (s *DeploySuite) TestDeployWithVersionAndArchiveURL(c *gocheck.C) {
 	c.Assert(message, gocheck.Equals, "you must specify either the version or the archive-url, but not both\n")
 }
 
+func (s *DeploySuite) TestDeployRollback(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs(a.Name).DropCollection()
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	image, err := s.provisioner.GitDeploy(&a, "a345f3e", &bytes.Buffer{})
+	c.Assert(err, gocheck.IsNil)
+	_, err = s.provisioner.GitDeploy(&a, "b345f3e", &bytes.Buffer{})
+	c.Assert(err, gocheck.IsNil)
+	err = s.conn.Deploys().Insert(bson.M{"app": a.Name, "commit": "a345f3e", "image": image, "error": ""})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy/rollback", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image="+image))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "text")
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Rollback called\nOK\n")
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, image)
+	count, err := s.conn.Deploys().Find(bson.M{"app": a.Name, "rollback": true, "image": image}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 1)
+	action := testing.Action{
+		Action: "deploy-rollback",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"app=" + a.Name, "image=" + image},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *DeploySuite) TestDeployPromote(c *gocheck.C) {
+	source := app.App{Name: "myapp-staging", Platform: "zend", Teams: []string{s.team.Name}}
+	a := app.App{Name: "myapp-prod", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(source, a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{source.Name, a.Name}}})
+	defer s.conn.Logs(a.Name).DropCollection()
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = s.conn.Deploys().Insert(bson.M{"app": source.Name, "commit": "a345f3e", "image": "tsuru/app-myapp-staging:v2", "error": ""})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(nil)
+	url := fmt.Sprintf("/apps/%s/deploy/promote", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("source="+source.Name))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "text")
+	c.Assert(recorder.Body.String(), gocheck.Matches, "(?s).*Image deploy called\nOK\n")
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, "tsuru/app-myapp-prod:v1")
+	count, err := s.conn.Deploys().Find(bson.M{"app": a.Name, "sourceapp": source.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 1)
+	action := testing.Action{
+		Action: "deploy-promote",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"app=" + a.Name, "source=" + source.Name},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *DeploySuite) TestCanaryStart(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs(a.Name).DropCollection()
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	body := strings.NewReader("image=tsuru/app-otherapp:v2&units=2&weight=10")
+	request, err := http.NewRequest("POST", "/apps/otherapp/canary", body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Start canary called\nOK\n")
+	image, units, weight := s.provisioner.Canary(&a)
+	c.Assert(image, gocheck.Equals, "tsuru/app-otherapp:v2")
+	c.Assert(units, gocheck.Equals, 2)
+	c.Assert(weight, gocheck.Equals, 10)
+	action := testing.Action{
+		Action: "canary-start",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"app=" + a.Name, "image=tsuru/app-otherapp:v2", "units=2", "weight=10"},
+	}
+	c.Assert(action, testing.IsRecorded)
+	request, err = http.NewRequest("POST", "/apps/otherapp/canary", strings.NewReader("image=tsuru/app-otherapp:v3&weight=10"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, provision.ErrCanaryInProgress.Error()+"\n")
+}
+
+func (s *DeploySuite) TestCanaryStartInvalidWeight(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/otherapp/canary", strings.NewReader("image=tsuru/app-otherapp:v2&weight=100"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "you must specify the weight of the canary, a percentage between 1 and 99\n")
+}
+
+func (s *DeploySuite) TestCanaryStartWithoutImage(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/otherapp/canary", strings.NewReader("weight=10"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "you must specify the image of the canary\n")
+}
+
+func (s *DeploySuite) TestCanaryWeight(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = s.provisioner.StartCanary(&a, "tsuru/app-otherapp:v2", 1, 10, ioutil.Discard)
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("POST", "/apps/otherapp/canary/weight", strings.NewReader("weight=50"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	_, _, weight := s.provisioner.Canary(&a)
+	c.Assert(weight, gocheck.Equals, 50)
+	action := testing.Action{
+		Action: "canary-weight",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"app=" + a.Name, "weight=50"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *DeploySuite) TestCanaryPromote(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs(a.Name).DropCollection()
+	defer s.conn.Deploys().RemoveAll(nil)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = s.provisioner.StartCanary(&a, "tsuru/app-otherapp:v2", 1, 10, ioutil.Discard)
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("POST", "/apps/otherapp/canary/promote", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Promote canary called\nOK\n")
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, "tsuru/app-otherapp:v2")
+	action := testing.Action{
+		Action: "canary-promote",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"app=" + a.Name},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *DeploySuite) TestCanaryAbort(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs(a.Name).DropCollection()
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	request, err := http.NewRequest("POST", "/apps/otherapp/canary/abort", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, provision.ErrNoCanaryInProgress.Error()+"\n")
+	err = s.provisioner.StartCanary(&a, "tsuru/app-otherapp:v2", 1, 10, ioutil.Discard)
+	c.Assert(err, gocheck.IsNil)
+	request, err = http.NewRequest("POST", "/apps/otherapp/canary/abort", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Abort canary called\nOK\n")
+	image, _, _ := s.provisioner.Canary(&a)
+	c.Assert(image, gocheck.Equals, "")
+	action := testing.Action{
+		Action: "canary-abort",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"app=" + a.Name},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *DeploySuite) TestDeployPromoteWithoutSource(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/myapp-prod/deploy/promote", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "you must specify the source app\n")
+}
+
+func (s *DeploySuite) TestDeployPromoteSourceNotDeployed(c *gocheck.C) {
+	source := app.App{Name: "myapp-staging", Platform: "zend", Teams: []string{s.team.Name}}
+	a := app.App{Name: "myapp-prod", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(source, a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{source.Name, a.Name}}})
+	url := fmt.Sprintf("/apps/%s/deploy/promote", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("source="+source.Name))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, app.ErrNoImageToPromote.Error()+"\n")
+}
+
+func (s *DeploySuite) TestDeployRollbackWithoutImage(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/otherapp/deploy/rollback", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "you must specify the image to roll back to\n")
+}
+
+func (s *DeploySuite) TestDeployRollbackAppNotFound(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/unknown/deploy/rollback", strings.NewReader("image=tsuru/app-unknown:v1"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *DeploySuite) TestDeployRollbackImageNotDeployed(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploy/rollback", a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image=tsuru/app-otherapp:v1"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, app.ErrDeployImageNotFound.Error()+"\n")
+}
+
+func (s *DeploySuite) TestDeployCancel(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs(a.Name).DropCollection()
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.BlockDeploys(&a)
+	errs := make(chan error)
+	go func() {
+		errs <- app.Deploy(app.DeployOptions{App: &a, Version: "a345f3e", OutputStream: &bytes.Buffer{}})
+	}()
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNoContent)
+	c.Assert(<-errs, gocheck.Equals, provision.ErrDeployCanceled)
+	action := testing.Action{
+		Action: "deploy-cancel",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"app=" + a.Name},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *DeploySuite) TestDeployCancelWhileLocked(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.BlockDeploys(&a)
+	locked, err := app.AcquireApplicationLock(a.Name, "someone", "POST /apps/otherapp/deploy")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(locked, gocheck.Equals, true)
+	defer app.ReleaseApplicationLock(a.Name)
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNoContent)
+}
+
+func (s *DeploySuite) TestDeployCancelWithoutDeployInProgress(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/deploy", a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, provision.ErrNoDeployInProgress.Error()+"\n")
+}
+
 func (s *DeploySuite) TestDeployList(c *gocheck.C) {
 	a := app.App{
 		Name:     "g1",
@@ -481,13 +907,18 @@ func (s *DeploySuite) TestDeployInfoByAdminUser(c *gocheck.C) {
 	err = json.Unmarshal(recorder.Body.Bytes(), &result)
 	c.Assert(err, gocheck.IsNil)
 	expected_deploy := map[string]interface{}{
-		"Id":        lastDeployId,
-		"App":       "g1",
-		"Timestamp": timestamp.Format(time.RFC3339),
-		"Duration":  10e9,
-		"Commit":    "e82nn93nd93mm12o2ueh83dhbd3iu112",
-		"Error":     "",
-		"Diff":      expected,
+		"Id":           lastDeployId,
+		"App":          "g1",
+		"Timestamp":    timestamp.Format(time.RFC3339),
+		"Duration":     10e9,
+		"Commit":       "e82nn93nd93mm12o2ueh83dhbd3iu112",
+		"Image":        "",
+		"Rollback":     false,
+		"SourceApp":    "",
+		"SourceDeploy": "",
+		"Running":      false,
+		"Error":        "",
+		"Diff":         expected,
 	}
 	c.Assert(result, gocheck.DeepEquals, expected_deploy)
 }
@@ -593,3 +1024,64 @@ func (s *DeploySuite) TestDeployInfoByUserWithoutAccess(c *gocheck.C) {
 	body := recorder.Body.String()
 	c.Assert(body, gocheck.Equals, "Deploy not found.\n")
 }
+
+func (s *DeploySuite) TestDeployLog(c *gocheck.C) {
+	a := app.App{
+		Name:     "g1",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	deploy := Deploy{ID: bson.NewObjectId(), App: "g1", Timestamp: time.Now(), Commit: "e82nn93nd93mm12o2ueh83dhbd3iu112"}
+	err = s.conn.Deploys().Insert(deploy)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(nil)
+	err = s.conn.DeployLogs().Insert(
+		app.DeployLog{Deploy: deploy.ID, Seq: 2, Message: "---- Building application image ----\n"},
+		app.DeployLog{Deploy: deploy.ID, Seq: 1, Message: "running the build\n"},
+	)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.DeployLogs().RemoveAll(nil)
+	url := fmt.Sprintf("/deploys/%s/log", deploy.ID.Hex())
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "text")
+	c.Assert(recorder.Body.String(), gocheck.Equals, "running the build\n---- Building application image ----\n")
+	action := testing.Action{
+		Action: "deploy-log",
+		User:   "whydidifall@thewho.com",
+		Extra:  []interface{}{"deploy=" + deploy.ID.Hex()},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *DeploySuite) TestDeployLogByUserWithoutAccess(c *gocheck.C) {
+	user := &auth.User{Email: "user@user.com", Password: "123456"}
+	nativeScheme := auth.ManagedScheme(native.NativeScheme{})
+	app.AuthScheme = nativeScheme
+	_, err := nativeScheme.Create(user)
+	c.Assert(err, gocheck.IsNil)
+	defer user.Delete()
+	token, err := nativeScheme.Login(map[string]string{"email": user.Email, "password": "123456"})
+	c.Assert(err, gocheck.IsNil)
+	deploy := Deploy{ID: bson.NewObjectId(), App: "g1", Timestamp: time.Now()}
+	err = s.conn.Deploys().Insert(deploy)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(nil)
+	url := fmt.Sprintf("/deploys/%s/log", deploy.ID.Hex())
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Deploy not found.\n")
+}
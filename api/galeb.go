@@ -0,0 +1,24 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/router/galeb"
+)
+
+// galebOperationsList lists the operations that failed in the Galeb API and
+// are waiting to be retried.
+func galebOperationsList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	operations, err := galeb.ListOperations()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(operations)
+}
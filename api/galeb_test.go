@@ -0,0 +1,60 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/tsuru/tsuru/router/galeb"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestGalebOperationsList(c *gocheck.C) {
+	operation := galeb.Operation{
+		Id:        bson.NewObjectId(),
+		Prefix:    "galeb",
+		Op:        "add-route",
+		Backend:   "myapp",
+		Arg:       "10.9.2.1:44001",
+		Attempts:  3,
+		LastError: "POST /backend/: invalid response code: 503",
+		CreatedAt: time.Now(),
+		NextRetry: time.Now().Add(time.Minute),
+	}
+	coll := s.conn.Collection("galeb_router_outbox")
+	err := coll.Insert(operation)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId(operation.Id)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/routers/galeb/operations", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.admintoken.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var operations []galeb.Operation
+	err = json.NewDecoder(recorder.Body).Decode(&operations)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 1)
+	c.Assert(operations[0].Op, gocheck.Equals, "add-route")
+	c.Assert(operations[0].Backend, gocheck.Equals, "myapp")
+	c.Assert(operations[0].Attempts, gocheck.Equals, 3)
+	c.Assert(operations[0].LastError, gocheck.Equals, operation.LastError)
+}
+
+func (s *S) TestGalebOperationsListRequiresAdmin(c *gocheck.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/routers/galeb/operations", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusForbidden)
+}
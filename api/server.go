@@ -28,6 +28,13 @@ func getProvisioner() (string, error) {
 	return provisioner, err
 }
 
+func initializeProvisioner(p provision.Provisioner) error {
+	if initializableProvisioner, ok := p.(provision.InitializableProvisioner); ok {
+		return initializableProvisioner.Initialize()
+	}
+	return nil
+}
+
 type TsuruHandler struct {
 	method string
 	path   string
@@ -103,6 +110,12 @@ func RunServer(dry bool) http.Handler {
 	m.Add("Get", "/apps/{app}", authorizationRequiredHandler(appInfo))
 	m.Add("Post", "/apps/{app}/cname", authorizationRequiredHandler(setCName))
 	m.Add("Delete", "/apps/{app}/cname", authorizationRequiredHandler(unsetCName))
+	m.Add("Get", "/apps/{app}/certificate", authorizationRequiredHandler(listCertificates))
+	m.Add("Put", "/apps/{app}/certificate", authorizationRequiredHandler(setCertificate))
+	m.Add("Delete", "/apps/{app}/certificate", authorizationRequiredHandler(unsetCertificate))
+	m.Add("Get", "/apps/{app}/rules", authorizationRequiredHandler(listRules))
+	m.Add("Post", "/apps/{app}/rules", authorizationRequiredHandler(addRule))
+	m.Add("Delete", "/apps/{app}/rules/{name}", authorizationRequiredHandler(removeRule))
 	runHandler := authorizationRequiredHandler(runCommand)
 	m.Add("Post", "/apps/{app}/run", runHandler)
 	m.Add("Post", "/apps/{app}/restart", authorizationRequiredHandler(restart))
@@ -116,6 +129,8 @@ func RunServer(dry bool) http.Handler {
 	m.Add("Get", "/apps", authorizationRequiredHandler(appList))
 	m.Add("Post", "/apps", authorizationRequiredHandler(createApp))
 	m.Add("Post", "/apps/{app}/team-owner", authorizationRequiredHandler(setTeamOwner))
+	m.Add("Post", "/apps/{app}/provisioner", AdminRequiredHandler(changeAppProvisioner))
+	m.Add("Post", "/apps/{app}/router", AdminRequiredHandler(changeAppRouter))
 	forceDeleteLockHandler := AdminRequiredHandler(forceDeleteLock)
 	m.Add("Delete", "/apps/{app}/lock", forceDeleteLockHandler)
 	m.Add("Put", "/apps/{app}/units", authorizationRequiredHandler(addUnits))
@@ -136,9 +151,11 @@ func RunServer(dry bool) http.Handler {
 	m.Add("Put", "/autoscale/{app}", authorizationRequiredHandler(autoScaleConfig))
 	m.Add("Put", "/autoscale/{app}/enable", authorizationRequiredHandler(autoScaleEnable))
 	m.Add("Put", "/autoscale/{app}/disable", authorizationRequiredHandler(autoScaleDisable))
+	m.Add("Post", "/autoscale/{app}/simulate", authorizationRequiredHandler(autoScaleSimulate))
 
 	m.Add("Get", "/deploys", AdminRequiredHandler(deploysList))
 	m.Add("Get", "/deploys/{deploy}", authorizationRequiredHandler(deployInfo))
+	m.Add("Get", "/deploys/{deploy}/log", authorizationRequiredHandler(deployLog))
 
 	m.Add("Get", "/platforms", authorizationRequiredHandler(platformList))
 	m.Add("Post", "/platforms", AdminRequiredHandler(platformAdd))
@@ -151,6 +168,14 @@ func RunServer(dry bool) http.Handler {
 	m.Add("Get", "/apps/{appname}/available", authorizationRequiredHandler(appIsAvailable))
 	m.Add("Post", "/apps/{appname}/repository/clone", authorizationRequiredHandler(deploy))
 	m.Add("Post", "/apps/{appname}/deploy", authorizationRequiredHandler(deploy))
+	m.Add("Post", "/apps/{appname}/deploy/rollback", authorizationRequiredHandler(deployRollback))
+	m.Add("Post", "/apps/{appname}/deploy/promote", authorizationRequiredHandler(deployPromote))
+	m.Add("Post", "/apps/{appname}/canary", authorizationRequiredHandler(canaryStart))
+	m.Add("Post", "/apps/{appname}/canary/weight", authorizationRequiredHandler(canaryWeight))
+	m.Add("Post", "/apps/{appname}/canary/promote", authorizationRequiredHandler(canaryPromote))
+	m.Add("Post", "/apps/{appname}/canary/abort", authorizationRequiredHandler(canaryAbort))
+	deployCancelHandler := authorizationRequiredHandler(deployCancel)
+	m.Add("Delete", "/apps/{appname}/deploy", deployCancelHandler)
 
 	m.Add("Get", "/users", AdminRequiredHandler(listUsers))
 	m.Add("Post", "/users", Handler(createUser))
@@ -181,9 +206,13 @@ func RunServer(dry bool) http.Handler {
 	m.Add("Delete", "/teams/{team}/{user}", authorizationRequiredHandler(removeUserFromTeam))
 
 	m.Add("Put", "/swap", authorizationRequiredHandler(swap))
+	m.Add("Get", "/swap/last", AdminRequiredHandler(swapLast))
+	m.Add("Post", "/swap/undo", AdminRequiredHandler(swapUndo))
 
 	m.Add("Get", "/healthcheck/", http.HandlerFunc(healthcheck))
 
+	m.Add("Get", "/routers/galeb/operations", AdminRequiredHandler(galebOperationsList))
+
 	m.Add("Get", "/iaas/machines", AdminRequiredHandler(machinesList))
 	m.Add("Delete", "/iaas/machines/{machine_id}", AdminRequiredHandler(machineDestroy))
 	m.Add("Get", "/iaas/templates", AdminRequiredHandler(templatesList))
@@ -209,6 +238,7 @@ func RunServer(dry bool) http.Handler {
 		logPostHandler,
 		runHandler,
 		forceDeleteLockHandler,
+		deployCancelHandler,
 		registerUnitHandler,
 		saveCustomDataHandler,
 		setUnitStatusHandler,
@@ -225,11 +255,24 @@ func RunServer(dry bool) http.Handler {
 			fatal(err)
 		}
 		fmt.Printf("Using %q provisioner.\n\n", provisioner)
-		if initializableProvisioner, ok := app.Provisioner.(provision.InitializableProvisioner); ok {
-			err = initializableProvisioner.Initialize()
+		err = initializeProvisioner(app.Provisioner)
+		if err != nil {
+			fatal(err)
+		}
+		extraProvisioners, _ := config.GetList("provisioners")
+		for _, name := range extraProvisioners {
+			if name == provisioner {
+				continue
+			}
+			p, err := provision.Get(name)
+			if err != nil {
+				fatal(err)
+			}
+			err = initializeProvisioner(p)
 			if err != nil {
 				fatal(err)
 			}
+			fmt.Printf("Enabled %q provisioner.\n\n", name)
 		}
 		scheme, err := getAuthScheme()
 		if err != nil {
@@ -245,6 +288,7 @@ func RunServer(dry bool) http.Handler {
 			fatal(err)
 		}
 		app.StartAutoScale()
+		app.StartMovedBackendsWorker()
 		tls, _ := config.GetBool("use-tls")
 		if tls {
 			certFile, err := config.GetString("tls:cert-file")
@@ -6,6 +6,7 @@ package app
 
 import (
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/tsuru/config"
@@ -18,6 +19,7 @@ import (
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
+	"github.com/tsuru/tsuru/router"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -190,7 +192,11 @@ var provisionApp = action.Action{
 		default:
 			return nil, errors.New("First parameter must be *App.")
 		}
-		err := Provisioner.Provision(app)
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			return nil, err
+		}
+		err = prov.Provision(app)
 		if err != nil {
 			return nil, err
 		}
@@ -198,7 +204,9 @@ var provisionApp = action.Action{
 	},
 	Backward: func(ctx action.BWContext) {
 		app := ctx.FWResult.(*App)
-		Provisioner.Destroy(app)
+		if prov, err := app.GetProvisioner(); err == nil {
+			prov.Destroy(app)
+		}
 	},
 	MinParams: 1,
 }
@@ -218,7 +226,11 @@ var setAppIp = action.Action{
 			return nil, err
 		}
 		defer conn.Close()
-		app.Ip, err = Provisioner.Addr(app)
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			return nil, err
+		}
+		app.Ip, err = prov.Addr(app)
 		if err != nil {
 			return nil, err
 		}
@@ -307,23 +319,151 @@ var provisionAddUnits = action.Action{
 		if len(ctx.Params) >= 3 {
 			w, _ = ctx.Params[2].(io.Writer)
 		}
+		var process string
+		if len(ctx.Params) >= 4 {
+			process, _ = ctx.Params[3].(string)
+		}
 		n := ctx.Previous.(int)
-		units, err := Provisioner.AddUnits(app, uint(n), w)
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			return nil, err
+		}
+		units, err := prov.AddUnits(app, uint(n), process, w)
 		if err != nil {
 			return nil, err
 		}
 		return units, nil
 	},
 	Backward: func(ctx action.BWContext) {
+		app := ctx.Params[0].(*App)
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			log.Errorf("Failed to rollback provisionAddUnits: %s", err)
+			return
+		}
 		units := ctx.FWResult.([]provision.Unit)
 		for _, unit := range units {
-			Provisioner.RemoveUnit(unit)
+			prov.RemoveUnit(unit)
 		}
 	},
 	MinParams: 1,
 }
 
-// ProvisionerDeploy is an action that calls the Provisioner.Deploy.
+// releaseAppBackend removes the backend of the app from its router, while
+// changing the provisioner of the app, as the new provisioner adds its own
+// backend for the app. It takes three arguments: the app, the name of the new
+// provisioner and a writer. Its result is the list of routes of the backend,
+// or nil when the app has no backend.
+var releaseAppBackend = action.Action{
+	Name: "release-app-backend",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		app := ctx.Params[0].(*App)
+		_, err := router.Retrieve(app.Name)
+		if err == mgo.ErrNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		r, err := app.getRouter()
+		if err != nil {
+			return nil, err
+		}
+		routes, err := router.AppRoutes(r, app.Name)
+		if err != nil {
+			return nil, err
+		}
+		err = r.RemoveBackend(app.Name)
+		if err != nil {
+			return nil, err
+		}
+		return routes, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		app := ctx.Params[0].(*App)
+		routes, ok := ctx.FWResult.([]string)
+		if !ok {
+			return
+		}
+		err := app.restoreBackend(routes)
+		if err != nil {
+			log.Errorf("Failed to rollback releaseAppBackend: %s", err)
+		}
+	},
+	MinParams: 1,
+}
+
+// provisionAppInNewProvisioner provisions the app in the new provisioner,
+// while changing the provisioner of the app. It takes the same arguments as
+// releaseAppBackend.
+var provisionAppInNewProvisioner = action.Action{
+	Name: "provision-app-in-new-provisioner",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		app := ctx.Params[0].(*App)
+		w := ctx.Params[2].(io.Writer)
+		prov, err := getProvisioner(ctx.Params[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(w, "---- Provisioning app in the %q provisioner ----\n", ctx.Params[1])
+		err = prov.Provision(app)
+		if err != nil {
+			return nil, err
+		}
+		return prov, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		app := ctx.Params[0].(*App)
+		prov := ctx.FWResult.(provision.Provisioner)
+		err := prov.Destroy(app)
+		if err != nil {
+			log.Errorf("Failed to rollback provisionAppInNewProvisioner: %s", err)
+		}
+	},
+	MinParams: 3,
+}
+
+// saveAppProvisioner stores the name of the new provisioner in the app,
+// while changing the provisioner of the app. It takes the same arguments as
+// releaseAppBackend.
+var saveAppProvisioner = action.Action{
+	Name: "save-app-provisioner",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		app := ctx.Params[0].(*App)
+		name := ctx.Params[1].(string)
+		old := app.ProvisionerName
+		err := updateAppProvisioner(app, name)
+		if err != nil {
+			return nil, err
+		}
+		return old, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		app := ctx.Params[0].(*App)
+		err := updateAppProvisioner(app, ctx.FWResult.(string))
+		if err != nil {
+			log.Errorf("Failed to rollback saveAppProvisioner: %s", err)
+		}
+	},
+	MinParams: 2,
+}
+
+func updateAppProvisioner(app *App, name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"provisioner": name}})
+	if err != nil {
+		return err
+	}
+	app.ProvisionerName = name
+	return nil
+}
+
+// ProvisionerDeploy is an action that calls the Provisioner.Deploy. Its result
+// is the image generated by the deploy.
 var ProvisionerDeploy = action.Action{
 	Name: "provisioner-deploy",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -335,25 +475,41 @@ var ProvisionerDeploy = action.Action{
 		if !ok {
 			return nil, errors.New("Second parameter must be an io.Writer")
 		}
+		prov, err := opts.App.GetProvisioner()
+		if err != nil {
+			return nil, err
+		}
+		if opts.Image != "" {
+			deployer, ok := prov.(provision.ImageDeployer)
+			if !ok {
+				return nil, ErrImageDeployNotSupported
+			}
+			return deployer.ImageDeploy(opts.App, opts.Image, writer)
+		}
 		if opts.File != nil {
-			if deployer, ok := Provisioner.(provision.UploadDeployer); ok {
-				return nil, deployer.UploadDeploy(opts.App, opts.File, writer)
+			if deployer, ok := prov.(provision.UploadDeployer); ok {
+				return deployer.UploadDeploy(opts.App, opts.File, writer)
 			}
 		}
 		if opts.ArchiveURL != "" {
-			if deployer, ok := Provisioner.(provision.ArchiveDeployer); ok {
-				return nil, deployer.ArchiveDeploy(opts.App, opts.ArchiveURL, writer)
+			if deployer, ok := prov.(provision.ArchiveDeployer); ok {
+				return deployer.ArchiveDeploy(opts.App, opts.ArchiveURL, writer)
 			}
 		}
-		err := Provisioner.(provision.GitDeployer).GitDeploy(opts.App, opts.Version, writer)
-		return nil, err
+		deployer, ok := prov.(provision.GitDeployer)
+		if !ok {
+			return nil, errors.New("provisioner does not support git deployments")
+		}
+		return deployer.GitDeploy(opts.App, opts.Version, writer)
 	},
 	Backward: func(ctx action.BWContext) {
 	},
 	MinParams: 2,
 }
 
-// Increment is an actions that increments the deploy number.
+// Increment is an actions that increments the deploy number. It keeps the
+// result of the previous action, so the image generated by the deploy is the
+// result of the pipeline.
 var IncrementDeploy = action.Action{
 	Name: "increment-deploy",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -362,7 +518,7 @@ var IncrementDeploy = action.Action{
 			return nil, errors.New("First parameter must be DeployOptions")
 		}
 		err := incrementDeploy(opts.App)
-		return nil, err
+		return ctx.Previous, err
 	},
 	Backward: func(ctx action.BWContext) {
 	},
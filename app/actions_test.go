@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/action"
@@ -15,6 +16,7 @@ import (
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/quota"
+	rtesting "github.com/tsuru/tsuru/router/testing"
 	"github.com/tsuru/tsuru/testing"
 	"gopkg.in/mgo.v2/bson"
 	"launchpad.net/gocheck"
@@ -600,7 +602,7 @@ func (s *S) TestProvisionAddUnitsBackward(c *gocheck.C) {
 	}
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	units, err := s.provisioner.AddUnits(&app, 3, nil)
+	units, err := s.provisioner.AddUnits(&app, 3, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	ctx := action.BWContext{Params: []interface{}{&app}, FWResult: units}
 	provisionAddUnits.Backward(ctx)
@@ -653,12 +655,45 @@ func (s *S) TestProvisionerDeployArchiveForward(c *gocheck.C) {
 	writer := &bytes.Buffer{}
 	opts := DeployOptions{App: &a, ArchiveURL: "https://s3.amazonaws.com/smt/archive.tar.gz"}
 	ctx := action.FWContext{Params: []interface{}{opts, writer}}
-	_, err = ProvisionerDeploy.Forward(ctx)
+	image, err := ProvisionerDeploy.Forward(ctx)
 	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-someApp:v1")
 	logs := writer.String()
 	c.Assert(logs, gocheck.Equals, "Archive deploy called")
 }
 
+func (s *S) TestProvisionerDeployImageForward(c *gocheck.C) {
+	a := App{
+		Name:     "someApp",
+		Platform: "django",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	writer := &bytes.Buffer{}
+	opts := DeployOptions{App: &a, Image: "registry.example.com/someapp:1.0"}
+	ctx := action.FWContext{Params: []interface{}{opts, writer}}
+	image, err := ProvisionerDeploy.Forward(ctx)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-someApp:v1")
+	c.Assert(writer.String(), gocheck.Equals, "Image deploy called")
+}
+
+func (s *S) TestProvisionerDeployImageNotSupported(c *gocheck.C) {
+	Provisioner = minimalProvisioner{s.provisioner}
+	defer func() {
+		Provisioner = s.provisioner
+	}()
+	a := App{Name: "someApp", Platform: "django"}
+	opts := DeployOptions{App: &a, Image: "registry.example.com/someapp:1.0"}
+	ctx := action.FWContext{Params: []interface{}{opts, &bytes.Buffer{}}}
+	_, err := ProvisionerDeploy.Forward(ctx)
+	c.Assert(err, gocheck.Equals, ErrImageDeployNotSupported)
+}
+
 func (s *S) TestProvisionerDeployParams(c *gocheck.C) {
 	ctx := action.FWContext{Params: []interface{}{""}}
 	_, err := ProvisionerDeploy.Forward(ctx)
@@ -694,6 +729,22 @@ func (s *S) TestIncrementDeployForward(c *gocheck.C) {
 	c.Assert(a.Deploys, gocheck.Equals, uint(1))
 }
 
+func (s *S) TestIncrementDeployForwardReturnsPreviousResult(c *gocheck.C) {
+	a := App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	opts := DeployOptions{App: &a, Version: "version"}
+	ctx := action.FWContext{Params: []interface{}{opts, &bytes.Buffer{}}, Previous: "tsuru/app-otherapp:v1"}
+	result, err := IncrementDeploy.Forward(ctx)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.Equals, "tsuru/app-otherapp:v1")
+}
+
 func (s *S) TestIncrementDeployParams(c *gocheck.C) {
 	ctx := action.FWContext{Params: []interface{}{""}}
 	_, err := IncrementDeploy.Forward(ctx)
@@ -737,3 +788,71 @@ func (s *S) TestSetAppIpBackward(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(gotApp.Ip, gocheck.Equals, "")
 }
+
+func (s *S) TestReleaseAppBackendInfo(c *gocheck.C) {
+	c.Assert(releaseAppBackend.Name, gocheck.Equals, "release-app-backend")
+	c.Assert(releaseAppBackend.MinParams, gocheck.Equals, 1)
+}
+
+func (s *S) TestReleaseAppBackendForwardAndBackward(c *gocheck.C) {
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	ctx := action.FWContext{Params: []interface{}{a, "fake-other", ioutil.Discard}}
+	result, err := releaseAppBackend.Forward(ctx)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, false)
+	bwCtx := action.BWContext{Params: ctx.Params, FWResult: result}
+	releaseAppBackend.Backward(bwCtx)
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, true)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.10.10.10:8080"), gocheck.Equals, true)
+}
+
+func (s *S) TestReleaseAppBackendWithoutBackend(c *gocheck.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	ctx := action.FWContext{Params: []interface{}{&a, "fake-other", ioutil.Discard}}
+	result, err := releaseAppBackend.Forward(ctx)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.IsNil)
+	releaseAppBackend.Backward(action.BWContext{Params: ctx.Params, FWResult: result})
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, false)
+}
+
+func (s *S) TestProvisionAppInNewProvisionerInfo(c *gocheck.C) {
+	c.Assert(provisionAppInNewProvisioner.Name, gocheck.Equals, "provision-app-in-new-provisioner")
+	c.Assert(provisionAppInNewProvisioner.MinParams, gocheck.Equals, 3)
+}
+
+func (s *S) TestProvisionAppInNewProvisionerForwardAndBackward(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	a := App{Name: "myapp"}
+	ctx := action.FWContext{Params: []interface{}{&a, "fake-other", ioutil.Discard}}
+	result, err := provisionAppInNewProvisioner.Forward(ctx)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.Equals, other)
+	c.Assert(other.Provisioned(&a), gocheck.Equals, true)
+	bwCtx := action.BWContext{Params: ctx.Params, FWResult: result}
+	provisionAppInNewProvisioner.Backward(bwCtx)
+	c.Assert(other.Provisioned(&a), gocheck.Equals, false)
+}
+
+func (s *S) TestSaveAppProvisionerForwardAndBackward(c *gocheck.C) {
+	a := App{Name: "myapp", ProvisionerName: "fake"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	ctx := action.FWContext{Params: []interface{}{&a, "fake-other", ioutil.Discard}}
+	result, err := saveAppProvisioner.Forward(ctx)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.Equals, "fake")
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.ProvisionerName, gocheck.Equals, "fake-other")
+	bwCtx := action.BWContext{Params: ctx.Params, FWResult: result}
+	saveAppProvisioner.Backward(bwCtx)
+	c.Assert(a.ProvisionerName, gocheck.Equals, "fake")
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.ProvisionerName, gocheck.Equals, "fake")
+}
@@ -9,12 +9,14 @@ import (
 	stderr "errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/tsuru/config"
 	"github.com/tsuru/go-gandalfclient"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app/bind"
@@ -25,6 +27,7 @@ import (
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
+	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/service"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -38,6 +41,10 @@ var (
 	cnameRegexp     = regexp.MustCompile(`^(\*\.)?[a-zA-Z0-9][\w-.]+$`)
 	ErrAppNotEqual  = stderr.New("Apps are not equal.")
 	ErrUnitNotFound = stderr.New("unit not found")
+
+	ErrAppsProvisionerNotEqual = stderr.New("Apps are not handled by the same provisioner.")
+	ErrSameProvisioner         = stderr.New("App is already handled by this provisioner.")
+	ErrSameRouter              = stderr.New("App already uses this router.")
 )
 
 const InternalAppName = "tsr"
@@ -78,15 +85,94 @@ type App struct {
 	UpdatePlatform  bool
 	Lock            AppLock
 	CustomData      map[string]interface{}
+	Processes       map[string]string
 	Plan            Plan
 	AutoScaleConfig *AutoScaleConfig
+	ProvisionerName string `bson:"provisioner"`
+	Pool            string
+	Rules           []router.Rule
 
 	quota.Quota
 }
 
 // Units returns the list of units.
 func (app *App) Units() []provision.Unit {
-	return Provisioner.Units(app)
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		log.Errorf("Unable to get provisioner of app %q: %s", app.Name, err)
+		return nil
+	}
+	return prov.Units(app)
+}
+
+// UnitsByProcess returns the units of the app grouped by the name of their
+// processes. Units without a process are handled as units of the web
+// process.
+func (app *App) UnitsByProcess() map[string][]provision.Unit {
+	result := make(map[string][]provision.Unit)
+	for _, unit := range app.Units() {
+		name := unit.ProcessName
+		if name == "" {
+			name = provision.WebProcessName
+		}
+		result[name] = append(result[name], unit)
+	}
+	return result
+}
+
+// GetProvisioner returns the provisioner responsible for the app. Apps that
+// don't have a provisioner recorded are handled by the default provisioner.
+func (app *App) GetProvisioner() (provision.Provisioner, error) {
+	return getProvisioner(app.ProvisionerName)
+}
+
+func getProvisioner(name string) (provision.Provisioner, error) {
+	if name == "" || name == defaultProvisionerName() {
+		return Provisioner, nil
+	}
+	return provision.Get(name)
+}
+
+// validatePool checks that the pool of a new app exists and that the teams of
+// the app can use it. Pools are kept by the default provisioner, so apps can
+// only choose a pool when it's a provision.PoolManager.
+func (app *App) validatePool() error {
+	if app.Pool == "" {
+		return nil
+	}
+	manager, ok := Provisioner.(provision.PoolManager)
+	if !ok {
+		return provision.ErrPoolNotFound
+	}
+	return manager.ValidatePool(app.Pool, app.Teams)
+}
+
+// chooseProvisioner returns the name of the provisioner of a new app: the
+// provisioner of its pool, declared in the "pools:<pool>:provisioner" setting,
+// or the provisioner of its plan.
+func (app *App) chooseProvisioner() (string, error) {
+	if app.Pool == "" {
+		return app.Plan.getProvisioner(), nil
+	}
+	name, err := config.GetString("pools:" + app.Pool + ":provisioner")
+	if err != nil {
+		return app.Plan.getProvisioner(), nil
+	}
+	if _, err := getProvisioner(name); err != nil {
+		msg := fmt.Sprintf("The provisioner %q of the pool %q is not available.", name, app.Pool)
+		return "", &errors.ValidationError{Message: msg}
+	}
+	return name, nil
+}
+
+// defaultProvisionerName returns the name of the provisioner declared in the
+// "provisioner" setting, which is also the name of Provisioner.
+func defaultProvisionerName() string {
+	name, _ := config.GetString("provisioner")
+	if name == "" {
+		name = "docker"
+	}
+	return name
 }
 
 // MarshalJSON marshals the app in json format.
@@ -95,7 +181,7 @@ func (app *App) MarshalJSON() ([]byte, error) {
 	result["name"] = app.Name
 	result["platform"] = app.Platform
 	result["teams"] = app.Teams
-	result["units"] = app.Units()
+	result["units"] = app.UnitsByProcess()
 	result["repository"] = repository.ReadWriteURL(app.Name)
 	result["ip"] = app.Ip
 	result["cname"] = app.CName
@@ -105,6 +191,8 @@ func (app *App) MarshalJSON() ([]byte, error) {
 	result["teamowner"] = app.TeamOwner
 	result["plan"] = app.Plan
 	result["autoScaleConfig"] = app.AutoScaleConfig
+	result["provisioner"] = app.ProvisionerName
+	result["pool"] = app.Pool
 	return json.Marshal(&result)
 }
 
@@ -211,6 +299,14 @@ func CreateApp(app *App, user *auth.User) error {
 	}
 	app.Teams = []string{app.TeamOwner}
 	app.Owner = user.Email
+	err = app.validatePool()
+	if err != nil {
+		return err
+	}
+	app.ProvisionerName, err = app.chooseProvisioner()
+	if err != nil {
+		return err
+	}
 	err = app.validate()
 	if err != nil {
 		return err
@@ -263,7 +359,10 @@ func asyncDestroyAppProvisioner(app *App) *sync.WaitGroup {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err := Provisioner.Destroy(app)
+		prov, err := app.GetProvisioner()
+		if err == nil {
+			err = prov.Destroy(app)
+		}
 		if err != nil {
 			log.Errorf("Unable to destroy app in provisioner: %s", err.Error())
 		}
@@ -284,6 +383,14 @@ func asyncDestroyAppProvisioner(app *App) *sync.WaitGroup {
 //       3. Remove the app from the database
 func Delete(app *App) error {
 	appName := app.Name
+	isTarget, err := app.isRuleTarget()
+	if err == nil && isTarget {
+		err = ErrRuleTargetRemoval
+	}
+	if err != nil {
+		ReleaseApplicationLock(appName)
+		return err
+	}
 	wg := asyncDestroyAppProvisioner(app)
 	wg.Add(1)
 	defer wg.Done()
@@ -307,7 +414,7 @@ func Delete(app *App) error {
 	gandalfClient := gandalf.Client{Endpoint: repository.ServerURL()}
 	gandalfClient.RemoveRepository(appName)
 	token := app.Env["TSURU_APP_TOKEN"].Value
-	err := AuthScheme.Logout(token)
+	err = AuthScheme.Logout(token)
 	if err != nil {
 		log.Errorf("Unable to remove app token in destroy: %s", err.Error())
 	}
@@ -366,39 +473,57 @@ func (app *App) serviceInstances() ([]service.ServiceInstance, error) {
 	return instances, nil
 }
 
-// AddUnits creates n new units within the provisioner, saves new units in the
-// database and enqueues the apprc serialization.
-func (app *App) AddUnits(n uint, writer io.Writer) error {
+// AddUnits creates n new units of the given process within the provisioner,
+// saves new units in the database and enqueues the apprc serialization. An
+// empty process name means the default process of the app.
+func (app *App) AddUnits(n uint, process string, writer io.Writer) error {
 	if n == 0 {
 		return stderr.New("Cannot add zero units.")
 	}
 	err := action.NewPipeline(
 		&reserveUnitsToAdd,
 		&provisionAddUnits,
-	).Execute(app, n, writer)
+	).Execute(app, n, writer, process)
 	return err
 }
 
-// RemoveUnits removes n units from the app. It's a process composed of
-// multiple steps:
+// RemoveUnits removes n units of the given process from the app. It's a
+// process composed of multiple steps:
 //
 //     1. Remove units from the provisioner
 //     2. Remove units from the app list
 //     3. Update quota
-func (app *App) RemoveUnits(n uint) error {
+func (app *App) RemoveUnits(n uint, process string) error {
+	units := app.Units()
+	available := uint(len(units))
+	if process != "" {
+		if _, err := provision.ResolveProcess(process, app.Processes); err != nil {
+			ReleaseApplicationLock(app.Name)
+			return err
+		}
+		available = uint(len(app.UnitsByProcess()[process]))
+	}
 	if n == 0 {
 		ReleaseApplicationLock(app.Name)
 		return stderr.New("Cannot remove zero units.")
-	} else if l := uint(len(app.Units())); l == n {
+	} else if l := uint(len(units)); l == n {
 		ReleaseApplicationLock(app.Name)
 		return stderr.New("Cannot remove all units from an app.")
+	} else if n > available && process != "" {
+		ReleaseApplicationLock(app.Name)
+		return fmt.Errorf("Cannot remove %d units from the process %q, it has only %d units.", n, process, available)
 	} else if n > l {
 		ReleaseApplicationLock(app.Name)
 		return fmt.Errorf("Cannot remove %d units from this app, it has only %d units.", n, l)
 	}
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		ReleaseApplicationLock(app.Name)
+		return err
+	}
 	go func() {
 		defer ReleaseApplicationLock(app.Name)
-		Provisioner.RemoveUnits(app, n)
+		prov.RemoveUnits(app, n, process)
 		conn, err := db.Conn()
 		if err != nil {
 			log.Errorf("Error: %s", err)
@@ -421,9 +546,13 @@ func (app *App) RemoveUnits(n uint) error {
 
 // SetUnitStatus changes the status of the given unit.
 func (app *App) SetUnitStatus(unitName string, status provision.Status) error {
-	for _, unit := range app.Units() {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	for _, unit := range prov.Units(app) {
 		if strings.HasPrefix(unit.Name, unitName) {
-			return Provisioner.SetUnitStatus(unit, status)
+			return prov.SetUnitStatus(unit, status)
 		}
 	}
 	return ErrUnitNotFound
@@ -621,20 +750,28 @@ func (app *App) sourced(cmd string, w io.Writer, once bool) error {
 }
 
 func (app *App) run(cmd string, w io.Writer, once bool) error {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return err
+	}
 	if once {
-		return Provisioner.ExecuteCommandOnce(w, w, app, cmd)
+		return prov.ExecuteCommandOnce(w, w, app, cmd)
 	}
-	return Provisioner.ExecuteCommand(w, w, app, cmd)
+	return prov.ExecuteCommand(w, w, app, cmd)
 }
 
-// Restart runs the restart hook for the app, writing its output to w.
-func (app *App) Restart(w io.Writer) error {
+// Restart runs the restart hook for the app, writing its output to w. Only the
+// units of the given process are restarted, unless the process is empty.
+func (app *App) Restart(w io.Writer, process string) error {
 	err := log.Write(w, []byte("---- Restarting your app ----\n"))
 	if err != nil {
 		log.Errorf("[restart] error on write app log for the app %s - %s", app.Name, err)
 		return err
 	}
-	err = Provisioner.Restart(app, w)
+	prov, err := app.GetProvisioner()
+	if err == nil {
+		err = prov.Restart(app, process, w)
+	}
 	if err != nil {
 		log.Errorf("[restart] error on restart the app %s - %s", app.Name, err)
 		return err
@@ -642,9 +779,14 @@ func (app *App) Restart(w io.Writer) error {
 	return nil
 }
 
-func (app *App) Stop(w io.Writer) error {
+// Stop stops the units of the given process, or all units of the app when
+// the process is empty.
+func (app *App) Stop(w io.Writer, process string) error {
 	log.Write(w, []byte("\n ---> Stopping your app\n"))
-	err := Provisioner.Stop(app)
+	prov, err := app.GetProvisioner()
+	if err == nil {
+		err = prov.Stop(app, process)
+	}
 	if err != nil {
 		log.Errorf("[stop] error on stop the app %s - %s", app.Name, err)
 		return err
@@ -760,7 +902,11 @@ func (app *App) setEnvsToApp(envs []bind.EnvVar, publicOnly, shouldRestart bool,
 		if !shouldRestart {
 			return nil
 		}
-		return Provisioner.Restart(app, w)
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			return err
+		}
+		return prov.Restart(app, "", w)
 	}
 	return nil
 }
@@ -795,7 +941,11 @@ func (app *App) UnsetEnvs(variableNames []string, publicOnly bool, w io.Writer)
 		if err != nil {
 			return err
 		}
-		return Provisioner.Restart(app, w)
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			return err
+		}
+		return prov.Restart(app, "", w)
 	}
 	return nil
 }
@@ -812,7 +962,11 @@ func (app *App) AddCName(cnames ...string) error {
 		if cnameExists(cname) {
 			return stderr.New("cname already exists!")
 		}
-		if s, ok := Provisioner.(provision.CNameManager); ok {
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			return err
+		}
+		if s, ok := prov.(provision.CNameManager); ok {
 			if err := s.SetCName(app, cname); err != nil {
 				return err
 			}
@@ -845,7 +999,15 @@ func (app *App) RemoveCName(cnames ...string) error {
 		if count == 0 {
 			return stderr.New("cname not exists!")
 		}
-		if s, ok := Provisioner.(provision.CNameManager); ok {
+		err := app.RemoveCertificate(cname)
+		if err != nil && err != ErrCertificateNotFound {
+			return err
+		}
+		prov, err := app.GetProvisioner()
+		if err != nil {
+			return err
+		}
+		if s, ok := prov.(provision.CNameManager); ok {
 			if err := s.UnsetCName(app, cname); err != nil {
 				return err
 			}
@@ -1009,40 +1171,223 @@ func List(u *auth.User) ([]App, error) {
 	return apps, nil
 }
 
-// Swap calls the Provisioner.Swap.
-// And updates the app.CName in the database.
-func Swap(app1, app2 *App) error {
-	err := Provisioner.Swap(app1, app2)
+// ChangeProvisioner moves the app to the provisioner identified by the given
+// name. It's a process composed of the following steps:
+//
+//     1. Remove the backend of the app from its router
+//     2. Provision the app in the new provisioner
+//     3. Save the new provisioner of the app in the database
+//     4. Destroy the app in the previous provisioner
+//
+// A failure in the first three steps moves the app back to its previous
+// provisioner, with its units untouched. The units and images of the app in
+// the previous provisioner are only destroyed once the app is moved, and a
+// failure to destroy them is reported without moving the app back. After
+// changing the provisioner, the app has no units and must be deployed again.
+func (app *App) ChangeProvisioner(name string, w io.Writer) error {
+	current := app.ProvisionerName
+	if current == "" {
+		current = defaultProvisionerName()
+	}
+	if name == current {
+		return ErrSameProvisioner
+	}
+	if _, err := getProvisioner(name); err != nil {
+		return err
+	}
+	from, err := app.GetProvisioner()
 	if err != nil {
 		return err
 	}
-	conn, err := db.Conn()
+	if w == nil {
+		w = ioutil.Discard
+	}
+	pipeline := action.NewPipeline(
+		&releaseAppBackend,
+		&provisionAppInNewProvisioner,
+		&saveAppProvisioner,
+	)
+	err = pipeline.Execute(app, name, w)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-	app1.CName, app2.CName = app2.CName, app1.CName
-	updateCName := func(app *App) error {
-		app.Ip, err = Provisioner.Addr(app)
+	units := from.Units(app)
+	fmt.Fprintf(w, "---- Destroying %d units in the %q provisioner ----\n", len(units), current)
+	err = app.destroyInProvisioner(from)
+	if err != nil {
+		log.Errorf("Failed to destroy app %q in the provisioner %q: %s", app.Name, current, err)
+		fmt.Fprintf(w, "WARNING: failed to destroy the app in the %q provisioner: %s\n", current, err)
+	}
+	fmt.Fprintf(w, "\n ---> App %q moved from %q to %q provisioner. Deploy it again to create its units.\n", app.Name, current, name)
+	return nil
+}
+
+// destroyInProvisioner destroys the app in the given provisioner, that is no
+// longer the provisioner of the app. Provisioners remove the backend of the
+// app from the router when destroying it, so the backend added by the current
+// provisioner is restored afterwards.
+func (app *App) destroyInProvisioner(prov provision.Provisioner) error {
+	_, err := router.Retrieve(app.Name)
+	hasBackend := err == nil
+	err = prov.Destroy(app)
+	if hasBackend {
+		if _, retrieveErr := router.Retrieve(app.Name); retrieveErr == mgo.ErrNotFound {
+			if restoreErr := app.restoreBackend(nil); restoreErr != nil {
+				log.Errorf("Failed to restore the backend of app %q: %s", app.Name, restoreErr)
+			}
+		}
+	}
+	return err
+}
+
+// restoreBackend adds the backend of the app to its router again, with the
+// given routes and the cnames of the app.
+func (app *App) restoreBackend(routes []string) error {
+	r, err := app.getRouter()
+	if err != nil {
+		return err
+	}
+	err = r.AddBackend(app.Name)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		err = r.AddRoute(app.Name, router.RouteURL(route))
+		if err != nil {
+			return err
+		}
+	}
+	for _, cname := range app.CName {
+		err = r.SetCName(cname, app.Name)
 		if err != nil {
 			return err
 		}
-		return conn.Apps().Update(
-			bson.M{"name": app.Name},
-			bson.M{"$set": bson.M{"cname": app.CName, "ip": app.Ip}},
-		)
 	}
-	err = updateCName(app1)
+	return nil
+}
+
+// ChangeRouter moves the app to the router identified by the given name,
+// without downtime. It's a process composed of the following steps:
+//
+//     1. Add a backend with the routes, cnames and rules of the app to the new
+//        router
+//     2. Save the new router of the app in the database
+//     3. Send the certificates of the cnames of the app to the new router
+//     4. Remove the backend of the app from the previous router, after the
+//        grace period
+//
+// The previous router keeps answering requests during the grace period, so
+// clients have time to resolve the new address of the app. The removal is
+// stored in the database and done by the moved backends worker, which also
+// keeps the routes of the previous backend in sync with the units of the app
+// until then. A failure in the
+// first two steps moves the app back to its previous router. Apps involved in
+// path rules can't be moved, as the apps of the rules would use different
+// routers.
+func (app *App) ChangeRouter(name string, gracePeriod time.Duration) error {
+	current, err := app.GetRouter()
 	if err != nil {
 		return err
 	}
-	return updateCName(app2)
+	if name == current {
+		return ErrSameRouter
+	}
+	to, err := router.Get(name)
+	if err != nil {
+		return err
+	}
+	from, err := router.Get(current)
+	if err != nil {
+		return err
+	}
+	toRules, err := app.checkMovableRules(to)
+	if err != nil {
+		return err
+	}
+	routes, err := router.AppRoutes(from, app.Name)
+	if err != nil {
+		return err
+	}
+	addresses := make([]string, len(routes))
+	for i, route := range routes {
+		addresses[i] = router.RouteURL(route)
+	}
+	moved, err := router.Move(to, app.Name, addresses, app.CName)
+	if err != nil {
+		return err
+	}
+	if len(routes) > 0 {
+		var movedRoutes []string
+		movedRoutes, err = router.AppRoutes(to, app.Name)
+		if err == nil && len(movedRoutes) == 0 {
+			err = fmt.Errorf("The routes of the app weren't added to the router %q.", name)
+		}
+		if err != nil {
+			if undoErr := router.UndoMove(to, moved); undoErr != nil {
+				log.Errorf("Failed to move %q back to the router %q: %s", app.Name, current, undoErr)
+			}
+			return err
+		}
+	}
+	if toRules != nil {
+		err = toRules.SetRules(app.Name, app.Rules)
+		if err != nil {
+			if undoErr := router.UndoMove(to, moved); undoErr != nil {
+				log.Errorf("Failed to move %q back to the router %q: %s", app.Name, current, undoErr)
+			}
+			return err
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		router.UndoMove(to, moved)
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"plan.router": name}})
+	if err != nil {
+		if undoErr := router.UndoMove(to, moved); undoErr != nil {
+			log.Errorf("Failed to move %q back to the router %q: %s", app.Name, current, undoErr)
+		}
+		return err
+	}
+	app.Plan.Router = name
+	app.moveCertificates()
+	return scheduleMovedBackendRemoval(current, moved, gracePeriod)
+}
+
+// moveCertificates sends the certificates of the cnames of the app to its
+// router. Failures are only logged, as the certificates can be set again.
+func (app *App) moveCertificates() {
+	certificates, err := app.Certificates()
+	if err != nil || len(certificates) == 0 {
+		return
+	}
+	manager, err := app.certificateManager()
+	if err == nil {
+		for _, certificate := range certificates {
+			var key string
+			key, err = certificate.PrivateKey()
+			if err == nil {
+				err = manager.SetCertificate(app, certificate.CName, certificate.Certificate, key)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Errorf("Failed to send the certificates of %q to its new router: %s", app.Name, err)
+	}
 }
 
-// Start starts the app calling the provisioner.Start method and
-// changing the units state to StatusStarted.
-func (app *App) Start(w io.Writer) error {
-	err := Provisioner.Start(app)
+// Start starts the units of the given process, or all units of the app when
+// the process is empty.
+func (app *App) Start(w io.Writer, process string) error {
+	prov, err := app.GetProvisioner()
+	if err == nil {
+		err = prov.Start(app, process)
+	}
 	if err != nil {
 		log.Errorf("[start] error on start the app %s - %s", app.Name, err)
 		return err
@@ -1066,26 +1411,64 @@ func (app *App) GetUpdatePlatform() bool {
 }
 
 func (app *App) RegisterUnit(unitId string) error {
-	for _, unit := range app.Units() {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	for _, unit := range prov.Units(app) {
 		if strings.HasPrefix(unit.Name, unitId) {
-			return Provisioner.RegisterUnit(unit)
+			return prov.RegisterUnit(unit)
 		}
 	}
 	return ErrUnitNotFound
 }
 
+// UpdateCustomData stores the custom data sent by the units of the app
+// during the deploy. When the custom data includes the content of the
+// Procfile of the app, under the "procfile" key, it also records the
+// processes declared in the Procfile.
 func (app *App) UpdateCustomData(customData map[string]interface{}) error {
+	update := bson.M{"customdata": customData}
+	if procfile, ok := customData["procfile"].(string); ok {
+		processes, err := provision.ParseProcfile(procfile)
+		if err != nil {
+			return err
+		}
+		app.Processes = processes
+		update["processes"] = processes
+	}
 	app.CustomData = customData
 	conn, err := db.Conn()
 	if err != nil {
 		return err
 	}
-	return conn.Apps().Update(
-		bson.M{"name": app.Name},
-		bson.M{"$set": bson.M{"customdata": app.CustomData}},
-	)
+	defer conn.Close()
+	return conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": update})
+}
+
+// SetProcesses records the processes declared in the Procfile of the app.
+func (app *App) SetProcesses(processes map[string]string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"processes": processes}})
+	if err != nil {
+		return err
+	}
+	app.Processes = processes
+	return nil
 }
 
 func (app *App) GetRouter() (string, error) {
 	return app.Plan.getRouter()
 }
+
+func (app *App) getRouter() (router.Router, error) {
+	name, err := app.GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	return router.Get(name)
+}
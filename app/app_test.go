@@ -24,6 +24,9 @@ import (
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
+	"github.com/tsuru/tsuru/router"
+	_ "github.com/tsuru/tsuru/router/proxy"
+	rtesting "github.com/tsuru/tsuru/router/testing"
 	"github.com/tsuru/tsuru/service"
 	"github.com/tsuru/tsuru/testing"
 	"gopkg.in/mgo.v2/bson"
@@ -487,10 +490,10 @@ func (s *S) TestAddUnits(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": app.Name})
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	err = app.AddUnits(5, nil)
+	err = app.AddUnits(5, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(app.Units(), gocheck.HasLen, 5)
-	err = app.AddUnits(2, nil)
+	err = app.AddUnits(2, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(app.Units(), gocheck.HasLen, 7)
 	for _, unit := range app.Units() {
@@ -509,7 +512,7 @@ func (s *S) TestAddUnitsWithWriter(c *gocheck.C) {
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
 	var buf bytes.Buffer
-	err = app.AddUnits(2, &buf)
+	err = app.AddUnits(2, "", &buf)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(app.Units(), gocheck.HasLen, 2)
 	for _, unit := range app.Units() {
@@ -529,11 +532,11 @@ func (s *S) TestAddUnitsQuota(c *gocheck.C) {
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
 	otherApp := App{Name: "warpaint"}
-	err = otherApp.AddUnits(5, nil)
+	err = otherApp.AddUnits(5, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	units := s.provisioner.GetUnits(&app)
 	c.Assert(units, gocheck.HasLen, 5)
-	err = otherApp.AddUnits(2, nil)
+	err = otherApp.AddUnits(2, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	units = s.provisioner.GetUnits(&app)
 	c.Assert(units, gocheck.HasLen, 7)
@@ -546,7 +549,7 @@ func (s *S) TestAddUnitsQuotaExceeded(c *gocheck.C) {
 	app := App{Name: "warpaint", Platform: "ruby"}
 	s.conn.Apps().Insert(app)
 	defer s.conn.Apps().Remove(bson.M{"name": app.Name})
-	err := app.AddUnits(1, nil)
+	err := app.AddUnits(1, "", nil)
 	e, ok := err.(*quota.QuotaExceededError)
 	c.Assert(ok, gocheck.Equals, true)
 	c.Assert(e.Available, gocheck.Equals, uint(0))
@@ -562,7 +565,7 @@ func (s *S) TestAddUnitsMultiple(c *gocheck.C) {
 	}
 	s.conn.Apps().Insert(app)
 	defer s.conn.Apps().Remove(bson.M{"name": app.Name})
-	err := app.AddUnits(11, nil)
+	err := app.AddUnits(11, "", nil)
 	e, ok := err.(*quota.QuotaExceededError)
 	c.Assert(ok, gocheck.Equals, true)
 	c.Assert(e.Available, gocheck.Equals, uint(10))
@@ -571,7 +574,7 @@ func (s *S) TestAddUnitsMultiple(c *gocheck.C) {
 
 func (s *S) TestAddZeroUnits(c *gocheck.C) {
 	app := App{Name: "warpaint", Platform: "ruby"}
-	err := app.AddUnits(0, nil)
+	err := app.AddUnits(0, "", nil)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "Cannot add zero units.")
 }
@@ -580,7 +583,7 @@ func (s *S) TestAddUnitsFailureInProvisioner(c *gocheck.C) {
 	app := App{Name: "scars", Platform: "golang", Quota: quota.Unlimited}
 	s.conn.Apps().Insert(app)
 	defer s.conn.Apps().Remove(bson.M{"name": app.Name})
-	err := app.AddUnits(2, nil)
+	err := app.AddUnits(2, "", nil)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "App is not provisioned.")
 }
@@ -590,7 +593,7 @@ func (s *S) TestAddUnitsIsAtomic(c *gocheck.C) {
 		Name: "warpaint", Platform: "golang",
 		Quota: quota.Unlimited,
 	}
-	err := app.AddUnits(2, nil)
+	err := app.AddUnits(2, "", nil)
 	c.Assert(err, gocheck.NotNil)
 	_, err = GetByName(app.Name)
 	c.Assert(err, gocheck.Equals, ErrAppNotFound)
@@ -606,9 +609,9 @@ func (s *S) TestRemoveUnitsWithQuota(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 5, nil)
+	s.provisioner.AddUnits(&a, 5, "", nil)
 	defer s.provisioner.Destroy(&a)
-	err = a.RemoveUnits(4)
+	err = a.RemoveUnits(4, "")
 	c.Assert(err, gocheck.IsNil)
 	time.Sleep(1e9)
 	app, err := GetByName(a.Name)
@@ -645,8 +648,8 @@ func (s *S) TestRemoveUnits(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	app.AddUnits(4, nil)
-	err = app.RemoveUnits(2)
+	app.AddUnits(4, "", nil)
+	err = app.RemoveUnits(2, "")
 	c.Assert(err, gocheck.IsNil)
 	time.Sleep(1e9)
 	ts.Close()
@@ -677,19 +680,51 @@ func (s *S) TestRemoveUnitsInvalidValues(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": app.Name})
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	s.provisioner.AddUnits(&app, 3, nil)
+	s.provisioner.AddUnits(&app, 3, "", nil)
 	for _, test := range tests {
-		err := app.RemoveUnits(test.n)
+		err := app.RemoveUnits(test.n, "")
 		c.Check(err, gocheck.NotNil)
 		c.Check(err.Error(), gocheck.Equals, test.expected)
 	}
 }
 
+func (s *S) TestRemoveUnitsProcess(c *gocheck.C) {
+	app := App{
+		Name:      "chemistryii",
+		Platform:  "python",
+		Processes: map[string]string{"web": "python app.py", "worker": "python worker.py"},
+	}
+	err := s.conn.Apps().Insert(app)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": app.Name})
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	s.provisioner.AddUnits(&app, 2, "web", nil)
+	s.provisioner.AddUnits(&app, 1, "worker", nil)
+	err = app.RemoveUnits(2, "worker")
+	c.Assert(err, gocheck.ErrorMatches, `Cannot remove 2 units from the process "worker", it has only 1 units.`)
+	err = app.RemoveUnits(1, "clock")
+	c.Assert(err, gocheck.FitsTypeOf, &provision.InvalidProcessError{})
+}
+
+func (s *S) TestUnitsByProcess(c *gocheck.C) {
+	app := App{Name: "chemistryii", Platform: "python"}
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	s.provisioner.AddUnits(&app, 2, "web", nil)
+	s.provisioner.AddUnits(&app, 1, "worker", nil)
+	units := app.UnitsByProcess()
+	c.Assert(units, gocheck.HasLen, 2)
+	c.Assert(units["web"], gocheck.HasLen, 2)
+	c.Assert(units["worker"], gocheck.HasLen, 1)
+	c.Assert(units["worker"][0].ProcessName, gocheck.Equals, "worker")
+}
+
 func (s *S) TestSetUnitStatus(c *gocheck.C) {
 	a := App{Name: "appName", Platform: "python"}
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 3, nil)
+	s.provisioner.AddUnits(&a, 3, "", nil)
 	units := a.Units()
 	err := a.SetUnitStatus(units[0].Name, provision.StatusError)
 	c.Assert(err, gocheck.IsNil)
@@ -701,7 +736,7 @@ func (s *S) TestSetUnitStatusPartialID(c *gocheck.C) {
 	a := App{Name: "appName", Platform: "python"}
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 3, nil)
+	s.provisioner.AddUnits(&a, 3, "", nil)
 	units := a.Units()
 	name := units[0].Name
 	err := a.SetUnitStatus(name[0:len(name)-2], provision.StatusError)
@@ -1467,7 +1502,7 @@ func (s *S) TestRestart(c *gocheck.C) {
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
 	var b bytes.Buffer
-	err := a.Restart(&b)
+	err := a.Restart(&b, "")
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(b.String(), gocheck.Matches, "(?s).*---- Restarting your app ----.*")
 	restarts := s.provisioner.Restarts(&a)
@@ -1482,7 +1517,7 @@ func (s *S) TestStop(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	var buf bytes.Buffer
-	err = a.Stop(&buf)
+	err = a.Stop(&buf, "")
 	c.Assert(err, gocheck.IsNil)
 	err = s.conn.Apps().Find(bson.M{"name": a.GetName()}).One(&a)
 	c.Assert(err, gocheck.IsNil)
@@ -1672,7 +1707,7 @@ func (s *S) TestGetUnits(c *gocheck.C) {
 	app := App{Name: "app"}
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	s.provisioner.AddUnits(&app, 1, nil)
+	s.provisioner.AddUnits(&app, 1, "", nil)
 	c.Assert(app.GetUnits(), gocheck.HasLen, 1)
 	c.Assert(app.Units()[0].Ip, gocheck.Equals, app.GetUnits()[0].GetIp())
 }
@@ -1701,7 +1736,7 @@ func (s *S) TestAppMarshalJSON(c *gocheck.C) {
 	expected["platform"] = "Framework"
 	expected["repository"] = repository.ReadWriteURL(app.Name)
 	expected["teams"] = []interface{}{"team1"}
-	expected["units"] = nil
+	expected["units"] = map[string]interface{}{}
 	expected["ip"] = "10.10.10.1"
 	expected["cname"] = []interface{}{"name.mycompany.com"}
 	expected["owner"] = "appOwner"
@@ -1746,6 +1781,38 @@ func (s *S) TestAppMarshalJSON(c *gocheck.C) {
 	c.Assert(decrease["wait"], gocheck.Equals, decreaseExpected["wait"])
 }
 
+func (s *S) TestAppMarshalJSONUnitsByProcess(c *gocheck.C) {
+	app := App{Name: "chemistryii", Platform: "python"}
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	s.provisioner.AddUnits(&app, 2, "web", nil)
+	s.provisioner.AddUnits(&app, 1, "worker", nil)
+	data, err := app.MarshalJSON()
+	c.Assert(err, gocheck.IsNil)
+	var result struct {
+		Units map[string][]provision.Unit
+	}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result.Units, gocheck.HasLen, 2)
+	c.Assert(result.Units["web"], gocheck.HasLen, 2)
+	c.Assert(result.Units["worker"], gocheck.HasLen, 1)
+}
+
+func (s *S) TestSetProcesses(c *gocheck.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	processes := map[string]string{"web": "python app.py", "worker": "python worker.py"}
+	err = a.SetProcesses(processes)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Processes, gocheck.DeepEquals, processes)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Processes, gocheck.DeepEquals, processes)
+}
+
 func (s *S) TestAppMarshalJSONReady(c *gocheck.C) {
 	app := App{
 		Name:      "name",
@@ -1758,13 +1825,16 @@ func (s *S) TestAppMarshalJSONReady(c *gocheck.C) {
 		Deploys:   7,
 		TeamOwner: "myteam",
 		Plan:      Plan{Name: "myplan", Memory: 64, Swap: 128, CpuShare: 100},
+
+		ProvisionerName: "fake",
+		Pool:            "mypool",
 	}
 	expected := make(map[string]interface{})
 	expected["name"] = "name"
 	expected["platform"] = "Framework"
 	expected["repository"] = repository.ReadWriteURL(app.Name)
 	expected["teams"] = []interface{}{"team1"}
-	expected["units"] = nil
+	expected["units"] = map[string]interface{}{}
 	expected["ip"] = "10.10.10.1"
 	expected["cname"] = []interface{}{"name.mycompany.com"}
 	expected["owner"] = "appOwner"
@@ -1773,6 +1843,8 @@ func (s *S) TestAppMarshalJSONReady(c *gocheck.C) {
 	expected["autoScaleConfig"] = nil
 	expected["plan"] = map[string]interface{}{"name": "myplan", "memory": float64(64), "swap": float64(128), "cpushare": float64(100)}
 	expected["ready"] = true
+	expected["provisioner"] = "fake"
+	expected["pool"] = "mypool"
 	data, err := app.MarshalJSON()
 	c.Assert(err, gocheck.IsNil)
 	result := make(map[string]interface{})
@@ -1788,7 +1860,7 @@ func (s *S) TestRun(c *gocheck.C) {
 	}
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	s.provisioner.AddUnits(&app, 1, nil)
+	s.provisioner.AddUnits(&app, 1, "", nil)
 	var buf bytes.Buffer
 	err := app.Run("ls -lh", &buf, false)
 	c.Assert(err, gocheck.IsNil)
@@ -1807,7 +1879,7 @@ func (s *S) TestRunOnce(c *gocheck.C) {
 	}
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	s.provisioner.AddUnits(&app, 1, nil)
+	s.provisioner.AddUnits(&app, 1, "", nil)
 	var buf bytes.Buffer
 	err := app.Run("ls -lh", &buf, true)
 	c.Assert(err, gocheck.IsNil)
@@ -1826,7 +1898,7 @@ func (s *S) TestRunWithoutEnv(c *gocheck.C) {
 	}
 	s.provisioner.Provision(&app)
 	defer s.provisioner.Destroy(&app)
-	s.provisioner.AddUnits(&app, 1, nil)
+	s.provisioner.AddUnits(&app, 1, "", nil)
 	var buf bytes.Buffer
 	err := app.run("ls -lh", &buf, false)
 	c.Assert(err, gocheck.IsNil)
@@ -1994,7 +2066,7 @@ func (s *S) TestAppUnits(c *gocheck.C) {
 	a := App{Name: "anycolor"}
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	c.Assert(a.Units(), gocheck.HasLen, 1)
 }
 
@@ -2004,42 +2076,12 @@ func (s *S) TestAppAvailable(c *gocheck.C) {
 	}
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 1, nil)
+	s.provisioner.AddUnits(&a, 1, "", nil)
 	c.Assert(a.Available(), gocheck.Equals, true)
-	s.provisioner.Stop(&a)
+	s.provisioner.Stop(&a, "")
 	c.Assert(a.Available(), gocheck.Equals, false)
 }
 
-func (s *S) TestSwap(c *gocheck.C) {
-	var err error
-	app1 := &App{Name: "app1", CName: []string{"cname"}}
-	err = s.provisioner.Provision(app1)
-	c.Assert(err, gocheck.IsNil)
-	app1.Ip, err = s.provisioner.Addr(app1)
-	c.Assert(err, gocheck.IsNil)
-	oldIp1 := app1.Ip
-	err = s.conn.Apps().Insert(app1)
-	c.Assert(err, gocheck.IsNil)
-	app2 := &App{Name: "app2"}
-	err = s.provisioner.Provision(app2)
-	c.Assert(err, gocheck.IsNil)
-	app2.Ip, err = s.provisioner.Addr(app2)
-	c.Assert(err, gocheck.IsNil)
-	oldIp2 := app2.Ip
-	err = s.conn.Apps().Insert(app2)
-	c.Assert(err, gocheck.IsNil)
-	defer func() {
-		s.conn.Apps().Remove(bson.M{"name": app1.Name})
-		s.conn.Apps().Remove(bson.M{"name": app2.Name})
-	}()
-	err = Swap(app1, app2)
-	c.Assert(err, gocheck.IsNil)
-	c.Assert(app1.CName, gocheck.IsNil)
-	c.Assert(app2.CName, gocheck.DeepEquals, []string{"cname"})
-	c.Assert(app1.Ip, gocheck.Equals, oldIp2)
-	c.Assert(app2.Ip, gocheck.Equals, oldIp1)
-}
-
 func (s *S) TestStart(c *gocheck.C) {
 	s.provisioner.PrepareOutput([]byte("not yaml")) // loadConf
 	a := App{
@@ -2053,7 +2095,7 @@ func (s *S) TestStart(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	var b bytes.Buffer
-	err = a.Start(&b)
+	err = a.Start(&b, "")
 	c.Assert(err, gocheck.IsNil)
 	starts := s.provisioner.Starts(&a)
 	c.Assert(starts, gocheck.Equals, 1)
@@ -2141,7 +2183,7 @@ func (s *S) TestAppRegisterUnit(c *gocheck.C) {
 	a := App{Name: "appName", Platform: "python"}
 	s.provisioner.Provision(&a)
 	defer s.provisioner.Destroy(&a)
-	s.provisioner.AddUnits(&a, 3, nil)
+	s.provisioner.AddUnits(&a, 3, "", nil)
 	units := a.Units()
 	var ips []string
 	for _, u := range units {
@@ -2230,3 +2272,368 @@ func (s *S) TestUpdateCustomData(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(dbApp.CustomData, gocheck.DeepEquals, customData)
 }
+
+func (s *S) TestUpdateCustomDataProcfile(c *gocheck.C) {
+	a := App{Name: "my-test-app"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	customData := map[string]interface{}{
+		"procfile": "web: python app.py\nworker: python worker.py\n",
+	}
+	err = a.UpdateCustomData(customData)
+	c.Assert(err, gocheck.IsNil)
+	expected := map[string]string{"web": "python app.py", "worker": "python worker.py"}
+	c.Assert(a.Processes, gocheck.DeepEquals, expected)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Processes, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestUpdateCustomDataInvalidProcfile(c *gocheck.C) {
+	a := App{Name: "my-test-app"}
+	err := a.UpdateCustomData(map[string]interface{}{"procfile": "web python app.py"})
+	c.Assert(err, gocheck.ErrorMatches, `invalid Procfile line: "web python app.py"`)
+}
+
+func (s *S) TestAppGetProvisioner(c *gocheck.C) {
+	a := App{Name: "myapp"}
+	prov, err := a.GetProvisioner()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(prov, gocheck.Equals, s.provisioner)
+	a.ProvisionerName = "docker"
+	prov, err = a.GetProvisioner()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(prov, gocheck.Equals, s.provisioner)
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	a.ProvisionerName = "fake-other"
+	prov, err = a.GetProvisioner()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(prov, gocheck.Equals, other)
+	a.ProvisionerName = "unknown"
+	_, err = a.GetProvisioner()
+	c.Assert(err, gocheck.ErrorMatches, `unknown provisioner: "unknown"`)
+}
+
+func (s *S) TestAppUnitsDispatchToAppProvisioner(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	a := App{Name: "myapp", ProvisionerName: "fake-other"}
+	other.Provision(&a)
+	other.AddUnits(&a, 2, "", nil)
+	c.Assert(a.Units(), gocheck.HasLen, 2)
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, false)
+}
+
+func (s *S) TestCreateAppRecordsProvisioner(c *gocheck.C) {
+	ts := testing.StartGandalfTestServer(&testHandler{})
+	defer ts.Close()
+	a := App{Name: "appname", Platform: "python"}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer Delete(&a)
+	retrievedApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(retrievedApp.ProvisionerName, gocheck.Equals, "docker")
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, true)
+}
+
+func (s *S) TestCreateAppWithPlanProvisioner(c *gocheck.C) {
+	ts := testing.StartGandalfTestServer(&testHandler{})
+	defer ts.Close()
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	plan := Plan{Name: "other-plan", Memory: 1, Swap: 2, CpuShare: 3, Provisioner: "fake-other"}
+	err := s.conn.Plans().Insert(plan)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(plan.Name)
+	a := App{Name: "appname", Platform: "python", Plan: Plan{Name: "other-plan"}}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer Delete(&a)
+	retrievedApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(retrievedApp.ProvisionerName, gocheck.Equals, "fake-other")
+	c.Assert(other.Provisioned(&a), gocheck.Equals, true)
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, false)
+}
+
+func (s *S) TestCreateAppWithPoolProvisioner(c *gocheck.C) {
+	ts := testing.StartGandalfTestServer(&testHandler{})
+	defer ts.Close()
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	config.Set("pools:pool1:provisioner", "fake-other")
+	defer config.Unset("pools")
+	s.provisioner.AddPool("pool1")
+	a := App{Name: "appname", Platform: "python", Pool: "pool1"}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer Delete(&a)
+	retrievedApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(retrievedApp.ProvisionerName, gocheck.Equals, "fake-other")
+	c.Assert(retrievedApp.Pool, gocheck.Equals, "pool1")
+	c.Assert(other.Provisioned(&a), gocheck.Equals, true)
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, false)
+}
+
+func (s *S) TestCreateAppWithPoolWithoutProvisioner(c *gocheck.C) {
+	ts := testing.StartGandalfTestServer(&testHandler{})
+	defer ts.Close()
+	s.provisioner.AddPool("pool2", s.team.Name)
+	a := App{Name: "appname", Platform: "python", Pool: "pool2"}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer Delete(&a)
+	c.Assert(a.ProvisionerName, gocheck.Equals, "docker")
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, true)
+}
+
+func (s *S) TestCreateAppWithPoolUnknownProvisioner(c *gocheck.C) {
+	config.Set("pools:pool1:provisioner", "unknown")
+	defer config.Unset("pools")
+	s.provisioner.AddPool("pool1")
+	a := App{Name: "appname", Platform: "python", Pool: "pool1"}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, `The provisioner "unknown" of the pool "pool1" is not available.`)
+}
+
+func (s *S) TestCreateAppWithUnknownPool(c *gocheck.C) {
+	a := App{Name: "appname", Platform: "python", Pool: "pool1"}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, gocheck.Equals, provision.ErrPoolNotFound)
+	_, err = GetByName(a.Name)
+	c.Assert(err, gocheck.Equals, ErrAppNotFound)
+}
+
+func (s *S) TestCreateAppWithPoolOfOtherTeam(c *gocheck.C) {
+	config.Set("pools:pool1:provisioner", "fake-other")
+	defer config.Unset("pools")
+	s.provisioner.AddPool("pool1", "otherteam")
+	a := App{Name: "appname", Platform: "python", Pool: "pool1"}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, gocheck.Equals, provision.ErrPoolNotAllowed)
+	_, err = GetByName(a.Name)
+	c.Assert(err, gocheck.Equals, ErrAppNotFound)
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, false)
+}
+
+func (s *S) TestChangeProvisioner(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	a := App{Name: "myapp", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	s.provisioner.AddUnits(&a, 2, "", nil)
+	var buf bytes.Buffer
+	err = a.ChangeProvisioner("fake-other", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.ProvisionerName, gocheck.Equals, "fake-other")
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, false)
+	c.Assert(other.Provisioned(&a), gocheck.Equals, true)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.ProvisionerName, gocheck.Equals, "fake-other")
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*App "myapp" moved from "docker" to "fake-other" provisioner.*`)
+}
+
+func (s *S) TestChangeProvisionerSameProvisioner(c *gocheck.C) {
+	a := App{Name: "myapp", ProvisionerName: "docker"}
+	err := a.ChangeProvisioner("docker", nil)
+	c.Assert(err, gocheck.Equals, ErrSameProvisioner)
+	a.ProvisionerName = ""
+	err = a.ChangeProvisioner("docker", nil)
+	c.Assert(err, gocheck.Equals, ErrSameProvisioner)
+}
+
+func (s *S) TestChangeProvisionerUnknownProvisioner(c *gocheck.C) {
+	a := App{Name: "myapp"}
+	err := a.ChangeProvisioner("unknown", nil)
+	c.Assert(err, gocheck.ErrorMatches, `unknown provisioner: "unknown"`)
+}
+
+func (s *S) TestChangeProvisionerRollback(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	other.PrepareFailure("Provision", stderr.New("provision failed"))
+	provision.Register("fake-other", other)
+	a := App{Name: "myapp", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	s.provisioner.AddUnits(&a, 2, "", nil)
+	err = a.ChangeProvisioner("fake-other", nil)
+	c.Assert(err, gocheck.ErrorMatches, "provision failed")
+	c.Assert(a.ProvisionerName, gocheck.Equals, "")
+	c.Assert(s.provisioner.Provisioned(&a), gocheck.Equals, true)
+	c.Assert(s.provisioner.GetUnits(&a), gocheck.HasLen, 2)
+	c.Assert(other.Provisioned(&a), gocheck.Equals, false)
+}
+
+func (s *S) TestChangeProvisionerRollbackRestoresBackend(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	other.PrepareFailure("Provision", stderr.New("provision failed"))
+	provision.Register("fake-other", other)
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	s.provisioner.Provision(a)
+	defer s.provisioner.Destroy(a)
+	err := a.ChangeProvisioner("fake-other", nil)
+	c.Assert(err, gocheck.ErrorMatches, "provision failed")
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, true)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.10.10.10:8080"), gocheck.Equals, true)
+}
+
+func (s *S) TestChangeProvisionerDestroyFailure(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	a := App{Name: "myapp", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.PrepareFailure("Destroy", stderr.New("destroy failed"))
+	var buf bytes.Buffer
+	err = a.ChangeProvisioner("fake-other", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.ProvisionerName, gocheck.Equals, "fake-other")
+	c.Assert(other.Provisioned(&a), gocheck.Equals, true)
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*WARNING: failed to destroy the app in the "docker" provisioner: destroy failed.*`)
+}
+
+func (s *S) createRouterApp(c *gocheck.C) *App {
+	config.Set("proxy:domain", "tsuru.io")
+	rtesting.FakeRouter.Reset()
+	a := App{Name: "myapp", CName: []string{"myapp.example.com"}, Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	return &a
+}
+
+func (s *S) removeRouterApp(a *App) {
+	if r, err := router.Get(a.Plan.Router); err == nil {
+		r.RemoveBackend(a.Name)
+	}
+	router.Remove(a.Name)
+	s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.conn.MovedBackends().RemoveAll(nil)
+	config.Unset("proxy:domain")
+}
+
+func (s *S) TestChangeRouter(c *gocheck.C) {
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	err := a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Plan.Router, gocheck.Equals, "proxy")
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Plan.Router, gocheck.Equals, "proxy")
+	r, err := router.Get("proxy")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, true)
+	var moved []movedBackend
+	err = s.conn.MovedBackends().Find(nil).All(&moved)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(moved, gocheck.HasLen, 1)
+	c.Assert(moved[0].Router, gocheck.Equals, "fake")
+	c.Assert(moved[0].Backend, gocheck.DeepEquals, router.MovedBackend{App: a.Name, Backend: a.Name, Kind: "fake"})
+	c.Assert(moved[0].RemoveAt.After(time.Now().Add(59*time.Minute)), gocheck.Equals, true)
+}
+
+func (s *S) TestChangeRouterHostPortRoutes(c *gocheck.C) {
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	err := rtesting.FakeRouter.RemoveRoute(a.Name, "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, a.Name)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.IsNil)
+	r, err := router.Get("proxy")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+}
+
+func (s *S) TestChangeRouterSyncsMovedBackend(c *gocheck.C) {
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	err := a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.IsNil)
+	r, err := router.Get("proxy")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute(a.Name, "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.RemoveRoute(a.Name, "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	runMovedBackendsOnce(time.Now())
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.10.10.10:8080"), gocheck.Equals, false)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.10.10.11:8080"), gocheck.Equals, true)
+	count, err := s.conn.MovedBackends().Find(nil).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 1)
+}
+
+func (s *S) TestChangeRouterRemovesMovedBackend(c *gocheck.C) {
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	err := a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.IsNil)
+	runMovedBackendsOnce(time.Now().Add(2 * time.Hour))
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, false)
+	count, err := s.conn.MovedBackends().Find(nil).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 0)
+	r, err := router.Get("proxy")
+	c.Assert(err, gocheck.IsNil)
+	addr, err := r.Addr(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp.tsuru.io")
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Lock.Locked, gocheck.Equals, false)
+}
+
+func (s *S) TestChangeRouterMovedBackendOfLockedApp(c *gocheck.C) {
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	err := a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.IsNil)
+	locked, err := AcquireApplicationLock(a.Name, "someone", "/deploy")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(locked, gocheck.Equals, true)
+	runMovedBackendsOnce(time.Now().Add(2 * time.Hour))
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, true)
+	ReleaseApplicationLock(a.Name)
+	runMovedBackendsOnce(time.Now().Add(2 * time.Hour))
+	c.Assert(rtesting.FakeRouter.HasBackend(a.Name), gocheck.Equals, false)
+}
+
+func (s *S) TestChangeRouterSameRouter(c *gocheck.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	err := a.ChangeRouter("fake", time.Hour)
+	c.Assert(err, gocheck.Equals, ErrSameRouter)
+}
+
+func (s *S) TestChangeRouterUnknownRouter(c *gocheck.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	err := a.ChangeRouter("unknown", time.Hour)
+	c.Assert(err, gocheck.ErrorMatches, `Unknown router: "unknown".`)
+}
@@ -5,19 +5,21 @@
 package app
 
 import (
-	"errors"
+	stderr "errors"
 	"fmt"
-	"regexp"
-	"strconv"
 	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/log"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
+// autoScaleInterval is the interval between the runs of the auto scale.
+const autoScaleInterval = 30 * time.Second
+
 func StartAutoScale() {
 	autoScaleEnabled, _ := config.GetBool("autoscale")
 	if autoScaleEnabled {
@@ -34,17 +36,30 @@ type AutoScaleEvent struct {
 	EndTime         time.Time `bson:",omitempty"`
 	AutoScaleConfig *AutoScaleConfig
 	Type            string
-	Successful      bool
-	Error           string `bson:",omitempty"`
+	// Schedule is the name of the schedule rule that started the event,
+	// in events of the "schedule" type.
+	Schedule string `bson:",omitempty" json:",omitempty"`
+	// Units is the number of units of the app set by the schedule, and
+	// ScaledDown whether the schedule removed units, in events of the
+	// "schedule" type.
+	Units      uint `bson:",omitempty" json:",omitempty"`
+	ScaledDown bool `bson:",omitempty" json:",omitempty"`
+	Successful bool
+	Error      string `bson:",omitempty"`
 }
 
 func NewAutoScaleEvent(a *App, scaleType string) (*AutoScaleEvent, error) {
+	return newAutoScaleEvent(a, scaleType, "")
+}
+
+func newAutoScaleEvent(a *App, scaleType, schedule string) (*AutoScaleEvent, error) {
 	evt := AutoScaleEvent{
 		ID:              bson.NewObjectId(),
 		StartTime:       time.Now().UTC(),
 		AutoScaleConfig: a.AutoScaleConfig,
 		AppName:         a.Name,
 		Type:            scaleType,
+		Schedule:        schedule,
 	}
 	conn, err := db.Conn()
 	if err != nil {
@@ -77,28 +92,50 @@ type Action struct {
 }
 
 func NewAction(expression string, units uint, wait time.Duration) (*Action, error) {
-	if expressionIsValid(expression) {
-		return &Action{Wait: wait, Expression: expression, Units: units}, nil
+	_, err := parseExpression(expression)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("Expression is not valid.")
+	return &Action{Wait: wait, Expression: expression, Units: units}, nil
 }
 
-var expressionRegex = regexp.MustCompile("{(.*)} ([><=]) ([0-9]+)")
-
 func expressionIsValid(expression string) bool {
-	return expressionRegex.MatchString(expression)
-}
-
-func (action *Action) metric() string {
-	return expressionRegex.FindStringSubmatch(action.Expression)[1]
+	_, err := parseExpression(expression)
+	return err == nil
 }
 
-func (action *Action) operator() string {
-	return expressionRegex.FindStringSubmatch(action.Expression)[2]
+// holds returns whether the expression of the action holds for the metrics of
+// the app at the given time. Actions without expression never hold, and
+// neither do actions whose metrics are not available, so missing metrics
+// don't scale apps.
+func (action *Action) holds(app *App, now time.Time) bool {
+	result, err := action.evaluate(func(q metricQuery) (float64, error) {
+		return app.readMetric(q, now)
+	})
+	if err != nil {
+		log.Errorf("[autoscale] Failed to evaluate %q for the app %s: %s", action.Expression, app.Name, err)
+		return false
+	}
+	return result
 }
 
-func (action *Action) value() (float64, error) {
-	return strconv.ParseFloat(expressionRegex.FindStringSubmatch(action.Expression)[3], 64)
+// evaluate evaluates the expression of the action with the metrics returned
+// by read. Actions without expression never hold.
+func (action *Action) evaluate(read metricReader) (bool, error) {
+	if action.Expression == "" {
+		return false, nil
+	}
+	expr, err := parseExpression(action.Expression)
+	if err != nil {
+		return false, err
+	}
+	return expr.eval(func(q metricQuery) (float64, error) {
+		value, err := read(q)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get %s: %s", q, err)
+		}
+		return value, nil
+	})
 }
 
 // AutoScaleConfig represents the App configuration for the auto scale.
@@ -108,6 +145,50 @@ type AutoScaleConfig struct {
 	MinUnits uint   `json:"minUnits"`
 	MaxUnits uint   `json:"maxUnits"`
 	Enabled  bool   `json:"enabled"`
+	// MetricsSource is the name of the source of the metrics of the app.
+	// When it's empty, the "autoscale:metrics-source" setting is used.
+	MetricsSource string `json:"metricsSource,omitempty"`
+	// Schedules are time-based rules, which run before the Increase and
+	// Decrease actions.
+	Schedules []ScheduleRule `json:"schedules,omitempty"`
+}
+
+// minUnits returns the minimum number of units of the app, which is at least
+// one.
+func (config *AutoScaleConfig) minUnits() uint {
+	if config.MinUnits == 0 {
+		return 1
+	}
+	return config.MinUnits
+}
+
+// maxUnits returns the maximum number of units of the app, which is at least
+// one.
+func (config *AutoScaleConfig) maxUnits() uint {
+	if config.MaxUnits == 0 {
+		return 1
+	}
+	return config.MaxUnits
+}
+
+// increaseUnits returns how many units the increase action adds to an app
+// with the given number of units, which must be less than the maximum.
+func (config *AutoScaleConfig) increaseUnits(current, max uint) uint {
+	inc := config.Increase.Units
+	if current+inc > max {
+		inc = max - current
+	}
+	return inc
+}
+
+// decreaseUnits returns how many units the decrease action removes from an
+// app with the given number of units, which must be more than the minimum.
+func (config *AutoScaleConfig) decreaseUnits(current, min uint) uint {
+	dec := config.Decrease.Units
+	if current < min+dec {
+		dec = current - min
+	}
+	return dec
 }
 
 func autoScalableApps() ([]App, error) {
@@ -130,7 +211,14 @@ func runAutoScaleOnce() {
 		log.Error(err.Error())
 	}
 	for _, app := range apps {
-		err := scaleApplicationIfNeeded(&app)
+		ran, err := runSchedules(&app, time.Now())
+		if err != nil {
+			log.Error(err.Error())
+		}
+		if ran {
+			continue
+		}
+		err = scaleApplicationIfNeeded(&app)
 		if err != nil {
 			log.Error(err.Error())
 		}
@@ -140,22 +228,22 @@ func runAutoScaleOnce() {
 func runAutoScale() {
 	for {
 		runAutoScaleOnce()
-		time.Sleep(30 * time.Second)
+		time.Sleep(autoScaleInterval)
 	}
 }
 
 func scaleApplicationIfNeeded(app *App) error {
 	if app.AutoScaleConfig == nil {
-		return errors.New("AutoScale is not configured.")
+		return stderr.New("AutoScale is not configured.")
 	}
-	increaseMetric, _ := app.Metric(app.AutoScaleConfig.Increase.metric())
-	value, _ := app.AutoScaleConfig.Increase.value()
-	if increaseMetric > value {
+	active, err := activeSchedule(app)
+	if err != nil {
+		return err
+	}
+	minUnits, maxUnits := app.AutoScaleConfig.actionBounds(active)
+	now := time.Now()
+	if app.AutoScaleConfig.Increase.holds(app, now) {
 		currentUnits := uint(len(app.Units()))
-		maxUnits := app.AutoScaleConfig.MaxUnits
-		if maxUnits == 0 {
-			maxUnits = 1
-		}
 		if currentUnits >= maxUnits {
 			return nil
 		}
@@ -173,25 +261,15 @@ func scaleApplicationIfNeeded(app *App) error {
 		if err != nil {
 			return fmt.Errorf("Error trying to insert auto scale event, auto scale aborted: %s", err.Error())
 		}
-		inc := app.AutoScaleConfig.Increase.Units
-		if currentUnits+inc > app.AutoScaleConfig.MaxUnits {
-			inc = app.AutoScaleConfig.MaxUnits - currentUnits
-		}
-		addUnitsErr := app.AddUnits(inc, nil)
+		addUnitsErr := app.AddUnits(app.AutoScaleConfig.increaseUnits(currentUnits, maxUnits), "", nil)
 		err = evt.update(addUnitsErr)
 		if err != nil {
 			log.Errorf("Error trying to update auto scale event: %s", err.Error())
 		}
 		return addUnitsErr
 	}
-	decreaseMetric, _ := app.Metric(app.AutoScaleConfig.Decrease.metric())
-	value, _ = app.AutoScaleConfig.Decrease.value()
-	if decreaseMetric < value {
+	if app.AutoScaleConfig.Decrease.holds(app, now) {
 		currentUnits := uint(len(app.Units()))
-		minUnits := app.AutoScaleConfig.MinUnits
-		if minUnits == 0 {
-			minUnits = 1
-		}
 		if currentUnits <= minUnits {
 			return nil
 		}
@@ -209,11 +287,7 @@ func scaleApplicationIfNeeded(app *App) error {
 		if err != nil {
 			return fmt.Errorf("Error trying to insert auto scale event, auto scale aborted: %s", err.Error())
 		}
-		dec := app.AutoScaleConfig.Decrease.Units
-		if currentUnits-dec < app.AutoScaleConfig.MinUnits {
-			dec = currentUnits - app.AutoScaleConfig.MinUnits
-		}
-		removeUnitsErr := app.RemoveUnits(dec)
+		removeUnitsErr := app.RemoveUnits(app.AutoScaleConfig.decreaseUnits(currentUnits, minUnits), "")
 		err = evt.update(removeUnitsErr)
 		if err != nil {
 			log.Errorf("Error trying to update auto scale event: %s", err.Error())
@@ -298,7 +372,65 @@ func AutoScaleDisable(app *App) error {
 	)
 }
 
+// validate checks the auto scale config of the app, returning a
+// *errors.ValidationError describing the first problem found.
+func (config *AutoScaleConfig) validate() error {
+	if config.MaxUnits > 0 && config.MinUnits > config.MaxUnits {
+		return &errors.ValidationError{Message: "The minimum number of units can't be greater than the maximum."}
+	}
+	if config.MetricsSource != "" {
+		if _, ok := metricsSources[config.MetricsSource]; !ok {
+			return &errors.ValidationError{Message: fmt.Sprintf("Metrics source %q is not known.", config.MetricsSource)}
+		}
+	}
+	names := make(map[string]bool)
+	for i := range config.Schedules {
+		rule := &config.Schedules[i]
+		if err := rule.validate(); err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid schedule: %s.", err)}
+		}
+		if max := config.maxUnits(); rule.Units > max {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid schedule: the schedule %q sets %d units, more than the maximum of %d units.", rule.Name, rule.Units, max)}
+		}
+		if names[rule.Name] {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid schedule: there are two schedules named %q.", rule.Name)}
+		}
+		names[rule.Name] = true
+	}
+	actions := []struct {
+		name   string
+		action *Action
+	}{{"increase", &config.Increase}, {"decrease", &config.Decrease}}
+	for _, a := range actions {
+		if a.action.Expression == "" {
+			continue
+		}
+		expr, err := parseExpression(a.action.Expression)
+		if err != nil {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid %s expression: %s.", a.name, err.(ExpressionError).reason)}
+		}
+		for _, q := range expr.queries() {
+			if q.aggregation == "" {
+				continue
+			}
+			source, err := getMetricsSource(&App{AutoScaleConfig: config})
+			if err != nil {
+				return &errors.ValidationError{Message: err.Error()}
+			}
+			if _, ok := source.(SeriesMetricsSource); !ok {
+				return &errors.ValidationError{Message: fmt.Sprintf("Invalid %s expression: the metrics source doesn't support aggregations over windows, used in %s.", a.name, q)}
+			}
+		}
+	}
+	return nil
+}
+
 func SetAutoScaleConfig(app *App, config *AutoScaleConfig) error {
+	if config != nil {
+		if err := config.validate(); err != nil {
+			return err
+		}
+	}
 	app.AutoScaleConfig = config
 	conn, err := db.Conn()
 	if err != nil {
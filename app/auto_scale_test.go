@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/quota"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -112,7 +113,7 @@ func (s *S) TestAutoScaleDown(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
 	s.provisioner.Provision(&newApp)
 	defer s.provisioner.Destroy(&newApp)
-	s.provisioner.AddUnits(&newApp, 2, nil)
+	s.provisioner.AddUnits(&newApp, 2, "", nil)
 	err = scaleApplicationIfNeeded(&newApp)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(newApp.Units(), gocheck.HasLen, 1)
@@ -180,7 +181,7 @@ func (s *S) TestRunAutoScaleOnce(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": down.Name})
 	s.provisioner.Provision(&down)
 	defer s.provisioner.Destroy(&down)
-	s.provisioner.AddUnits(&down, 3, nil)
+	s.provisioner.AddUnits(&down, 3, "", nil)
 	runAutoScaleOnce()
 	c.Assert(up.Units(), gocheck.HasLen, 1)
 	c.Assert(down.Units(), gocheck.HasLen, 2)
@@ -204,34 +205,27 @@ func (s *S) TestRunAutoScaleOnce(c *gocheck.C) {
 	c.Assert(events[1].AutoScaleConfig, gocheck.DeepEquals, down.AutoScaleConfig)
 }
 
-func (s *S) TestActionMetric(c *gocheck.C) {
-	a := &Action{Expression: "{cpu} > 80"}
-	c.Assert(a.metric(), gocheck.Equals, "cpu")
-}
-
-func (s *S) TestActionOperator(c *gocheck.C) {
-	a := &Action{Expression: "{cpu} > 80"}
-	c.Assert(a.operator(), gocheck.Equals, ">")
-}
-
-func (s *S) TestActionValue(c *gocheck.C) {
-	a := &Action{Expression: "{cpu} > 80"}
-	value, err := a.value()
-	c.Assert(err, gocheck.IsNil)
-	c.Assert(value, gocheck.Equals, float64(80))
-}
-
 func (s *S) TestValidateExpression(c *gocheck.C) {
 	cases := map[string]bool{
-		"{cpu} > 10": true,
-		"{cpu} = 10": true,
-		"{cpu} < 10": true,
-		"cpu < 10":   false,
-		"{cpu} 10":   false,
-		"{cpu} <":    false,
-		"{cpu}":      false,
-		"<":          false,
-		"100":        false,
+		"{cpu} > 10":    true,
+		"{cpu} = 10":    true,
+		"{cpu} < 10":    true,
+		"{cpu} >= 10.5": true,
+		"{cpu} != 10":   true,
+		"avg({cpu}, 5m) > 80 and max({mem}, 1h) > 50": true,
+		"not ({cpu} > 10 or {mem} <= 10)":             true,
+		"p95({cpu}, 10m) > 80":                        true,
+		"cpu < 10":                                    false,
+		"{cpu} 10":                                    false,
+		"{cpu} <":                                     false,
+		"{cpu}":                                       false,
+		"<":                                           false,
+		"100":                                         false,
+		"avg({cpu}) > 10":                             false,
+		"avg({cpu}, 0m) > 10":                         false,
+		"median({cpu}, 5m) > 10":                      false,
+		"{cpu} > 10 and":                              false,
+		"({cpu} > 10":                                 false,
 	}
 	for expression, expected := range cases {
 		c.Assert(expressionIsValid(expression), gocheck.Equals, expected)
@@ -364,6 +358,45 @@ func (s *S) TestAutoScaleConfig(c *gocheck.C) {
 	c.Assert(a.AutoScaleConfig, gocheck.DeepEquals, &config)
 }
 
+func (s *S) TestAutoScaleConfigInvalidExpression(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{
+		Increase: Action{Units: 1, Expression: "{cpu} > 80"},
+		Decrease: Action{Units: 1, Expression: "avg({cpu}, 5m) <"},
+	}
+	err := SetAutoScaleConfig(&a, &config)
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, "Invalid decrease expression: unexpected end of expression, expecting a number.")
+	c.Assert(a.AutoScaleConfig, gocheck.IsNil)
+}
+
+func (s *S) TestAutoScaleConfigMinGreaterThanMax(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{MinUnits: 5, MaxUnits: 2}
+	err := SetAutoScaleConfig(&a, &config)
+	c.Assert(err, gocheck.ErrorMatches, "The minimum number of units can't be greater than the maximum.")
+}
+
+func (s *S) TestAutoScaleConfigWindowsWithoutSeries(c *gocheck.C) {
+	RegisterMetricsSource("fake-source", &fakeMetricsSource{})
+	defer delete(metricsSources, "fake-source")
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{
+		Increase:      Action{Units: 1, Expression: "avg({cpu}, 5m) > 80"},
+		MetricsSource: "fake-source",
+	}
+	err := SetAutoScaleConfig(&a, &config)
+	c.Assert(err, gocheck.ErrorMatches, `Invalid increase expression: the metrics source doesn't support aggregations over windows, used in avg\({cpu}, 5m0s\).`)
+}
+
+func (s *S) TestAutoScaleConfigUnknownMetricsSource(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{Enabled: true, MetricsSource: "unknown"}
+	err := SetAutoScaleConfig(&a, &config)
+	c.Assert(err, gocheck.ErrorMatches, `Metrics source "unknown" is not known.`)
+	c.Assert(a.AutoScaleConfig, gocheck.IsNil)
+}
+
 func (s *S) TestAutoScaleUpWaitEventStillRunning(c *gocheck.C) {
 	h := metricHandler{cpuMax: "90.2"}
 	ts := httptest.NewServer(&h)
@@ -587,7 +620,7 @@ func (s *S) TestAutoScaleMinUnits(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
 	s.provisioner.Provision(&newApp)
 	defer s.provisioner.Destroy(&newApp)
-	s.provisioner.AddUnits(&newApp, 5, nil)
+	s.provisioner.AddUnits(&newApp, 5, "", nil)
 	err = scaleApplicationIfNeeded(&newApp)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(newApp.Units(), gocheck.HasLen, 3)
@@ -662,7 +695,7 @@ func (s *S) TestAutoScaleDownMin(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
 	s.provisioner.Provision(&newApp)
 	defer s.provisioner.Destroy(&newApp)
-	s.provisioner.AddUnits(&newApp, 1, nil)
+	s.provisioner.AddUnits(&newApp, 1, "", nil)
 	err = scaleApplicationIfNeeded(&newApp)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(newApp.Units(), gocheck.HasLen, 1)
@@ -698,7 +731,7 @@ func (s *S) TestAutoScaleUpMax(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
 	s.provisioner.Provision(&newApp)
 	defer s.provisioner.Destroy(&newApp)
-	s.provisioner.AddUnits(&newApp, 2, nil)
+	s.provisioner.AddUnits(&newApp, 2, "", nil)
 	err = scaleApplicationIfNeeded(&newApp)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(newApp.Units(), gocheck.HasLen, 2)
@@ -707,3 +740,96 @@ func (s *S) TestAutoScaleUpMax(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(events, gocheck.HasLen, 0)
 }
+
+type fakeSeriesSource struct {
+	fakeMetricsSource
+	series []float64
+	from   time.Time
+	until  time.Time
+}
+
+func (s *fakeSeriesSource) Series(app *App, kind string, from, until time.Time) ([]MetricValue, error) {
+	s.from, s.until = from, until
+	values := make([]MetricValue, len(s.series))
+	for i, v := range s.series {
+		values[i] = MetricValue{Time: until, Value: v}
+	}
+	return values, nil
+}
+
+func (s *S) TestAutoScaleWithWindowIgnoresSpikes(c *gocheck.C) {
+	source := fakeSeriesSource{fakeMetricsSource: fakeMetricsSource{value: 95}, series: []float64{20, 30, 95}}
+	RegisterMetricsSource("fake-series", &source)
+	defer delete(metricsSources, "fake-series")
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Quota:    quota.Unlimited,
+		AutoScaleConfig: &AutoScaleConfig{
+			Increase:      Action{Units: 1, Expression: "avg({cpu_max}, 5m) > 80"},
+			Decrease:      Action{Units: 1, Expression: "max({cpu_max}, 5m) < 20"},
+			Enabled:       true,
+			MaxUnits:      10,
+			MetricsSource: "fake-series",
+		},
+	}
+	err := scaleApplicationIfNeeded(&newApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(source.until.Sub(source.from), gocheck.Equals, 5*time.Minute)
+	var events []AutoScaleEvent
+	err = s.conn.AutoScale().Find(nil).All(&events)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(events, gocheck.HasLen, 0)
+}
+
+func (s *S) TestAutoScaleUpWithWindow(c *gocheck.C) {
+	source := fakeSeriesSource{series: []float64{85, 90, 95}}
+	RegisterMetricsSource("fake-series", &source)
+	defer delete(metricsSources, "fake-series")
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Quota:    quota.Unlimited,
+		AutoScaleConfig: &AutoScaleConfig{
+			Increase:      Action{Units: 1, Expression: "avg({cpu_max}, 5m) > 80 and {cpu_max} >= 0"},
+			Enabled:       true,
+			MaxUnits:      10,
+			MetricsSource: "fake-series",
+		},
+	}
+	err := s.conn.Apps().Insert(newApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
+	s.provisioner.Provision(&newApp)
+	defer s.provisioner.Destroy(&newApp)
+	err = scaleApplicationIfNeeded(&newApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(newApp.Units(), gocheck.HasLen, 1)
+	var events []AutoScaleEvent
+	err = s.conn.AutoScale().Find(nil).All(&events)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(events, gocheck.HasLen, 1)
+	c.Assert(events[0].Type, gocheck.Equals, "increase")
+}
+
+func (s *S) TestAutoScaleWithoutMetricsDoesNotScaleDown(c *gocheck.C) {
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Quota:    quota.Unlimited,
+		AutoScaleConfig: &AutoScaleConfig{
+			Increase: Action{Units: 1, Expression: "{cpu_max} > 80"},
+			Decrease: Action{Units: 1, Expression: "{cpu_max} < 20"},
+			Enabled:  true,
+		},
+	}
+	err := s.conn.Apps().Insert(newApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
+	s.provisioner.Provision(&newApp)
+	defer s.provisioner.Destroy(&newApp)
+	s.provisioner.AddUnits(&newApp, 2, "", nil)
+	err = scaleApplicationIfNeeded(&newApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(newApp.Units(), gocheck.HasLen, 2)
+}
@@ -0,0 +1,222 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	stderr "errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrCertificateNotFound = stderr.New("certificate not found")
+
+	// ErrCertificateEncryptionKey is returned when tsuru can't store
+	// certificates because the key used to encrypt their private keys is
+	// not configured.
+	ErrCertificateEncryptionKey = stderr.New("certificates:encryption-key is not configured")
+)
+
+// CertificateValidationError is returned by SetCertificate when the
+// certificate can't be used for the cname.
+type CertificateValidationError struct{ reason string }
+
+func (e CertificateValidationError) Error() string {
+	return "invalid certificate: " + e.reason
+}
+
+// Certificate is the TLS certificate of a cname of an app. Its private key is
+// stored encrypted, and is never included in JSON representations.
+type Certificate struct {
+	CName       string `bson:"_id"`
+	App         string
+	Certificate string
+	Key         []byte `json:"-"`
+	Expiration  time.Time
+}
+
+// PrivateKey returns the decrypted private key of the certificate.
+func (c *Certificate) PrivateKey() (string, error) {
+	aead, err := certificateKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(c.Key) < aead.NonceSize() {
+		return "", stderr.New("invalid encrypted private key")
+	}
+	nonce, data := c.Key[:aead.NonceSize()], c.Key[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// certificateKeyCipher returns the cipher used to encrypt the private keys of
+// certificates, derived from the certificates:encryption-key setting.
+func certificateKeyCipher() (cipher.AEAD, error) {
+	secret, _ := config.GetString("certificates:encryption-key")
+	if secret == "" {
+		return nil, ErrCertificateEncryptionKey
+	}
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptCertificateKey(key string) ([]byte, error) {
+	aead, err := certificateKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(key), nil), nil
+}
+
+// validateCertificate checks that the certificate matches the private key and
+// is valid for the cname, returning its expiration.
+func validateCertificate(cname, certificate, key string) (time.Time, error) {
+	pair, err := tls.X509KeyPair([]byte(certificate), []byte(key))
+	if err != nil {
+		return time.Time{}, CertificateValidationError{err.Error()}
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return time.Time{}, CertificateValidationError{err.Error()}
+	}
+	err = leaf.VerifyHostname(cname)
+	if err != nil {
+		return time.Time{}, CertificateValidationError{err.Error()}
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return time.Time{}, CertificateValidationError{fmt.Sprintf("the certificate expired at %s", leaf.NotAfter)}
+	}
+	return leaf.NotAfter, nil
+}
+
+func (app *App) hasCName(cname string) bool {
+	for _, appCName := range app.CName {
+		if appCName == cname {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) certificateManager() (provision.CertificateManager, error) {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return nil, err
+	}
+	manager, ok := prov.(provision.CertificateManager)
+	if !ok {
+		return nil, router.ErrTLSNotSupported
+	}
+	return manager, nil
+}
+
+// SetCertificate validates the certificate against one of the cnames of the
+// app and sends it to the router of the app, replacing the previous
+// certificate of the cname.
+func (app *App) SetCertificate(cname, certificate, key string) error {
+	if !app.hasCName(cname) {
+		return CertificateValidationError{fmt.Sprintf("%q is not a cname of the app %q", cname, app.Name)}
+	}
+	expiration, err := validateCertificate(cname, certificate, key)
+	if err != nil {
+		return err
+	}
+	encryptedKey, err := encryptCertificateKey(key)
+	if err != nil {
+		return err
+	}
+	manager, err := app.certificateManager()
+	if err != nil {
+		return err
+	}
+	err = manager.SetCertificate(app, cname, certificate, key)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Certificates().UpsertId(cname, Certificate{
+		CName:       cname,
+		App:         app.Name,
+		Certificate: certificate,
+		Key:         encryptedKey,
+		Expiration:  expiration,
+	})
+	return err
+}
+
+// RemoveCertificate removes the certificate of the cname from the router of
+// the app.
+func (app *App) RemoveCertificate(cname string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	count, err := conn.Certificates().Find(bson.M{"_id": cname, "app": app.Name}).Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrCertificateNotFound
+	}
+	manager, err := app.certificateManager()
+	if err != nil {
+		return err
+	}
+	err = manager.UnsetCertificate(app, cname)
+	if err != nil {
+		return err
+	}
+	err = conn.Certificates().RemoveId(cname)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Certificates returns the certificates of the cnames of the app.
+func (app *App) Certificates() ([]Certificate, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var certificates []Certificate
+	err = conn.Certificates().Find(bson.M{"app": app.Name}).Sort("_id").All(&certificates)
+	if err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"io/ioutil"
+
+	"github.com/tsuru/config"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) readCertificate(c *gocheck.C) (string, string) {
+	certificate, err := ioutil.ReadFile("testdata/cert.pem")
+	c.Assert(err, gocheck.IsNil)
+	key, err := ioutil.ReadFile("testdata/key.pem")
+	c.Assert(err, gocheck.IsNil)
+	return string(certificate), string(key)
+}
+
+func (s *S) createCertificateApp(c *gocheck.C, cnames ...string) *App {
+	a := &App{Name: "myapp", CName: cnames}
+	err := s.provisioner.Provision(a)
+	c.Assert(err, gocheck.IsNil)
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	return a
+}
+
+func (s *S) removeCertificateApp(a *App) {
+	s.provisioner.Destroy(a)
+	s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.conn.Certificates().RemoveAll(nil)
+}
+
+func (s *S) TestSetCertificate(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := s.createCertificateApp(c, "app.example.com")
+	defer s.removeCertificateApp(a)
+	certificate, key := s.readCertificate(c)
+	err := a.SetCertificate("app.example.com", certificate, key)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Certificate(a, "app.example.com"), gocheck.Equals, certificate)
+	certificates, err := a.Certificates()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificates, gocheck.HasLen, 1)
+	c.Assert(certificates[0].CName, gocheck.Equals, "app.example.com")
+	c.Assert(certificates[0].App, gocheck.Equals, a.Name)
+	c.Assert(certificates[0].Certificate, gocheck.Equals, certificate)
+	c.Assert(certificates[0].Expiration.Year(), gocheck.Equals, 2126)
+	c.Assert(string(certificates[0].Key), gocheck.Not(gocheck.Equals), key)
+	privateKey, err := certificates[0].PrivateKey()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(privateKey, gocheck.Equals, key)
+}
+
+func (s *S) TestSetCertificateReplacesCertificate(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := s.createCertificateApp(c, "app.example.com")
+	defer s.removeCertificateApp(a)
+	certificate, key := s.readCertificate(c)
+	err := a.SetCertificate("app.example.com", certificate, key)
+	c.Assert(err, gocheck.IsNil)
+	err = a.SetCertificate("app.example.com", certificate, key)
+	c.Assert(err, gocheck.IsNil)
+	certificates, err := a.Certificates()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificates, gocheck.HasLen, 1)
+}
+
+func (s *S) TestSetCertificateUnknownCName(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := s.createCertificateApp(c)
+	defer s.removeCertificateApp(a)
+	certificate, key := s.readCertificate(c)
+	err := a.SetCertificate("app.example.com", certificate, key)
+	c.Assert(err, gocheck.FitsTypeOf, CertificateValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, `invalid certificate: "app.example.com" is not a cname of the app "myapp"`)
+	c.Assert(s.provisioner.Certificate(a, "app.example.com"), gocheck.Equals, "")
+}
+
+func (s *S) TestSetCertificateInvalidHostname(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := s.createCertificateApp(c, "other.example.com")
+	defer s.removeCertificateApp(a)
+	certificate, key := s.readCertificate(c)
+	err := a.SetCertificate("other.example.com", certificate, key)
+	c.Assert(err, gocheck.FitsTypeOf, CertificateValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, "invalid certificate: .*other.example.com.*")
+}
+
+func (s *S) TestSetCertificateInvalidKey(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := s.createCertificateApp(c, "app.example.com")
+	defer s.removeCertificateApp(a)
+	certificate, _ := s.readCertificate(c)
+	err := a.SetCertificate("app.example.com", certificate, "invalid key")
+	c.Assert(err, gocheck.FitsTypeOf, CertificateValidationError{})
+}
+
+func (s *S) TestSetCertificateWithoutEncryptionKey(c *gocheck.C) {
+	a := s.createCertificateApp(c, "app.example.com")
+	defer s.removeCertificateApp(a)
+	certificate, key := s.readCertificate(c)
+	err := a.SetCertificate("app.example.com", certificate, key)
+	c.Assert(err, gocheck.Equals, ErrCertificateEncryptionKey)
+	c.Assert(s.provisioner.Certificate(a, "app.example.com"), gocheck.Equals, "")
+}
+
+func (s *S) TestRemoveCertificate(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := s.createCertificateApp(c, "app.example.com")
+	defer s.removeCertificateApp(a)
+	certificate, key := s.readCertificate(c)
+	err := a.SetCertificate("app.example.com", certificate, key)
+	c.Assert(err, gocheck.IsNil)
+	err = a.RemoveCertificate("app.example.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Certificate(a, "app.example.com"), gocheck.Equals, "")
+	certificates, err := a.Certificates()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificates, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRemoveCertificateNotFound(c *gocheck.C) {
+	a := s.createCertificateApp(c, "app.example.com")
+	defer s.removeCertificateApp(a)
+	err := a.RemoveCertificate("app.example.com")
+	c.Assert(err, gocheck.Equals, ErrCertificateNotFound)
+}
+
+func (s *S) TestRemoveCNameRemovesCertificate(c *gocheck.C) {
+	config.Set("certificates:encryption-key", "secret")
+	defer config.Unset("certificates:encryption-key")
+	a := s.createCertificateApp(c, "app.example.com")
+	defer s.removeCertificateApp(a)
+	certificate, key := s.readCertificate(c)
+	err := a.SetCertificate("app.example.com", certificate, key)
+	c.Assert(err, gocheck.IsNil)
+	err = a.RemoveCName("app.example.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Certificate(a, "app.example.com"), gocheck.Equals, "")
+	certificates, err := a.Certificates()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificates, gocheck.HasLen, 0)
+}
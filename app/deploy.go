@@ -10,23 +10,41 @@ import (
 	"io"
 	"time"
 
+	"github.com/tsuru/config"
 	"github.com/tsuru/go-gandalfclient"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/repository"
 	"github.com/tsuru/tsuru/service"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
+var (
+	ErrRollbackNotSupported     = errors.New("the provisioner of the app does not support rollbacks")
+	ErrDeployImageNotFound      = errors.New("the image was not generated by a successful deploy of the app")
+	ErrDeployCancelNotSupported = errors.New("the provisioner of the app does not support canceling deploys")
+	ErrDeployTimeout            = errors.New("deploy canceled: it exceeded the maximum duration")
+	ErrImageDeployNotSupported  = errors.New("the provisioner of the app does not support deploying images")
+	ErrNoImageToPromote         = errors.New("the source app has no image to promote, it must be deployed first")
+	ErrCanaryNotSupported       = errors.New("the provisioner of the app does not support canary deploys")
+)
+
 type deploy struct {
-	ID        bson.ObjectId `bson:"_id,omitempty"`
-	App       string
-	Timestamp time.Time
-	Duration  time.Duration
-	Commit    string
-	Error     string
+	ID           bson.ObjectId `bson:"_id,omitempty"`
+	App          string
+	Timestamp    time.Time
+	Duration     time.Duration
+	Commit       string
+	Image        string
+	Rollback     bool
+	SourceApp    string        `bson:",omitempty"`
+	SourceDeploy bson.ObjectId `bson:",omitempty"`
+	Error        string
+	Running      bool
 }
 
 func (app *App) ListDeploys(u *auth.User) ([]deploy, error) {
@@ -152,51 +170,280 @@ type DeployOptions struct {
 	Version      string
 	Commit       string
 	ArchiveURL   string
+	Image        string
 	File         io.ReadCloser
 	OutputStream io.Writer
+	SourceApp    string
+	SourceDeploy bson.ObjectId
 }
 
-// Deploy runs a deployment of an application. It will first try to run an
-// archive based deploy (if opts.ArchiveURL is not empty), and then fallback to
-// the Git based deployment.
+// Deploy runs a deployment of an application. When opts.Image is not empty, the
+// given image is deployed without being built. Otherwise it will first try to
+// run an archive based deploy (if opts.ArchiveURL is not empty), and then
+// fallback to the Git based deployment.
 func Deploy(opts DeployOptions) error {
 	var pipeline *action.Pipeline
 	start := time.Now()
-	if cprovisioner, ok := Provisioner.(provision.CustomizedDeployPipelineProvisioner); ok {
+	prov, err := opts.App.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	if cprovisioner, ok := prov.(provision.CustomizedDeployPipelineProvisioner); ok {
 		pipeline = cprovisioner.DeployPipeline()
 	} else {
 		actions := []*action.Action{&ProvisionerDeploy, &IncrementDeploy}
 		pipeline = action.NewPipeline(actions...)
 	}
-	logWriter := LogWriter{App: opts.App, Writer: opts.OutputStream}
-	err := pipeline.Execute(opts, &logWriter)
-	elapsed := time.Since(start)
+	d := deploy{
+		App:          opts.App.Name,
+		Commit:       opts.Commit,
+		SourceApp:    opts.SourceApp,
+		SourceDeploy: opts.SourceDeploy,
+	}
+	deployLog, err := startDeploy(&d)
+	if err != nil {
+		return err
+	}
+	defer deployLog.close()
+	timedOut := make(chan struct{})
+	if maxDuration := deployMaxDuration(); maxDuration > 0 {
+		timer := time.AfterFunc(maxDuration, func() {
+			close(timedOut)
+			if err := CancelDeploy(opts.App); err != nil && err != provision.ErrNoDeployInProgress {
+				log.Errorf("Failed to cancel the deploy of the app %q after %s: %s", opts.App.Name, maxDuration, err)
+			}
+		})
+		defer timer.Stop()
+	}
+	logWriter := LogWriter{App: opts.App, Writer: io.MultiWriter(opts.OutputStream, deployLog)}
+	err = pipeline.Execute(opts, &logWriter)
+	if err == provision.ErrDeployCanceled {
+		select {
+		case <-timedOut:
+			err = ErrDeployTimeout
+		default:
+		}
+	}
+	d.Duration = time.Since(start)
 	if err != nil {
-		saveDeployData(opts.App.Name, opts.Commit, elapsed, err)
+		saveDeployData(d, err)
 		return err
 	}
 	if opts.App.UpdatePlatform == true {
 		opts.App.SetUpdatePlatform(false)
 	}
-	return saveDeployData(opts.App.Name, opts.Commit, elapsed, nil)
+	d.Image, _ = pipeline.Result().(string)
+	return saveDeployData(d, nil)
+}
+
+// deployMaxDuration returns the maximum duration of deploys, defined in the
+// "deploy:max-duration" setting, in seconds. Zero means that deploys never
+// time out.
+func deployMaxDuration() time.Duration {
+	seconds, _ := config.GetInt("deploy:max-duration")
+	if seconds < 0 {
+		seconds = 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// CancelDeploy cancels the deploy of the app that is currently running. The
+// canceled deploy is rolled back and fails with provision.ErrDeployCanceled.
+func CancelDeploy(app *App) error {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	canceler, ok := prov.(provision.DeployCanceler)
+	if !ok {
+		return ErrDeployCancelNotSupported
+	}
+	return canceler.CancelDeploy(app)
+}
+
+// Rollback deploys again the image generated by a previous successful deploy
+// of the app, without building it. The rollback is recorded as a new deploy,
+// using the commit of the deploy that generated the image.
+func Rollback(app *App, image string, w io.Writer) error {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	deployer, ok := prov.(provision.RollbackDeployer)
+	if !ok {
+		return ErrRollbackNotSupported
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var previous deploy
+	query := bson.M{"app": app.Name, "image": image, "error": ""}
+	err = conn.Deploys().Find(query).Sort("-timestamp").One(&previous)
+	if err == mgo.ErrNotFound {
+		return ErrDeployImageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	d := deploy{
+		App:      app.Name,
+		Commit:   previous.Commit,
+		Image:    image,
+		Rollback: true,
+	}
+	deployLog, err := startDeploy(&d)
+	if err != nil {
+		return err
+	}
+	defer deployLog.close()
+	logWriter := LogWriter{App: app, Writer: io.MultiWriter(w, deployLog)}
+	err = deployer.Rollback(app, image, &logWriter)
+	d.Duration = time.Since(start)
+	if err != nil {
+		saveDeployData(d, err)
+		return err
+	}
+	return saveDeployData(d, nil)
+}
+
+// Promote deploys to the given app the image of the last successful deploy of
+// the source app, without building it. The deploy is recorded with the source
+// app and the ID of the deploy that generated the image.
+func Promote(source, app *App, w io.Writer) error {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	if _, ok := prov.(provision.ImageDeployer); !ok {
+		return ErrImageDeployNotSupported
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var sourceDeploy deploy
+	query := bson.M{"app": source.Name, "error": "", "image": bson.M{"$gt": ""}}
+	err = conn.Deploys().Find(query).Sort("-timestamp").One(&sourceDeploy)
+	if err == mgo.ErrNotFound {
+		return ErrNoImageToPromote
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n---- Promoting image %s from app %s ----\n", sourceDeploy.Image, source.Name)
+	return Deploy(DeployOptions{
+		App:          app,
+		Commit:       sourceDeploy.Commit,
+		Image:        sourceDeploy.Image,
+		OutputStream: w,
+		SourceApp:    source.Name,
+		SourceDeploy: sourceDeploy.ID,
+	})
+}
+
+func canaryDeployer(app *App) (provision.CanaryDeployer, error) {
+	prov, err := app.GetProvisioner()
+	if err != nil {
+		return nil, err
+	}
+	deployer, ok := prov.(provision.CanaryDeployer)
+	if !ok {
+		return nil, ErrCanaryNotSupported
+	}
+	return deployer, nil
+}
+
+// StartCanary starts the given number of units running the image next to the
+// current units of the app, sending them the given percentage of the traffic
+// of the app. The canary must then be promoted or aborted.
+func StartCanary(app *App, image string, units, weight int, w io.Writer) error {
+	deployer, err := canaryDeployer(app)
+	if err != nil {
+		return err
+	}
+	logWriter := LogWriter{App: app, Writer: w}
+	return deployer.StartCanary(app, image, units, weight, &logWriter)
+}
+
+// SetCanaryWeight changes the percentage of the traffic of the app sent to
+// the units of its canary.
+func SetCanaryWeight(app *App, weight int) error {
+	deployer, err := canaryDeployer(app)
+	if err != nil {
+		return err
+	}
+	return deployer.SetCanaryWeight(app, weight)
+}
+
+// PromoteCanary replaces the units of the app with units running the image of
+// its canary. The promotion is recorded as a deploy of the image, using the
+// commit of the deploy that generated it, if any.
+func PromoteCanary(app *App, w io.Writer) error {
+	deployer, err := canaryDeployer(app)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	d := deploy{App: app.Name}
+	deployLog, err := startDeploy(&d)
+	if err != nil {
+		return err
+	}
+	defer deployLog.close()
+	logWriter := LogWriter{App: app, Writer: io.MultiWriter(w, deployLog)}
+	image, err := deployer.PromoteCanary(app, &logWriter)
+	d.Duration = time.Since(start)
+	if err != nil {
+		saveDeployData(d, err)
+		return err
+	}
+	d.Image = image
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var previous deploy
+	query := bson.M{"app": app.Name, "image": image, "error": ""}
+	if conn.Deploys().Find(query).Sort("-timestamp").One(&previous) == nil {
+		d.Commit = previous.Commit
+	}
+	return saveDeployData(d, nil)
+}
+
+// AbortCanary removes the units of the canary of the app, sending all the
+// traffic of the app back to its other units.
+func AbortCanary(app *App, w io.Writer) error {
+	deployer, err := canaryDeployer(app)
+	if err != nil {
+		return err
+	}
+	logWriter := LogWriter{App: app, Writer: w}
+	return deployer.AbortCanary(app, &logWriter)
 }
 
-func saveDeployData(appName, commit string, duration time.Duration, deployError error) error {
+// saveDeployData stores the given deploy as finished, setting its timestamp
+// and the error, if any.
+func saveDeployData(d deploy, deployError error) error {
 	conn, err := db.Conn()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	deploy := deploy{
-		App:       appName,
-		Timestamp: time.Now(),
-		Duration:  duration,
-		Commit:    commit,
+	if d.ID == "" {
+		d.ID = bson.NewObjectId()
 	}
+	d.Timestamp = time.Now()
+	d.Running = false
 	if deployError != nil {
-		deploy.Error = deployError.Error()
+		d.Error = deployError.Error()
 	}
-	return conn.Deploys().Insert(deploy)
+	_, err = conn.Deploys().UpsertId(d.ID, d)
+	return err
 }
 
 func incrementDeploy(app *App) error {
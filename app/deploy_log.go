@@ -0,0 +1,238 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeployLog is a piece of the output of a deploy. The output is stored so it
+// can be replayed after the deploy finishes.
+type DeployLog struct {
+	Deploy  bson.ObjectId
+	Seq     int
+	Date    time.Time
+	Message string
+}
+
+// deployLogMessage is the message published to the clients that follow the
+// log of a running deploy. The last message has End set to true.
+type deployLogMessage struct {
+	Log DeployLog
+	End bool
+}
+
+// deployLogPollInterval is the interval used by clients following a deploy to
+// check whether the deploy is still running, in case the notification of its
+// end is lost.
+var deployLogPollInterval = 10 * time.Second
+
+// deployLogMaxIdle is the maximum time clients follow a running deploy
+// without getting any output from it. A deploy stuck in the running state,
+// like when the API instance running it dies, doesn't keep the clients
+// following it forever.
+var deployLogMaxIdle = 10 * time.Minute
+
+func deployLogQueueName(id bson.ObjectId) string {
+	return "pubsub:deploy:" + id.Hex()
+}
+
+// deployLogWriter stores everything written to it as the log of a deploy,
+// notifying the clients that follow the deploy. Failures to store the log are
+// logged and don't fail the writes, so they don't abort the output of the
+// deploy.
+type deployLogWriter struct {
+	deploy bson.ObjectId
+	seq    int
+	mut    sync.Mutex
+}
+
+func (w *deployLogWriter) Write(data []byte) (int, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("Failed to store the log of the deploy %s: %s", w.deploy.Hex(), err)
+		return len(data), nil
+	}
+	defer conn.Close()
+	entry := DeployLog{Deploy: w.deploy, Seq: w.seq + 1, Date: time.Now(), Message: string(data)}
+	err = conn.DeployLogs().Insert(entry)
+	if err != nil {
+		log.Errorf("Failed to store the log of the deploy %s: %s", w.deploy.Hex(), err)
+		return len(data), nil
+	}
+	w.seq++
+	notifyDeployLog(w.deploy, deployLogMessage{Log: entry})
+	return len(data), nil
+}
+
+// close notifies the clients that follow the deploy that it has finished.
+func (w *deployLogWriter) close() {
+	notifyDeployLog(w.deploy, deployLogMessage{End: true})
+}
+
+func notifyDeployLog(id bson.ObjectId, msg deployLogMessage) {
+	factory, err := queue.Factory()
+	if err != nil {
+		log.Errorf("Error on deploy log notify: %s", err.Error())
+		return
+	}
+	pubSubQ, err := factory.Get(deployLogQueueName(id))
+	if err != nil {
+		log.Errorf("Error on deploy log notify: %s", err.Error())
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Errorf("Error on deploy log notify: %s", err.Error())
+		return
+	}
+	err = pubSubQ.Pub(data)
+	if err != nil {
+		log.Errorf("Error on deploy log notify: %s", err.Error())
+	}
+}
+
+// startDeploy stores the given deploy as running, so its log can be followed
+// while it runs, and returns the writer for the log of the deploy.
+func startDeploy(d *deploy) (*deployLogWriter, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	d.ID = bson.NewObjectId()
+	d.Timestamp = time.Now()
+	d.Running = true
+	err = conn.Deploys().Insert(d)
+	if err != nil {
+		return nil, err
+	}
+	return &deployLogWriter{deploy: d.ID}, nil
+}
+
+func deployLogsAfter(id bson.ObjectId, seq int) ([]DeployLog, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var logs []DeployLog
+	query := bson.M{"deploy": id, "seq": bson.M{"$gt": seq}}
+	err = conn.DeployLogs().Find(query).Sort("seq").All(&logs)
+	return logs, err
+}
+
+func isDeployRunning(id bson.ObjectId) (bool, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	var d deploy
+	err = conn.Deploys().FindId(id).One(&d)
+	if err != nil {
+		return false, err
+	}
+	return d.Running, nil
+}
+
+// WriteDeployLog writes the stored output of the given deploy to w. When
+// follow is true and the deploy is still running, it keeps writing the output
+// of the deploy until the deploy finishes, or until the deploy doesn't write
+// any output for deployLogMaxIdle.
+func WriteDeployLog(d *deploy, w io.Writer, follow bool) error {
+	var messages chan []byte
+	if follow && d.Running {
+		factory, err := queue.Factory()
+		if err != nil {
+			return err
+		}
+		pubSubQ, err := factory.Get(deployLogQueueName(d.ID))
+		if err != nil {
+			return err
+		}
+		messages, err = pubSubQ.Sub()
+		if err != nil {
+			return err
+		}
+		defer pubSubQ.UnSub()
+	}
+	var seq int
+	lastOutput := time.Now()
+	writeLogs := func() error {
+		logs, err := deployLogsAfter(d.ID, seq)
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			if _, err := w.Write([]byte(l.Message)); err != nil {
+				return err
+			}
+			seq = l.Seq
+			lastOutput = time.Now()
+		}
+		return nil
+	}
+	if err := writeLogs(); err != nil || messages == nil {
+		return err
+	}
+	running, err := isDeployRunning(d.ID)
+	if err != nil {
+		return err
+	}
+	if !running {
+		return writeLogs()
+	}
+	for {
+		select {
+		case data, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var msg deployLogMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				log.Errorf("Unparsable deploy log message, ignoring: %s", string(data))
+				continue
+			}
+			if msg.End {
+				return writeLogs()
+			}
+			if msg.Log.Seq <= seq {
+				continue
+			}
+			if msg.Log.Seq > seq+1 {
+				// some messages were published before the subscription, they
+				// are read from the database.
+				if err := writeLogs(); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := w.Write([]byte(msg.Log.Message)); err != nil {
+				return err
+			}
+			seq = msg.Log.Seq
+			lastOutput = time.Now()
+		case <-time.After(deployLogPollInterval):
+			running, err := isDeployRunning(d.ID)
+			if err != nil {
+				return err
+			}
+			if !running || time.Since(lastOutput) >= deployLogMaxIdle {
+				return writeLogs()
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestStartDeploy(c *gocheck.C) {
+	d := deploy{App: "myapp", Commit: "e82nn93nd93mm12o2ueh83dhbd3iu112"}
+	w, err := startDeploy(&d)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveId(d.ID)
+	c.Assert(w.deploy, gocheck.Equals, d.ID)
+	var stored deploy
+	err = s.conn.Deploys().FindId(d.ID).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.App, gocheck.Equals, "myapp")
+	c.Assert(stored.Running, gocheck.Equals, true)
+}
+
+func (s *S) TestDeployLogWriter(c *gocheck.C) {
+	w := deployLogWriter{deploy: bson.NewObjectId()}
+	defer s.conn.DeployLogs().RemoveAll(bson.M{"deploy": w.deploy})
+	n, err := w.Write([]byte("building\n"))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 9)
+	_, err = w.Write([]byte("done\n"))
+	c.Assert(err, gocheck.IsNil)
+	var logs []DeployLog
+	err = s.conn.DeployLogs().Find(bson.M{"deploy": w.deploy}).Sort("seq").All(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 2)
+	c.Assert(logs[0].Seq, gocheck.Equals, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "building\n")
+	c.Assert(logs[1].Seq, gocheck.Equals, 2)
+	c.Assert(logs[1].Message, gocheck.Equals, "done\n")
+}
+
+func (s *S) TestSaveDeployDataFinishesDeploy(c *gocheck.C) {
+	d := deploy{App: "myapp"}
+	_, err := startDeploy(&d)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveId(d.ID)
+	err = saveDeployData(d, errors.New("failed"))
+	c.Assert(err, gocheck.IsNil)
+	var stored []deploy
+	err = s.conn.Deploys().Find(bson.M{"app": "myapp"}).All(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored, gocheck.HasLen, 1)
+	c.Assert(stored[0].Running, gocheck.Equals, false)
+	c.Assert(stored[0].Error, gocheck.Equals, "failed")
+}
+
+func (s *S) TestWriteDeployLog(c *gocheck.C) {
+	d := deploy{ID: bson.NewObjectId(), App: "myapp"}
+	err := s.conn.DeployLogs().Insert(
+		DeployLog{Deploy: d.ID, Seq: 2, Message: "done\n"},
+		DeployLog{Deploy: d.ID, Seq: 1, Message: "building\n"},
+		DeployLog{Deploy: bson.NewObjectId(), Seq: 1, Message: "other deploy\n"},
+	)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.DeployLogs().RemoveAll(nil)
+	var buf bytes.Buffer
+	err = WriteDeployLog(&d, &buf, true)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "building\ndone\n")
+}
+
+func (s *S) TestWriteDeployLogFollow(c *gocheck.C) {
+	old := deployLogPollInterval
+	deployLogPollInterval = 100 * time.Millisecond
+	defer func() {
+		deployLogPollInterval = old
+	}()
+	d := deploy{App: "myapp"}
+	w, err := startDeploy(&d)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveId(d.ID)
+	defer s.conn.DeployLogs().RemoveAll(bson.M{"deploy": d.ID})
+	_, err = w.Write([]byte("building\n"))
+	c.Assert(err, gocheck.IsNil)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done\n"))
+		saveDeployData(d, nil)
+		w.close()
+	}()
+	var buf bytes.Buffer
+	err = WriteDeployLog(&d, &buf, true)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "building\ndone\n")
+}
+
+func (s *S) TestWriteDeployLogFollowStopsWhenIdle(c *gocheck.C) {
+	oldInterval, oldIdle := deployLogPollInterval, deployLogMaxIdle
+	deployLogPollInterval = 50 * time.Millisecond
+	deployLogMaxIdle = 200 * time.Millisecond
+	defer func() {
+		deployLogPollInterval, deployLogMaxIdle = oldInterval, oldIdle
+	}()
+	d := deploy{App: "myapp"}
+	w, err := startDeploy(&d)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveId(d.ID)
+	defer s.conn.DeployLogs().RemoveAll(bson.M{"deploy": d.ID})
+	_, err = w.Write([]byte("building\n"))
+	c.Assert(err, gocheck.IsNil)
+	done := make(chan error)
+	var buf bytes.Buffer
+	go func() {
+		done <- WriteDeployLog(&d, &buf, true)
+	}()
+	select {
+	case err = <-done:
+		c.Assert(err, gocheck.IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("WriteDeployLog didn't stop following the idle deploy")
+	}
+	c.Assert(buf.String(), gocheck.Equals, "building\n")
+	running, err := isDeployRunning(d.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(running, gocheck.Equals, true)
+}
@@ -8,8 +8,10 @@ import (
 	"bytes"
 	"time"
 
+	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/auth/native"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/service"
 	"github.com/tsuru/tsuru/testing"
 	"gopkg.in/mgo.v2/bson"
@@ -406,6 +408,38 @@ func (s *S) TestDeployAppSaveDeployData(c *gocheck.C) {
 	c.Assert(diff < 60*time.Second, gocheck.Equals, true)
 	c.Assert(result["duration"], gocheck.Not(gocheck.Equals), 0)
 	c.Assert(result["commit"], gocheck.Equals, commit)
+	c.Assert(result["image"], gocheck.Equals, "tsuru/app-otherapp:v1")
+	c.Assert(result["rollback"], gocheck.Equals, false)
+}
+
+func (s *S) TestDeployAppSaveDeployLog(c *gocheck.C) {
+	a := App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	writer := &bytes.Buffer{}
+	err = Deploy(DeployOptions{
+		App:          &a,
+		Version:      "version",
+		Commit:       "1ee1f1084927b3a5db59c9033bc5c4abefb7b93c",
+		OutputStream: writer,
+	})
+	c.Assert(err, gocheck.IsNil)
+	var d deploy
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.DeployLogs().RemoveAll(bson.M{"deploy": d.ID})
+	c.Assert(d.Running, gocheck.Equals, false)
+	var buf bytes.Buffer
+	err = WriteDeployLog(&d, &buf, false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, writer.String())
 }
 
 func (s *S) TestDeployCustomPipeline(c *gocheck.C) {
@@ -513,3 +547,276 @@ func (s *S) TestUserHasNoPermission(c *gocheck.C) {
 	hasPermission := userHasPermission(user, a.Name)
 	c.Assert(hasPermission, gocheck.Equals, false)
 }
+
+func (s *S) TestRollback(c *gocheck.C) {
+	s.conn.Deploys().RemoveAll(nil)
+	a := App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	writer := &bytes.Buffer{}
+	for _, commit := range []string{"1ee1f1084927b3a5db59c9033bc5c4abefb7b93c", "fdc0b16a6ed3a7ba6b2d1dc7b5f3f4e2fa0f5c3a"} {
+		err = Deploy(DeployOptions{App: &a, Version: "version", Commit: commit, OutputStream: writer})
+		c.Assert(err, gocheck.IsNil)
+	}
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, "tsuru/app-otherapp:v2")
+	writer.Reset()
+	err = Rollback(&a, "tsuru/app-otherapp:v1", writer)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(writer.String(), gocheck.Equals, "Rollback called")
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, "tsuru/app-otherapp:v1")
+	s.conn.Apps().Find(bson.M{"name": a.Name}).One(&a)
+	c.Assert(a.Deploys, gocheck.Equals, uint(2))
+	var result deploy
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name, "rollback": true}).One(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result.Image, gocheck.Equals, "tsuru/app-otherapp:v1")
+	c.Assert(result.Commit, gocheck.Equals, "1ee1f1084927b3a5db59c9033bc5c4abefb7b93c")
+	c.Assert(result.Error, gocheck.Equals, "")
+}
+
+func (s *S) TestRollbackImageNotDeployed(c *gocheck.C) {
+	s.conn.Deploys().RemoveAll(nil)
+	a := App{Name: "otherapp", Platform: "zend"}
+	err := s.conn.Deploys().Insert(deploy{App: a.Name, Image: "tsuru/app-otherapp:v1", Error: "failed"})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	err = Rollback(&a, "tsuru/app-otherapp:v1", &bytes.Buffer{})
+	c.Assert(err, gocheck.Equals, ErrDeployImageNotFound)
+}
+
+// minimalProvisioner implements only the methods of provision.Provisioner.
+type minimalProvisioner struct {
+	provision.Provisioner
+}
+
+func (s *S) TestRollbackNotSupported(c *gocheck.C) {
+	Provisioner = minimalProvisioner{s.provisioner}
+	defer func() {
+		Provisioner = s.provisioner
+	}()
+	a := App{Name: "otherapp", Platform: "zend"}
+	err := Rollback(&a, "tsuru/app-otherapp:v1", &bytes.Buffer{})
+	c.Assert(err, gocheck.Equals, ErrRollbackNotSupported)
+}
+
+func (s *S) TestPromote(c *gocheck.C) {
+	source := App{Name: "myapp-staging", Platform: "zend", Teams: []string{s.team.Name}}
+	a := App{Name: "myapp-prod", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(source, a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{source.Name, a.Name}}})
+	defer s.conn.Deploys().RemoveAll(nil)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	sourceDeploy := deploy{
+		ID:        bson.NewObjectId(),
+		App:       source.Name,
+		Commit:    "1ee1f1084927b3a5db59c9033bc5c4abefb7b93c",
+		Image:     "tsuru/app-myapp-staging:v3",
+		Timestamp: time.Now(),
+	}
+	err = s.conn.Deploys().Insert(sourceDeploy)
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	err = Promote(&source, &a, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*Promoting image tsuru/app-myapp-staging:v3 from app myapp-staging.*Image deploy called")
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, "tsuru/app-myapp-prod:v1")
+	var d deploy
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.Image, gocheck.Equals, "tsuru/app-myapp-prod:v1")
+	c.Assert(d.Commit, gocheck.Equals, sourceDeploy.Commit)
+	c.Assert(d.SourceApp, gocheck.Equals, source.Name)
+	c.Assert(d.SourceDeploy, gocheck.Equals, sourceDeploy.ID)
+}
+
+func (s *S) TestPromoteSourceNotDeployed(c *gocheck.C) {
+	source := App{Name: "myapp-staging", Platform: "zend"}
+	a := App{Name: "myapp-prod", Platform: "zend"}
+	err := s.conn.Deploys().Insert(deploy{App: source.Name, Image: "tsuru/app-myapp-staging:v1", Error: "failed"})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(nil)
+	err = Promote(&source, &a, &bytes.Buffer{})
+	c.Assert(err, gocheck.Equals, ErrNoImageToPromote)
+}
+
+func (s *S) TestPromoteNotSupported(c *gocheck.C) {
+	Provisioner = minimalProvisioner{s.provisioner}
+	defer func() {
+		Provisioner = s.provisioner
+	}()
+	source := App{Name: "myapp-staging", Platform: "zend"}
+	a := App{Name: "myapp-prod", Platform: "zend"}
+	err := Promote(&source, &a, &bytes.Buffer{})
+	c.Assert(err, gocheck.Equals, ErrImageDeployNotSupported)
+}
+
+func (s *S) TestStartCanary(c *gocheck.C) {
+	a := App{Name: "myapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var buf bytes.Buffer
+	err = StartCanary(&a, "tsuru/app-myapp:v2", 2, 10, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "Start canary called")
+	err = SetCanaryWeight(&a, 50)
+	c.Assert(err, gocheck.IsNil)
+	image, units, weight := s.provisioner.Canary(&a)
+	c.Assert(image, gocheck.Equals, "tsuru/app-myapp:v2")
+	c.Assert(units, gocheck.Equals, 2)
+	c.Assert(weight, gocheck.Equals, 50)
+}
+
+func (s *S) TestPromoteCanary(c *gocheck.C) {
+	a := App{Name: "myapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(nil)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = s.conn.Deploys().Insert(deploy{
+		App:       a.Name,
+		Commit:    "1ee1f1084927b3a5db59c9033bc5c4abefb7b93c",
+		Image:     "tsuru/app-myapp:v2",
+		Timestamp: time.Now(),
+	})
+	c.Assert(err, gocheck.IsNil)
+	err = StartCanary(&a, "tsuru/app-myapp:v2", 1, 10, &bytes.Buffer{})
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	err = PromoteCanary(&a, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "Promote canary called")
+	c.Assert(s.provisioner.Image(&a), gocheck.Equals, "tsuru/app-myapp:v2")
+	var deploys []deploy
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).Sort("-timestamp").All(&deploys)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(deploys, gocheck.HasLen, 2)
+	c.Assert(deploys[0].Image, gocheck.Equals, "tsuru/app-myapp:v2")
+	c.Assert(deploys[0].Commit, gocheck.Equals, "1ee1f1084927b3a5db59c9033bc5c4abefb7b93c")
+	c.Assert(deploys[0].Running, gocheck.Equals, false)
+	c.Assert(deploys[0].Error, gocheck.Equals, "")
+}
+
+func (s *S) TestAbortCanary(c *gocheck.C) {
+	a := App{Name: "myapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = StartCanary(&a, "tsuru/app-myapp:v2", 1, 10, &bytes.Buffer{})
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	err = AbortCanary(&a, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "Abort canary called")
+	image, _, _ := s.provisioner.Canary(&a)
+	c.Assert(image, gocheck.Equals, "")
+	err = AbortCanary(&a, &buf)
+	c.Assert(err, gocheck.Equals, provision.ErrNoCanaryInProgress)
+}
+
+func (s *S) TestCanaryNotSupported(c *gocheck.C) {
+	Provisioner = minimalProvisioner{s.provisioner}
+	defer func() {
+		Provisioner = s.provisioner
+	}()
+	a := App{Name: "myapp", Platform: "zend"}
+	err := StartCanary(&a, "tsuru/app-myapp:v2", 1, 10, &bytes.Buffer{})
+	c.Assert(err, gocheck.Equals, ErrCanaryNotSupported)
+	err = SetCanaryWeight(&a, 10)
+	c.Assert(err, gocheck.Equals, ErrCanaryNotSupported)
+	err = PromoteCanary(&a, &bytes.Buffer{})
+	c.Assert(err, gocheck.Equals, ErrCanaryNotSupported)
+	err = AbortCanary(&a, &bytes.Buffer{})
+	c.Assert(err, gocheck.Equals, ErrCanaryNotSupported)
+}
+
+func (s *S) TestDeployCanceled(c *gocheck.C) {
+	a := App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.BlockDeploys(&a)
+	errs := make(chan error)
+	go func() {
+		errs <- Deploy(DeployOptions{App: &a, Version: "version", OutputStream: &bytes.Buffer{}})
+	}()
+	err = CancelDeploy(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(<-errs, gocheck.Equals, provision.ErrDeployCanceled)
+	var result deploy
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result.Error, gocheck.Equals, provision.ErrDeployCanceled.Error())
+	s.conn.Apps().Find(bson.M{"name": a.Name}).One(&a)
+	c.Assert(a.Deploys, gocheck.Equals, uint(0))
+}
+
+func (s *S) TestDeployTimeout(c *gocheck.C) {
+	config.Set("deploy:max-duration", 1)
+	defer config.Unset("deploy:max-duration")
+	a := App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.BlockDeploys(&a)
+	err = Deploy(DeployOptions{App: &a, Version: "version", OutputStream: &bytes.Buffer{}})
+	c.Assert(err, gocheck.Equals, ErrDeployTimeout)
+	var result deploy
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result.Error, gocheck.Equals, ErrDeployTimeout.Error())
+}
+
+func (s *S) TestDeployMaxDuration(c *gocheck.C) {
+	c.Assert(deployMaxDuration(), gocheck.Equals, time.Duration(0))
+	config.Set("deploy:max-duration", 600)
+	defer config.Unset("deploy:max-duration")
+	c.Assert(deployMaxDuration(), gocheck.Equals, 10*time.Minute)
+}
+
+func (s *S) TestCancelDeployNotInProgress(c *gocheck.C) {
+	a := App{Name: "otherapp", Platform: "zend"}
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err := CancelDeploy(&a)
+	c.Assert(err, gocheck.Equals, provision.ErrNoDeployInProgress)
+}
+
+func (s *S) TestCancelDeployNotSupported(c *gocheck.C) {
+	Provisioner = minimalProvisioner{s.provisioner}
+	defer func() {
+		Provisioner = s.provisioner
+	}()
+	a := App{Name: "otherapp", Platform: "zend"}
+	err := CancelDeploy(&a)
+	c.Assert(err, gocheck.Equals, ErrDeployCancelNotSupported)
+}
@@ -0,0 +1,391 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ExpressionError is returned when the expression of an auto scale action is
+// not valid.
+type ExpressionError struct {
+	expression string
+	reason     string
+}
+
+func (e ExpressionError) Error() string {
+	return fmt.Sprintf("invalid expression %q: %s", e.expression, e.reason)
+}
+
+// metricQuery is a reference to a metric in an expression, like {cpu_max} or
+// avg({cpu_max}, 5m). Queries without aggregation refer to the last value of
+// the metric.
+type metricQuery struct {
+	kind        string
+	aggregation string
+	window      time.Duration
+}
+
+func (q metricQuery) String() string {
+	if q.aggregation == "" {
+		return "{" + q.kind + "}"
+	}
+	return fmt.Sprintf("%s({%s}, %s)", q.aggregation, q.kind, q.window)
+}
+
+// metricReader returns the value of a metric used in an expression.
+type metricReader func(q metricQuery) (float64, error)
+
+// expression is a parsed auto scale expression. Expressions compare metrics
+// with numbers, and combine comparisons with the and, or and not operators,
+// like:
+//
+//	avg({cpu_max}, 5m) > 80 and not max({mem_max}, 10m) <= 50
+//
+// The available aggregations are avg, min, max and percentiles like p95.
+type expression interface {
+	eval(read metricReader) (bool, error)
+	queries() []metricQuery
+}
+
+type comparison struct {
+	query    metricQuery
+	operator string
+	value    float64
+}
+
+func (c *comparison) eval(read metricReader) (bool, error) {
+	v, err := read(c.query)
+	if err != nil {
+		return false, err
+	}
+	switch c.operator {
+	case ">":
+		return v > c.value, nil
+	case ">=":
+		return v >= c.value, nil
+	case "<":
+		return v < c.value, nil
+	case "<=":
+		return v <= c.value, nil
+	case "!=":
+		return v != c.value, nil
+	}
+	return v == c.value, nil
+}
+
+func (c *comparison) queries() []metricQuery {
+	return []metricQuery{c.query}
+}
+
+type binaryExpression struct {
+	operator    string
+	left, right expression
+}
+
+func (e *binaryExpression) eval(read metricReader) (bool, error) {
+	left, err := e.left.eval(read)
+	if err != nil {
+		return false, err
+	}
+	if e.operator == "and" && !left || e.operator == "or" && left {
+		return left, nil
+	}
+	return e.right.eval(read)
+}
+
+func (e *binaryExpression) queries() []metricQuery {
+	return append(e.left.queries(), e.right.queries()...)
+}
+
+type notExpression struct {
+	expr expression
+}
+
+func (e *notExpression) eval(read metricReader) (bool, error) {
+	v, err := e.expr.eval(read)
+	return !v, err
+}
+
+func (e *notExpression) queries() []metricQuery {
+	return e.expr.queries()
+}
+
+const (
+	tokenEOF = iota
+	tokenMetric
+	tokenIdent
+	tokenNumber
+	tokenOperator
+	tokenPunct
+)
+
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	value := t.value
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenMetric:
+		value = "{" + value + "}"
+	}
+	return fmt.Sprintf("%q at position %d", value, t.pos+1)
+}
+
+type parser struct {
+	expression string
+	tokens     []token
+	pos        int
+}
+
+// parseExpression parses the expression of an auto scale action.
+func parseExpression(expr string) (expression, error) {
+	p := parser{expression: expr}
+	err := p.tokenize()
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf("unexpected %s", t)
+	}
+	return e, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return ExpressionError{expression: p.expression, reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) tokenize() error {
+	s := p.expression
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return p.errorf("unclosed metric at position %d", i+1)
+			}
+			name := strings.TrimSpace(s[i+1 : i+end])
+			if name == "" {
+				return p.errorf("empty metric name at position %d", i+1)
+			}
+			p.tokens = append(p.tokens, token{kind: tokenMetric, value: name, pos: i})
+			i += end + 1
+		case strings.ContainsRune("<>=!", r):
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			op := s[i:j]
+			if op == "!" {
+				return p.errorf("unknown operator %q at position %d", op, i+1)
+			}
+			if op == "==" {
+				op = "="
+			}
+			p.tokens = append(p.tokens, token{kind: tokenOperator, value: op, pos: i})
+			i = j
+		case strings.ContainsRune("(),", r):
+			p.tokens = append(p.tokens, token{kind: tokenPunct, value: string(r), pos: i})
+			i++
+		case unicode.IsDigit(r) || r == '.' || r == '-' || unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || unicode.IsLetter(rune(s[j])) || s[j] == '.' || s[j] == '_') {
+				j++
+			}
+			kind := tokenIdent
+			if !unicode.IsLetter(r) && r != '_' {
+				kind = tokenNumber
+			}
+			p.tokens = append(p.tokens, token{kind: kind, value: s[i:j], pos: i})
+			i = j
+		default:
+			return p.errorf("unexpected character %q at position %d", r, i+1)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokenEOF, pos: len(s)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(value string) error {
+	if t := p.next(); t.value != value || t.kind == tokenEOF {
+		return p.errorf("unexpected %s, expecting %q", t, value)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenIdent && p.peek().value == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenIdent && p.peek().value == "and" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpression{operator: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expression, error) {
+	t := p.peek()
+	if t.kind == tokenIdent && t.value == "not" {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpression{expr: e}, nil
+	}
+	if t.kind == tokenPunct && t.value == "(" {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expression, error) {
+	query, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	if op.kind != tokenOperator {
+		return nil, p.errorf("unexpected %s, expecting a comparison operator", op)
+	}
+	t := p.next()
+	if t.kind != tokenNumber {
+		return nil, p.errorf("unexpected %s, expecting a number", t)
+	}
+	value, err := strconv.ParseFloat(t.value, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", t)
+	}
+	return &comparison{query: query, operator: op.value, value: value}, nil
+}
+
+func (p *parser) parseQuery() (metricQuery, error) {
+	t := p.next()
+	if t.kind == tokenMetric {
+		return metricQuery{kind: t.value}, nil
+	}
+	if t.kind != tokenIdent {
+		return metricQuery{}, p.errorf("unexpected %s, expecting a metric", t)
+	}
+	if !validAggregation(t.value) {
+		return metricQuery{}, p.errorf("unknown aggregation %s", t)
+	}
+	query := metricQuery{aggregation: t.value}
+	if err := p.expect("("); err != nil {
+		return metricQuery{}, err
+	}
+	metric := p.next()
+	if metric.kind != tokenMetric {
+		return metricQuery{}, p.errorf("unexpected %s, expecting a metric", metric)
+	}
+	query.kind = metric.value
+	if err := p.expect(","); err != nil {
+		return metricQuery{}, err
+	}
+	window := p.next()
+	d, err := time.ParseDuration(window.value)
+	if window.kind != tokenNumber || err != nil || d <= 0 {
+		return metricQuery{}, p.errorf("unexpected %s, expecting a window like 5m", window)
+	}
+	query.window = d
+	return query, p.expect(")")
+}
+
+func validAggregation(name string) bool {
+	switch name {
+	case "avg", "min", "max":
+		return true
+	}
+	if strings.HasPrefix(name, "p") {
+		p, err := strconv.Atoi(name[1:])
+		return err == nil && p > 0 && p <= 100
+	}
+	return false
+}
+
+// aggregate aggregates the values of a metric in a window.
+func aggregate(aggregation string, values []float64) float64 {
+	switch aggregation {
+	case "avg":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	case "min", "max":
+		result := values[0]
+		for _, v := range values[1:] {
+			if aggregation == "min" && v < result || aggregation == "max" && v > result {
+				result = v
+			}
+		}
+		return result
+	}
+	percentile, _ := strconv.Atoi(aggregation[1:])
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(float64(percentile) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
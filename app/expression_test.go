@@ -0,0 +1,115 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"time"
+
+	"launchpad.net/gocheck"
+)
+
+func fixedMetrics(values map[string]float64) metricReader {
+	return func(q metricQuery) (float64, error) {
+		if v, ok := values[q.String()]; ok {
+			return v, nil
+		}
+		return 0, errors.New("there is no metrics")
+	}
+}
+
+func (s *S) TestParseExpressionQueries(c *gocheck.C) {
+	expr, err := parseExpression("{cpu} > 80 or (avg({cpu_max}, 5m) >= 70 and not p95({mem}, 1h) != 20)")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(expr.queries(), gocheck.DeepEquals, []metricQuery{
+		{kind: "cpu"},
+		{kind: "cpu_max", aggregation: "avg", window: 5 * time.Minute},
+		{kind: "mem", aggregation: "p95", window: time.Hour},
+	})
+}
+
+func (s *S) TestParseExpressionErrors(c *gocheck.C) {
+	cases := map[string]string{
+		"":                      "unexpected end of expression, expecting a metric",
+		"{cpu > 10":             "unclosed metric at position 1",
+		"{} > 10":               "empty metric name at position 1",
+		"{cpu} ! 10":            `unknown operator "!" at position 7`,
+		"{cpu} > 10 %":          `unexpected character '%' at position 12`,
+		"{cpu} > 10 {mem}":      `unexpected "\{mem\}" at position 12`,
+		"{cpu} 10":              `unexpected "10" at position 7, expecting a comparison operator`,
+		"{cpu} > {mem}":         `unexpected "\{mem\}" at position 9, expecting a number`,
+		"sum({cpu}, 5m) > 10":   `unknown aggregation "sum" at position 1`,
+		"avg({cpu}) > 10":       `unexpected "\)" at position 10, expecting ","`,
+		"avg({cpu}, 5) > 10":    `unexpected "5" at position 12, expecting a window like 5m`,
+		"({cpu} > 10 and {mem}": `unexpected end of expression, expecting a comparison operator`,
+		"({cpu} > 10":           `unexpected end of expression, expecting "\)"`,
+	}
+	for expression, reason := range cases {
+		_, err := parseExpression(expression)
+		c.Check(err, gocheck.FitsTypeOf, ExpressionError{})
+		c.Check(err, gocheck.ErrorMatches, `invalid expression ".*": `+reason, gocheck.Commentf(expression))
+	}
+}
+
+func (s *S) TestExpressionOperators(c *gocheck.C) {
+	read := fixedMetrics(map[string]float64{"{cpu}": 80})
+	cases := map[string]bool{
+		"{cpu} > 80":  false,
+		"{cpu} >= 80": true,
+		"{cpu} < 80":  false,
+		"{cpu} <= 80": true,
+		"{cpu} = 80":  true,
+		"{cpu} == 80": true,
+		"{cpu} != 80": false,
+	}
+	for expression, expected := range cases {
+		expr, err := parseExpression(expression)
+		c.Assert(err, gocheck.IsNil)
+		result, err := expr.eval(read)
+		c.Assert(err, gocheck.IsNil)
+		c.Check(result, gocheck.Equals, expected, gocheck.Commentf(expression))
+	}
+}
+
+func (s *S) TestExpressionBooleanOperators(c *gocheck.C) {
+	read := fixedMetrics(map[string]float64{
+		"{cpu}":              90,
+		"max({mem}, 10m0s)":  40,
+		"avg({cpu}, 5m0s)":   60,
+		"p95({cpu}, 1h0m0s)": 95,
+	})
+	cases := map[string]bool{
+		"{cpu} > 80 and max({mem}, 10m) > 50":                             false,
+		"{cpu} > 80 or max({mem}, 10m) > 50":                              true,
+		"not {cpu} > 80":                                                  false,
+		"avg({cpu}, 5m) > 80 or p95({cpu}, 1h) > 90 and {cpu} > 80":       true,
+		"(avg({cpu}, 5m) > 80 or p95({cpu}, 1h) > 90) and not {cpu} > 80": false,
+	}
+	for expression, expected := range cases {
+		expr, err := parseExpression(expression)
+		c.Assert(err, gocheck.IsNil)
+		result, err := expr.eval(read)
+		c.Assert(err, gocheck.IsNil)
+		c.Check(result, gocheck.Equals, expected, gocheck.Commentf(expression))
+	}
+}
+
+func (s *S) TestExpressionMissingMetric(c *gocheck.C) {
+	expr, err := parseExpression("{cpu} > 80 or {mem} > 80")
+	c.Assert(err, gocheck.IsNil)
+	_, err = expr.eval(fixedMetrics(map[string]float64{"{cpu}": 10}))
+	c.Assert(err, gocheck.ErrorMatches, "there is no metrics")
+}
+
+func (s *S) TestAggregate(c *gocheck.C) {
+	values := []float64{10, 50, 20, 90, 30, 40, 60, 70, 80, 100}
+	c.Assert(aggregate("avg", values), gocheck.Equals, 55.0)
+	c.Assert(aggregate("min", values), gocheck.Equals, 10.0)
+	c.Assert(aggregate("max", values), gocheck.Equals, 100.0)
+	c.Assert(aggregate("p50", values), gocheck.Equals, 50.0)
+	c.Assert(aggregate("p95", values), gocheck.Equals, 100.0)
+	c.Assert(aggregate("p90", values), gocheck.Equals, 90.0)
+	c.Assert(values[0], gocheck.Equals, 10.0)
+}
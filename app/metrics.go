@@ -9,13 +9,95 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/tsuru/config"
 )
 
+const defaultMetricsSource = "graphite"
+
+// metricsClient is the client used by the graphite and http metrics sources.
+var metricsClient = &http.Client{Timeout: 10 * time.Second}
+
+// MetricsSource is a source of metrics of the units of apps, used by the
+// auto scale to decide when to add or remove units.
+type MetricsSource interface {
+	// Metric returns the last value of the given kind of metric of the
+	// app, like "cpu_max".
+	Metric(app *App, kind string) (float64, error)
+}
+
+// SeriesMetricsSource is a MetricsSource that also returns the values of
+// metrics over a period of time, needed by auto scale expressions that
+// aggregate metrics over a window, like avg({cpu_max}, 5m).
+type SeriesMetricsSource interface {
+	MetricsSource
+
+	// Series returns the values of the given kind of metric of the app
+	// after from and up to until, oldest first.
+	Series(app *App, kind string, from, until time.Time) ([]MetricValue, error)
+}
+
+// MetricValue is the value of a metric at a given time.
+type MetricValue struct {
+	Time  time.Time
+	Value float64
+}
+
+var metricsSources = map[string]MetricsSource{
+	"graphite": graphiteSource{},
+	"http":     httpSource{},
+}
+
+// RegisterMetricsSource registers a new metrics source. This is how one would
+// add a new source of metrics to the auto scale.
+func RegisterMetricsSource(name string, source MetricsSource) {
+	metricsSources[name] = source
+}
+
+// getMetricsSource returns the metrics source of the app, defined in its auto
+// scale config or, when it's not defined there, by the
+// "autoscale:metrics-source" setting.
+func getMetricsSource(app *App) (MetricsSource, error) {
+	var name string
+	if app.AutoScaleConfig != nil {
+		name = app.AutoScaleConfig.MetricsSource
+	}
+	if name == "" {
+		name, _ = config.GetString("autoscale:metrics-source")
+	}
+	if name == "" {
+		name = defaultMetricsSource
+	}
+	if source, ok := metricsSources[name]; ok {
+		return source, nil
+	}
+	return nil, fmt.Errorf("Metrics source %q is not known.", name)
+}
+
 type metrics struct {
 	DataPoints [][]float64
 }
 
+type seriesMetrics struct {
+	DataPoints [][]*float64
+}
+
+// graphiteSource reads the metrics sent by the units of the app to statsite,
+// from the Graphite server in the GRAPHITE_HOST environment variable of the
+// app.
+type graphiteSource struct{}
+
+func (graphiteSource) Metric(app *App, kind string) (float64, error) {
+	if !hasMetricsEnabled(app) {
+		return 0, errors.New("metrics disabled")
+	}
+	return getLastMetric(app, kind)
+}
+
 func hasMetricsEnabled(app *App) bool {
 	_, ok := app.Env["GRAPHITE_HOST"]
 	return ok
@@ -27,30 +109,184 @@ func getLastMetric(app *App, kind string) (float64, error) {
 		host = fmt.Sprintf("http://%s", host)
 	}
 	url := fmt.Sprintf("%s/render/?target=keepLastValue(maxSeries(statsite.tsuru.%s.*.*.%s))&from=-10min&format=json", host, app.Name, kind)
-	resp, err := http.Get(url)
+	resp, err := metricsClient.Get(url)
 	if err != nil {
 		return 0, err
 	}
+	defer resp.Body.Close()
 	var data []metrics
 	err = json.NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
 		return 0, errors.New("metrics disabled")
 	}
-	defer resp.Body.Close()
-	if len(data[0].DataPoints) > 0 {
+	if len(data) > 0 && len(data[0].DataPoints) > 0 {
 		index := len(data[0].DataPoints) - 1
 		return data[0].DataPoints[index][0], nil
 	}
 	return 0, errors.New("there is no metrics")
 }
 
-func (app *App) Metric(kind string) (float64, error) {
-	if hasMetricsEnabled(app) {
-		m, err := getLastMetric(app, kind)
-		if err != nil {
-			return 0, err
+func (graphiteSource) Series(app *App, kind string, from, until time.Time) ([]MetricValue, error) {
+	if !hasMetricsEnabled(app) {
+		return nil, errors.New("metrics disabled")
+	}
+	host := app.Env["GRAPHITE_HOST"].Value
+	if !strings.Contains(host, "http") {
+		host = fmt.Sprintf("http://%s", host)
+	}
+	url := fmt.Sprintf("%s/render/?target=maxSeries(statsite.tsuru.%s.*.*.%s)&from=%d&until=%d&format=json", host, app.Name, kind, from.Unix(), until.Unix())
+	resp, err := metricsClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var data []seriesMetrics
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("metrics disabled")
+	}
+	var values []MetricValue
+	for _, point := range data[0].DataPoints {
+		if len(point) > 1 && point[0] != nil && point[1] != nil {
+			values = append(values, MetricValue{Time: time.Unix(int64(*point[1]), 0), Value: *point[0]})
 		}
-		return m, nil
 	}
-	return 0, errors.New("metrics disabled")
+	return values, nil
+}
+
+// httpSource reads metrics from the HTTP server in the
+// "autoscale:http-metrics-url" setting, which answers requests like
+// GET <url>?app=myapp&metric=cpu_max with a JSON object like {"value": 8.2},
+// and requests with the from and until parameters, as Unix timestamps, with a
+// JSON object like {"values": [{"time": 1425643200, "value": 7.1}]}. It's
+// meant to be used as a stand-in for real metrics in local installations and
+// tests.
+type httpSource struct{}
+
+func (httpSource) Metric(app *App, kind string) (float64, error) {
+	baseURL, err := config.GetString("autoscale:http-metrics-url")
+	if err != nil {
+		return 0, errors.New("metrics disabled")
+	}
+	params := url.Values{"app": []string{app.Name}, "metric": []string{kind}}
+	resp, err := metricsClient.Get(baseURL + "?" + params.Encode())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("invalid response code from metrics server: %d", resp.StatusCode)
+	}
+	var data struct {
+		Value *float64
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return 0, err
+	}
+	if data.Value == nil {
+		return 0, errors.New("there is no metrics")
+	}
+	return *data.Value, nil
+}
+
+func (httpSource) Series(app *App, kind string, from, until time.Time) ([]MetricValue, error) {
+	baseURL, err := config.GetString("autoscale:http-metrics-url")
+	if err != nil {
+		return nil, errors.New("metrics disabled")
+	}
+	params := url.Values{
+		"app":    []string{app.Name},
+		"metric": []string{kind},
+		"from":   []string{strconv.FormatInt(from.Unix(), 10)},
+		"until":  []string{strconv.FormatInt(until.Unix(), 10)},
+	}
+	resp, err := metricsClient.Get(baseURL + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid response code from metrics server: %d", resp.StatusCode)
+	}
+	var data struct {
+		Values []struct {
+			Time  int64
+			Value float64
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]MetricValue, len(data.Values))
+	for i, v := range data.Values {
+		values[i] = MetricValue{Time: time.Unix(v.Time, 0), Value: v.Value}
+	}
+	return values, nil
+}
+
+func (app *App) Metric(kind string) (float64, error) {
+	source, err := getMetricsSource(app)
+	if err != nil {
+		return 0, err
+	}
+	return source.Metric(app, kind)
+}
+
+// readMetric returns the value of a metric used in auto scale expressions,
+// aggregating the values of the metric in the window ending now when the
+// query has an aggregation.
+func (app *App) readMetric(q metricQuery, now time.Time) (float64, error) {
+	source, err := getMetricsSource(app)
+	if err != nil {
+		return 0, err
+	}
+	if q.aggregation == "" {
+		return source.Metric(app, q.kind)
+	}
+	seriesSource, ok := source.(SeriesMetricsSource)
+	if !ok {
+		return 0, errors.New("the metrics source doesn't support aggregations over windows")
+	}
+	return readSeriesMetric(app, seriesSource, q, now)
+}
+
+// lastValueWindow is how far in the past readSeriesMetric looks for the last
+// value of metrics, like the Graphite source does.
+const lastValueWindow = 10 * time.Minute
+
+// readSeriesMetric returns the value of a metric used in auto scale
+// expressions at the given time, from the series of the metric in the source.
+func readSeriesMetric(app *App, source SeriesMetricsSource, q metricQuery, at time.Time) (float64, error) {
+	values, err := source.Series(app, q.kind, at.Add(-q.seriesWindow()), at)
+	if err != nil {
+		return 0, err
+	}
+	return seriesMetric(q, values)
+}
+
+// seriesWindow returns how far in the past the values of the metric of the
+// query are read from its series.
+func (q metricQuery) seriesWindow() time.Duration {
+	if q.aggregation == "" {
+		return lastValueWindow
+	}
+	return q.window
+}
+
+// seriesMetric returns the value of the query from the values of the metric
+// in its window, oldest first.
+func seriesMetric(q metricQuery, values []MetricValue) (float64, error) {
+	if len(values) == 0 {
+		return 0, errors.New("there is no metrics")
+	}
+	if q.aggregation == "" {
+		return values[len(values)-1].Value, nil
+	}
+	floats := make([]float64, len(values))
+	for i, v := range values {
+		floats[i] = v.Value
+	}
+	return aggregate(q.aggregation, floats), nil
 }
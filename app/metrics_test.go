@@ -8,8 +8,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"time"
 
+	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app/bind"
 	"launchpad.net/gocheck"
 )
@@ -79,6 +82,49 @@ func (s *S) TestMetricServerDown(c *gocheck.C) {
 	c.Assert(err, gocheck.Not(gocheck.IsNil))
 }
 
+func (s *S) TestMetricServerTimeout(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer ts.Close()
+	oldClient := metricsClient
+	metricsClient = &http.Client{Timeout: 50 * time.Millisecond}
+	defer func() { metricsClient = oldClient }()
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Env: map[string]bind.EnvVar{
+			"GRAPHITE_HOST": {
+				Name:   "GRAPHITE_HOST",
+				Value:  ts.URL,
+				Public: true,
+			},
+		},
+	}
+	_, err := newApp.Metric("cpu")
+	c.Assert(err, gocheck.Not(gocheck.IsNil))
+}
+
+func (s *S) TestMetricWithoutSeries(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Env: map[string]bind.EnvVar{
+			"GRAPHITE_HOST": {
+				Name:   "GRAPHITE_HOST",
+				Value:  ts.URL,
+				Public: true,
+			},
+		},
+	}
+	_, err := newApp.Metric("cpu")
+	c.Assert(err, gocheck.ErrorMatches, "there is no metrics")
+}
+
 func (s *S) TestMetricEnvWithoutSchema(c *gocheck.C) {
 	h := metricHandler{cpuMax: "8.2"}
 	ts := httptest.NewServer(&h)
@@ -98,3 +144,91 @@ func (s *S) TestMetricEnvWithoutSchema(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(cpu, gocheck.Equals, 8.2)
 }
+
+type fakeMetricsSource struct {
+	value float64
+	kinds []string
+}
+
+func (s *fakeMetricsSource) Metric(app *App, kind string) (float64, error) {
+	s.kinds = append(s.kinds, kind)
+	return s.value, nil
+}
+
+func (s *S) TestRegisterMetricsSource(c *gocheck.C) {
+	source := fakeMetricsSource{value: 42}
+	RegisterMetricsSource("fake-source", &source)
+	defer delete(metricsSources, "fake-source")
+	config.Set("autoscale:metrics-source", "fake-source")
+	defer config.Unset("autoscale:metrics-source")
+	newApp := App{Name: "myApp", Platform: "Django"}
+	cpu, err := newApp.Metric("cpu_max")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cpu, gocheck.Equals, 42.0)
+	c.Assert(source.kinds, gocheck.DeepEquals, []string{"cpu_max"})
+}
+
+func (s *S) TestMetricSourceFromAutoScaleConfig(c *gocheck.C) {
+	source := fakeMetricsSource{value: 42}
+	RegisterMetricsSource("fake-source", &source)
+	defer delete(metricsSources, "fake-source")
+	config.Set("autoscale:metrics-source", "graphite")
+	defer config.Unset("autoscale:metrics-source")
+	newApp := App{
+		Name:            "myApp",
+		Platform:        "Django",
+		AutoScaleConfig: &AutoScaleConfig{MetricsSource: "fake-source"},
+	}
+	cpu, err := newApp.Metric("cpu_max")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cpu, gocheck.Equals, 42.0)
+}
+
+func (s *S) TestMetricUnknownSource(c *gocheck.C) {
+	config.Set("autoscale:metrics-source", "unknown")
+	defer config.Unset("autoscale:metrics-source")
+	newApp := App{Name: "myApp", Platform: "Django"}
+	_, err := newApp.Metric("cpu_max")
+	c.Assert(err, gocheck.ErrorMatches, `Metrics source "unknown" is not known.`)
+}
+
+func (s *S) TestMetricHTTPSource(c *gocheck.C) {
+	var query url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"value": 8.2}`))
+	}))
+	defer ts.Close()
+	config.Set("autoscale:metrics-source", "http")
+	defer config.Unset("autoscale:metrics-source")
+	config.Set("autoscale:http-metrics-url", ts.URL+"/metrics")
+	defer config.Unset("autoscale:http-metrics-url")
+	newApp := App{Name: "myApp", Platform: "Django"}
+	cpu, err := newApp.Metric("cpu_max")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cpu, gocheck.Equals, 8.2)
+	c.Assert(query.Get("app"), gocheck.Equals, "myApp")
+	c.Assert(query.Get("metric"), gocheck.Equals, "cpu_max")
+}
+
+func (s *S) TestMetricHTTPSourceWithoutValue(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	config.Set("autoscale:metrics-source", "http")
+	defer config.Unset("autoscale:metrics-source")
+	config.Set("autoscale:http-metrics-url", ts.URL)
+	defer config.Unset("autoscale:http-metrics-url")
+	newApp := App{Name: "myApp", Platform: "Django"}
+	_, err := newApp.Metric("cpu_max")
+	c.Assert(err, gocheck.ErrorMatches, "there is no metrics")
+}
+
+func (s *S) TestMetricHTTPSourceNotConfigured(c *gocheck.C) {
+	config.Set("autoscale:metrics-source", "http")
+	defer config.Unset("autoscale:metrics-source")
+	newApp := App{Name: "myApp", Platform: "Django"}
+	_, err := newApp.Metric("cpu_max")
+	c.Assert(err, gocheck.ErrorMatches, "metrics disabled")
+}
@@ -0,0 +1,131 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// movedBackendsInterval is the interval between the runs of the moved
+// backends worker.
+const movedBackendsInterval = 10 * time.Second
+
+var movedBackendsOnce sync.Once
+
+// movedBackend is a backend left by ChangeRouter in the previous router of an
+// app, Router, to be removed after RemoveAt. It's stored in the database, so
+// any API instance can remove it, even after a restart.
+type movedBackend struct {
+	ID       bson.ObjectId `bson:"_id"`
+	Router   string
+	Backend  router.MovedBackend
+	RemoveAt time.Time
+}
+
+func scheduleMovedBackendRemoval(routerName string, moved *router.MovedBackend, gracePeriod time.Duration) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.MovedBackends().Insert(movedBackend{
+		ID:       bson.NewObjectId(),
+		Router:   routerName,
+		Backend:  *moved,
+		RemoveAt: time.Now().Add(gracePeriod),
+	})
+}
+
+// StartMovedBackendsWorker starts the worker that removes the backends left in
+// the previous router of apps by ChangeRouter.
+func StartMovedBackendsWorker() {
+	movedBackendsOnce.Do(func() {
+		go func() {
+			for {
+				runMovedBackendsOnce(time.Now())
+				time.Sleep(movedBackendsInterval)
+			}
+		}()
+	})
+}
+
+// runMovedBackendsOnce removes the moved backends whose grace period is over
+// at the given time, and syncs the routes of the others with the units of
+// their apps. Apps that are locked are skipped until the next run.
+func runMovedBackendsOnce(now time.Time) {
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("[moved backends] Failed to connect to the database: %s", err)
+		return
+	}
+	defer conn.Close()
+	var backends []movedBackend
+	err = conn.MovedBackends().Find(nil).All(&backends)
+	if err != nil {
+		log.Errorf("[moved backends] Failed to list the moved backends: %s", err)
+		return
+	}
+	for _, backend := range backends {
+		err = processMovedBackend(&backend, now)
+		if err != nil {
+			log.Errorf("[moved backends] Failed to handle the backend of %q in the router %q: %s", backend.Backend.App, backend.Router, err)
+		}
+	}
+}
+
+// processMovedBackend removes the moved backend when its grace period is over
+// at the given time, or syncs its routes otherwise. The backend of an app that
+// was removed is removed right away.
+func processMovedBackend(backend *movedBackend, now time.Time) error {
+	appName := backend.Backend.App
+	app, err := GetByName(appName)
+	if err != nil && err != ErrAppNotFound {
+		return err
+	}
+	if app != nil {
+		locked, err := AcquireApplicationLock(appName, InternalAppName, "moved-backend")
+		if err != nil || !locked {
+			return err
+		}
+		defer ReleaseApplicationLock(appName)
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Another API instance may have removed the backend before the lock was
+	// acquired.
+	count, err := conn.MovedBackends().FindId(backend.ID).Count()
+	if err != nil || count == 0 {
+		return err
+	}
+	from, err := router.Get(backend.Router)
+	if err != nil {
+		return err
+	}
+	if app != nil && now.Before(backend.RemoveAt) {
+		return app.syncMovedBackend(from, &backend.Backend)
+	}
+	err = router.RemoveMovedBackend(from, &backend.Backend)
+	if err != nil {
+		return err
+	}
+	return conn.MovedBackends().RemoveId(backend.ID)
+}
+
+func (app *App) syncMovedBackend(from router.Router, moved *router.MovedBackend) error {
+	to, err := app.getRouter()
+	if err != nil {
+		return err
+	}
+	return router.SyncMovedBackend(from, to, moved)
+}
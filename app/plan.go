@@ -11,6 +11,7 @@ import (
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/router"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -23,6 +24,8 @@ type Plan struct {
 	CpuShare int    `json:"cpushare"`
 	Default  bool   `json:"default,omitempty"`
 	Router   string `json:"router,omitempty"`
+
+	Provisioner string `json:"provisioner,omitempty"`
 }
 
 type PlanValidationError struct{ field string }
@@ -50,6 +53,12 @@ func (plan *Plan) Save() error {
 			return PlanValidationError{"router"}
 		}
 	}
+	if plan.Provisioner != "" {
+		_, err := provision.Get(plan.Provisioner)
+		if err != nil {
+			return PlanValidationError{"provisioner"}
+		}
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return err
@@ -75,6 +84,13 @@ func (plan *Plan) getRouter() (string, error) {
 	return config.GetString("docker:router")
 }
 
+func (plan *Plan) getProvisioner() string {
+	if plan.Provisioner != "" {
+		return plan.Provisioner
+	}
+	return defaultProvisionerName()
+}
+
 func PlansList() ([]Plan, error) {
 	conn, err := db.Conn()
 	if err != nil {
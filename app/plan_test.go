@@ -67,6 +67,13 @@ func (s *S) TestPlanAddInvalid(c *gocheck.C) {
 			CpuShare: 100,
 			Router:   "invalid",
 		},
+		{
+			Name:        "plan1",
+			Memory:      1024,
+			Swap:        1024,
+			CpuShare:    100,
+			Provisioner: "invalid",
+		},
 	}
 	for _, p := range invalidPlans {
 		err := p.Save()
@@ -223,3 +230,30 @@ func (s *S) TestPlanGetRouter(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(r2, gocheck.Equals, "defaultrouter")
 }
+
+func (s *S) TestPlanAddWithProvisioner(c *gocheck.C) {
+	p := Plan{
+		Name:        "plan1",
+		Memory:      1024,
+		Swap:        1024,
+		CpuShare:    100,
+		Provisioner: "fake",
+	}
+	err := p.Save()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(p.Name)
+	dbPlan, err := findPlanByName(p.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(*dbPlan, gocheck.DeepEquals, p)
+}
+
+func (s *S) TestPlanGetProvisioner(c *gocheck.C) {
+	config.Set("provisioner", "defaultprovisioner")
+	defer config.Unset("provisioner")
+	p := Plan{Name: "plan1", Provisioner: "myprovisioner"}
+	c.Assert(p.getProvisioner(), gocheck.Equals, "myprovisioner")
+	p2 := Plan{Name: "plan2"}
+	c.Assert(p2.getProvisioner(), gocheck.Equals, "defaultprovisioner")
+	config.Unset("provisioner")
+	c.Assert(p2.getProvisioner(), gocheck.Equals, "docker")
+}
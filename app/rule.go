@@ -0,0 +1,180 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"fmt"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrRuleNotFound = stderr.New("rule not found")
+
+	// ErrRuleTarget is returned when an app that gets the requests of path
+	// rules of other apps is moved to another router.
+	ErrRuleTarget = stderr.New("App is the target of path rules of other apps, remove them before changing its router.")
+
+	// ErrRuleTargetRemoval is returned when an app that gets the requests of
+	// path rules of other apps is removed.
+	ErrRuleTargetRemoval = stderr.New("App is the target of path rules of other apps, remove them before removing it.")
+
+	// ErrPathRules is returned when an app with path rules is moved to
+	// another router, as the apps of the rules don't use it.
+	ErrPathRules = stderr.New("App has path rules, remove them before changing its router.")
+)
+
+func (app *App) ruleRouter() (router.RuleRouter, error) {
+	r, err := app.getRouter()
+	if err != nil {
+		return nil, err
+	}
+	ruleRouter, ok := r.(router.RuleRouter)
+	if !ok {
+		return nil, router.ErrRulesNotSupported
+	}
+	return ruleRouter, nil
+}
+
+// validateRuleTarget checks that the app of a path rule uses the same router
+// of the app.
+func (app *App) validateRuleTarget(rule *router.Rule) error {
+	if rule.Type != router.PathRule {
+		return nil
+	}
+	if rule.App == app.Name {
+		return &errors.ValidationError{Message: "Path rules can't send requests to the app itself."}
+	}
+	target, err := GetByName(rule.App)
+	if err == ErrAppNotFound {
+		return &errors.ValidationError{Message: fmt.Sprintf("App %q not found.", rule.App)}
+	}
+	if err != nil {
+		return err
+	}
+	appRouter, err := app.GetRouter()
+	if err != nil {
+		return err
+	}
+	targetRouter, err := target.GetRouter()
+	if err != nil {
+		return err
+	}
+	if appRouter != targetRouter {
+		return &errors.ValidationError{Message: fmt.Sprintf("App %q doesn't use the router of the app %q.", rule.App, app.Name)}
+	}
+	return nil
+}
+
+// AddRule validates the rule and adds it to the app, sending all rules of the
+// app to its router. The name of the rule must be unique in the app.
+func (app *App) AddRule(rule router.Rule) error {
+	err := rule.Validate()
+	if err != nil {
+		return err
+	}
+	for _, r := range app.Rules {
+		if r.Name == rule.Name {
+			return &errors.ValidationError{Message: fmt.Sprintf("The app already has a rule named %q.", rule.Name)}
+		}
+	}
+	err = app.validateRuleTarget(&rule)
+	if err != nil {
+		return err
+	}
+	ruleRouter, err := app.ruleRouter()
+	if err != nil {
+		return err
+	}
+	rules := append(append([]router.Rule(nil), app.Rules...), rule)
+	err = ruleRouter.SetRules(app.Name, rules)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$push": bson.M{"rules": rule}})
+	if err != nil {
+		return err
+	}
+	app.Rules = rules
+	return nil
+}
+
+// RemoveRule removes the named rule from the app and its router.
+func (app *App) RemoveRule(name string) error {
+	var rules []router.Rule
+	for _, rule := range app.Rules {
+		if rule.Name != name {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(app.Rules) {
+		return ErrRuleNotFound
+	}
+	ruleRouter, err := app.ruleRouter()
+	if err != nil {
+		return err
+	}
+	err = ruleRouter.SetRules(app.Name, rules)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$pull": bson.M{"rules": bson.M{"name": name}}})
+	if err != nil {
+		return err
+	}
+	app.Rules = rules
+	return nil
+}
+
+// checkMovableRules checks that the rules of the app can be moved to the router r,
+// returning it as a rule router when the app has rules to move.
+func (app *App) checkMovableRules(r router.Router) (router.RuleRouter, error) {
+	isTarget, err := app.isRuleTarget()
+	if err != nil {
+		return nil, err
+	}
+	if isTarget {
+		return nil, ErrRuleTarget
+	}
+	if len(app.Rules) == 0 {
+		return nil, nil
+	}
+	for _, rule := range app.Rules {
+		if rule.Type == router.PathRule {
+			return nil, ErrPathRules
+		}
+	}
+	ruleRouter, ok := r.(router.RuleRouter)
+	if !ok {
+		return nil, router.ErrRulesNotSupported
+	}
+	return ruleRouter, nil
+}
+
+// isRuleTarget returns whether the app gets the requests of path rules of
+// other apps.
+func (app *App) isRuleTarget() (bool, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	count, err := conn.Apps().Find(bson.M{"rules.app": app.Name, "name": bson.M{"$ne": app.Name}}).Count()
+	return count > 0, err
+}
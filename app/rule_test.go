@@ -0,0 +1,173 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/router"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) createRuleApp(c *gocheck.C, name string) *App {
+	a := App{Name: name, Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	return &a
+}
+
+func (s *S) removeRuleApp(a *App) {
+	rtesting.FakeRouter.RemoveBackend(a.Name)
+	router.Remove(a.Name)
+	s.conn.Apps().Remove(bson.M{"name": a.Name})
+}
+
+func (s *S) TestAddRule(c *gocheck.C) {
+	rtesting.FakeRouter.Reset()
+	a := s.createRuleApp(c, "myapp")
+	defer s.removeRuleApp(a)
+	api := s.createRuleApp(c, "myapp-api")
+	defer s.removeRuleApp(api)
+	pathRule := router.Rule{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}
+	err := a.AddRule(pathRule)
+	c.Assert(err, gocheck.IsNil)
+	redirectRule := router.Rule{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}
+	err = a.AddRule(redirectRule)
+	c.Assert(err, gocheck.IsNil)
+	expected := []router.Rule{pathRule, redirectRule}
+	c.Assert(a.Rules, gocheck.DeepEquals, expected)
+	c.Assert(rtesting.FakeRouter.Rules(a.Name), gocheck.DeepEquals, expected)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Rules, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAddRuleInvalidRule(c *gocheck.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	err := a.AddRule(router.Rule{Name: "api", Type: router.PathRule, Path: "/api"})
+	c.Assert(err, gocheck.FitsTypeOf, router.RuleValidationError{})
+}
+
+func (s *S) TestAddRuleDuplicateName(c *gocheck.C) {
+	rule := router.Rule{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}, Rules: []router.Rule{rule}}
+	err := a.AddRule(rule)
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, `The app already has a rule named "https".`)
+}
+
+func (s *S) TestAddRuleUnknownApp(c *gocheck.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	err := a.AddRule(router.Rule{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"})
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, `App "myapp-api" not found.`)
+}
+
+func (s *S) TestAddRuleAppInAnotherRouter(c *gocheck.C) {
+	api := App{Name: "myapp-api", Plan: Plan{Router: "proxy"}}
+	err := s.conn.Apps().Insert(api)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": api.Name})
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	err = a.AddRule(router.Rule{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"})
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, `App "myapp-api" doesn't use the router of the app "myapp".`)
+}
+
+func (s *S) TestRemoveRule(c *gocheck.C) {
+	rtesting.FakeRouter.Reset()
+	a := s.createRuleApp(c, "myapp")
+	defer s.removeRuleApp(a)
+	httpsRule := router.Rule{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}
+	err := a.AddRule(httpsRule)
+	c.Assert(err, gocheck.IsNil)
+	wwwRule := router.Rule{Name: "www", Type: router.RedirectRule, Host: "example.com", RedirectHost: "www.example.com"}
+	err = a.AddRule(wwwRule)
+	c.Assert(err, gocheck.IsNil)
+	err = a.RemoveRule("https")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Rules, gocheck.DeepEquals, []router.Rule{wwwRule})
+	c.Assert(rtesting.FakeRouter.Rules(a.Name), gocheck.DeepEquals, []router.Rule{wwwRule})
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Rules, gocheck.DeepEquals, []router.Rule{wwwRule})
+}
+
+func (s *S) TestRemoveRuleNotFound(c *gocheck.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}}
+	err := a.RemoveRule("https")
+	c.Assert(err, gocheck.Equals, ErrRuleNotFound)
+}
+
+func (s *S) TestChangeRouterMovesRules(c *gocheck.C) {
+	a := s.createRouterApp(c)
+	defer s.removeRouterApp(a)
+	rule := router.Rule{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}
+	err := a.AddRule(rule)
+	c.Assert(err, gocheck.IsNil)
+	err = a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.IsNil)
+	var backend struct{ Rules []router.Rule }
+	err = s.conn.Collection("proxy_router").FindId(a.Name).One(&backend)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.Rules, gocheck.DeepEquals, []router.Rule{rule})
+}
+
+func (s *S) TestChangeRouterWithPathRules(c *gocheck.C) {
+	config.Set("proxy:domain", "tsuru.io")
+	defer config.Unset("proxy:domain")
+	a := App{
+		Name:  "myapp",
+		Plan:  Plan{Router: "fake"},
+		Rules: []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}},
+	}
+	err := a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.Equals, ErrPathRules)
+}
+
+func (s *S) TestChangeRouterRuleTarget(c *gocheck.C) {
+	config.Set("proxy:domain", "tsuru.io")
+	defer config.Unset("proxy:domain")
+	other := App{
+		Name:  "myapp",
+		Plan:  Plan{Router: "fake"},
+		Rules: []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}},
+	}
+	err := s.conn.Apps().Insert(other)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": other.Name})
+	a := App{Name: "myapp-api", Plan: Plan{Router: "fake"}}
+	err = a.ChangeRouter("proxy", time.Hour)
+	c.Assert(err, gocheck.Equals, ErrRuleTarget)
+}
+
+func (s *S) TestDeleteRuleTarget(c *gocheck.C) {
+	other := App{
+		Name:  "myapp",
+		Plan:  Plan{Router: "fake"},
+		Rules: []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}},
+	}
+	err := s.conn.Apps().Insert(other)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": other.Name})
+	a := App{Name: "myapp-api", Plan: Plan{Router: "fake"}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	locked, err := AcquireApplicationLock(a.Name, "someone", "app-delete")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(locked, gocheck.Equals, true)
+	err = Delete(&a)
+	c.Assert(err, gocheck.Equals, ErrRuleTargetRemoval)
+	app, err := GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(app.Lock.Locked, gocheck.Equals, false)
+}
@@ -0,0 +1,397 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// scheduleLookback is how far in the past the auto scale looks for missed
+// runs of schedules, covering the interval between runs of the auto scale.
+// Runs older than that, missed while tsuru was down, are skipped.
+const scheduleLookback = 2 * time.Minute
+
+// ScheduleRule is a time-based auto scale rule, which sets the number of units
+// of a process of the app at the times defined by a cron expression, like
+// "0 8 * * mon-fri". The number of units is kept between the MinUnits and
+// MaxUnits of the auto scale config, counting the units of all processes.
+// Until the next schedule runs, the units set by the schedule are the minimum
+// of the metric-based actions when the schedule added units, and the maximum
+// when it removed units.
+type ScheduleRule struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron"`
+	TimeZone string `json:"timeZone,omitempty"`
+	Process  string `json:"process,omitempty"`
+	Units    uint   `json:"units"`
+}
+
+func (rule *ScheduleRule) location() (*time.Location, error) {
+	if rule.TimeZone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(rule.TimeZone)
+}
+
+// validate returns an error describing the first problem found in the rule.
+func (rule *ScheduleRule) validate() error {
+	if rule.Name == "" {
+		return stderr.New("schedules must have a name")
+	}
+	if rule.Units == 0 {
+		return fmt.Errorf("the schedule %q must set at least one unit", rule.Name)
+	}
+	if _, err := parseCron(rule.Cron); err != nil {
+		return fmt.Errorf("the schedule %q has an invalid cron expression %q: %s", rule.Name, rule.Cron, err)
+	}
+	if _, err := rule.location(); err != nil {
+		return fmt.Errorf("the schedule %q has an unknown time zone %q", rule.Name, rule.TimeZone)
+	}
+	return nil
+}
+
+// next returns the first time the rule runs after the given time, or the
+// zero time if the rule never runs.
+func (rule *ScheduleRule) next(after time.Time) (time.Time, error) {
+	schedule, err := parseCron(rule.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := rule.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.next(after.In(loc)), nil
+}
+
+// due returns whether the rule should run now, considering the last time it
+// ran for the app.
+func (rule *ScheduleRule) due(app *App, now time.Time) (bool, error) {
+	after := now.Add(-scheduleLookback)
+	last, err := lastScheduleEvent(app.Name, rule.Name)
+	if err != nil && err != mgo.ErrNotFound {
+		return false, err
+	}
+	if err == nil && last.StartTime.After(after) {
+		after = last.StartTime
+	}
+	return rule.runsBetween(after, now)
+}
+
+// runsBetween returns whether the rule runs after the time after, up to now.
+func (rule *ScheduleRule) runsBetween(after, now time.Time) (bool, error) {
+	next, err := rule.next(after)
+	if err != nil {
+		return false, err
+	}
+	return !next.IsZero() && !next.After(now), nil
+}
+
+func lastScheduleEvent(appName, schedule string) (AutoScaleEvent, error) {
+	var event AutoScaleEvent
+	conn, err := db.Conn()
+	if err != nil {
+		return event, err
+	}
+	defer conn.Close()
+	err = conn.AutoScale().Find(bson.M{"appname": appName, "schedule": schedule}).Sort("-starttime").One(&event)
+	return event, err
+}
+
+// runSchedules runs the first schedule of the app that is due, returning
+// whether a schedule ran, in which case metric-based actions are skipped.
+func runSchedules(app *App, now time.Time) (bool, error) {
+	if app.AutoScaleConfig == nil {
+		return false, nil
+	}
+	for i := range app.AutoScaleConfig.Schedules {
+		rule := &app.AutoScaleConfig.Schedules[i]
+		due, err := rule.due(app, now)
+		if err != nil {
+			return false, err
+		}
+		if due {
+			return true, runSchedule(app, rule)
+		}
+	}
+	return false, nil
+}
+
+// activeSchedule returns the event of the last schedule that ran for the app,
+// which bounds the metric-based actions until the next schedule runs. It
+// returns nil when the last run failed or its schedule was removed from the
+// config.
+func activeSchedule(app *App) (*AutoScaleEvent, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var event AutoScaleEvent
+	err = conn.AutoScale().Find(bson.M{"appname": app.Name, "type": "schedule"}).Sort("-starttime").One(&event)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !event.Successful {
+		return nil, nil
+	}
+	for _, rule := range app.AutoScaleConfig.Schedules {
+		if rule.Name == event.Schedule {
+			return &event, nil
+		}
+	}
+	return nil, nil
+}
+
+// actionBounds returns the minimum and maximum number of units of the app for
+// the Increase and Decrease actions, given the event of the active schedule,
+// which may be nil.
+func (config *AutoScaleConfig) actionBounds(active *AutoScaleEvent) (uint, uint) {
+	minUnits, maxUnits := config.minUnits(), config.maxUnits()
+	if active == nil {
+		return minUnits, maxUnits
+	}
+	if active.ScaledDown {
+		if active.Units < maxUnits {
+			maxUnits = active.Units
+		}
+	} else if active.Units > minUnits {
+		minUnits = active.Units
+	}
+	if minUnits > maxUnits {
+		minUnits = maxUnits
+	}
+	return minUnits, maxUnits
+}
+
+// scheduleTarget returns the number of units the process of the rule should
+// have, given the current number of units of the process and of the app. It
+// fails when the other processes of the app alone reach MaxUnits, leaving no
+// units for the process of the rule.
+func (config *AutoScaleConfig) scheduleTarget(rule *ScheduleRule, processUnits, totalUnits uint) (uint, error) {
+	minUnits, maxUnits := config.minUnits(), config.maxUnits()
+	others := totalUnits - processUnits
+	if others >= maxUnits {
+		return 0, fmt.Errorf("the other processes of the app have %d units, reaching the maximum of %d units", others, maxUnits)
+	}
+	total := others + rule.Units
+	if total > maxUnits {
+		total = maxUnits
+	}
+	if total < minUnits {
+		total = minUnits
+	}
+	return total - others, nil
+}
+
+// runSchedule sets the units of the process of the rule. Runs that fail,
+// including the ones skipped because the app is locked, are recorded as
+// failed events, so they're visible in the auto scale history.
+func runSchedule(app *App, rule *ScheduleRule) error {
+	locked, err := AcquireApplicationLock(app.Name, InternalAppName, "auto-scale")
+	if err != nil {
+		return err
+	}
+	evt, err := newAutoScaleEvent(app, "schedule", rule.Name)
+	if err != nil {
+		if locked {
+			ReleaseApplicationLock(app.Name)
+		}
+		return fmt.Errorf("Error trying to insert auto scale event, auto scale aborted: %s", err.Error())
+	}
+	if !locked {
+		err = fmt.Errorf("Failed to run the schedule %q of the app %s: the app is locked.", rule.Name, app.Name)
+		if updateErr := evt.update(err); updateErr != nil {
+			log.Errorf("Error trying to update auto scale event: %s", updateErr.Error())
+		}
+		return err
+	}
+	defer ReleaseApplicationLock(app.Name)
+	total := uint(len(app.Units()))
+	current := total
+	if rule.Process != "" {
+		current = uint(len(app.UnitsByProcess()[rule.Process]))
+	}
+	target, err := app.AutoScaleConfig.scheduleTarget(rule, current, total)
+	if err != nil {
+		if updateErr := evt.update(err); updateErr != nil {
+			log.Errorf("Error trying to update auto scale event: %s", updateErr.Error())
+		}
+		return err
+	}
+	evt.Units = total - current + target
+	evt.ScaledDown = target < current
+	var scaleErr error
+	if target > current {
+		scaleErr = app.AddUnits(target-current, rule.Process, nil)
+	} else if target < current {
+		scaleErr = app.RemoveUnits(current-target, rule.Process)
+	}
+	err = evt.update(scaleErr)
+	if err != nil {
+		log.Errorf("Error trying to update auto scale event: %s", err.Error())
+	}
+	return scaleErr
+}
+
+// cronField is the set of values matched by a field of a cron expression.
+type cronField map[int]bool
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow cronField
+	// anyDom and anyDow are set when the day of month or the day of week
+	// fields are "*". When both are restricted, a day matches if it
+	// matches either of them, as in cron.
+	anyDom, anyDow bool
+}
+
+var (
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	dayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// parseCron parses a cron expression with five fields: minute, hour, day of
+// month, month and day of week. Fields accept lists, ranges and steps, like
+// "1-5", "*/15" and "0,30", and months and days of week accept names, like
+// "jan" and "mon-fri".
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.anyDom = fields[2] == "*"
+	s.anyDow = fields[4] == "*"
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (cronField, error) {
+	result := make(cronField)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				end = max
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		for v := start; v <= end; v += step {
+			result[v] = true
+		}
+	}
+	return result, nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(value)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, min, max)
+	}
+	return v, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time matched by the schedule after t, in the
+// location of t, or the zero time if no time in the next five years matches.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,360 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestParseCronErrors(c *gocheck.C) {
+	cases := map[string]string{
+		"0 8 * *":       "expected 5 fields, got 4",
+		"60 8 * * *":    `value 60 out of range \[0, 59\]`,
+		"0 8 0 * *":     `value 0 out of range \[1, 31\]`,
+		"0 8 * foo *":   `invalid value "foo"`,
+		"*/0 8 * * *":   `invalid step in "\*/0"`,
+		"0 20-8 * * *":  `invalid range "20-8"`,
+		"0 8 * * fri-a": `invalid value "a"`,
+	}
+	for expr, msg := range cases {
+		_, err := parseCron(expr)
+		c.Check(err, gocheck.ErrorMatches, msg, gocheck.Commentf(expr))
+	}
+}
+
+func (s *S) TestCronScheduleNext(c *gocheck.C) {
+	base := time.Date(2015, time.March, 6, 19, 30, 0, 0, time.UTC) // a Friday
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"0 20 * * *", time.Date(2015, time.March, 6, 20, 0, 0, 0, time.UTC)},
+		{"0 8 * * mon-fri", time.Date(2015, time.March, 9, 8, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2015, time.March, 6, 19, 45, 0, 0, time.UTC)},
+		{"30 19 * * *", time.Date(2015, time.March, 7, 19, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 0", time.Date(2015, time.March, 8, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2015, time.March, 8, 12, 0, 0, 0, time.UTC)},
+		{"0 12 10 * sat", time.Date(2015, time.March, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, t := range cases {
+		schedule, err := parseCron(t.expr)
+		c.Assert(err, gocheck.IsNil)
+		c.Check(schedule.next(base), gocheck.DeepEquals, t.expected, gocheck.Commentf(t.expr))
+	}
+}
+
+func (s *S) TestScheduleRuleNextInTimeZone(c *gocheck.C) {
+	rule := ScheduleRule{Name: "morning", Cron: "0 8 * * mon-fri", TimeZone: "America/Sao_Paulo", Units: 10}
+	next, err := rule.next(time.Date(2015, time.March, 6, 12, 0, 0, 0, time.UTC))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(next.UTC(), gocheck.DeepEquals, time.Date(2015, time.March, 9, 11, 0, 0, 0, time.UTC))
+}
+
+func (s *S) TestScheduleTarget(c *gocheck.C) {
+	config := AutoScaleConfig{MinUnits: 2, MaxUnits: 10}
+	cases := []struct {
+		units, process, total, expected uint
+	}{
+		{units: 5, process: 3, total: 3, expected: 5},
+		{units: 20, process: 3, total: 3, expected: 10},
+		{units: 1, process: 3, total: 3, expected: 2},
+		{units: 8, process: 2, total: 5, expected: 7},
+		{units: 1, process: 2, total: 5, expected: 1},
+		{units: 5, process: 1, total: 10, expected: 1},
+	}
+	for _, t := range cases {
+		rule := ScheduleRule{Units: t.units}
+		target, err := config.scheduleTarget(&rule, t.process, t.total)
+		c.Check(err, gocheck.IsNil)
+		c.Check(target, gocheck.Equals, t.expected, gocheck.Commentf("%#v", t))
+	}
+}
+
+func (s *S) TestScheduleTargetOtherProcessesAtMaximum(c *gocheck.C) {
+	config := AutoScaleConfig{MinUnits: 2, MaxUnits: 10}
+	rule := ScheduleRule{Units: 5}
+	_, err := config.scheduleTarget(&rule, 1, 12)
+	c.Assert(err, gocheck.ErrorMatches, "the other processes of the app have 11 units, reaching the maximum of 10 units")
+	_, err = config.scheduleTarget(&rule, 0, 10)
+	c.Assert(err, gocheck.Not(gocheck.IsNil))
+}
+
+func (s *S) TestScheduleRuleDue(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	rule := ScheduleRule{Name: "morning", Cron: "0 8 * * *", Units: 10}
+	now := time.Date(2015, time.March, 6, 8, 0, 30, 0, time.UTC)
+	due, err := rule.due(&a, now)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(due, gocheck.Equals, true)
+	due, err = rule.due(&a, now.Add(-time.Minute))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(due, gocheck.Equals, false)
+	due, err = rule.due(&a, now.Add(time.Hour))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(due, gocheck.Equals, false)
+	err = s.conn.AutoScale().Insert(AutoScaleEvent{
+		ID:        bson.NewObjectId(),
+		AppName:   a.Name,
+		StartTime: now.Add(-10 * time.Second),
+		Type:      "schedule",
+		Schedule:  "morning",
+	})
+	c.Assert(err, gocheck.IsNil)
+	due, err = rule.due(&a, now)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(due, gocheck.Equals, false)
+}
+
+func (s *S) TestRunSchedules(c *gocheck.C) {
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Quota:    quota.Unlimited,
+		AutoScaleConfig: &AutoScaleConfig{
+			Enabled:  true,
+			MinUnits: 1,
+			MaxUnits: 10,
+			Schedules: []ScheduleRule{
+				{Name: "night", Cron: "0 20 * * *", Units: 2},
+				{Name: "morning", Cron: "0 8 * * mon-fri", Units: 5},
+			},
+		},
+	}
+	err := s.conn.Apps().Insert(newApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
+	s.provisioner.Provision(&newApp)
+	defer s.provisioner.Destroy(&newApp)
+	s.provisioner.AddUnits(&newApp, 1, "", nil)
+	ran, err := runSchedules(&newApp, time.Date(2015, time.March, 6, 8, 0, 30, 0, time.UTC))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ran, gocheck.Equals, true)
+	c.Assert(newApp.Units(), gocheck.HasLen, 5)
+	var events []AutoScaleEvent
+	err = s.conn.AutoScale().Find(nil).All(&events)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(events, gocheck.HasLen, 1)
+	c.Assert(events[0].Type, gocheck.Equals, "schedule")
+	c.Assert(events[0].Schedule, gocheck.Equals, "morning")
+	c.Assert(events[0].AppName, gocheck.Equals, newApp.Name)
+	c.Assert(events[0].EndTime, gocheck.Not(gocheck.DeepEquals), time.Time{})
+	c.Assert(events[0].Successful, gocheck.Equals, true)
+	c.Assert(events[0].Units, gocheck.Equals, uint(5))
+	c.Assert(events[0].ScaledDown, gocheck.Equals, false)
+}
+
+func (s *S) TestRunSchedulesAppLocked(c *gocheck.C) {
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Quota:    quota.Unlimited,
+		AutoScaleConfig: &AutoScaleConfig{
+			Enabled:   true,
+			MinUnits:  1,
+			MaxUnits:  10,
+			Schedules: []ScheduleRule{{Name: "morning", Cron: "0 8 * * mon-fri", Units: 5}},
+		},
+	}
+	err := s.conn.Apps().Insert(newApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
+	locked, err := AcquireApplicationLock(newApp.Name, "foo", "/something")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(locked, gocheck.Equals, true)
+	defer ReleaseApplicationLock(newApp.Name)
+	ran, err := runSchedules(&newApp, time.Date(2015, time.March, 6, 8, 0, 30, 0, time.UTC))
+	c.Assert(err, gocheck.ErrorMatches, `Failed to run the schedule "morning" of the app myApp: the app is locked.`)
+	c.Assert(ran, gocheck.Equals, true)
+	var events []AutoScaleEvent
+	err = s.conn.AutoScale().Find(nil).All(&events)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(events, gocheck.HasLen, 1)
+	c.Assert(events[0].Schedule, gocheck.Equals, "morning")
+	c.Assert(events[0].Successful, gocheck.Equals, false)
+	c.Assert(events[0].Error, gocheck.Equals, `Failed to run the schedule "morning" of the app myApp: the app is locked.`)
+}
+
+func (s *S) TestRunSchedulesNotDue(c *gocheck.C) {
+	newApp := App{
+		Name: "myApp",
+		AutoScaleConfig: &AutoScaleConfig{
+			Enabled:   true,
+			Schedules: []ScheduleRule{{Name: "morning", Cron: "0 8 * * mon-fri", Units: 5}},
+		},
+	}
+	ran, err := runSchedules(&newApp, time.Date(2015, time.March, 7, 8, 0, 30, 0, time.UTC))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ran, gocheck.Equals, false)
+	var events []AutoScaleEvent
+	err = s.conn.AutoScale().Find(nil).All(&events)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(events, gocheck.HasLen, 0)
+}
+
+func (s *S) TestAutoScaleConfigInvalidSchedule(c *gocheck.C) {
+	cases := []struct {
+		rule ScheduleRule
+		msg  string
+	}{
+		{
+			ScheduleRule{Cron: "0 8 * * *", Units: 1},
+			`Invalid schedule: schedules must have a name.`,
+		},
+		{
+			ScheduleRule{Name: "morning", Cron: "0 8 * * *"},
+			`Invalid schedule: the schedule "morning" must set at least one unit.`,
+		},
+		{
+			ScheduleRule{Name: "morning", Cron: "0 8 * *", Units: 1},
+			`Invalid schedule: the schedule "morning" has an invalid cron expression "0 8 \* \*": expected 5 fields, got 4.`,
+		},
+		{
+			ScheduleRule{Name: "morning", Cron: "0 8 * * *", TimeZone: "Nowhere/City", Units: 1},
+			`Invalid schedule: the schedule "morning" has an unknown time zone "Nowhere/City".`,
+		},
+	}
+	for _, t := range cases {
+		a := App{Name: "myApp"}
+		config := AutoScaleConfig{Schedules: []ScheduleRule{t.rule}}
+		err := SetAutoScaleConfig(&a, &config)
+		c.Check(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+		c.Check(err, gocheck.ErrorMatches, t.msg)
+	}
+}
+
+func (s *S) TestAutoScaleConfigDuplicateSchedule(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{MaxUnits: 10, Schedules: []ScheduleRule{
+		{Name: "morning", Cron: "0 8 * * *", Units: 5},
+		{Name: "morning", Cron: "0 9 * * *", Units: 5},
+	}}
+	err := SetAutoScaleConfig(&a, &config)
+	c.Assert(err, gocheck.ErrorMatches, `Invalid schedule: there are two schedules named "morning".`)
+}
+
+func (s *S) TestAutoScaleConfigScheduleAboveMaxUnits(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{MaxUnits: 5, Schedules: []ScheduleRule{
+		{Name: "morning", Cron: "0 8 * * *", Units: 8},
+	}}
+	err := SetAutoScaleConfig(&a, &config)
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, `Invalid schedule: the schedule "morning" sets 8 units, more than the maximum of 5 units.`)
+	config = AutoScaleConfig{Schedules: []ScheduleRule{
+		{Name: "morning", Cron: "0 8 * * *", Units: 2},
+	}}
+	err = SetAutoScaleConfig(&a, &config)
+	c.Assert(err, gocheck.ErrorMatches, `Invalid schedule: the schedule "morning" sets 2 units, more than the maximum of 1 units.`)
+}
+
+func (s *S) TestActionBounds(c *gocheck.C) {
+	config := AutoScaleConfig{MinUnits: 2, MaxUnits: 10}
+	cases := []struct {
+		active   *AutoScaleEvent
+		min, max uint
+	}{
+		{nil, 2, 10},
+		{&AutoScaleEvent{Units: 6}, 6, 10},
+		{&AutoScaleEvent{Units: 1}, 2, 10},
+		{&AutoScaleEvent{Units: 12}, 10, 10},
+		{&AutoScaleEvent{Units: 3, ScaledDown: true}, 2, 3},
+		{&AutoScaleEvent{Units: 12, ScaledDown: true}, 2, 10},
+	}
+	for _, t := range cases {
+		min, max := config.actionBounds(t.active)
+		c.Check(min, gocheck.Equals, t.min, gocheck.Commentf("%#v", t.active))
+		c.Check(max, gocheck.Equals, t.max, gocheck.Commentf("%#v", t.active))
+	}
+}
+
+func (s *S) TestActiveSchedule(c *gocheck.C) {
+	a := App{
+		Name: "myApp",
+		AutoScaleConfig: &AutoScaleConfig{
+			Schedules: []ScheduleRule{{Name: "morning", Cron: "0 8 * * *", Units: 5}},
+		},
+	}
+	now := time.Now().UTC()
+	events := []AutoScaleEvent{
+		{ID: bson.NewObjectId(), AppName: a.Name, StartTime: now.Add(-time.Hour), Type: "schedule", Schedule: "morning", Units: 5, Successful: true},
+		{ID: bson.NewObjectId(), AppName: a.Name, StartTime: now.Add(-time.Minute), Type: "increase", Successful: true},
+	}
+	for _, evt := range events {
+		err := s.conn.AutoScale().Insert(evt)
+		c.Assert(err, gocheck.IsNil)
+	}
+	active, err := activeSchedule(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(active, gocheck.NotNil)
+	c.Assert(active.Schedule, gocheck.Equals, "morning")
+	c.Assert(active.Units, gocheck.Equals, uint(5))
+	a.AutoScaleConfig.Schedules[0].Name = "business-hours"
+	active, err = activeSchedule(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(active, gocheck.IsNil)
+	a.AutoScaleConfig.Schedules[0].Name = "morning"
+	err = s.conn.AutoScale().Insert(AutoScaleEvent{
+		ID:        bson.NewObjectId(),
+		AppName:   a.Name,
+		StartTime: now,
+		Type:      "schedule",
+		Schedule:  "morning",
+		Error:     "failed",
+	})
+	c.Assert(err, gocheck.IsNil)
+	active, err = activeSchedule(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(active, gocheck.IsNil)
+}
+
+func (s *S) TestScaleApplicationIfNeededKeepsScheduleUnits(c *gocheck.C) {
+	source := fakeMetricsSource{value: 10}
+	RegisterMetricsSource("fake-source", &source)
+	defer delete(metricsSources, "fake-source")
+	newApp := App{
+		Name:     "myApp",
+		Platform: "Django",
+		Quota:    quota.Unlimited,
+		AutoScaleConfig: &AutoScaleConfig{
+			Increase:      Action{Units: 1, Expression: "{cpu_max} > 80"},
+			Decrease:      Action{Units: 1, Expression: "{cpu_max} < 20"},
+			MaxUnits:      10,
+			MetricsSource: "fake-source",
+			Enabled:       true,
+			Schedules:     []ScheduleRule{{Name: "morning", Cron: "0 8 * * *", Units: 3}},
+		},
+	}
+	err := s.conn.Apps().Insert(newApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
+	s.provisioner.Provision(&newApp)
+	defer s.provisioner.Destroy(&newApp)
+	s.provisioner.AddUnits(&newApp, 3, "", nil)
+	err = s.conn.AutoScale().Insert(AutoScaleEvent{
+		ID:         bson.NewObjectId(),
+		AppName:    newApp.Name,
+		StartTime:  time.Now().UTC().Add(-time.Hour),
+		EndTime:    time.Now().UTC().Add(-time.Hour),
+		Type:       "schedule",
+		Schedule:   "morning",
+		Units:      3,
+		Successful: true,
+	})
+	c.Assert(err, gocheck.IsNil)
+	err = scaleApplicationIfNeeded(&newApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(newApp.Units(), gocheck.HasLen, 3)
+	source.value = 90
+	err = scaleApplicationIfNeeded(&newApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(newApp.Units(), gocheck.HasLen, 4)
+}
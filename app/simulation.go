@@ -0,0 +1,285 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+)
+
+// maxSimulationPeriod limits the period replayed by simulations, as the
+// metrics of the app in the whole period are read from the source.
+const maxSimulationPeriod = 24 * time.Hour
+
+// AutoScaleDecision is a decision made by the auto scale in a simulation.
+// Decisions that don't scale the app, like when the action holds but the app
+// is waiting since the last scaling, have the reason in Skipped and no
+// event.
+type AutoScaleDecision struct {
+	Time time.Time `json:"time"`
+	// Type is the type of the decision: "increase", "decrease" or
+	// "schedule".
+	Type string `json:"type"`
+	// Schedule is the name of the schedule rule, in decisions of the
+	// "schedule" type.
+	Schedule string `json:"schedule,omitempty"`
+	// Units is the number of units of the app before the decision, and
+	// Target is the number of units after it.
+	Units  uint `json:"units"`
+	Target uint `json:"target"`
+	// Clamped is set when the number of units was limited by the
+	// MinUnits and MaxUnits of the config, or by the active schedule.
+	Clamped bool            `json:"clamped,omitempty"`
+	Skipped string          `json:"skipped,omitempty"`
+	Event   *AutoScaleEvent `json:"event,omitempty"`
+}
+
+// AutoScaleSimulation is the result of replaying the metrics of an app
+// against an auto scale config.
+type AutoScaleSimulation struct {
+	From         time.Time           `json:"from"`
+	Until        time.Time           `json:"until"`
+	InitialUnits uint                `json:"initialUnits"`
+	FinalUnits   uint                `json:"finalUnits"`
+	Decisions    []AutoScaleDecision `json:"decisions"`
+}
+
+// SimulateAutoScale replays the metrics of the app in the given period, up to
+// now, returning the decisions the auto scale would have made with the given
+// config. The simulation starts with the current units of the app, and
+// doesn't consider the auto scale events it already has. It requires a
+// metrics source that keeps the history of metrics, and doesn't change the
+// app.
+func SimulateAutoScale(app *App, config *AutoScaleConfig, period time.Duration) (*AutoScaleSimulation, error) {
+	if period <= 0 || period > maxSimulationPeriod {
+		return nil, &errors.ValidationError{Message: fmt.Sprintf("The period of the simulation must be between 0 and %s.", maxSimulationPeriod)}
+	}
+	until := time.Now().UTC()
+	return simulateAutoScale(app, config, until.Add(-period), until)
+}
+
+func simulateAutoScale(app *App, config *AutoScaleConfig, from, until time.Time) (*AutoScaleSimulation, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	candidate := *app
+	candidate.AutoScaleConfig = config
+	source, err := getMetricsSource(&candidate)
+	if err != nil {
+		return nil, err
+	}
+	seriesSource, ok := source.(SeriesMetricsSource)
+	if !ok {
+		return nil, &errors.ValidationError{Message: "The metrics source of the app doesn't keep the history of metrics, needed by simulations."}
+	}
+	sim := autoScaleSimulator{
+		app:       &candidate,
+		source:    seriesSource,
+		units:     uint(len(app.Units())),
+		processes: make(map[string]uint),
+		schedules: make(map[string]time.Time),
+	}
+	for process, units := range app.UnitsByProcess() {
+		sim.processes[process] = uint(len(units))
+	}
+	if err := sim.fetchSeries(from, until); err != nil {
+		return nil, err
+	}
+	result := AutoScaleSimulation{From: from, Until: until, InitialUnits: sim.units}
+	for now := from; !now.After(until); now = now.Add(autoScaleInterval) {
+		decision, err := sim.round(now)
+		if err != nil {
+			return nil, err
+		}
+		if decision != nil {
+			result.Decisions = append(result.Decisions, *decision)
+		}
+	}
+	result.FinalUnits = sim.units
+	return &result, nil
+}
+
+// autoScaleSimulator keeps the state of the app in a simulation, replacing
+// the units and the auto scale events of the app.
+type autoScaleSimulator struct {
+	app    *App
+	source SeriesMetricsSource
+	units  uint
+	// processes is the number of units of each process. Units added and
+	// removed by the Increase and Decrease actions change only the total.
+	processes map[string]uint
+	// lastEvent is the time of the last simulated event, and schedules
+	// the time of the last run of each schedule rule. active is the event
+	// of the last schedule that ran, which bounds the actions.
+	lastEvent time.Time
+	schedules map[string]time.Time
+	active    *AutoScaleEvent
+	// series holds the series of each kind of metric used by the actions,
+	// read once for the whole simulation.
+	series map[string]simulatedSeries
+}
+
+type simulatedSeries struct {
+	values []MetricValue
+	err    error
+}
+
+// fetchSeries reads the series of each kind of metric used by the actions,
+// covering the windows of all rounds between from and until. Failures to read
+// a series are kept, so the actions using it don't hold, like in the auto
+// scale.
+func (s *autoScaleSimulator) fetchSeries(from, until time.Time) error {
+	config := s.app.AutoScaleConfig
+	windows := make(map[string]time.Duration)
+	for _, action := range []*Action{&config.Increase, &config.Decrease} {
+		if action.Expression == "" {
+			continue
+		}
+		expr, err := parseExpression(action.Expression)
+		if err != nil {
+			return err
+		}
+		for _, q := range expr.queries() {
+			if window := q.seriesWindow(); window > windows[q.kind] {
+				windows[q.kind] = window
+			}
+		}
+	}
+	s.series = make(map[string]simulatedSeries, len(windows))
+	for kind, window := range windows {
+		values, err := s.source.Series(s.app, kind, from.Add(-window), until)
+		s.series[kind] = simulatedSeries{values: values, err: err}
+	}
+	return nil
+}
+
+// readMetric returns the value of the query at the given time, from the
+// values of the series of its metric in the window of the query, like
+// readSeriesMetric does.
+func (s *autoScaleSimulator) readMetric(q metricQuery, at time.Time) (float64, error) {
+	series := s.series[q.kind]
+	if series.err != nil {
+		return 0, series.err
+	}
+	values := series.values
+	from := at.Add(-q.seriesWindow())
+	start := sort.Search(len(values), func(i int) bool { return values[i].Time.After(from) })
+	end := sort.Search(len(values), func(i int) bool { return values[i].Time.After(at) })
+	return seriesMetric(q, values[start:end])
+}
+
+// round makes the decision of a run of the auto scale at the given time,
+// following runAutoScaleOnce. It returns nil when there's nothing to decide.
+func (s *autoScaleSimulator) round(now time.Time) (*AutoScaleDecision, error) {
+	config := s.app.AutoScaleConfig
+	for i := range config.Schedules {
+		rule := &config.Schedules[i]
+		after := now.Add(-scheduleLookback)
+		if last := s.schedules[rule.Name]; last.After(after) {
+			after = last
+		}
+		due, err := rule.runsBetween(after, now)
+		if err != nil {
+			return nil, err
+		}
+		if due {
+			return s.runSchedule(rule, now), nil
+		}
+	}
+	read := func(q metricQuery) (float64, error) {
+		return s.readMetric(q, now)
+	}
+	minUnits, maxUnits := config.actionBounds(s.active)
+	if holds, _ := config.Increase.evaluate(read); holds {
+		decision := AutoScaleDecision{Time: now, Type: "increase", Units: s.units, Target: s.units}
+		if s.units >= maxUnits {
+			decision.Skipped = "the app has the maximum number of units"
+		} else if s.waiting(config.Increase.Wait, now) {
+			decision.Skipped = "waiting since the last scaling"
+		} else {
+			inc := config.increaseUnits(s.units, maxUnits)
+			decision.Target = s.units + inc
+			decision.Clamped = inc != config.Increase.Units
+			s.scale(&decision, "", now)
+		}
+		return &decision, nil
+	}
+	if holds, _ := config.Decrease.evaluate(read); holds {
+		decision := AutoScaleDecision{Time: now, Type: "decrease", Units: s.units, Target: s.units}
+		if s.units <= minUnits {
+			decision.Skipped = "the app has the minimum number of units"
+		} else if s.waiting(config.Decrease.Wait, now) {
+			decision.Skipped = "waiting since the last scaling"
+		} else {
+			dec := config.decreaseUnits(s.units, minUnits)
+			decision.Target = s.units - dec
+			decision.Clamped = dec != config.Decrease.Units
+			s.scale(&decision, "", now)
+		}
+		return &decision, nil
+	}
+	return nil, nil
+}
+
+func (s *autoScaleSimulator) runSchedule(rule *ScheduleRule, now time.Time) *AutoScaleDecision {
+	s.schedules[rule.Name] = now
+	current := s.units
+	if rule.Process != "" {
+		current = s.processes[rule.Process]
+	}
+	target, err := s.app.AutoScaleConfig.scheduleTarget(rule, current, s.units)
+	if err != nil {
+		s.active = nil
+		return &AutoScaleDecision{
+			Time:     now,
+			Type:     "schedule",
+			Schedule: rule.Name,
+			Units:    s.units,
+			Target:   s.units,
+			Skipped:  err.Error(),
+		}
+	}
+	decision := AutoScaleDecision{
+		Time:     now,
+		Type:     "schedule",
+		Schedule: rule.Name,
+		Units:    s.units,
+		Target:   s.units - current + target,
+		Clamped:  target != rule.Units,
+	}
+	s.scale(&decision, rule.Process, now)
+	decision.Event.Units = decision.Target
+	decision.Event.ScaledDown = target < current
+	s.active = decision.Event
+	return &decision
+}
+
+// scale changes the units of the app to the target of the decision, recording
+// the event the auto scale would write.
+func (s *autoScaleSimulator) scale(decision *AutoScaleDecision, process string, now time.Time) {
+	if process != "" {
+		s.processes[process] = s.processes[process] + decision.Target - decision.Units
+	}
+	s.units = decision.Target
+	s.lastEvent = now
+	decision.Event = &AutoScaleEvent{
+		AppName:         s.app.Name,
+		StartTime:       now,
+		EndTime:         now,
+		AutoScaleConfig: s.app.AutoScaleConfig,
+		Type:            decision.Type,
+		Schedule:        decision.Schedule,
+		Successful:      true,
+	}
+}
+
+// waiting returns whether the app is in the wait period of an action, like
+// shouldWait does.
+func (s *autoScaleSimulator) waiting(wait time.Duration, now time.Time) bool {
+	return !s.lastEvent.IsZero() && now.Sub(s.lastEvent) <= wait
+}
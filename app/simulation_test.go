@@ -0,0 +1,181 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+// historySource is a metrics source with a point every 30 seconds, given by
+// the value function.
+type historySource struct {
+	fakeMetricsSource
+	value func(t time.Time) float64
+	calls int
+}
+
+func (s *historySource) Series(app *App, kind string, from, until time.Time) ([]MetricValue, error) {
+	s.calls++
+	var values []MetricValue
+	for t := from.Truncate(30 * time.Second); !t.After(until); t = t.Add(30 * time.Second) {
+		if t.After(from) {
+			values = append(values, MetricValue{Time: t, Value: s.value(t)})
+		}
+	}
+	return values, nil
+}
+
+func (s *S) TestSimulateAutoScale(c *gocheck.C) {
+	base := time.Date(2015, time.March, 6, 12, 0, 0, 0, time.UTC)
+	source := historySource{value: func(t time.Time) float64 {
+		if t.Before(base.Add(5 * time.Minute)) {
+			return 90
+		}
+		return 10
+	}}
+	RegisterMetricsSource("fake-history", &source)
+	defer delete(metricsSources, "fake-history")
+	newApp := App{Name: "myApp", Platform: "Django", Quota: quota.Unlimited}
+	err := s.conn.Apps().Insert(newApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": newApp.Name})
+	s.provisioner.Provision(&newApp)
+	defer s.provisioner.Destroy(&newApp)
+	s.provisioner.AddUnits(&newApp, 1, "", nil)
+	config := AutoScaleConfig{
+		Increase:      Action{Units: 2, Wait: time.Minute, Expression: "{cpu_max} > 80"},
+		Decrease:      Action{Units: 1, Wait: 2 * time.Minute, Expression: "avg({cpu_max}, 1m) < 20"},
+		MinUnits:      1,
+		MaxUnits:      4,
+		MetricsSource: "fake-history",
+	}
+	result, err := simulateAutoScale(&newApp, &config, base, base.Add(10*time.Minute))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result.InitialUnits, gocheck.Equals, uint(1))
+	c.Assert(result.FinalUnits, gocheck.Equals, uint(2))
+	c.Assert(result.Decisions, gocheck.HasLen, 20)
+	first := result.Decisions[0]
+	c.Assert(first.Time, gocheck.DeepEquals, base)
+	c.Assert(first.Type, gocheck.Equals, "increase")
+	c.Assert(first.Units, gocheck.Equals, uint(1))
+	c.Assert(first.Target, gocheck.Equals, uint(3))
+	c.Assert(first.Clamped, gocheck.Equals, false)
+	c.Assert(first.Event.Type, gocheck.Equals, "increase")
+	c.Assert(first.Event.AppName, gocheck.Equals, "myApp")
+	c.Assert(first.Event.AutoScaleConfig, gocheck.Equals, &config)
+	c.Assert(first.Event.Successful, gocheck.Equals, true)
+	c.Assert(result.Decisions[1].Skipped, gocheck.Equals, "waiting since the last scaling")
+	c.Assert(result.Decisions[1].Event, gocheck.IsNil)
+	c.Assert(result.Decisions[2].Skipped, gocheck.Equals, "waiting since the last scaling")
+	clamped := result.Decisions[3]
+	c.Assert(clamped.Target, gocheck.Equals, uint(4))
+	c.Assert(clamped.Clamped, gocheck.Equals, true)
+	c.Assert(result.Decisions[4].Skipped, gocheck.Equals, "the app has the maximum number of units")
+	var targets []uint
+	for _, d := range result.Decisions[10:] {
+		c.Check(d.Type, gocheck.Equals, "decrease")
+		if d.Event != nil {
+			targets = append(targets, d.Target)
+		}
+	}
+	c.Assert(result.Decisions[10].Time, gocheck.DeepEquals, base.Add(5*time.Minute+30*time.Second))
+	c.Assert(targets, gocheck.DeepEquals, []uint{3, 2})
+	c.Assert(source.calls, gocheck.Equals, 1)
+	c.Assert(newApp.Units(), gocheck.HasLen, 1)
+	count, err := s.conn.AutoScale().Find(nil).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 0)
+}
+
+func (s *S) TestSimulateAutoScaleSchedule(c *gocheck.C) {
+	source := historySource{value: func(time.Time) float64 { return 50 }}
+	RegisterMetricsSource("fake-history", &source)
+	defer delete(metricsSources, "fake-history")
+	newApp := App{Name: "myApp", Platform: "Django", Quota: quota.Unlimited}
+	config := AutoScaleConfig{
+		Increase:      Action{Units: 1, Expression: "{cpu_max} > 80"},
+		MinUnits:      3,
+		MaxUnits:      5,
+		MetricsSource: "fake-history",
+		Schedules: []ScheduleRule{
+			{Name: "morning", Cron: "0 9 * * *", Units: 2},
+		},
+	}
+	from := time.Date(2015, time.March, 6, 8, 58, 0, 0, time.UTC)
+	result, err := simulateAutoScale(&newApp, &config, from, from.Add(4*time.Minute))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result.Decisions, gocheck.HasLen, 1)
+	decision := result.Decisions[0]
+	c.Assert(decision.Time, gocheck.DeepEquals, from.Add(2*time.Minute))
+	c.Assert(decision.Type, gocheck.Equals, "schedule")
+	c.Assert(decision.Schedule, gocheck.Equals, "morning")
+	c.Assert(decision.Target, gocheck.Equals, uint(3))
+	c.Assert(decision.Clamped, gocheck.Equals, true)
+	c.Assert(decision.Event.Schedule, gocheck.Equals, "morning")
+	c.Assert(decision.Event.Units, gocheck.Equals, uint(3))
+	c.Assert(result.FinalUnits, gocheck.Equals, uint(3))
+}
+
+func (s *S) TestSimulateAutoScaleScheduleBoundsActions(c *gocheck.C) {
+	source := historySource{value: func(time.Time) float64 { return 50 }}
+	RegisterMetricsSource("fake-history", &source)
+	defer delete(metricsSources, "fake-history")
+	newApp := App{Name: "myApp", Platform: "Django", Quota: quota.Unlimited}
+	config := AutoScaleConfig{
+		Increase:      Action{Units: 1, Expression: "{cpu_max} > 40"},
+		MaxUnits:      10,
+		MetricsSource: "fake-history",
+		Schedules: []ScheduleRule{
+			{Name: "night", Cron: "0 20 * * *", Units: 2},
+		},
+	}
+	from := time.Date(2015, time.March, 6, 19, 58, 0, 0, time.UTC)
+	result, err := simulateAutoScale(&newApp, &config, from, from.Add(3*time.Minute))
+	c.Assert(err, gocheck.IsNil)
+	var types []string
+	for _, d := range result.Decisions {
+		types = append(types, d.Type)
+	}
+	c.Assert(types, gocheck.DeepEquals, []string{"increase", "increase", "increase", "increase", "schedule", "increase", "increase"})
+	night := result.Decisions[4]
+	c.Assert(night.Units, gocheck.Equals, uint(4))
+	c.Assert(night.Target, gocheck.Equals, uint(2))
+	c.Assert(night.Event.ScaledDown, gocheck.Equals, true)
+	c.Assert(result.Decisions[5].Skipped, gocheck.Equals, "the app has the maximum number of units")
+	c.Assert(result.Decisions[5].Event, gocheck.IsNil)
+	c.Assert(result.FinalUnits, gocheck.Equals, uint(2))
+}
+
+func (s *S) TestSimulateAutoScaleRequiresHistory(c *gocheck.C) {
+	RegisterMetricsSource("fake-source", &fakeMetricsSource{})
+	defer delete(metricsSources, "fake-source")
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{MetricsSource: "fake-source"}
+	_, err := SimulateAutoScale(&a, &config, time.Hour)
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, "The metrics source of the app doesn't keep the history of metrics, needed by simulations.")
+}
+
+func (s *S) TestSimulateAutoScaleInvalidConfig(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{MinUnits: 5, MaxUnits: 2}
+	_, err := SimulateAutoScale(&a, &config, time.Hour)
+	c.Assert(err, gocheck.ErrorMatches, "The minimum number of units can't be greater than the maximum.")
+}
+
+func (s *S) TestSimulateAutoScaleInvalidPeriod(c *gocheck.C) {
+	a := App{Name: "myApp"}
+	config := AutoScaleConfig{}
+	for _, period := range []time.Duration{0, 48 * time.Hour} {
+		_, err := SimulateAutoScale(&a, &config, period)
+		c.Check(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+		c.Check(err, gocheck.ErrorMatches, "The period of the simulation must be between 0 and 24h0m0s.")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"fmt"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrNoSwap            = stderr.New("no swap found")
+	ErrSwapAlreadyUndone = stderr.New("the last swap was already undone")
+)
+
+// SwapValidationError is returned by Swap when the apps can't be swapped.
+type SwapValidationError struct{ reason string }
+
+func (e SwapValidationError) Error() string {
+	return "cannot swap apps: " + e.reason
+}
+
+// SwapEvent records a swap between two apps, so it can be undone later.
+type SwapEvent struct {
+	ID        bson.ObjectId `bson:"_id"`
+	App1      string
+	App2      string
+	User      string
+	Timestamp time.Time
+	Undone    bool
+	UndoneBy  string    `bson:",omitempty"`
+	UndoneAt  time.Time `bson:",omitempty"`
+}
+
+// healthy returns true when the app has units and all of them are started.
+func (app *App) healthy() bool {
+	units := app.Units()
+	if len(units) == 0 {
+		return false
+	}
+	for _, unit := range units {
+		if unit.Status != provision.StatusStarted {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSwap checks that the apps use the same router, that all their
+// units are started and that their plans give the same resources to the
+// units. Forced swaps skip the check of the units.
+func validateSwap(app1, app2 *App, force bool) error {
+	// apps without a router in their plans use the default router, that is
+	// checked again by the router when swapping.
+	router1, _ := app1.GetRouter()
+	router2, _ := app2.GetRouter()
+	if router1 != router2 {
+		return SwapValidationError{fmt.Sprintf("%q uses the router %q and %q uses the router %q",
+			app1.Name, router1, app2.Name, router2)}
+	}
+	for _, app := range []*App{app1, app2} {
+		if !force && !app.healthy() {
+			return SwapValidationError{fmt.Sprintf("all units of the app %q must be started", app.Name)}
+		}
+	}
+	p1, p2 := app1.Plan, app2.Plan
+	if p1.Memory != p2.Memory || p1.Swap != p2.Swap || p1.CpuShare != p2.CpuShare {
+		return SwapValidationError{fmt.Sprintf("the plans of %q and %q must have the same memory, swap and cpu share",
+			app1.Name, app2.Name)}
+	}
+	return nil
+}
+
+// Swap validates the apps and calls the Provisioner.Swap, updating the
+// app.CName and the certificates of the cnames in the database. The swap is
+// recorded, so it can be undone with UndoSwap. Forced swaps don't require the
+// units of the apps to be started.
+func Swap(app1, app2 *App, user string, force bool) error {
+	prov, err := swapProvisioner(app1, app2)
+	if err != nil {
+		return err
+	}
+	err = validateSwap(app1, app2, force)
+	if err != nil {
+		return err
+	}
+	err = swapApps(prov, app1, app2)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	evt := SwapEvent{
+		ID:        bson.NewObjectId(),
+		App1:      app1.Name,
+		App2:      app2.Name,
+		User:      user,
+		Timestamp: time.Now(),
+	}
+	err = conn.Swaps().Insert(evt)
+	if err != nil {
+		log.Errorf("Failed to record the swap of %q and %q: %s", app1.Name, app2.Name, err)
+	}
+	return nil
+}
+
+// swapProvisioner returns the provisioner of the apps, failing when they're
+// handled by different provisioners.
+func swapProvisioner(app1, app2 *App) (provision.Provisioner, error) {
+	prov, err := app1.GetProvisioner()
+	if err != nil {
+		return nil, err
+	}
+	prov2, err := app2.GetProvisioner()
+	if err != nil {
+		return nil, err
+	}
+	if prov != prov2 {
+		return nil, ErrAppsProvisionerNotEqual
+	}
+	return prov, nil
+}
+
+// swapApps swaps the apps in the provisioner and then stores their swapped
+// cnames and addresses. When storing the apps fails, the swap is undone in
+// the provisioner and the previous cnames and addresses of the apps are
+// restored.
+func swapApps(prov provision.Provisioner, app1, app2 *App) error {
+	err := prov.Swap(app1, app2)
+	if err != nil {
+		return err
+	}
+	cname1, ip1 := app1.CName, app1.Ip
+	cname2, ip2 := app2.CName, app2.Ip
+	app1.CName, app2.CName = app2.CName, app1.CName
+	for _, app := range []*App{app1, app2} {
+		app.Ip, err = prov.Addr(app)
+		if err == nil {
+			err = saveSwappedApp(app)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		if errUndo := prov.Swap(app1, app2); errUndo != nil {
+			log.Errorf("Failed to undo the swap of %q and %q in the provisioner: %s", app1.Name, app2.Name, errUndo)
+		}
+		app1.CName, app1.Ip = cname1, ip1
+		app2.CName, app2.Ip = cname2, ip2
+		for _, app := range []*App{app1, app2} {
+			if errRestore := saveSwappedApp(app); errRestore != nil {
+				log.Errorf("Failed to restore the cnames of the app %q: %s", app.Name, errRestore)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// saveSwappedApp stores the cnames and the address of the app, moving the
+// certificates of its cnames to it.
+func saveSwappedApp(app *App) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(
+		bson.M{"name": app.Name},
+		bson.M{"$set": bson.M{"cname": app.CName, "ip": app.Ip}},
+	)
+	if err != nil || len(app.CName) == 0 {
+		return err
+	}
+	_, err = conn.Certificates().UpdateAll(
+		bson.M{"_id": bson.M{"$in": app.CName}},
+		bson.M{"$set": bson.M{"app": app.Name}},
+	)
+	return err
+}
+
+// LastSwap returns the last swap between apps, which may have been undone.
+func LastSwap() (*SwapEvent, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var evt SwapEvent
+	err = conn.Swaps().Find(nil).Sort("-timestamp").One(&evt)
+	if err == mgo.ErrNotFound {
+		return nil, ErrNoSwap
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// UndoSwap swaps back the apps of the given swap. The apps are not validated
+// again, so a swap can be undone even when the units of the apps are not
+// healthy.
+func UndoSwap(evt *SwapEvent, user string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	now := time.Now()
+	err = conn.Swaps().Update(
+		bson.M{"_id": evt.ID, "undone": false},
+		bson.M{"$set": bson.M{"undone": true, "undoneby": user, "undoneat": now}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrSwapAlreadyUndone
+	}
+	if err != nil {
+		return err
+	}
+	err = swapEventApps(evt)
+	if err != nil {
+		conn.Swaps().UpdateId(evt.ID, bson.M{
+			"$set":   bson.M{"undone": false},
+			"$unset": bson.M{"undoneby": "", "undoneat": ""},
+		})
+		return err
+	}
+	evt.Undone = true
+	evt.UndoneBy = user
+	evt.UndoneAt = now
+	return nil
+}
+
+func swapEventApps(evt *SwapEvent) error {
+	app1, err := GetByName(evt.App1)
+	if err != nil {
+		return err
+	}
+	app2, err := GetByName(evt.App2)
+	if err != nil {
+		return err
+	}
+	prov, err := swapProvisioner(app1, app2)
+	if err != nil {
+		return err
+	}
+	return swapApps(prov, app1, app2)
+}
@@ -0,0 +1,216 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/testing"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) createSwapApps(c *gocheck.C, app1, app2 *App) {
+	for _, a := range []*App{app1, app2} {
+		err := s.provisioner.Provision(a)
+		c.Assert(err, gocheck.IsNil)
+		a.Ip, err = s.provisioner.Addr(a)
+		c.Assert(err, gocheck.IsNil)
+		_, err = s.provisioner.AddUnits(a, 1, "", nil)
+		c.Assert(err, gocheck.IsNil)
+		err = s.conn.Apps().Insert(a)
+		c.Assert(err, gocheck.IsNil)
+	}
+}
+
+func (s *S) removeSwapApps(app1, app2 *App) {
+	for _, a := range []*App{app1, app2} {
+		s.provisioner.Destroy(a)
+		s.conn.Apps().Remove(bson.M{"name": a.Name})
+	}
+	s.conn.Swaps().RemoveAll(nil)
+}
+
+func (s *S) TestSwap(c *gocheck.C) {
+	app1 := &App{Name: "app1", CName: []string{"cname"}}
+	app2 := &App{Name: "app2"}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	oldIp1, oldIp2 := app1.Ip, app2.Ip
+	err := Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(app1.CName, gocheck.IsNil)
+	c.Assert(app2.CName, gocheck.DeepEquals, []string{"cname"})
+	c.Assert(app1.Ip, gocheck.Equals, oldIp2)
+	c.Assert(app2.Ip, gocheck.Equals, oldIp1)
+	evt, err := LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.App1, gocheck.Equals, "app1")
+	c.Assert(evt.App2, gocheck.Equals, "app2")
+	c.Assert(evt.User, gocheck.Equals, "admin@tsuru.io")
+	c.Assert(evt.Undone, gocheck.Equals, false)
+}
+
+func (s *S) TestSwapMovesCertificates(c *gocheck.C) {
+	app1 := &App{Name: "app1", CName: []string{"cname"}}
+	app2 := &App{Name: "app2"}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	err := s.conn.Certificates().Insert(Certificate{CName: "cname", App: app1.Name})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Certificates().RemoveId("cname")
+	err = Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.IsNil)
+	var certificate Certificate
+	err = s.conn.Certificates().FindId("cname").One(&certificate)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificate.App, gocheck.Equals, app2.Name)
+}
+
+func (s *S) TestSwapRollbackWhenStoringFails(c *gocheck.C) {
+	app1 := &App{Name: "app1", CName: []string{"cname"}}
+	app2 := &App{Name: "app2"}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	oldIp1, oldIp2 := app1.Ip, app2.Ip
+	s.provisioner.PrepareFailure("Addr", stderr.New("addr failed"))
+	err := Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.ErrorMatches, "addr failed")
+	c.Assert(app1.CName, gocheck.DeepEquals, []string{"cname"})
+	c.Assert(app2.CName, gocheck.IsNil)
+	c.Assert(app1.Ip, gocheck.Equals, oldIp1)
+	c.Assert(app2.Ip, gocheck.Equals, oldIp2)
+	addr, err := s.provisioner.Addr(app1)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, oldIp1)
+	var dbApp App
+	err = s.conn.Apps().Find(bson.M{"name": app1.Name}).One(&dbApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.CName, gocheck.DeepEquals, []string{"cname"})
+	c.Assert(dbApp.Ip, gocheck.Equals, oldIp1)
+	_, err = LastSwap()
+	c.Assert(err, gocheck.Equals, ErrNoSwap)
+}
+
+func (s *S) TestSwapDifferentProvisioners(c *gocheck.C) {
+	other := testing.NewFakeProvisioner()
+	provision.Register("fake-other", other)
+	app1 := &App{Name: "app1"}
+	app2 := &App{Name: "app2", ProvisionerName: "fake-other"}
+	err := Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.Equals, ErrAppsProvisionerNotEqual)
+}
+
+func (s *S) TestSwapDifferentRouters(c *gocheck.C) {
+	app1 := &App{Name: "app1", Plan: Plan{Router: "hipache"}}
+	app2 := &App{Name: "app2", Plan: Plan{Router: "galeb"}}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	err := Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.FitsTypeOf, SwapValidationError{})
+	c.Assert(err, gocheck.ErrorMatches, `cannot swap apps: "app1" uses the router "hipache" and "app2" uses the router "galeb"`)
+}
+
+func (s *S) TestSwapUnhealthyApp(c *gocheck.C) {
+	app1 := &App{Name: "app1"}
+	app2 := &App{Name: "app2"}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	units := s.provisioner.GetUnits(app2)
+	err := s.provisioner.SetUnitStatus(units[0], provision.StatusError)
+	c.Assert(err, gocheck.IsNil)
+	err = Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.ErrorMatches, `cannot swap apps: all units of the app "app2" must be started`)
+	_, err = LastSwap()
+	c.Assert(err, gocheck.Equals, ErrNoSwap)
+}
+
+func (s *S) TestSwapUnhealthyAppForced(c *gocheck.C) {
+	app1 := &App{Name: "app1"}
+	app2 := &App{Name: "app2"}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	units := s.provisioner.GetUnits(app2)
+	err := s.provisioner.SetUnitStatus(units[0], provision.StatusError)
+	c.Assert(err, gocheck.IsNil)
+	err = Swap(app1, app2, "admin@tsuru.io", true)
+	c.Assert(err, gocheck.IsNil)
+	evt, err := LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.App1, gocheck.Equals, "app1")
+	c.Assert(evt.App2, gocheck.Equals, "app2")
+}
+
+func (s *S) TestSwapWithoutUnits(c *gocheck.C) {
+	app1 := &App{Name: "app1"}
+	err := s.provisioner.Provision(app1)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(app1)
+	app2 := &App{Name: "app2"}
+	err = s.provisioner.Provision(app2)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(app2)
+	err = Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.ErrorMatches, `cannot swap apps: all units of the app "app1" must be started`)
+}
+
+func (s *S) TestSwapIncompatiblePlans(c *gocheck.C) {
+	app1 := &App{Name: "app1", Plan: Plan{Name: "small", Memory: 64, Swap: 128, CpuShare: 100}}
+	app2 := &App{Name: "app2", Plan: Plan{Name: "large", Memory: 512, Swap: 128, CpuShare: 100}}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	err := Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.ErrorMatches, `cannot swap apps: the plans of "app1" and "app2" must have the same memory, swap and cpu share`)
+}
+
+func (s *S) TestLastSwapWithoutSwaps(c *gocheck.C) {
+	_, err := LastSwap()
+	c.Assert(err, gocheck.Equals, ErrNoSwap)
+}
+
+func (s *S) TestUndoSwap(c *gocheck.C) {
+	app1 := &App{Name: "app1", CName: []string{"cname"}}
+	app2 := &App{Name: "app2"}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	oldIp1 := app1.Ip
+	err := Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.IsNil)
+	evt, err := LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	err = UndoSwap(evt, "other@tsuru.io")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.Undone, gocheck.Equals, true)
+	dbApp, err := GetByName(app1.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.CName, gocheck.DeepEquals, []string{"cname"})
+	c.Assert(dbApp.Ip, gocheck.Equals, oldIp1)
+	evt, err = LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.Undone, gocheck.Equals, true)
+	c.Assert(evt.UndoneBy, gocheck.Equals, "other@tsuru.io")
+	err = UndoSwap(evt, "other@tsuru.io")
+	c.Assert(err, gocheck.Equals, ErrSwapAlreadyUndone)
+}
+
+func (s *S) TestUndoSwapFailureKeepsSwap(c *gocheck.C) {
+	app1 := &App{Name: "app1"}
+	app2 := &App{Name: "app2"}
+	s.createSwapApps(c, app1, app2)
+	defer s.removeSwapApps(app1, app2)
+	err := Swap(app1, app2, "admin@tsuru.io", false)
+	c.Assert(err, gocheck.IsNil)
+	evt, err := LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	err = s.conn.Apps().Remove(bson.M{"name": app2.Name})
+	c.Assert(err, gocheck.IsNil)
+	err = UndoSwap(evt, "other@tsuru.io")
+	c.Assert(err, gocheck.Equals, ErrAppNotFound)
+	evt, err = LastSwap()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(evt.Undone, gocheck.Equals, false)
+	c.Assert(evt.UndoneBy, gocheck.Equals, "")
+}
@@ -0,0 +1,368 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tsuruIo "github.com/tsuru/tsuru/io"
+)
+
+// AdminCommands returns the tsuru-admin commands that are not bound to any
+// provisioner. tsuru-admin registers them whatever the provisioner of the
+// tsuru server is, next to the commands of the provisioner, which it loads
+// through the AdminCommandable interface.
+func AdminCommands() []Command {
+	return []Command{
+		&appChangeProvisioner{},
+		&appDeployRollback{},
+		&appDeployPromote{},
+		&appCanaryStart{},
+		&appCanaryWeight{},
+		&appCanaryPromote{},
+		&appCanaryAbort{},
+		&appSwapLast{},
+		&appSwapUndo{},
+	}
+}
+
+type appChangeProvisioner struct {
+	ConfirmationCommand
+}
+
+func (c *appChangeProvisioner) Info() *Info {
+	return &Info{
+		Name:  "app-change-provisioner",
+		Usage: "app-change-provisioner <appname> <provisioner> [-y/--assume-yes]",
+		Desc: `Moves an app to another provisioner.
+
+The units of the app are destroyed in the current provisioner, so the app must
+be deployed again after the command finishes.`,
+		MinArgs: 2,
+	}
+}
+
+func (c *appChangeProvisioner) Run(context *Context, client *Client) error {
+	appName, provisioner := context.Args[0], context.Args[1]
+	question := fmt.Sprintf("Are you sure you want to move the app %q to the %q provisioner? All its units will be destroyed.", appName, provisioner)
+	if !c.Confirm(context, question) {
+		return nil
+	}
+	u, err := GetURL(fmt.Sprintf("/apps/%s/provisioner", appName))
+	if err != nil {
+		return err
+	}
+	body := strings.NewReader(url.Values{"provisioner": []string{provisioner}}.Encode())
+	request, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	w := tsuruIo.NewStreamWriter(context.Stdout, nil)
+	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(w, response.Body) {
+	}
+	return err
+}
+
+type appDeployRollback struct {
+	ConfirmationCommand
+}
+
+func (c *appDeployRollback) Info() *Info {
+	return &Info{
+		Name:  "app-deploy-rollback",
+		Usage: "app-deploy-rollback <appname> <image> [-y/--assume-yes]",
+		Desc: `Deploys again the image generated by a previous deploy of an app.
+
+The image is not built again, and must have been generated by a successful
+deploy of the app. The images of the deploys are listed by the deploy-list
+command.`,
+		MinArgs: 2,
+	}
+}
+
+func (c *appDeployRollback) Run(context *Context, client *Client) error {
+	appName, image := context.Args[0], context.Args[1]
+	question := fmt.Sprintf("Are you sure you want to roll back the app %q to the image %q?", appName, image)
+	if !c.Confirm(context, question) {
+		return nil
+	}
+	u, err := GetURL(fmt.Sprintf("/apps/%s/deploy/rollback", appName))
+	if err != nil {
+		return err
+	}
+	body := strings.NewReader(url.Values{"image": []string{image}}.Encode())
+	request, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+type appDeployPromote struct {
+	ConfirmationCommand
+}
+
+func (c *appDeployPromote) Info() *Info {
+	return &Info{
+		Name:  "app-deploy-promote",
+		Usage: "app-deploy-promote <source-appname> <appname> [-y/--assume-yes]",
+		Desc: `Deploys the image currently running in an app to another app.
+
+The image is not built again, so the app runs exactly what was deployed in the
+source app, for example, promoting a staging app to production.`,
+		MinArgs: 2,
+	}
+}
+
+func (c *appDeployPromote) Run(context *Context, client *Client) error {
+	sourceName, appName := context.Args[0], context.Args[1]
+	question := fmt.Sprintf("Are you sure you want to deploy the image of the app %q to the app %q?", sourceName, appName)
+	if !c.Confirm(context, question) {
+		return nil
+	}
+	u, err := GetURL(fmt.Sprintf("/apps/%s/deploy/promote", appName))
+	if err != nil {
+		return err
+	}
+	body := strings.NewReader(url.Values{"source": []string{sourceName}}.Encode())
+	request, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+// postCanary sends the given form to the canary endpoint of the app, copying
+// the response to the output of the command.
+func postCanary(context *Context, client *Client, appName, path string, form url.Values) error {
+	u, err := GetURL(fmt.Sprintf("/apps/%s/canary%s", appName, path))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
+
+type appCanaryStart struct {
+	ConfirmationCommand
+}
+
+func (c *appCanaryStart) Info() *Info {
+	return &Info{
+		Name:  "app-canary-start",
+		Usage: "app-canary-start <appname> <image> <units> <weight> [-y/--assume-yes]",
+		Desc: `Starts a canary of an image in an app.
+
+The given number of units run the image next to the current units of the app,
+receiving the given percentage of its traffic. The image may be generated by a
+previous deploy of the app or built elsewhere. The canary must then be promoted,
+with app-canary-promote, or aborted, with app-canary-abort.`,
+		MinArgs: 4,
+	}
+}
+
+func (c *appCanaryStart) Run(context *Context, client *Client) error {
+	appName, image, units, weight := context.Args[0], context.Args[1], context.Args[2], context.Args[3]
+	question := fmt.Sprintf("Are you sure you want to send %s%% of the traffic of the app %q to the image %q?", weight, appName, image)
+	if !c.Confirm(context, question) {
+		return nil
+	}
+	form := url.Values{"image": []string{image}, "units": []string{units}, "weight": []string{weight}}
+	return postCanary(context, client, appName, "", form)
+}
+
+type appCanaryWeight struct{}
+
+func (c *appCanaryWeight) Info() *Info {
+	return &Info{
+		Name:    "app-canary-weight",
+		Usage:   "app-canary-weight <appname> <weight>",
+		Desc:    "Changes the percentage of the traffic of an app sent to its canary.",
+		MinArgs: 2,
+	}
+}
+
+func (c *appCanaryWeight) Run(context *Context, client *Client) error {
+	appName, weight := context.Args[0], context.Args[1]
+	err := postCanary(context, client, appName, "/weight", url.Values{"weight": []string{weight}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "%s%% of the traffic of the app %q is now sent to the canary.\n", weight, appName)
+	return nil
+}
+
+type appCanaryPromote struct {
+	ConfirmationCommand
+}
+
+func (c *appCanaryPromote) Info() *Info {
+	return &Info{
+		Name:  "app-canary-promote",
+		Usage: "app-canary-promote <appname> [-y/--assume-yes]",
+		Desc: `Promotes the canary of an app.
+
+The units of the app are replaced by units running the image of the canary,
+and then the units of the canary are removed.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *appCanaryPromote) Run(context *Context, client *Client) error {
+	appName := context.Args[0]
+	question := fmt.Sprintf("Are you sure you want to promote the canary of the app %q?", appName)
+	if !c.Confirm(context, question) {
+		return nil
+	}
+	return postCanary(context, client, appName, "/promote", nil)
+}
+
+type appCanaryAbort struct {
+	ConfirmationCommand
+}
+
+func (c *appCanaryAbort) Info() *Info {
+	return &Info{
+		Name:  "app-canary-abort",
+		Usage: "app-canary-abort <appname> [-y/--assume-yes]",
+		Desc: `Aborts the canary of an app.
+
+The units of the canary are removed, and all the traffic of the app goes back
+to its other units.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *appCanaryAbort) Run(context *Context, client *Client) error {
+	appName := context.Args[0]
+	question := fmt.Sprintf("Are you sure you want to abort the canary of the app %q?", appName)
+	if !c.Confirm(context, question) {
+		return nil
+	}
+	return postCanary(context, client, appName, "/abort", nil)
+}
+
+type appSwapLast struct{}
+
+func (c *appSwapLast) Info() *Info {
+	return &Info{
+		Name:  "app-swap-last",
+		Usage: "app-swap-last",
+		Desc:  "Shows the last swap between apps, and whether it was undone.",
+	}
+}
+
+func (c *appSwapLast) Run(context *Context, client *Client) error {
+	u, err := GetURL("/swap/last")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var swap struct {
+		App1      string
+		App2      string
+		User      string
+		Timestamp time.Time
+		Undone    bool
+		UndoneBy  string
+		UndoneAt  time.Time
+	}
+	err = json.Unmarshal(b, &swap)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "The apps %q and %q were swapped by %s at %s.\n",
+		swap.App1, swap.App2, swap.User, swap.Timestamp.Local().Format(time.Stamp))
+	if swap.Undone {
+		fmt.Fprintf(context.Stdout, "The swap was undone by %s at %s.\n",
+			swap.UndoneBy, swap.UndoneAt.Local().Format(time.Stamp))
+	}
+	return nil
+}
+
+type appSwapUndo struct {
+	ConfirmationCommand
+}
+
+func (c *appSwapUndo) Info() *Info {
+	return &Info{
+		Name:  "app-swap-undo",
+		Usage: "app-swap-undo [-y/--assume-yes]",
+		Desc: `Undoes the last swap between apps, swapping them back.
+
+The apps are not validated again, so the swap can be undone even when the units
+of the apps are not healthy.`,
+	}
+}
+
+func (c *appSwapUndo) Run(context *Context, client *Client) error {
+	if !c.Confirm(context, "Are you sure you want to undo the last swap?") {
+		return nil
+	}
+	u, err := GetURL("/swap/undo")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	fmt.Fprintln(context.Stdout, "The last swap was undone.")
+	return nil
+}
@@ -0,0 +1,365 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	ttesting "github.com/tsuru/tsuru/cmd/testing"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestAdminCommands(c *gocheck.C) {
+	commands := AdminCommands()
+	c.Assert(commands, gocheck.HasLen, 9)
+	c.Assert(commands[0], gocheck.FitsTypeOf, &appChangeProvisioner{})
+	c.Assert(commands[1], gocheck.FitsTypeOf, &appDeployRollback{})
+	c.Assert(commands[2], gocheck.FitsTypeOf, &appDeployPromote{})
+	c.Assert(commands[3], gocheck.FitsTypeOf, &appCanaryStart{})
+	c.Assert(commands[4], gocheck.FitsTypeOf, &appCanaryWeight{})
+	c.Assert(commands[5], gocheck.FitsTypeOf, &appCanaryPromote{})
+	c.Assert(commands[6], gocheck.FitsTypeOf, &appCanaryAbort{})
+	c.Assert(commands[7], gocheck.FitsTypeOf, &appSwapLast{})
+	c.Assert(commands[8], gocheck.FitsTypeOf, &appSwapUndo{})
+}
+
+func (s *S) TestAppChangeProvisionerInfo(c *gocheck.C) {
+	info := (&appChangeProvisioner{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-change-provisioner")
+	c.Assert(info.MinArgs, gocheck.Equals, 2)
+}
+
+func (s *S) TestAppChangeProvisionerRun(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{
+		Args:   []string{"myapp", "local"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	msg := `{"Message":"moved"}` + "\n"
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: msg, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/myapp/provisioner" && req.Method == "POST" &&
+				req.FormValue("provisioner") == "local"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appChangeProvisioner{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	expected := `Are you sure you want to move the app "myapp" to the "local" provisioner? All its units will be destroyed. (y/n) moved`
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppChangeProvisionerRunError(c *gocheck.C) {
+	context := Context{
+		Args:   []string{"myapp", "local"},
+		Stdout: new(bytes.Buffer),
+		Stdin:  strings.NewReader("y\n"),
+	}
+	msg := `{"Message":"moving"}` + "\n" + `{"Message":"","Error":"provision failed"}` + "\n"
+	trans := ttesting.Transport{Message: msg, Status: http.StatusOK}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appChangeProvisioner{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.ErrorMatches, "provision failed")
+}
+
+func (s *S) TestAppChangeProvisionerRunWithoutConfirmation(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Args:   []string{"myapp", "local"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("n\n"),
+	}
+	command := appChangeProvisioner{}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*Abort.`+"\n")
+}
+
+func (s *S) TestAppDeployRollbackInfo(c *gocheck.C) {
+	info := (&appDeployRollback{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-deploy-rollback")
+	c.Assert(info.MinArgs, gocheck.Equals, 2)
+}
+
+func (s *S) TestAppDeployRollbackRun(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{
+		Args:   []string{"myapp", "tsuru/app-myapp:v2"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "Rollback called\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/myapp/deploy/rollback" && req.Method == "POST" &&
+				req.FormValue("image") == "tsuru/app-myapp:v2"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appDeployRollback{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	expected := `Are you sure you want to roll back the app "myapp" to the image "tsuru/app-myapp:v2"? (y/n) Rollback called` + "\nOK\n"
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppDeployRollbackRunWithoutConfirmation(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Args:   []string{"myapp", "tsuru/app-myapp:v2"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("n\n"),
+	}
+	command := appDeployRollback{}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*Abort.`+"\n")
+}
+
+func (s *S) TestAppDeployPromoteInfo(c *gocheck.C) {
+	info := (&appDeployPromote{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-deploy-promote")
+	c.Assert(info.MinArgs, gocheck.Equals, 2)
+}
+
+func (s *S) TestAppDeployPromoteRun(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{
+		Args:   []string{"myapp-staging", "myapp-prod"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "Image deploy called\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/myapp-prod/deploy/promote" && req.Method == "POST" &&
+				req.FormValue("source") == "myapp-staging"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appDeployPromote{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	expected := `Are you sure you want to deploy the image of the app "myapp-staging" to the app "myapp-prod"? (y/n) Image deploy called` + "\nOK\n"
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppDeployPromoteRunWithoutConfirmation(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Args:   []string{"myapp-staging", "myapp-prod"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("n\n"),
+	}
+	command := appDeployPromote{}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*Abort.`+"\n")
+}
+
+func (s *S) TestAppCanaryStartInfo(c *gocheck.C) {
+	info := (&appCanaryStart{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-canary-start")
+	c.Assert(info.MinArgs, gocheck.Equals, 4)
+}
+
+func (s *S) TestAppCanaryStartRun(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{
+		Args:   []string{"myapp", "tsuru/app-myapp:v2", "2", "10"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "Start canary called\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/myapp/canary" && req.Method == "POST" &&
+				req.FormValue("image") == "tsuru/app-myapp:v2" && req.FormValue("units") == "2" &&
+				req.FormValue("weight") == "10"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appCanaryStart{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	expected := `Are you sure you want to send 10% of the traffic of the app "myapp" to the image "tsuru/app-myapp:v2"? (y/n) Start canary called` + "\nOK\n"
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppCanaryStartRunWithoutConfirmation(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Args:   []string{"myapp", "tsuru/app-myapp:v2", "2", "10"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("n\n"),
+	}
+	command := appCanaryStart{}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*Abort.`+"\n")
+}
+
+func (s *S) TestAppCanaryWeightInfo(c *gocheck.C) {
+	info := (&appCanaryWeight{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-canary-weight")
+	c.Assert(info.MinArgs, gocheck.Equals, 2)
+}
+
+func (s *S) TestAppCanaryWeightRun(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Args:   []string{"myapp", "50"},
+		Stdout: &buf,
+	}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/canary/weight" && req.Method == "POST" &&
+				req.FormValue("weight") == "50"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appCanaryWeight{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, `50% of the traffic of the app "myapp" is now sent to the canary.`+"\n")
+}
+
+func (s *S) TestAppCanaryPromoteInfo(c *gocheck.C) {
+	info := (&appCanaryPromote{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-canary-promote")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestAppCanaryPromoteRun(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Args:   []string{"myapp"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "Promote canary called\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/canary/promote" && req.Method == "POST"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appCanaryPromote{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `Are you sure you want to promote the canary of the app "myapp"? (y/n) Promote canary called` + "\nOK\n"
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppCanaryAbortInfo(c *gocheck.C) {
+	info := (&appCanaryAbort{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-canary-abort")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestAppCanaryAbortRun(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Args:   []string{"myapp"},
+		Stdout: &buf,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "Abort canary called\nOK\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/canary/abort" && req.Method == "POST"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appCanaryAbort{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `Are you sure you want to abort the canary of the app "myapp"? (y/n) Abort canary called` + "\nOK\n"
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppSwapLastInfo(c *gocheck.C) {
+	info := (&appSwapLast{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-swap-last")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestAppSwapLastRun(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{Stdout: &buf}
+	swapped := time.Date(2014, 10, 2, 15, 4, 5, 0, time.UTC)
+	undone := swapped.Add(time.Hour)
+	body := fmt.Sprintf(`{"App1":"app1","App2":"app2","User":"admin@tsuru.io","Timestamp":%q,"Undone":true,"UndoneBy":"other@tsuru.io","UndoneAt":%q}`,
+		swapped.Format(time.RFC3339), undone.Format(time.RFC3339))
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: body, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/swap/last" && req.Method == "GET"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appSwapLast{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := fmt.Sprintf(`The apps "app1" and "app2" were swapped by admin@tsuru.io at %s.
+The swap was undone by other@tsuru.io at %s.
+`, swapped.Local().Format(time.Stamp), undone.Local().Format(time.Stamp))
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppSwapUndoInfo(c *gocheck.C) {
+	info := (&appSwapUndo{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-swap-undo")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestAppSwapUndoRun(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := Context{
+		Stdout: &buf,
+		Stdin:  strings.NewReader("y\n"),
+	}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/swap/undo" && req.Method == "POST"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appSwapUndo{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := "Are you sure you want to undo the last swap? (y/n) The last swap was undone.\n"
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/provision"
 	_ "github.com/tsuru/tsuru/provision/docker"
+	_ "github.com/tsuru/tsuru/provision/local"
 )
 
 const defaultConfigPath = "/etc/tsuru/tsuru.conf"
@@ -23,6 +24,7 @@ func buildManager() *cmd.Manager {
 	m := cmd.NewManager("tsr", "0.9.1", "", os.Stdout, os.Stderr, os.Stdin, nil)
 	m.Register(&tsrCommand{Command: &apiCmd{}})
 	m.Register(&tsrCommand{Command: tokenCmd{}})
+	m.Register(&tsrCommand{Command: &routerCmd{}})
 	registerProvisionersCommands(m)
 	return m
 }
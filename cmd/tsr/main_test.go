@@ -50,6 +50,15 @@ func (s *S) TestTokenCmdIsRegistered(c *gocheck.C) {
 	c.Assert(tsrToken.Command, gocheck.FitsTypeOf, tokenCmd{})
 }
 
+func (s *S) TestRouterCmdIsRegistered(c *gocheck.C) {
+	manager := buildManager()
+	router, ok := manager.Commands["router"]
+	c.Assert(ok, gocheck.Equals, true)
+	tsrRouter, ok := router.(*tsrCommand)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(tsrRouter.Command, gocheck.FitsTypeOf, &routerCmd{})
+}
+
 func (s *S) TestShouldRegisterAllCommandsFromProvisioners(c *gocheck.C) {
 	fp := testing.NewFakeProvisioner()
 	p := CommandableProvisioner{FakeProvisioner: *fp}
@@ -0,0 +1,63 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/router/proxy"
+	"launchpad.net/gnuflag"
+)
+
+type routerCmd struct {
+	fs   *gnuflag.FlagSet
+	name string
+}
+
+// prefix returns the config prefix of the router, following the same rules
+// of router.Get.
+func (c *routerCmd) prefix() (string, error) {
+	prefix := "routers:" + c.name
+	routerType, err := config.GetString(prefix + ":type")
+	if err != nil {
+		return c.name, nil
+	}
+	if routerType != "proxy" {
+		return "", fmt.Errorf("the router %q is a %q router, only proxy routers can be served by tsr", c.name, routerType)
+	}
+	return prefix, nil
+}
+
+func (c *routerCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+	server, err := proxy.NewServer(prefix)
+	if err != nil {
+		return err
+	}
+	return server.ListenAndServe()
+}
+
+func (routerCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "router",
+		Usage:   "router [--router/-r <name>]",
+		Desc:    "Starts the tsuru proxy router, serving the apps that use it.",
+		MinArgs: 0,
+	}
+}
+
+func (c *routerCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("router", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.name, "router", "proxy", "name of the router in the configuration file")
+		c.fs.StringVar(&c.name, "r", "proxy", "name of the router in the configuration file")
+	}
+	return c.fs
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/cmd"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestRouterCmdInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:    "router",
+		Usage:   "router [--router/-r <name>]",
+		Desc:    "Starts the tsuru proxy router, serving the apps that use it.",
+		MinArgs: 0,
+	}
+	c.Assert(routerCmd{}.Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestRouterCmdIsACommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &routerCmd{}
+}
+
+func (s *S) TestRouterCmdFlags(c *gocheck.C) {
+	command := routerCmd{}
+	flagset := command.Flags()
+	c.Assert(flagset, gocheck.NotNil)
+	flagset.Parse(true, []string{"--router", "myrouter"})
+	flag := flagset.Lookup("router")
+	c.Assert(flag, gocheck.NotNil)
+	c.Assert(flag.Usage, gocheck.Equals, "name of the router in the configuration file")
+	c.Assert(flag.DefValue, gocheck.Equals, "proxy")
+	c.Assert(command.name, gocheck.Equals, "myrouter")
+	flagset.Parse(true, []string{"-r", "other"})
+	flag = flagset.Lookup("r")
+	c.Assert(flag, gocheck.NotNil)
+	c.Assert(flag.DefValue, gocheck.Equals, "proxy")
+	c.Assert(command.name, gocheck.Equals, "other")
+}
+
+func (s *S) TestRouterCmdPrefix(c *gocheck.C) {
+	config.Set("routers:myproxy:type", "proxy")
+	defer config.Unset("routers:myproxy:type")
+	command := routerCmd{name: "myproxy"}
+	prefix, err := command.prefix()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(prefix, gocheck.Equals, "routers:myproxy")
+	command = routerCmd{name: "proxy"}
+	prefix, err = command.prefix()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(prefix, gocheck.Equals, "proxy")
+}
+
+func (s *S) TestRouterCmdPrefixOtherRouterType(c *gocheck.C) {
+	config.Set("routers:myhipache:type", "hipache")
+	defer config.Unset("routers:myhipache:type")
+	command := routerCmd{name: "myhipache"}
+	_, err := command.prefix()
+	c.Assert(err, gocheck.ErrorMatches, `the router "myhipache" is a "hipache" router, only proxy routers can be served by tsr`)
+}
@@ -69,6 +69,35 @@ func (s *Storage) Deploys() *storage.Collection {
 	return s.Collection("deploys")
 }
 
+// DeployLogs returns the collection that stores the output of deploys from
+// MongoDB.
+func (s *Storage) DeployLogs() *storage.Collection {
+	deployIndex := mgo.Index{Key: []string{"deploy", "seq"}}
+	c := s.Collection("deploy_logs")
+	c.EnsureIndex(deployIndex)
+	return c
+}
+
+// Swaps returns the collection that stores the swaps of apps from MongoDB.
+func (s *Storage) Swaps() *storage.Collection {
+	return s.Collection("swaps")
+}
+
+// MovedBackends returns the collection that stores the backends left in the
+// previous router of apps, waiting to be removed, from MongoDB.
+func (s *Storage) MovedBackends() *storage.Collection {
+	return s.Collection("moved_backends")
+}
+
+// Certificates returns the collection that stores the TLS certificates of the
+// cnames of apps from MongoDB.
+func (s *Storage) Certificates() *storage.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	c := s.Collection("certificates")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
 // Platforms returns the platforms collection from MongoDB.
 func (s *Storage) Platforms() *storage.Collection {
 	return s.Collection("platforms")
@@ -149,6 +149,52 @@ func (s *S) TestDeploys(c *gocheck.C) {
 	c.Assert(deploys, gocheck.DeepEquals, deploysc)
 }
 
+func (s *S) TestDeployLogs(c *gocheck.C) {
+	strg, err := Conn()
+	c.Assert(err, gocheck.IsNil)
+	logs := strg.DeployLogs()
+	logsc := strg.Collection("deploy_logs")
+	c.Assert(logs, gocheck.DeepEquals, logsc)
+}
+
+func (s *S) TestDeployLogsDeployIndex(c *gocheck.C) {
+	strg, err := Conn()
+	c.Assert(err, gocheck.IsNil)
+	logs := strg.DeployLogs()
+	c.Assert(logs, HasIndex, []string{"deploy", "seq"})
+}
+
+func (s *S) TestSwaps(c *gocheck.C) {
+	strg, err := Conn()
+	c.Assert(err, gocheck.IsNil)
+	swaps := strg.Swaps()
+	swapsc := strg.Collection("swaps")
+	c.Assert(swaps, gocheck.DeepEquals, swapsc)
+}
+
+func (s *S) TestMovedBackends(c *gocheck.C) {
+	strg, err := Conn()
+	c.Assert(err, gocheck.IsNil)
+	moved := strg.MovedBackends()
+	movedc := strg.Collection("moved_backends")
+	c.Assert(moved, gocheck.DeepEquals, movedc)
+}
+
+func (s *S) TestCertificates(c *gocheck.C) {
+	strg, err := Conn()
+	c.Assert(err, gocheck.IsNil)
+	certificates := strg.Certificates()
+	certificatesc := strg.Collection("certificates")
+	c.Assert(certificates, gocheck.DeepEquals, certificatesc)
+}
+
+func (s *S) TestCertificatesAppIndex(c *gocheck.C) {
+	strg, err := Conn()
+	c.Assert(err, gocheck.IsNil)
+	certificates := strg.Certificates()
+	c.Assert(certificates, HasIndex, []string{"app"})
+}
+
 func (s *S) TestPlatforms(c *gocheck.C) {
 	strg, err := Conn()
 	c.Assert(err, gocheck.IsNil)
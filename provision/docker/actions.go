@@ -22,6 +22,7 @@ import (
 type runContainerActionsArgs struct {
 	app              provision.App
 	imageID          string
+	processName      string
 	commands         []string
 	destinationHosts []string
 	privateKey       []byte
@@ -30,11 +31,13 @@ type runContainerActionsArgs struct {
 }
 
 type changeUnitsPipelineArgs struct {
-	app        provision.App
-	writer     io.Writer
-	toRemove   []container
-	unitsToAdd int
-	toHost     string
+	app      provision.App
+	writer   io.Writer
+	toRemove []container
+	toAdd    map[string]int
+	toHost   string
+	imageID  string
+	weight   int
 }
 
 var insertEmptyContainerInDB = action.Action{
@@ -43,12 +46,13 @@ var insertEmptyContainerInDB = action.Action{
 		args := ctx.Params[0].(runContainerActionsArgs)
 		contName := containerName()
 		cont := container{
-			AppName:    args.app.GetName(),
-			Type:       args.app.GetPlatform(),
-			Name:       contName,
-			Status:     provision.StatusCreated.String(),
-			Image:      args.imageID,
-			PrivateKey: string(args.privateKey),
+			AppName:     args.app.GetName(),
+			ProcessName: args.processName,
+			Type:        args.app.GetPlatform(),
+			Name:        contName,
+			Status:      provision.StatusCreated.String(),
+			Image:       args.imageID,
+			PrivateKey:  string(args.privateKey),
 		}
 		coll := collection()
 		defer coll.Close()
@@ -150,9 +154,24 @@ var provisionAddUnitsToHost = action.Action{
 		if args.toHost != "" {
 			destinationHosts = []string{args.toHost}
 		}
-		containers, err := addContainersWithHost(args.writer, args.app, args.unitsToAdd, destinationHosts...)
-		if err != nil {
-			return nil, err
+		var containers []container
+		for _, process := range sortedProcesses(args.toAdd) {
+			var (
+				added []container
+				err   error
+			)
+			if args.imageID != "" {
+				added, err = addContainersWithImage(args.writer, args.app, args.imageID, args.toAdd[process], process, destinationHosts...)
+			} else {
+				added, err = addContainersWithHost(args.writer, args.app, args.toAdd[process], process, destinationHosts...)
+			}
+			if err != nil {
+				for _, cont := range containers {
+					removeContainer(&cont)
+				}
+				return nil, err
+			}
+			containers = append(containers, added...)
 		}
 		return containers, nil
 	},
@@ -181,9 +200,10 @@ var addNewRoutes = action.Action{
 		if writer == nil {
 			writer = ioutil.Discard
 		}
-		fmt.Fprintf(writer, "\n---- Adding routes to %d new units ----\n", len(newContainers))
-		addedContainers := make([]container, 0, len(newContainers))
-		for _, cont := range newContainers {
+		routable := routableContainers(newContainers)
+		fmt.Fprintf(writer, "\n---- Adding routes to %d new units ----\n", len(routable))
+		addedContainers := make([]container, 0, len(routable))
+		for _, cont := range routable {
 			err = r.AddRoute(cont.AppName, cont.getAddress())
 			if err != nil {
 				for _, toRemoveCont := range addedContainers {
@@ -203,7 +223,7 @@ var addNewRoutes = action.Action{
 		if err != nil {
 			log.Errorf("[add-new-routes:Backward] Error geting router: %s", err.Error())
 		}
-		for _, cont := range newContainers {
+		for _, cont := range routableContainers(newContainers) {
 			err = r.RemoveRoute(cont.AppName, cont.getAddress())
 			if err != nil {
 				log.Errorf("[add-new-routes:Backward] Error removing route for %s: %s", cont.ID, err.Error())
@@ -224,9 +244,10 @@ var removeOldRoutes = action.Action{
 		if writer == nil {
 			writer = ioutil.Discard
 		}
-		fmt.Fprintf(writer, "\n---- Removing routes from %d old units ----\n", len(args.toRemove))
-		removedConts := make([]container, 0, len(args.toRemove))
-		for _, cont := range args.toRemove {
+		toRemove := routableContainers(args.toRemove)
+		fmt.Fprintf(writer, "\n---- Removing routes from %d old units ----\n", len(toRemove))
+		removedConts := make([]container, 0, len(toRemove))
+		for _, cont := range toRemove {
 			err = r.RemoveRoute(cont.AppName, cont.getAddress())
 			if err != router.ErrRouteNotFound && err != nil {
 				for _, toAddCont := range removedConts {
@@ -245,7 +266,7 @@ var removeOldRoutes = action.Action{
 		if err != nil {
 			log.Errorf("[add-new-routes:Backward] Error geting router: %s", err.Error())
 		}
-		for _, cont := range args.toRemove {
+		for _, cont := range routableContainers(args.toRemove) {
 			err = r.AddRoute(cont.AppName, cont.getAddress())
 			if err != nil {
 				log.Errorf("[remove-old-routes:Backward] Error adding back route for %s: %s", cont.ID, err.Error())
@@ -255,6 +276,41 @@ var removeOldRoutes = action.Action{
 	MinParams: 1,
 }
 
+var setCanaryRoutesWeight = action.Action{
+	Name: "set-canary-routes-weight",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		newContainers := ctx.Previous.([]container)
+		r, err := getWeightedRouterForApp(args.app)
+		if err != nil {
+			return nil, err
+		}
+		var addresses []string
+		for _, cont := range routableContainers(newContainers) {
+			addresses = append(addresses, cont.getAddress())
+		}
+		fmt.Fprintf(args.writer, "\n---- Sending %d%% of the traffic to the canary ----\n", args.weight)
+		err = r.SetRoutesWeight(args.app.GetName(), addresses, args.weight)
+		if err != nil {
+			return nil, err
+		}
+		return newContainers, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		r, err := getWeightedRouterForApp(args.app)
+		if err != nil {
+			log.Errorf("[set-canary-routes-weight:Backward] Error geting router: %s", err.Error())
+			return
+		}
+		err = r.ResetRoutesWeight(args.app.GetName())
+		if err != nil {
+			log.Errorf("[set-canary-routes-weight:Backward] Error resetting the weight of routes: %s", err.Error())
+		}
+	},
+	MinParams: 1,
+}
+
 var provisionRemoveOldUnits = action.Action{
 	Name: "provision-remove-old-units",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -303,6 +359,11 @@ var provisionRemoveOldUnits = action.Action{
 	MinParams: 1,
 }
 
+func errDeployCanceled(w io.Writer) error {
+	fmt.Fprintf(w, "\n---- Deploy canceled ----\n")
+	return provision.ErrDeployCanceled
+}
+
 var followLogsAndCommit = action.Action{
 	Name: "follow-logs-and-commit",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -313,10 +374,16 @@ var followLogsAndCommit = action.Action{
 		args := ctx.Params[0].(runContainerActionsArgs)
 		err := c.logs(args.writer)
 		if err != nil {
+			if c.deployCanceled() {
+				return nil, errDeployCanceled(args.writer)
+			}
 			log.Errorf("error on get logs for container %s - %s", c.ID, err)
 			return nil, err
 		}
 		status, err := dockerCluster().WaitContainer(c.ID)
+		if c.deployCanceled() {
+			return nil, errDeployCanceled(args.writer)
+		}
 		if err != nil {
 			log.Errorf("Process failed for container %q: %s", c.ID, err)
 			return nil, err
@@ -324,10 +391,19 @@ var followLogsAndCommit = action.Action{
 		if status != 0 {
 			return nil, fmt.Errorf("Exit status %d", status)
 		}
+		imageCont, hookImage, err := runBuildHooks(&c, args.app, args.writer)
+		if err != nil {
+			log.Errorf("error on running build hooks for container %s - %s", c.ID, err)
+			return nil, err
+		}
 		fmt.Fprintf(args.writer, "\n---- Building application image ----\n")
-		imageId, err := c.commit(args.writer)
+		imageId, err := imageCont.commit(args.writer)
+		if imageCont != &c {
+			removeHookContainer(imageCont)
+			removeHookImage(hookImage)
+		}
 		if err != nil {
-			log.Errorf("error on commit container %s - %s", c.ID, err)
+			log.Errorf("error on commit container %s - %s", imageCont.ID, err)
 			return nil, err
 		}
 		fmt.Fprintf(args.writer, " ---> Cleaning up\n")
@@ -352,9 +352,9 @@ func (s *S) TestProvisionAddUnitsToHostForward(c *gocheck.C) {
 	coll.Insert(container{ID: "container-id", AppName: app.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": app.GetName()})
 	args := changeUnitsPipelineArgs{
-		app:        app,
-		toHost:     "localhost",
-		unitsToAdd: 2,
+		app:    app,
+		toHost: "localhost",
+		toAdd:  map[string]int{"web": 2},
 	}
 	context := action.FWContext{Params: []interface{}{args}}
 	result, err := provisionAddUnitsToHost.Forward(context)
@@ -383,8 +383,8 @@ func (s *S) TestProvisionAddUnitsToHostForwardWithoutHost(c *gocheck.C) {
 	coll.Insert(container{ID: "container-id", AppName: app.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": app.GetName()})
 	args := changeUnitsPipelineArgs{
-		app:        app,
-		unitsToAdd: 3,
+		app:   app,
+		toAdd: map[string]int{"web": 3},
 	}
 	context := action.FWContext{Params: []interface{}{args}}
 	result, err := provisionAddUnitsToHost.Forward(context)
@@ -465,7 +465,7 @@ func (s *S) TestFollowLogsAndCommitForward(c *gocheck.C) {
 	context := action.FWContext{Params: []interface{}{args}, Previous: cont}
 	imageId, err := followLogsAndCommit.Forward(context)
 	c.Assert(err, gocheck.IsNil)
-	c.Assert(imageId, gocheck.Equals, "tsuru/app-mightyapp")
+	c.Assert(imageId, gocheck.Equals, "tsuru/app-mightyapp:v1")
 	c.Assert(buf.String(), gocheck.Not(gocheck.Equals), "")
 	var dbCont container
 	coll := collection()
@@ -476,7 +476,7 @@ func (s *S) TestFollowLogsAndCommitForward(c *gocheck.C) {
 	_, err = dockerCluster().InspectContainer(cont.ID)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Matches, "No such container.*")
-	err = dockerCluster().RemoveImage("tsuru/app-mightyapp")
+	err = dockerCluster().RemoveImage("tsuru/app-mightyapp:v1")
 	c.Assert(err, gocheck.IsNil)
 }
 
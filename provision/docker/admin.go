@@ -16,12 +16,14 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/tsuru/tsuru/cmd"
 	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/provision"
 	"golang.org/x/crypto/ssh/terminal"
 	"launchpad.net/gnuflag"
 )
@@ -104,6 +106,83 @@ func (fixContainersCmd) Run(context *cmd.Context, client *cmd.Client) error {
 	return err
 }
 
+type routesCheckCmd struct {
+	fs      *gnuflag.FlagSet
+	appName string
+	fix     bool
+}
+
+func (c *routesCheckCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "routes-check",
+		Usage: "routes-check [-a/--app appname] [--fix]",
+		Desc: `Compares the routes of apps in their routers with their started units.
+
+Lists the routes that point to no started unit and the started units without a
+route. With --fix, the missing routes are added and the stale ones are removed.`,
+	}
+}
+
+func (c *routesCheckCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	method, path := "GET", "/docker/routes"
+	if c.fix {
+		method, path = "POST", "/docker/routes/fix"
+	}
+	if c.appName != "" {
+		path += "?app=" + url.QueryEscape(c.appName)
+	}
+	u, err := cmd.GetURL(path)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var result []appRoutes
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		fmt.Fprintln(context.Stdout, "The routes of the apps match their units.")
+		return nil
+	}
+	t := cmd.Table{Headers: cmd.Row([]string{"App", "Missing routes", "Stale routes", "Status"}), LineSeparator: true}
+	for _, routes := range result {
+		status := "not fixed"
+		if routes.Fixed {
+			status = "fixed"
+		}
+		if routes.Error != "" {
+			status = "error: " + routes.Error
+		}
+		t.AddRow(cmd.Row([]string{
+			routes.App,
+			strings.Join(routes.Missing, "\n"),
+			strings.Join(routes.Stale, "\n"),
+			status,
+		}))
+	}
+	context.Stdout.Write(t.Bytes())
+	return nil
+}
+
+func (c *routesCheckCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("routes-check", gnuflag.ContinueOnError)
+		c.fs.StringVar(&c.appName, "app", "", "Check only the routes of the given app")
+		c.fs.StringVar(&c.appName, "a", "", "Check only the routes of the given app")
+		c.fs.BoolVar(&c.fix, "fix", false, "Fix the routes that don't match the units")
+	}
+	return c.fs
+}
+
 type moveContainerCmd struct{}
 
 func (c *moveContainerCmd) Info() *cmd.Info {
@@ -311,10 +390,21 @@ func (c *sshToContainerCmd) getContainer(ctx *cmd.Context, client *cmd.Client) (
 		if err != nil {
 			return "", err
 		}
-		if len(app.Units) < 1 {
+		units := app.Units[provision.WebProcessName]
+		if len(units) == 0 {
+			processes := make([]string, 0, len(app.Units))
+			for process := range app.Units {
+				processes = append(processes, process)
+			}
+			sort.Strings(processes)
+			for _, process := range processes {
+				units = append(units, app.Units[process]...)
+			}
+		}
+		if len(units) < 1 {
 			return "", errors.New("app must have at least one container")
 		}
-		return app.Units[0].Name, nil
+		return units[0].Name, nil
 	}
 	return "", errors.New("you need to specify either the container id or the app name")
 }
@@ -323,6 +413,7 @@ type unit struct {
 	Name string
 }
 
+// apiApp is an app returned by the API, with its units grouped by process.
 type apiApp struct {
-	Units []unit
+	Units map[string][]unit
 }
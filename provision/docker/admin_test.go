@@ -231,6 +231,58 @@ func (s *S) TestFixContainersCmdInfo(c *gocheck.C) {
 	c.Assert(*info, gocheck.DeepEquals, expected)
 }
 
+func (s *S) TestRoutesCheckCmdInfo(c *gocheck.C) {
+	info := (&routesCheckCmd{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "routes-check")
+	c.Assert(info.Usage, gocheck.Equals, "routes-check [-a/--app appname] [--fix]")
+}
+
+func (s *S) TestRoutesCheckCmdRun(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Stdout: &buf, Stderr: &buf}
+	result := `[{"App":"myapp","Missing":["http://10.0.0.2:49154"],"Stale":["http://10.0.0.9:49999"]},{"App":"other","Error":"the app is locked, skipped"}]`
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/docker/routes" && req.Method == "GET"
+		},
+	}
+	manager := cmd.NewManager("admin", "0.1", "admin-ver", &buf, &buf, nil, nil)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := routesCheckCmd{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `+-------+-----------------------+-----------------------+-----------------------------------+
+| App   | Missing routes        | Stale routes          | Status                            |
++-------+-----------------------+-----------------------+-----------------------------------+
+| myapp | http://10.0.0.2:49154 | http://10.0.0.9:49999 | not fixed                         |
++-------+-----------------------+-----------------------+-----------------------------------+
+| other |                       |                       | error: the app is locked, skipped |
++-------+-----------------------+-----------------------+-----------------------------------+
+`
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestRoutesCheckCmdRunFix(c *gocheck.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Stdout: &buf, Stderr: &buf}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/docker/routes/fix" && req.Method == "POST" &&
+				req.URL.Query().Get("app") == "myapp"
+		},
+	}
+	manager := cmd.NewManager("admin", "0.1", "admin-ver", &buf, &buf, nil, nil)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := routesCheckCmd{}
+	err := command.Flags().Parse(true, []string{"--app", "myapp", "--fix"})
+	c.Assert(err, gocheck.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "The routes of the apps match their units.\n")
+}
+
 func (s *S) TestSSHToContainerCmdInfo(c *gocheck.C) {
 	expected := cmd.Info{
 		Name:    "ssh",
@@ -281,7 +333,7 @@ func (s *S) TestSSHToContainerCmdRunWithApp(c *gocheck.C) {
 	var closeClientConn func()
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/apps/myapp" && r.Method == "GET" && r.Header.Get("Authorization") == "bearer abc123" {
-			app := apiApp{Units: []unit{{Name: "abc123f0"}, {Name: "abc123f1"}}}
+			app := apiApp{Units: map[string][]unit{"web": {{Name: "abc123f0"}, {Name: "abc123f1"}}}}
 			json.NewEncoder(w).Encode(app)
 		} else if r.URL.Path == "/docker/ssh/abc123f0" && r.Method == "GET" && r.Header.Get("Authorization") == "bearer abc123" {
 			conn, _, err := w.(http.Hijacker).Hijack()
@@ -353,7 +405,7 @@ func (s *S) TestSSHToContainerAppWithNoUnits(c *gocheck.C) {
 	guesser := testing.FakeGuesser{Name: "myapp"}
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/apps/myapp" && r.Method == "GET" && r.Header.Get("Authorization") == "bearer abc123" {
-			w.Write([]byte(`{"Units":[]}`))
+			w.Write([]byte(`{"Units":{}}`))
 		} else {
 			http.Error(w, "not found", http.StatusNotFound)
 		}
@@ -0,0 +1,468 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const canariesCollection = "docker_canaries"
+
+const (
+	canaryStarting  = "starting"
+	canaryRunning   = "running"
+	canaryPromoting = "promoting"
+	canaryPromoted  = "promoted"
+	canaryAborting  = "aborting"
+	canaryAborted   = "aborted"
+)
+
+// canaryTransitionTimeout is how long a canary may stay in the starting,
+// promoting or aborting states. Canaries left in them for longer, like when
+// the API is restarted in the middle of an operation, are recovered.
+const canaryTransitionTimeout = time.Hour
+
+var errCanaryBusy = errors.New("the canary of the app is being started, promoted or aborted, try again later")
+
+// appCanary stores the state of the last canary of an app. A canary goes from
+// starting to running, and then to promoted, through promoting, or to
+// aborted, through aborting. A failed promotion or abortion takes the canary
+// back to running, and a failed start removes it. StateChangedAt is the time
+// of the last change of state, used to recover canaries left in the starting,
+// promoting and aborting states.
+type appCanary struct {
+	AppName        string `bson:"_id"`
+	Image          string
+	Units          []string
+	Weight         int
+	State          string
+	StartedAt      time.Time
+	StateChangedAt time.Time
+}
+
+func (c *appCanary) inProgress() bool {
+	return c.State != canaryPromoted && c.State != canaryAborted
+}
+
+// stale returns whether the canary was left in the starting, promoting or
+// aborting states for longer than canaryTransitionTimeout.
+func (c *appCanary) stale(now time.Time) bool {
+	if c.State != canaryStarting && c.State != canaryPromoting && c.State != canaryAborting {
+		return false
+	}
+	changedAt := c.StateChangedAt
+	if changedAt.IsZero() {
+		changedAt = c.StartedAt
+	}
+	return now.Sub(changedAt) > canaryTransitionTimeout
+}
+
+func canariesColl() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(canariesCollection), nil
+}
+
+// getCanary returns the last canary of the app, or nil when the app never had
+// a canary. A stale canary is recovered first: a start that didn't finish is
+// handled as aborted, and a promotion or abortion that didn't finish takes
+// the canary back to running, so it can be promoted or aborted again.
+func getCanary(appName string) (*appCanary, error) {
+	coll, err := canariesColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var canary appCanary
+	err = coll.FindId(appName).One(&canary)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !canary.stale(now) {
+		return &canary, nil
+	}
+	to := canaryRunning
+	if canary.State == canaryStarting {
+		to = canaryAborted
+	}
+	log.Errorf("Recovering the canary of the app %q, left in the %s state since %s.", appName, canary.State, canary.StateChangedAt)
+	query := bson.M{"_id": appName, "state": canary.State, "statechangedat": canary.StateChangedAt}
+	if canary.StateChangedAt.IsZero() {
+		// Canaries stored before StateChangedAt was added don't have it.
+		query["statechangedat"] = bson.M{"$in": []interface{}{nil, canary.StateChangedAt}}
+	}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"state": to, "statechangedat": now}}, ReturnNew: true}
+	_, err = coll.Find(query).Apply(change, &canary)
+	if err == mgo.ErrNotFound {
+		// Another API instance changed the canary in the meantime.
+		err = coll.FindId(appName).One(&canary)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &canary, nil
+}
+
+func canaryInProgress(appName string) (bool, error) {
+	canary, err := getCanary(appName)
+	if err != nil || canary == nil {
+		return false, err
+	}
+	return canary.inProgress(), nil
+}
+
+// runningCanary returns the canary of the app, failing when it's not running.
+func runningCanary(appName string) (*appCanary, error) {
+	canary, err := getCanary(appName)
+	if err != nil {
+		return nil, err
+	}
+	if canary == nil || !canary.inProgress() {
+		return nil, provision.ErrNoCanaryInProgress
+	}
+	if canary.State != canaryRunning {
+		return nil, errCanaryBusy
+	}
+	return canary, nil
+}
+
+// claimCanary stores a new canary for the app, in the starting state. It
+// fails with provision.ErrCanaryInProgress when the last canary of the app
+// was neither promoted nor aborted.
+func claimCanary(appName string) error {
+	if _, err := getCanary(appName); err != nil {
+		return err
+	}
+	coll, err := canariesColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	query := bson.M{"_id": appName, "state": bson.M{"$in": []string{canaryPromoted, canaryAborted}}}
+	now := time.Now()
+	canary := appCanary{AppName: appName, State: canaryStarting, StartedAt: now, StateChangedAt: now}
+	_, err = coll.Upsert(query, canary)
+	if mgo.IsDup(err) {
+		return provision.ErrCanaryInProgress
+	}
+	return err
+}
+
+// setCanaryState moves the canary of the app from one state to another,
+// returning the canary.
+func setCanaryState(appName, from, to string) (*appCanary, error) {
+	if _, err := getCanary(appName); err != nil {
+		return nil, err
+	}
+	coll, err := canariesColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var canary appCanary
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"state": to, "statechangedat": time.Now()}}, ReturnNew: true}
+	_, err = coll.Find(bson.M{"_id": appName, "state": from}).Apply(change, &canary)
+	if err == mgo.ErrNotFound {
+		_, err = runningCanary(appName)
+		if err == nil {
+			err = errCanaryBusy
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &canary, nil
+}
+
+func updateCanary(appName string, update bson.M) error {
+	coll, err := canariesColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.UpdateId(appName, bson.M{"$set": update})
+}
+
+func removeStartingCanary(appName string) {
+	coll, err := canariesColl()
+	if err != nil {
+		log.Errorf("Failed to remove the canary of the app %q: %s", appName, err)
+		return
+	}
+	defer coll.Close()
+	err = coll.Remove(bson.M{"_id": appName, "state": canaryStarting})
+	if err != nil {
+		log.Errorf("Failed to remove the canary of the app %q: %s", appName, err)
+	}
+}
+
+func restoreCanaryState(appName, from string) {
+	if _, err := setCanaryState(appName, from, canaryRunning); err != nil {
+		log.Errorf("Failed to restore the canary of the app %q: %s", appName, err)
+	}
+}
+
+// canaryContainers splits the containers of the app in the units of the
+// canary and the other units.
+func canaryContainers(appName string, canary *appCanary) ([]container, []container, error) {
+	containers, err := listContainersByApp(appName)
+	if err != nil {
+		return nil, nil, err
+	}
+	units := make(map[string]bool, len(canary.Units))
+	for _, id := range canary.Units {
+		units[id] = true
+	}
+	var canaryUnits, others []container
+	for _, c := range containers {
+		if units[c.ID] {
+			canaryUnits = append(canaryUnits, c)
+		} else {
+			others = append(others, c)
+		}
+	}
+	return canaryUnits, others, nil
+}
+
+// canaryImage returns the image that the units of the canary run. An image
+// that is not in the history of images of the app is pulled into the
+// repository of the app, like in ImageDeploy.
+func canaryImage(a provision.App, image string, w io.Writer) (string, error) {
+	images, err := listAppImages(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	for _, img := range images {
+		if img == image {
+			return image, nil
+		}
+	}
+	return imageDeploy(a, image, w)
+}
+
+// runStartCanaryPipeline starts the given number of web units running the
+// given image, adds their routes and sends them the given percentage of the
+// traffic of the app.
+func runStartCanaryPipeline(w io.Writer, a provision.App, imageId string, units, weight int) ([]container, error) {
+	args := changeUnitsPipelineArgs{
+		app:     a,
+		toAdd:   map[string]int{provision.WebProcessName: units},
+		imageID: imageId,
+		weight:  weight,
+		writer:  w,
+	}
+	pipeline := action.NewPipeline(
+		&provisionAddUnitsToHost,
+		&addNewRoutes,
+		&setCanaryRoutesWeight,
+	)
+	err := pipeline.Execute(args)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.Result().([]container), nil
+}
+
+// StartCanary starts the given number of web units running the image next to
+// the current units of the app, and sends the given percentage of the traffic
+// of the app to them. The image may be in the history of images of the app or
+// be an image built elsewhere.
+func (p *dockerProvisioner) StartCanary(a provision.App, image string, units, weight int, w io.Writer) error {
+	if units < 1 {
+		return errors.New("the canary must have at least one unit")
+	}
+	if weight < 1 || weight > 99 {
+		return router.ErrInvalidWeight
+	}
+	length, err := getContainerCountForAppName(a.GetName())
+	if err != nil {
+		return err
+	}
+	if length < 1 {
+		return errors.New("Canaries can only be started after the first deployment")
+	}
+	_, err = getWeightedRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = claimCanary(a.GetName())
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "\n---- Starting canary of image %s ----\n", image)
+	imageId, err := canaryImage(a, image, w)
+	var containers []container
+	if err == nil {
+		containers, err = runStartCanaryPipeline(w, a, imageId, units, weight)
+	}
+	if err != nil {
+		removeStartingCanary(a.GetName())
+		return err
+	}
+	ids := make([]string, len(containers))
+	for i, c := range containers {
+		ids[i] = c.ID
+	}
+	return updateCanary(a.GetName(), bson.M{
+		"image":          imageId,
+		"units":          ids,
+		"weight":         weight,
+		"state":          canaryRunning,
+		"statechangedat": time.Now(),
+	})
+}
+
+// SetCanaryWeight changes the percentage of the traffic of the app sent to
+// the units of the running canary.
+func (p *dockerProvisioner) SetCanaryWeight(a provision.App, weight int) error {
+	if weight < 1 || weight > 99 {
+		return router.ErrInvalidWeight
+	}
+	canary, err := runningCanary(a.GetName())
+	if err != nil {
+		return err
+	}
+	units, _, err := canaryContainers(a.GetName(), canary)
+	if err != nil {
+		return err
+	}
+	var addresses []string
+	for _, c := range routableContainers(units) {
+		addresses = append(addresses, c.getAddress())
+	}
+	r, err := getWeightedRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = r.SetRoutesWeight(a.GetName(), addresses, weight)
+	if err != nil {
+		return err
+	}
+	return updateCanary(a.GetName(), bson.M{"weight": weight})
+}
+
+// PromoteCanary replaces the units that were running before the canary with
+// units running the image of the canary, like a deploy, and then removes the
+// units of the canary.
+func (p *dockerProvisioner) PromoteCanary(a provision.App, w io.Writer) (string, error) {
+	canary, err := setCanaryState(a.GetName(), canaryRunning, canaryPromoting)
+	if err != nil {
+		return "", err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "\n---- Promoting canary of image %s ----\n", canary.Image)
+	units, others, err := canaryContainers(a.GetName(), canary)
+	if err == nil {
+		err = p.deployContainers(a, canary.Image, others, w, nil)
+	}
+	if err == nil {
+		err = runRemoveUnitsPipeline(w, a, units)
+	}
+	if err == nil {
+		err = resetRoutesWeight(a)
+	}
+	if err != nil {
+		restoreCanaryState(a.GetName(), canaryPromoting)
+		return "", err
+	}
+	_, err = setCanaryState(a.GetName(), canaryPromoting, canaryPromoted)
+	if err != nil {
+		return "", err
+	}
+	return canary.Image, nil
+}
+
+// AbortCanary removes the units of the canary, sending all the traffic of the
+// app back to the units that were running before the canary.
+func (p *dockerProvisioner) AbortCanary(a provision.App, w io.Writer) error {
+	canary, err := setCanaryState(a.GetName(), canaryRunning, canaryAborting)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "\n---- Aborting canary of image %s ----\n", canary.Image)
+	units, _, err := canaryContainers(a.GetName(), canary)
+	if err == nil {
+		err = runRemoveUnitsPipeline(w, a, units)
+	}
+	if err == nil {
+		err = resetRoutesWeight(a)
+	}
+	if err != nil {
+		restoreCanaryState(a.GetName(), canaryAborting)
+		return err
+	}
+	removeUnusedCanaryImage(a.GetName(), canary.Image)
+	_, err = setCanaryState(a.GetName(), canaryAborting, canaryAborted)
+	return err
+}
+
+func resetRoutesWeight(a provision.App) error {
+	r, err := getWeightedRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	return r.ResetRoutesWeight(a.GetName())
+}
+
+// getWeightedRouterForApp returns the router of the app, or
+// router.ErrWeightNotSupported when it can't split the traffic of the app by
+// weight.
+func getWeightedRouterForApp(a provision.App) (router.WeightedRouter, error) {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return nil, err
+	}
+	weightedRouter, ok := r.(router.WeightedRouter)
+	if !ok {
+		return nil, router.ErrWeightNotSupported
+	}
+	return weightedRouter, nil
+}
+
+// removeUnusedCanaryImage removes the image of an aborted canary when it's
+// not in the history of images of the app.
+func removeUnusedCanaryImage(appName, image string) {
+	images, err := listAppImages(appName)
+	if err != nil {
+		log.Errorf("Failed to list the images of the app %q: %s", appName, err)
+		return
+	}
+	for _, img := range images {
+		if img == image {
+			return
+		}
+	}
+	if err := removeImage(image); err != nil {
+		log.Errorf("Failed to remove the image %q: %s", image, err)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	"github.com/tsuru/tsuru/testing"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) startCanaryApp(c *gocheck.C) (provision.App, []container) {
+	err := newImage("tsuru/app-myapp", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	appInstance := testing.NewFakeApp("myapp", "python", 0)
+	var p dockerProvisioner
+	err = p.Provision(appInstance)
+	c.Assert(err, gocheck.IsNil)
+	containers, err := addContainersWithHost(nil, appInstance, 1, "web")
+	c.Assert(err, gocheck.IsNil)
+	err = appendAppImageName(appInstance.GetName(), "tsuru/app-myapp")
+	c.Assert(err, gocheck.IsNil)
+	return appInstance, containers
+}
+
+func (s *S) TestClaimCanary(c *gocheck.C) {
+	err := claimCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	canary, err := getCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryStarting)
+	err = claimCanary("myapp")
+	c.Assert(err, gocheck.Equals, provision.ErrCanaryInProgress)
+	err = updateCanary("myapp", bson.M{"state": canaryAborted})
+	c.Assert(err, gocheck.IsNil)
+	err = claimCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestSetCanaryState(c *gocheck.C) {
+	_, err := setCanaryState("myapp", canaryRunning, canaryPromoting)
+	c.Assert(err, gocheck.Equals, provision.ErrNoCanaryInProgress)
+	err = claimCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	_, err = setCanaryState("myapp", canaryRunning, canaryPromoting)
+	c.Assert(err, gocheck.Equals, errCanaryBusy)
+	err = updateCanary("myapp", bson.M{"state": canaryRunning, "image": "tsuru/app-myapp:v2"})
+	c.Assert(err, gocheck.IsNil)
+	canary, err := setCanaryState("myapp", canaryRunning, canaryPromoting)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryPromoting)
+	c.Assert(canary.Image, gocheck.Equals, "tsuru/app-myapp:v2")
+}
+
+func (s *S) TestClaimCanaryStaleStart(c *gocheck.C) {
+	err := claimCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = updateCanary("myapp", bson.M{"statechangedat": time.Now().Add(-canaryTransitionTimeout - time.Minute)})
+	c.Assert(err, gocheck.IsNil)
+	inProgress, err := canaryInProgress("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(inProgress, gocheck.Equals, false)
+	canary, err := getCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryAborted)
+	err = claimCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestSetCanaryStateStalePromotion(c *gocheck.C) {
+	err := claimCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = updateCanary("myapp", bson.M{"state": canaryPromoting, "statechangedat": time.Now().Add(-canaryTransitionTimeout / 2)})
+	c.Assert(err, gocheck.IsNil)
+	_, err = setCanaryState("myapp", canaryRunning, canaryAborting)
+	c.Assert(err, gocheck.Equals, errCanaryBusy)
+	err = updateCanary("myapp", bson.M{"statechangedat": time.Now().Add(-canaryTransitionTimeout - time.Minute)})
+	c.Assert(err, gocheck.IsNil)
+	canary, err := setCanaryState("myapp", canaryRunning, canaryAborting)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryAborting)
+}
+
+func (s *S) TestSetCanaryStateStaleWithoutStateChange(c *gocheck.C) {
+	err := claimCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = updateCanary("myapp", bson.M{
+		"state":          canaryAborting,
+		"startedat":      time.Now().Add(-canaryTransitionTimeout - time.Minute),
+		"statechangedat": time.Time{},
+	})
+	c.Assert(err, gocheck.IsNil)
+	canary, err := getCanary("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryRunning)
+}
+
+func (s *S) TestStartCanary(c *gocheck.C) {
+	appInstance, old := s.startCanaryApp(c)
+	var p dockerProvisioner
+	defer p.Destroy(appInstance)
+	var buf bytes.Buffer
+	err := p.StartCanary(appInstance, "tsuru/app-myapp", 2, 10, &buf)
+	c.Assert(err, gocheck.IsNil)
+	canary, err := getCanary(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryRunning)
+	c.Assert(canary.Image, gocheck.Equals, "tsuru/app-myapp")
+	c.Assert(canary.Weight, gocheck.Equals, 10)
+	c.Assert(canary.Units, gocheck.HasLen, 2)
+	units, others, err := canaryContainers(appInstance.GetName(), canary)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 2)
+	c.Assert(others, gocheck.HasLen, 1)
+	c.Assert(others[0].ID, gocheck.Equals, old[0].ID)
+	addresses, weight := rtesting.FakeRouter.RoutesWeight(appInstance.GetName())
+	c.Assert(weight, gocheck.Equals, 10)
+	c.Assert(addresses, gocheck.DeepEquals, []string{units[0].getAddress(), units[1].getAddress()})
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*---- Sending 10% of the traffic to the canary ----.*")
+	err = p.StartCanary(appInstance, "tsuru/app-myapp", 1, 10, &buf)
+	c.Assert(err, gocheck.Equals, provision.ErrCanaryInProgress)
+	err = p.deploy(appInstance, "tsuru/app-myapp", &buf, nil)
+	c.Assert(err, gocheck.Equals, provision.ErrCanaryInProgress)
+}
+
+func (s *S) TestStartCanaryInvalidWeight(c *gocheck.C) {
+	appInstance := testing.NewFakeApp("myapp", "python", 0)
+	var p dockerProvisioner
+	err := p.StartCanary(appInstance, "tsuru/app-myapp", 1, 100, nil)
+	c.Assert(err, gocheck.Equals, router.ErrInvalidWeight)
+}
+
+func (s *S) TestStartCanaryBeforeDeploy(c *gocheck.C) {
+	appInstance := testing.NewFakeApp("myapp", "python", 0)
+	var p dockerProvisioner
+	err := p.StartCanary(appInstance, "tsuru/app-myapp", 1, 10, nil)
+	c.Assert(err, gocheck.ErrorMatches, "Canaries can only be started after the first deployment")
+	canary, err := getCanary(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary, gocheck.IsNil)
+}
+
+func (s *S) TestSetCanaryWeight(c *gocheck.C) {
+	appInstance, _ := s.startCanaryApp(c)
+	var p dockerProvisioner
+	defer p.Destroy(appInstance)
+	err := p.SetCanaryWeight(appInstance, 30)
+	c.Assert(err, gocheck.Equals, provision.ErrNoCanaryInProgress)
+	err = p.StartCanary(appInstance, "tsuru/app-myapp", 1, 10, nil)
+	c.Assert(err, gocheck.IsNil)
+	err = p.SetCanaryWeight(appInstance, 30)
+	c.Assert(err, gocheck.IsNil)
+	_, weight := rtesting.FakeRouter.RoutesWeight(appInstance.GetName())
+	c.Assert(weight, gocheck.Equals, 30)
+	canary, err := getCanary(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.Weight, gocheck.Equals, 30)
+}
+
+func (s *S) TestPromoteCanary(c *gocheck.C) {
+	appInstance, old := s.startCanaryApp(c)
+	var p dockerProvisioner
+	defer p.Destroy(appInstance)
+	err := p.StartCanary(appInstance, "tsuru/app-myapp", 2, 10, nil)
+	c.Assert(err, gocheck.IsNil)
+	canary, err := getCanary(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	image, err := p.PromoteCanary(appInstance, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-myapp")
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*---- Promoting canary of image tsuru/app-myapp ----.*")
+	containers, err := listContainersByApp(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 1)
+	c.Assert(containers[0].ID, gocheck.Not(gocheck.Equals), old[0].ID)
+	for _, id := range canary.Units {
+		c.Assert(containers[0].ID, gocheck.Not(gocheck.Equals), id)
+	}
+	addresses, weight := rtesting.FakeRouter.RoutesWeight(appInstance.GetName())
+	c.Assert(addresses, gocheck.IsNil)
+	c.Assert(weight, gocheck.Equals, 0)
+	canary, err = getCanary(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryPromoted)
+	err = p.deploy(appInstance, "tsuru/app-myapp", &buf, nil)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestPromoteCanaryWithoutCanary(c *gocheck.C) {
+	appInstance := testing.NewFakeApp("myapp", "python", 0)
+	var p dockerProvisioner
+	_, err := p.PromoteCanary(appInstance, nil)
+	c.Assert(err, gocheck.Equals, provision.ErrNoCanaryInProgress)
+}
+
+func (s *S) TestAbortCanary(c *gocheck.C) {
+	appInstance, old := s.startCanaryApp(c)
+	var p dockerProvisioner
+	defer p.Destroy(appInstance)
+	err := p.StartCanary(appInstance, "tsuru/app-myapp", 2, 10, nil)
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	err = p.AbortCanary(appInstance, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*---- Aborting canary of image tsuru/app-myapp ----.*")
+	containers, err := listContainersByApp(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 1)
+	c.Assert(containers[0].ID, gocheck.Equals, old[0].ID)
+	c.Assert(rtesting.FakeRouter.HasRoute(appInstance.GetName(), old[0].getAddress()), gocheck.Equals, true)
+	_, weight := rtesting.FakeRouter.RoutesWeight(appInstance.GetName())
+	c.Assert(weight, gocheck.Equals, 0)
+	canary, err := getCanary(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(canary.State, gocheck.Equals, canaryAborted)
+	err = p.AbortCanary(appInstance, &buf)
+	c.Assert(err, gocheck.Equals, provision.ErrNoCanaryInProgress)
+}
@@ -98,7 +98,7 @@ func (s *S) TestHealerHealNode(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 1, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 1, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -333,7 +333,7 @@ func (s *S) TestHealerHealNodeDestroyError(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 1, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 1, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -427,7 +427,7 @@ func (s *S) TestHealContainer(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 1, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 1, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -484,7 +484,7 @@ func (s *S) TestRunContainerHealer(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 2, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 2, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -559,7 +559,7 @@ func (s *S) TestRunContainerHealerConcurrency(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 2, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 2, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -639,7 +639,7 @@ func (s *S) TestRunContainerHealerAlreadyHealed(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 2, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 2, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -710,7 +710,7 @@ func (s *S) TestRunContainerHealerDoesntHealWithProcfileInTop(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	cont, err := addContainersWithHost(nil, appInstance, 1, "127.0.0.1")
+	cont, err := addContainersWithHost(nil, appInstance, 1, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -757,7 +757,7 @@ func (s *S) TestRunContainerHealerWithError(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 2, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 2, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -870,7 +870,7 @@ func (s *S) TestHealerHandleError(c *gocheck.C) {
 	var p dockerProvisioner
 	defer p.Destroy(appInstance)
 	p.Provision(appInstance)
-	_, err = addContainersWithHost(nil, appInstance, 1, "127.0.0.1")
+	_, err = addContainersWithHost(nil, appInstance, 1, "web", "127.0.0.1")
 	c.Assert(err, gocheck.IsNil)
 
 	conn, err := db.Conn()
@@ -50,12 +50,17 @@ func deployCmds(app provision.App, params ...string) ([]string, error) {
 }
 
 // runWithAgentCmds returns the list of commands that should be passed when the
-// provisioner will run a unit using tsuru_unit_agent to start.
-func runWithAgentCmds(app provision.App, publicKey []byte) ([]string, error) {
+// provisioner will run a unit using tsuru_unit_agent to start. When the app
+// declares the given process in its Procfile, the name of the process is
+// passed to the run command, so the unit runs only that process.
+func runWithAgentCmds(app provision.App, publicKey []byte, processName string) ([]string, error) {
 	runCmd, err := config.GetString("docker:run-cmd:bin")
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := appProcesses(app)[processName]; ok {
+		runCmd += " " + processName
+	}
 	ssh, err := sshCmds(publicKey)
 	if err != nil {
 		return nil, err
@@ -85,3 +90,13 @@ func sshCmds(publicKey []byte) ([]string, error) {
 		sshdCommand + " -D",
 	}, nil
 }
+
+// hookCmds returns the list of commands used to run the given hook commands
+// in a container of the app. The commands run in the directory of the
+// application, and the first failing command interrupts the hook.
+func hookCmds(commands []string) []string {
+	source := "[ -f /home/application/apprc ] && source /home/application/apprc"
+	cd := "[ -d /home/application/current ] && cd /home/application/current"
+	cmd := fmt.Sprintf("%s; %s; %s", source, cd, strings.Join(commands, " && "))
+	return []string{"/bin/bash", "-lc", cmd}
+}
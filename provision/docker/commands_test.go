@@ -10,8 +10,11 @@ import (
 	"strings"
 
 	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/testing"
+	"gopkg.in/mgo.v2/bson"
 	"launchpad.net/gocheck"
 )
 
@@ -121,11 +124,28 @@ func (s *S) TestRunWithAgentCmds(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	cmd := fmt.Sprintf("%s && %s", unitAgentCmd, sshCmd)
 	expected := []string{"/bin/bash", "-lc", cmd}
-	cmds, err := runWithAgentCmds(app, key)
+	cmds, err := runWithAgentCmds(app, key, "web")
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(cmds, gocheck.DeepEquals, expected)
 }
 
+func (s *S) TestRunWithAgentCmdsProcess(c *gocheck.C) {
+	conn, err := db.Conn()
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Close()
+	processes := map[string]string{"web": "python app.py", "worker": "python worker.py"}
+	err = conn.Apps().Insert(app.App{Name: "app-name", Processes: processes})
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Apps().Remove(bson.M{"name": "app-name"})
+	a := testing.NewFakeApp("app-name", "python", 1)
+	runCmd, err := config.GetString("docker:run-cmd:bin")
+	c.Assert(err, gocheck.IsNil)
+	cmds, err := runWithAgentCmds(a, []byte("key-content"), "worker")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cmds, gocheck.HasLen, 3)
+	c.Assert(cmds[2], gocheck.Matches, fmt.Sprintf("tsuru_unit_agent .* app-name %s worker && .*", runCmd))
+}
+
 func (s *S) TestSSHCmds(c *gocheck.C) {
 	addKeyCommand, err := config.GetString("docker:ssh:add-key-cmd")
 	c.Assert(err, gocheck.IsNil)
@@ -155,3 +175,12 @@ func (s *S) TestSSHCmdsMissingAddKeyCommand(c *gocheck.C) {
 	c.Assert(commands, gocheck.IsNil)
 	c.Assert(err, gocheck.NotNil)
 }
+
+func (s *S) TestHookCmds(c *gocheck.C) {
+	cmds := hookCmds([]string{"python manage.py migrate", "python manage.py collectstatic"})
+	expected := []string{
+		"/bin/bash", "-lc",
+		"[ -f /home/application/apprc ] && source /home/application/apprc; [ -d /home/application/current ] && cd /home/application/current; python manage.py migrate && python manage.py collectstatic",
+	}
+	c.Assert(cmds, gocheck.DeepEquals, expected)
+}
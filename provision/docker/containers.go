@@ -11,8 +11,12 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/tsuru/config"
 	"github.com/tsuru/docker-cluster/cluster"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app"
@@ -88,7 +92,49 @@ func handleMoveErrors(moveErrors chan error, encoder *json.Encoder) error {
 	return nil
 }
 
+// unitsByProcess returns the number of containers running each process.
+func unitsByProcess(containers []container) map[string]int {
+	result := make(map[string]int)
+	for _, c := range containers {
+		name := c.ProcessName
+		if name == "" {
+			name = provision.WebProcessName
+		}
+		result[name]++
+	}
+	return result
+}
+
+func sortedProcesses(units map[string]int) []string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// routableContainers filters the given containers, returning only the ones
+// that should be added to the router of the app.
+func routableContainers(containers []container) []container {
+	result := make([]container, 0, len(containers))
+	for _, c := range containers {
+		if c.routable() {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// runReplaceUnitsPipeline replaces the given containers with new ones,
+// running the same processes.
 func runReplaceUnitsPipeline(w io.Writer, a provision.App, toRemoveContainers []container, toHosts ...string) ([]container, error) {
+	return runReplaceUnitsByProcessPipeline(w, a, toRemoveContainers, unitsByProcess(toRemoveContainers), toHosts...)
+}
+
+// runReplaceUnitsByProcessPipeline replaces the given containers with new
+// ones, adding the given number of units of each process.
+func runReplaceUnitsByProcessPipeline(w io.Writer, a provision.App, toRemoveContainers []container, toAdd map[string]int, toHosts ...string) ([]container, error) {
 	var toHost string
 	if len(toHosts) > 0 {
 		toHost = toHosts[0]
@@ -97,11 +143,11 @@ func runReplaceUnitsPipeline(w io.Writer, a provision.App, toRemoveContainers []
 		w = ioutil.Discard
 	}
 	args := changeUnitsPipelineArgs{
-		app:        a,
-		toRemove:   toRemoveContainers,
-		unitsToAdd: len(toRemoveContainers),
-		toHost:     toHost,
-		writer:     w,
+		app:      a,
+		toRemove: toRemoveContainers,
+		toAdd:    toAdd,
+		toHost:   toHost,
+		writer:   w,
 	}
 	pipeline := action.NewPipeline(
 		&provisionAddUnitsToHost,
@@ -113,21 +159,242 @@ func runReplaceUnitsPipeline(w io.Writer, a provision.App, toRemoveContainers []
 	if err != nil {
 		return nil, err
 	}
-	err = removeImage(assembleImageName(a.GetName(), a.GetPlatform()))
+	return pipeline.Result().([]container), nil
+}
+
+// runRemoveUnitsPipeline removes the routes of the given containers and
+// then removes them.
+func runRemoveUnitsPipeline(w io.Writer, a provision.App, toRemoveContainers []container) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	args := changeUnitsPipelineArgs{
+		app:      a,
+		toRemove: toRemoveContainers,
+		writer:   w,
+	}
+	pipeline := action.NewPipeline(
+		&removeOldRoutes,
+		&provisionRemoveOldUnits,
+	)
+	return pipeline.Execute(args)
+}
+
+// rollingBatch describes one step of a rolling update: the number of old
+// units removed before the new units are started, the number of new units
+// and the number of old units removed after the new ones pass the
+// healthcheck and get their routes.
+type rollingBatch struct {
+	removeBefore int
+	add          int
+	removeAfter  int
+}
+
+// rollingBatches splits the replacement of old units by new units in
+// batches, so that at most maxSurge units are running above the number of new
+// units and at most maxUnavailable units are out of the router at any time.
+func rollingBatches(oldUnits, newUnits, maxSurge, maxUnavailable int) []rollingBatch {
+	if maxSurge+maxUnavailable < 1 {
+		maxSurge = 1
+	}
+	step := maxSurge + maxUnavailable
+	var batches []rollingBatch
+	for oldUnits > 0 || newUnits > 0 {
+		var b rollingBatch
+		b.add = min(step, newUnits)
+		remove := min(step, oldUnits)
+		if b.add == newUnits {
+			remove = oldUnits
+		}
+		b.removeBefore = min(maxUnavailable, remove)
+		b.removeAfter = remove - b.removeBefore
+		oldUnits -= remove
+		newUnits -= b.add
+		batches = append(batches, b)
+	}
+	return batches
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// rollingUpdateSetting is a rolling update setting, either a number of units
+// or, when percent is set, a percentage of the units of the process.
+type rollingUpdateSetting struct {
+	value   int
+	percent bool
+}
+
+// units returns the number of units the setting stands for in a process with
+// the given number of units.
+func (s *rollingUpdateSetting) units(n int, roundUp bool) int {
+	if !s.percent {
+		return s.value
+	}
+	units := float64(n*s.value) / 100
+	if roundUp {
+		return int(math.Ceil(units))
+	}
+	return int(units)
+}
+
+// rollingUpdateValue reads the given rolling update setting. It returns nil
+// when the setting isn't defined, and an error when it isn't a number of units
+// or a percentage, or when it's below 0.
+func rollingUpdateValue(key string) (*rollingUpdateSetting, error) {
+	value, err := config.Get(key)
 	if err != nil {
-		log.Debugf("Ignored error removing old images: %s", err.Error())
+		return nil, nil
 	}
-	return pipeline.Result().([]container), nil
+	var setting rollingUpdateSetting
+	switch v := value.(type) {
+	case int:
+		setting.value = v
+	case string:
+		setting.percent = strings.HasSuffix(v, "%")
+		setting.value, err = strconv.Atoi(strings.TrimSuffix(v, "%"))
+	default:
+		err = errors.New("not a number")
+	}
+	if err != nil || setting.value < 0 {
+		return nil, fmt.Errorf("Invalid value for %q: %v. It must be a number of units or a percentage, like 25%%, not below 0.", key, value)
+	}
+	return &setting, nil
+}
+
+// rollingUpdateParams returns the max surge and max unavailable units for
+// replacing the given number of units in a rolling update. The third return
+// value is false when none of them is configured, meaning that all units
+// should be replaced at once. Invalid settings, including both of them being
+// 0, return an error.
+func rollingUpdateParams(units int) (int, int, bool, error) {
+	surge, err := rollingUpdateValue("docker:deploy:max-surge")
+	if err != nil {
+		return 0, 0, false, err
+	}
+	unavailable, err := rollingUpdateValue("docker:deploy:max-unavailable")
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if surge == nil && unavailable == nil {
+		return 0, 0, false, nil
+	}
+	var maxSurge, maxUnavailable int
+	if surge != nil {
+		maxSurge = surge.units(units, true)
+	}
+	if unavailable != nil {
+		maxUnavailable = unavailable.units(units, false)
+	}
+	if (surge == nil || surge.value == 0) && (unavailable == nil || unavailable.value == 0) {
+		return 0, 0, false, errors.New(`Invalid rolling update settings: "docker:deploy:max-surge" and "docker:deploy:max-unavailable" can't both be 0.`)
+	}
+	return maxSurge, maxUnavailable, true, nil
+}
+
+// runRollingReplaceUnitsPipeline replaces the given containers with new ones
+// in batches, process by process. Each batch runs the replace units pipeline,
+// so the new units must pass the healthcheck before getting routes and before
+// the old units of the batch are removed. The rollout stops in the first
+// failing batch, or before any batch when the given deploy is canceled,
+// returning the new containers that were kept so far and the number of old
+// units of each process removed, so the caller is able to restore them.
+func runRollingReplaceUnitsPipeline(w io.Writer, a provision.App, toRemoveContainers []container, toAdd map[string]int, dep *appDeploy) ([]container, map[string]int, error) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	oldByProcess := make(map[string][]container)
+	processes := make(map[string]int)
+	for _, c := range toRemoveContainers {
+		name := c.ProcessName
+		if name == "" {
+			name = provision.WebProcessName
+		}
+		oldByProcess[name] = append(oldByProcess[name], c)
+		processes[name]++
+	}
+	for process, n := range toAdd {
+		processes[process] += n
+	}
+	var added []container
+	removed := make(map[string]int)
+	for _, process := range sortedProcesses(processes) {
+		old := oldByProcess[process]
+		surge, unavailable, _, err := rollingUpdateParams(toAdd[process])
+		if err != nil {
+			return added, removed, err
+		}
+		batches := rollingBatches(len(old), toAdd[process], surge, unavailable)
+		for i, b := range batches {
+			if err := dep.checkCanceled(w); err != nil {
+				return added, removed, err
+			}
+			fmt.Fprintf(w, "\n---- Rolling update [%s]: batch %d/%d ----\n", process, i+1, len(batches))
+			if b.removeBefore > 0 {
+				err := runRemoveUnitsPipeline(w, a, old[:b.removeBefore])
+				if err != nil {
+					return added, removed, err
+				}
+				removed[process] += b.removeBefore
+				old = old[b.removeBefore:]
+			}
+			if b.add == 0 {
+				err := runRemoveUnitsPipeline(w, a, old[:b.removeAfter])
+				if err != nil {
+					return added, removed, err
+				}
+			} else {
+				newContainers, err := runReplaceUnitsByProcessPipeline(w, a, old[:b.removeAfter], map[string]int{process: b.add})
+				if err != nil {
+					return added, removed, err
+				}
+				added = append(added, newContainers...)
+			}
+			removed[process] += b.removeAfter
+			old = old[b.removeAfter:]
+		}
+	}
+	return added, removed, nil
+}
+
+// restoreRollingUpdate undoes a failed rolling update, replacing the new
+// containers by the given number of units of each process. The image of the
+// app must be restored before calling this function.
+func restoreRollingUpdate(w io.Writer, a provision.App, added []container, removed map[string]int) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	for process, n := range removed {
+		if n == 0 {
+			delete(removed, process)
+		}
+	}
+	fmt.Fprintf(w, "\n---- Rolling update failed, restoring old units ----\n")
+	var err error
+	switch {
+	case len(removed) == 0 && len(added) == 0:
+	case len(removed) == 0:
+		err = runRemoveUnitsPipeline(w, a, added)
+	case len(added) == 0:
+		_, err = runCreateUnitsPipeline(w, a, removed)
+	default:
+		_, err = runReplaceUnitsByProcessPipeline(w, a, added, removed)
+	}
+	return err
 }
 
-func runCreateUnitsPipeline(w io.Writer, a provision.App, toAddCount int) ([]container, error) {
+func runCreateUnitsPipeline(w io.Writer, a provision.App, toAdd map[string]int) ([]container, error) {
 	if w == nil {
 		w = ioutil.Discard
 	}
 	args := changeUnitsPipelineArgs{
-		app:        a,
-		unitsToAdd: toAddCount,
-		writer:     w,
+		app:    a,
+		toAdd:  toAdd,
+		writer: w,
 	}
 	pipeline := action.NewPipeline(
 		&provisionAddUnitsToHost,
@@ -243,17 +510,18 @@ func rebalanceContainers(encoder *json.Encoder, dryRun bool) error {
 	defer coll.Close()
 	fullDocQuery := bson.M{
 		// Could use $$ROOT instead of repeating fields but only in Mongo 2.6+.
-		"_id":      "$_id",
-		"id":       "$id",
-		"name":     "$name",
-		"appname":  "$appname",
-		"type":     "$type",
-		"ip":       "$ip",
-		"image":    "$image",
-		"hostaddr": "$hostaddr",
-		"hostport": "$hostport",
-		"status":   "$status",
-		"version":  "$version",
+		"_id":         "$_id",
+		"id":          "$id",
+		"name":        "$name",
+		"appname":     "$appname",
+		"processname": "$processname",
+		"type":        "$type",
+		"ip":          "$ip",
+		"image":       "$image",
+		"hostaddr":    "$hostaddr",
+		"hostport":    "$hostport",
+		"status":      "$status",
+		"version":     "$version",
 	}
 	appsPipe := coll.Pipe([]bson.M{
 		{"$group": bson.M{"_id": "$appname", "count": bson.M{"$sum": 1}}},
@@ -9,8 +9,11 @@ import (
 	"encoding/json"
 	"strings"
 
+	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/provision"
+	rtesting "github.com/tsuru/tsuru/router/testing"
 	"github.com/tsuru/tsuru/testing"
 	"gopkg.in/mgo.v2/bson"
 	"launchpad.net/gocheck"
@@ -30,7 +33,7 @@ func (s *S) TestMoveContainers(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "container-id", AppName: appInstance.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
-	_, err = addContainersWithHost(nil, appInstance, 2, "localhost")
+	_, err = addContainersWithHost(nil, appInstance, 2, "web", "localhost")
 	c.Assert(err, gocheck.IsNil)
 	conn, err := db.Conn()
 	c.Assert(err, gocheck.IsNil)
@@ -73,7 +76,7 @@ func (s *S) TestMoveContainersUnknownDest(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "container-id", AppName: appInstance.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
-	_, err = addContainersWithHost(nil, appInstance, 2, "localhost")
+	_, err = addContainersWithHost(nil, appInstance, 2, "web", "localhost")
 	c.Assert(err, gocheck.IsNil)
 	conn, err := db.Conn()
 	c.Assert(err, gocheck.IsNil)
@@ -112,7 +115,7 @@ func (s *S) TestMoveContainer(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "container-id", AppName: appInstance.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
-	addedConts, err := addContainersWithHost(nil, appInstance, 2, "localhost")
+	addedConts, err := addContainersWithHost(nil, appInstance, 2, "web", "localhost")
 	c.Assert(err, gocheck.IsNil)
 	conn, err := db.Conn()
 	c.Assert(err, gocheck.IsNil)
@@ -147,7 +150,7 @@ func (s *S) TestRebalanceContainers(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "container-id", AppName: appInstance.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
-	_, err = addContainersWithHost(nil, appInstance, 5, "localhost")
+	_, err = addContainersWithHost(nil, appInstance, 5, "web", "localhost")
 	c.Assert(err, gocheck.IsNil)
 	conn, err := db.Conn()
 	c.Assert(err, gocheck.IsNil)
@@ -168,6 +171,188 @@ func (s *S) TestRebalanceContainers(c *gocheck.C) {
 	c.Assert(len(c2), gocheck.Equals, 2)
 }
 
+func (s *S) TestUnitsByProcess(c *gocheck.C) {
+	containers := []container{
+		{ID: "c1", ProcessName: "web"},
+		{ID: "c2"},
+		{ID: "c3", ProcessName: "worker"},
+	}
+	c.Assert(unitsByProcess(containers), gocheck.DeepEquals, map[string]int{"web": 2, "worker": 1})
+}
+
+func (s *S) TestSortedProcesses(c *gocheck.C) {
+	processes := sortedProcesses(map[string]int{"worker": 1, "clock": 1, "web": 2})
+	c.Assert(processes, gocheck.DeepEquals, []string{"clock", "web", "worker"})
+}
+
+func (s *S) TestRoutableContainers(c *gocheck.C) {
+	containers := []container{
+		{ID: "c1", ProcessName: "web"},
+		{ID: "c2"},
+		{ID: "c3", ProcessName: "worker"},
+	}
+	routable := routableContainers(containers)
+	c.Assert(routable, gocheck.HasLen, 2)
+	c.Assert(routable[0].ID, gocheck.Equals, "c1")
+	c.Assert(routable[1].ID, gocheck.Equals, "c2")
+}
+
+func (s *S) TestRollingBatches(c *gocheck.C) {
+	var tests = []struct {
+		old, new, surge, unavailable int
+		expected                     []rollingBatch
+	}{
+		{4, 4, 1, 0, []rollingBatch{{0, 1, 1}, {0, 1, 1}, {0, 1, 1}, {0, 1, 1}}},
+		{4, 4, 2, 0, []rollingBatch{{0, 2, 2}, {0, 2, 2}}},
+		{4, 4, 1, 1, []rollingBatch{{1, 2, 1}, {1, 2, 1}}},
+		{3, 3, 0, 2, []rollingBatch{{2, 2, 0}, {1, 1, 0}}},
+		{3, 3, 0, 0, []rollingBatch{{0, 1, 1}, {0, 1, 1}, {0, 1, 1}}},
+		{2, 4, 1, 0, []rollingBatch{{0, 1, 1}, {0, 1, 1}, {0, 1, 0}, {0, 1, 0}}},
+		{4, 2, 1, 0, []rollingBatch{{0, 1, 1}, {0, 1, 3}}},
+		{0, 2, 1, 0, []rollingBatch{{0, 1, 0}, {0, 1, 0}}},
+		{2, 0, 1, 0, []rollingBatch{{0, 0, 2}}},
+		{2, 2, 10, 0, []rollingBatch{{0, 2, 2}}},
+	}
+	for _, t := range tests {
+		c.Check(rollingBatches(t.old, t.new, t.surge, t.unavailable), gocheck.DeepEquals, t.expected)
+	}
+}
+
+func (s *S) TestRollingUpdateParams(c *gocheck.C) {
+	_, _, ok, err := rollingUpdateParams(10)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, false)
+	config.Set("docker:deploy:max-surge", 2)
+	defer config.Unset("docker:deploy:max-surge")
+	surge, unavailable, ok, err := rollingUpdateParams(10)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(surge, gocheck.Equals, 2)
+	c.Assert(unavailable, gocheck.Equals, 0)
+	config.Set("docker:deploy:max-surge", "25%")
+	config.Set("docker:deploy:max-unavailable", "25%")
+	defer config.Unset("docker:deploy:max-unavailable")
+	surge, unavailable, ok, err = rollingUpdateParams(10)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(surge, gocheck.Equals, 3)
+	c.Assert(unavailable, gocheck.Equals, 2)
+	config.Set("docker:deploy:max-surge", "0")
+	config.Set("docker:deploy:max-unavailable", "10%")
+	surge, unavailable, ok, err = rollingUpdateParams(3)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(surge, gocheck.Equals, 0)
+	c.Assert(unavailable, gocheck.Equals, 0)
+}
+
+func (s *S) TestRollingUpdateParamsInvalid(c *gocheck.C) {
+	defer config.Unset("docker:deploy:max-surge")
+	defer config.Unset("docker:deploy:max-unavailable")
+	var tests = []struct {
+		surge, unavailable interface{}
+		msg                string
+	}{
+		{"a lot", 1, `Invalid value for "docker:deploy:max-surge": a lot.*`},
+		{1, -1, `Invalid value for "docker:deploy:max-unavailable": -1.*`},
+		{"-25%", 1, `Invalid value for "docker:deploy:max-surge": -25%.*`},
+		{0, "0%", `.*can't both be 0.`},
+		{"0", 0, `.*can't both be 0.`},
+	}
+	for _, t := range tests {
+		config.Set("docker:deploy:max-surge", t.surge)
+		config.Set("docker:deploy:max-unavailable", t.unavailable)
+		_, _, _, err := rollingUpdateParams(10)
+		c.Check(err, gocheck.ErrorMatches, t.msg)
+	}
+	config.Unset("docker:deploy:max-surge")
+	config.Set("docker:deploy:max-unavailable", 0)
+	_, _, _, err := rollingUpdateParams(10)
+	c.Check(err, gocheck.ErrorMatches, `.*can't both be 0.`)
+}
+
+func (s *S) TestRunRollingReplaceUnitsPipeline(c *gocheck.C) {
+	err := newImage("tsuru/app-myapp", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	appInstance := testing.NewFakeApp("myapp", "python", 0)
+	var p dockerProvisioner
+	defer p.Destroy(appInstance)
+	p.Provision(appInstance)
+	coll := collection()
+	defer coll.Close()
+	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
+	oldContainers, err := addContainersWithHost(nil, appInstance, 3, "web")
+	c.Assert(err, gocheck.IsNil)
+	config.Set("docker:deploy:max-surge", 1)
+	defer config.Unset("docker:deploy:max-surge")
+	var buf bytes.Buffer
+	added, removed, err := runRollingReplaceUnitsPipeline(&buf, appInstance, oldContainers, map[string]int{"web": 3}, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(added, gocheck.HasLen, 3)
+	c.Assert(removed, gocheck.DeepEquals, map[string]int{"web": 3})
+	containers, err := listContainersByApp(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 3)
+	for _, cont := range oldContainers {
+		c.Assert(rtesting.FakeRouter.HasRoute(appInstance.GetName(), cont.getAddress()), gocheck.Equals, false)
+	}
+	for _, cont := range added {
+		c.Assert(rtesting.FakeRouter.HasRoute(appInstance.GetName(), cont.getAddress()), gocheck.Equals, true)
+	}
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*Rolling update \[web\]: batch 1/3.*batch 3/3.*`)
+}
+
+func (s *S) TestRunRollingReplaceUnitsPipelineCanceled(c *gocheck.C) {
+	err := newImage("tsuru/app-myapp", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	appInstance := testing.NewFakeApp("myapp", "python", 0)
+	var p dockerProvisioner
+	defer p.Destroy(appInstance)
+	p.Provision(appInstance)
+	coll := collection()
+	defer coll.Close()
+	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
+	oldContainers, err := addContainersWithHost(nil, appInstance, 2, "web")
+	c.Assert(err, gocheck.IsNil)
+	config.Set("docker:deploy:max-surge", 1)
+	defer config.Unset("docker:deploy:max-surge")
+	dep, err := startAppDeploy(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	defer dep.finish()
+	err = p.CancelDeploy(appInstance)
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	added, removed, err := runRollingReplaceUnitsPipeline(&buf, appInstance, oldContainers, map[string]int{"web": 2}, dep)
+	c.Assert(err, gocheck.Equals, provision.ErrDeployCanceled)
+	c.Assert(added, gocheck.HasLen, 0)
+	c.Assert(removed, gocheck.DeepEquals, map[string]int{})
+	containers, err := listContainersByApp(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 2)
+}
+
+func (s *S) TestRestoreRollingUpdate(c *gocheck.C) {
+	err := newImage("tsuru/app-myapp", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	appInstance := testing.NewFakeApp("myapp", "python", 0)
+	var p dockerProvisioner
+	defer p.Destroy(appInstance)
+	p.Provision(appInstance)
+	coll := collection()
+	defer coll.Close()
+	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
+	added, err := addContainersWithHost(nil, appInstance, 1, "web")
+	c.Assert(err, gocheck.IsNil)
+	err = restoreRollingUpdate(nil, appInstance, added, map[string]int{"web": 2, "worker": 0})
+	c.Assert(err, gocheck.IsNil)
+	containers, err := listContainersByApp(appInstance.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 2)
+	for _, cont := range containers {
+		c.Assert(cont.ID, gocheck.Not(gocheck.Equals), added[0].ID)
+	}
+}
+
 func (s *S) TestAppLocker(c *gocheck.C) {
 	appName := "myapp"
 	conn, err := db.Conn()
@@ -0,0 +1,124 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"io"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const deploysCollection = "docker_deploys"
+
+// appDeploy is the deploy of an app in progress. It's stored in the database,
+// so the deploy can be canceled from any API instance: CancelDeploy sets
+// Canceled, and the deploy checks it between its steps. Container is the
+// container receiving the archive of an upload deploy, which isn't stored
+// with the other containers of the app.
+type appDeploy struct {
+	AppName   string `bson:"_id"`
+	ID        bson.ObjectId
+	Container string
+	Canceled  bool
+	StartedAt time.Time
+}
+
+func deploysColl() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(deploysCollection), nil
+}
+
+// startAppDeploy stores a new deploy in progress for the app. It must be
+// finished when the deploy ends.
+func startAppDeploy(appName string) (*appDeploy, error) {
+	coll, err := deploysColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	d := appDeploy{AppName: appName, ID: bson.NewObjectId(), StartedAt: time.Now()}
+	_, err = coll.UpsertId(appName, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// finish removes the deploy, unless another deploy of the app replaced it.
+func (d *appDeploy) finish() {
+	coll, err := deploysColl()
+	if err != nil {
+		return
+	}
+	defer coll.Close()
+	coll.Remove(bson.M{"_id": d.AppName, "id": d.ID})
+}
+
+func (d *appDeploy) setContainer(id string) error {
+	coll, err := deploysColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	d.Container = id
+	return coll.Update(bson.M{"_id": d.AppName, "id": d.ID}, bson.M{"$set": bson.M{"container": id}})
+}
+
+// canceled checks whether the deploy was canceled. A nil deploy, like the
+// promotion of a canary, can't be canceled.
+func (d *appDeploy) canceled() bool {
+	if d == nil {
+		return false
+	}
+	coll, err := deploysColl()
+	if err != nil {
+		return false
+	}
+	defer coll.Close()
+	n, err := coll.Find(bson.M{"_id": d.AppName, "id": d.ID, "canceled": true}).Count()
+	return err == nil && n > 0
+}
+
+// checkCanceled returns provision.ErrDeployCanceled when the deploy was
+// canceled, writing it to the given writer.
+func (d *appDeploy) checkCanceled(w io.Writer) error {
+	if d.canceled() {
+		return errDeployCanceled(w)
+	}
+	return nil
+}
+
+// cancelAppDeploy marks the deploy in progress of the app as canceled. It
+// returns nil when the app has no deploy in progress.
+func cancelAppDeploy(appName string) (*appDeploy, error) {
+	coll, err := deploysColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	err = coll.UpdateId(appName, bson.M{"$set": bson.M{"canceled": true}})
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d appDeploy
+	err = coll.FindId(appName).One(&d)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -127,6 +127,20 @@ func initDockerCluster() error {
 	if activeMonitoring > 0 {
 		dCluster.StartActiveMonitoring(activeMonitoring * time.Second)
 	}
+	reconcileInterval, _ := config.GetDuration("docker:routes:reconcile-interval")
+	if reconcileInterval > 0 {
+		fixRoutes, _ := config.GetBool("docker:routes:reconcile-fix")
+		go runRoutesReconciler(reconcileInterval*time.Second, fixRoutes)
+	}
+	healthcheckInterval, _ := config.GetDuration("docker:healthcheck:interval")
+	if healthcheckInterval > 0 {
+		maxFailures, _ := config.GetInt("docker:healthcheck:max-failures")
+		if maxFailures <= 0 {
+			maxFailures = 3
+		}
+		checker := unitsHealthchecker{maxFailures: maxFailures}
+		go checker.run(healthcheckInterval * time.Second)
+	}
 	return nil
 }
 
@@ -176,6 +190,7 @@ func hostToNodeAddress(host string) (string, error) {
 type container struct {
 	ID                      string
 	AppName                 string
+	ProcessName             string
 	Type                    string
 	IP                      string
 	HostAddr                string
@@ -190,6 +205,8 @@ type container struct {
 	LastStatusUpdate        time.Time
 	LastSuccessStatusUpdate time.Time
 	LockedUntil             time.Time
+	HealthcheckFailures     int
+	Unhealthy               bool
 	appCache                provision.App
 }
 
@@ -206,6 +223,13 @@ func (c *container) available() bool {
 		c.Status == provision.StatusStarting.String()
 }
 
+// routable returns true if the container runs the web process of the app.
+// Containers created before the introduction of process types have no
+// process name, and run the web process too.
+func (c *container) routable() bool {
+	return c.ProcessName == "" || c.ProcessName == provision.WebProcessName
+}
+
 func (c *container) getAddress() string {
 	return fmt.Sprintf("http://%s:%s", c.HostAddr, c.HostPort)
 }
@@ -329,6 +353,13 @@ func (c *container) setStatus(status string, updateDB ...bool) error {
 	return coll.Update(bson.M{"id": c.ID}, bson.M{"$set": updateData})
 }
 
+// deployCanceled checks whether the deploy running in the container was
+// canceled, which is indicated by the stopped status.
+func (c *container) deployCanceled() bool {
+	dbCont, err := getContainer(c.ID)
+	return err == nil && dbCont.Status == provision.StatusStopped.String()
+}
+
 func (c *container) setImage(imageId string) error {
 	c.Image = imageId
 	coll := collection()
@@ -352,6 +383,30 @@ func archiveDeploy(app provision.App, image, archiveURL string, w io.Writer) (st
 	return deploy(app, image, commands, w)
 }
 
+// imageDeploy pulls the given image, built elsewhere, and commits it as a new
+// image of the app, returning the name of the new image.
+func imageDeploy(app provision.App, image string, w io.Writer) (string, error) {
+	fmt.Fprintf(w, "\n---- Pulling image %s ----\n", image)
+	repository, tag := splitImageName(image)
+	pullOpts := docker.PullImageOptions{Repository: repository, Tag: tag, OutputStream: w}
+	err := dockerCluster().PullImage(pullOpts, docker.AuthConfiguration{})
+	if err != nil {
+		log.Errorf("error on pulling image %s - %s", image, err)
+		return "", err
+	}
+	user, _ := config.GetString("docker:ssh:user")
+	opts := docker.CreateContainerOptions{Config: &docker.Config{Image: image, User: user}}
+	_, cont, err := dockerCluster().CreateContainerSchedulerOpts(opts, app.GetName())
+	if err != nil {
+		log.Errorf("error on creating container from image %s - %s", image, err)
+		return "", err
+	}
+	c := container{ID: cont.ID, AppName: app.GetName()}
+	defer dockerCluster().RemoveContainer(docker.RemoveContainerOptions{ID: c.ID})
+	fmt.Fprintf(w, "\n---- Building application image ----\n")
+	return c.commit(w)
+}
+
 func deploy(app provision.App, imageId string, commands []string, w io.Writer) (string, error) {
 	actions := []*action.Action{
 		&insertEmptyContainerInDB,
@@ -376,7 +431,7 @@ func deploy(app provision.App, imageId string, commands []string, w io.Writer) (
 	return pipeline.Result().(string), nil
 }
 
-func start(app provision.App, imageId string, w io.Writer, destinationHosts ...string) (*container, error) {
+func start(app provision.App, imageId, processName string, w io.Writer, destinationHosts ...string) (*container, error) {
 	keyPair, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
 		return nil, err
@@ -385,7 +440,7 @@ func start(app provision.App, imageId string, w io.Writer, destinationHosts ...s
 	if err != nil {
 		return nil, err
 	}
-	commands, err := runWithAgentCmds(app, publicKey)
+	commands, err := runWithAgentCmds(app, publicKey, processName)
 	if err != nil {
 		return nil, err
 	}
@@ -400,6 +455,7 @@ func start(app provision.App, imageId string, w io.Writer, destinationHosts ...s
 	args := runContainerActionsArgs{
 		app:              app,
 		imageID:          imageId,
+		processName:      processName,
 		commands:         commands,
 		destinationHosts: destinationHosts,
 		privateKey:       privateKey,
@@ -554,28 +610,32 @@ func (c *container) exec(stdout, stderr io.Writer, cmd string, args ...string) e
 }
 
 // commit commits an image in docker based in the container
-// and returns the image repository.
+// and returns the name of the image, tagged with a new version.
 func (c *container) commit(writer io.Writer) (string, error) {
 	log.Debugf("commiting container %s", c.ID)
-	repository := assembleImageName(c.AppName, "")
-	opts := docker.CommitContainerOptions{Container: c.ID, Repository: repository}
+	imageName, err := appNewImageName(c.AppName)
+	if err != nil {
+		return "", err
+	}
+	repository, tag := splitImageName(imageName)
+	opts := docker.CommitContainerOptions{Container: c.ID, Repository: repository, Tag: tag}
 	image, err := dockerCluster().CommitContainer(opts)
 	if err != nil {
 		log.Errorf("Could not commit docker image: %s", err)
 		return "", fmt.Errorf("error in commit container %s: %s", c.ID, err.Error())
 	}
-	imgData, err := dockerCluster().InspectImage(repository)
+	imgData, err := dockerCluster().InspectImage(imageName)
 	imgSize := ""
 	if err == nil {
 		imgSize = fmt.Sprintf("(%.02fMB)", float64(imgData.Size)/1024/1024)
 	}
 	fmt.Fprintf(writer, " ---> Sending image to repository %s\n", imgSize)
 	log.Debugf("image %s generated from container %s", image.ID, c.ID)
-	err = pushImage(repository)
+	err = pushImage(imageName)
 	if err != nil {
-		return "", fmt.Errorf("error in push image %s: %s", repository, err.Error())
+		return "", fmt.Errorf("error in push image %s: %s", imageName, err.Error())
 	}
-	return repository, nil
+	return imageName, nil
 }
 
 // stop stops the container.
@@ -655,11 +715,12 @@ func (c *container) logs(w io.Writer) error {
 
 func (c *container) asUnit(a provision.App) provision.Unit {
 	return provision.Unit{
-		Name:    c.ID,
-		AppName: a.GetName(),
-		Type:    a.GetPlatform(),
-		Ip:      c.HostAddr,
-		Status:  provision.StatusBuilding,
+		Name:        c.ID,
+		AppName:     a.GetName(),
+		ProcessName: c.ProcessName,
+		Type:        a.GetPlatform(),
+		Ip:          c.HostAddr,
+		Status:      provision.StatusBuilding,
 	}
 }
 
@@ -680,11 +741,12 @@ func removeImage(imageId string) error {
 }
 
 // pushImage sends the given image to the registry server defined in the
-// configuration file.
+// configuration file. The name of the image may include a tag.
 func pushImage(name string) error {
 	if _, err := config.GetString("docker:registry"); err == nil {
 		var buf safe.Buffer
-		pushOpts := docker.PushImageOptions{Name: name, OutputStream: &buf}
+		repository, tag := splitImageName(name)
+		pushOpts := docker.PushImageOptions{Name: repository, Tag: tag, OutputStream: &buf}
 		err = dockerCluster().PushImage(pushOpts, docker.AuthConfiguration{})
 		if err != nil {
 			log.Errorf("[docker] Failed to push image %q (%s): %s", name, err, buf.String())
@@ -722,10 +784,11 @@ func usePlatformImage(app provision.App) bool {
 // unitFromContainer returns a unit that represents a container.
 func unitFromContainer(c container) provision.Unit {
 	return provision.Unit{
-		Name:    c.ID,
-		AppName: c.AppName,
-		Type:    c.Type,
-		Status:  provision.Status(c.Status),
-		Ip:      c.HostAddr,
+		Name:        c.ID,
+		AppName:     c.AppName,
+		ProcessName: c.ProcessName,
+		Type:        c.Type,
+		Status:      provision.Status(c.Status),
+		Ip:          c.HostAddr,
 	}
 }
@@ -40,6 +40,15 @@ func (s *S) TestContainerGetAddress(c *gocheck.C) {
 	c.Assert(address, gocheck.Equals, expected)
 }
 
+func (s *S) TestContainerRoutable(c *gocheck.C) {
+	cont := container{ProcessName: "web"}
+	c.Assert(cont.routable(), gocheck.Equals, true)
+	cont.ProcessName = ""
+	c.Assert(cont.routable(), gocheck.Equals, true)
+	cont.ProcessName = "worker"
+	c.Assert(cont.routable(), gocheck.Equals, false)
+}
+
 func (s *S) TestContainerCreate(c *gocheck.C) {
 	app := testing.NewFakeApp("app-name", "brainfuck", 1)
 	app.Memory = 15
@@ -528,7 +537,7 @@ func (s *S) TestContainerCommit(c *gocheck.C) {
 	imageId, err := cont.commit(&buf)
 	c.Assert(err, gocheck.IsNil)
 	repoNamespace, _ := config.GetString("docker:repository-namespace")
-	repository := repoNamespace + "/app-" + cont.AppName
+	repository := repoNamespace + "/app-" + cont.AppName + ":v1"
 	c.Assert(imageId, gocheck.Equals, repository)
 }
 
@@ -542,7 +551,7 @@ func (s *S) TestContainerCommitWithRegistry(c *gocheck.C) {
 	imageId, err := cont.commit(&buf)
 	c.Assert(err, gocheck.IsNil)
 	repoNamespace, _ := config.GetString("docker:repository-namespace")
-	repository := "localhost:3030/" + repoNamespace + "/app-" + cont.AppName
+	repository := "localhost:3030/" + repoNamespace + "/app-" + cont.AppName + ":v1"
 	c.Assert(imageId, gocheck.Equals, repository)
 }
 
@@ -590,14 +599,14 @@ func (s *S) TestGitDeploy(c *gocheck.C) {
 	var buf bytes.Buffer
 	imageId, err := gitDeploy(app, "ff13e", &buf)
 	c.Assert(err, gocheck.IsNil)
-	c.Assert(imageId, gocheck.Equals, "tsuru/app-myapp")
+	c.Assert(imageId, gocheck.Equals, "tsuru/app-myapp:v1")
 	var conts []container
 	coll := collection()
 	defer coll.Close()
 	err = coll.Find(nil).All(&conts)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(conts, gocheck.HasLen, 0)
-	err = dockerCluster().RemoveImage("tsuru/app-myapp")
+	err = dockerCluster().RemoveImage("tsuru/app-myapp:v1")
 	c.Assert(err, gocheck.IsNil)
 }
 
@@ -642,6 +651,19 @@ func (s *S) TestArchiveDeploy(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 }
 
+func (s *S) TestImageDeploy(c *gocheck.C) {
+	app := testing.NewFakeApp("myapp", "python", 1)
+	var buf bytes.Buffer
+	imageId, err := imageDeploy(app, "registry.example.com/myapp:1.0", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(imageId, gocheck.Equals, "tsuru/app-myapp:v1")
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*---- Pulling image registry.example.com/myapp:1.0 ----.*")
+	_, err = dockerCluster().InspectImage("tsuru/app-myapp:v1")
+	c.Assert(err, gocheck.IsNil)
+	err = dockerCluster().RemoveImage("tsuru/app-myapp:v1")
+	c.Assert(err, gocheck.IsNil)
+}
+
 func (s *S) TestStart(c *gocheck.C) {
 	err := newImage("tsuru/python", s.server.URL())
 	c.Assert(err, gocheck.IsNil)
@@ -650,7 +672,7 @@ func (s *S) TestStart(c *gocheck.C) {
 	rtesting.FakeRouter.AddBackend(app.GetName())
 	defer rtesting.FakeRouter.RemoveBackend(app.GetName())
 	var buf bytes.Buffer
-	cont, err := start(app, imageId, &buf)
+	cont, err := start(app, imageId, "web", &buf)
 	c.Assert(err, gocheck.IsNil)
 	defer cont.remove()
 	c.Assert(cont.ID, gocheck.Not(gocheck.Equals), "")
@@ -15,6 +15,7 @@ import (
 	"strings"
 
 	"github.com/tsuru/tsuru/api"
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/errors"
@@ -41,6 +42,8 @@ func init() {
 	api.RegisterHandler("/docker/fix-containers", "POST", api.AdminRequiredHandler(fixContainersHandler))
 	api.RegisterHandler("/docker/ssh/{container_id}", "GET", api.AdminRequiredHandler(sshToContainerHandler))
 	api.RegisterHandler("/docker/healing", "GET", api.AdminRequiredHandler(healingHistoryHandler))
+	api.RegisterHandler("/docker/routes", "GET", api.AdminRequiredHandler(routesHandler))
+	api.RegisterHandler("/docker/routes/fix", "POST", api.AdminRequiredHandler(fixRoutesHandler))
 }
 
 func validateNodeAddress(address string) error {
@@ -347,3 +350,23 @@ func healingHistoryHandler(w http.ResponseWriter, r *http.Request, t auth.Token)
 	}
 	return json.NewEncoder(w).Encode(history)
 }
+
+func reconcileRoutesHandler(w http.ResponseWriter, r *http.Request, fix bool) error {
+	result, err := reconcileRoutes(r.URL.Query().Get("app"), fix)
+	if err == app.ErrAppNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
+func routesHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	return reconcileRoutesHandler(w, r, false)
+}
+
+func fixRoutesHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	return reconcileRoutesHandler(w, r, true)
+}
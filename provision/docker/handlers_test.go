@@ -28,6 +28,7 @@ import (
 	"github.com/tsuru/tsuru/iaas"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/quota"
+	rtesting "github.com/tsuru/tsuru/router/testing"
 	"github.com/tsuru/tsuru/safe"
 	"github.com/tsuru/tsuru/testing"
 	"gopkg.in/mgo.v2"
@@ -500,7 +501,7 @@ func (s *S) TestRebalanceContainersEmptyBodyHandler(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "container-id", AppName: appInstance.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
-	units, err := addContainersWithHost(nil, appInstance, 5, "localhost")
+	units, err := addContainersWithHost(nil, appInstance, 5, "web", "localhost")
 	c.Assert(err, gocheck.IsNil)
 	conn, err := db.Conn()
 	c.Assert(err, gocheck.IsNil)
@@ -551,7 +552,7 @@ func (s *S) TestRebalanceContainersDryBodyHandler(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "container-id", AppName: appInstance.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": appInstance.GetName()})
-	units, err := addContainersWithHost(nil, appInstance, 5, "localhost")
+	units, err := addContainersWithHost(nil, appInstance, 5, "web", "localhost")
 	c.Assert(err, gocheck.IsNil)
 	conn, err := db.Conn()
 	c.Assert(err, gocheck.IsNil)
@@ -859,3 +860,75 @@ func (s *HandlersSuite) TestHealingHistoryHandlerFilterNode(c *gocheck.C) {
 	c.Assert(healings[1].Action, gocheck.Equals, "node-healing")
 	c.Assert(healings[1].ID, gocheck.Equals, evt1.ID)
 }
+
+func (s *HandlersSuite) createRoutesApp(c *gocheck.C) {
+	err := s.conn.Apps().Insert(app.App{Name: "myapp"})
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute("myapp", "http://10.0.0.9:49999")
+	c.Assert(err, gocheck.IsNil)
+	coll := collection()
+	defer coll.Close()
+	err = coll.Insert(container{ID: "c1", AppName: "myapp", ProcessName: "web", HostAddr: "10.0.0.1", HostPort: "49153", Status: provision.StatusStarted.String()})
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *HandlersSuite) TestRoutesHandler(c *gocheck.C) {
+	oldProvisioner := app.Provisioner
+	app.Provisioner = &dockerProvisioner{}
+	defer func() { app.Provisioner = oldProvisioner }()
+	s.createRoutesApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": "myapp"})
+	defer rtesting.FakeRouter.Reset()
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/docker/routes?app=myapp", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var result []appRoutes
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, []appRoutes{{
+		App:     "myapp",
+		Missing: []string{"http://10.0.0.1:49153"},
+		Stale:   []string{"http://10.0.0.9:49999"},
+	}})
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", "http://10.0.0.9:49999"), gocheck.Equals, true)
+}
+
+func (s *HandlersSuite) TestFixRoutesHandler(c *gocheck.C) {
+	oldProvisioner := app.Provisioner
+	app.Provisioner = &dockerProvisioner{}
+	defer func() { app.Provisioner = oldProvisioner }()
+	s.createRoutesApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": "myapp"})
+	defer rtesting.FakeRouter.Reset()
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/routes/fix?app=myapp", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	var result []appRoutes
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 1)
+	c.Assert(result[0].Fixed, gocheck.Equals, true)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", "http://10.0.0.9:49999"), gocheck.Equals, false)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", "http://10.0.0.1:49153"), gocheck.Equals, true)
+}
+
+func (s *HandlersSuite) TestRoutesHandlerAppNotFound(c *gocheck.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/docker/routes?app=unknown", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNotFound)
+}
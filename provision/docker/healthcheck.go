@@ -32,18 +32,26 @@ func clientWithTimeout(timeout time.Duration) *http.Client {
 
 var timeoutHttpClient = clientWithTimeout(5 * time.Second)
 
-func runHealthcheck(cont *container, w io.Writer) error {
-	dbApp, err := app.GetByName(cont.AppName)
-	if err != nil {
-		return nil
-	}
-	hc, ok := dbApp.CustomData["healthcheck"].(map[string]interface{})
+// healthcheckConfig is the healthcheck declared by an app in its
+// CustomData.
+type healthcheckConfig struct {
+	path    string
+	method  string
+	status  int
+	match   string
+	matchRE *regexp.Regexp
+}
+
+// getHealthcheckConfig returns the healthcheck declared by the app, or nil
+// when the app doesn't declare a healthcheck.
+func getHealthcheckConfig(a *app.App) (*healthcheckConfig, error) {
+	hc, ok := a.CustomData["healthcheck"].(map[string]interface{})
 	if !ok {
-		return nil
+		return nil, nil
 	}
 	path, _ := hc["path"].(string)
 	if path == "" {
-		return nil
+		return nil, nil
 	}
 	path = strings.TrimSpace(strings.TrimLeft(path, "/"))
 	method, _ := hc["method"].(string)
@@ -63,14 +71,56 @@ func runHealthcheck(cont *container, w io.Writer) error {
 	if status == 0 && match == "" {
 		status = 200
 	}
-	var matchRE *regexp.Regexp
+	result := healthcheckConfig{path: path, method: method, status: status}
 	if match != "" {
-		match = "(?s)" + match
-		matchRE, err = regexp.Compile(match)
+		result.match = "(?s)" + match
+		var err error
+		result.matchRE, err = regexp.Compile(result.match)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	return &result, nil
+}
+
+// check runs the healthcheck once against the container. The returned bool
+// indicates whether the check may be retried, which is the case when the
+// container couldn't be reached.
+func (hc *healthcheckConfig) check(cont *container) (bool, error) {
+	url := fmt.Sprintf("http://%s:%s/%s", cont.HostAddr, cont.HostPort, hc.path)
+	req, err := http.NewRequest(hc.method, url, nil)
+	if err != nil {
+		return false, err
+	}
+	rsp, err := timeoutHttpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("healthcheck fail(%s): %s", cont.shortID(), err.Error())
+	}
+	defer rsp.Body.Close()
+	if hc.status != 0 && rsp.StatusCode != hc.status {
+		return false, fmt.Errorf("healthcheck fail(%s): wrong status code, expected %d, got: %d", cont.shortID(), hc.status, rsp.StatusCode)
+	}
+	if hc.matchRE != nil {
+		result, err := ioutil.ReadAll(rsp.Body)
+		if err != nil {
+			return false, err
+		}
+		if !hc.matchRE.Match(result) {
+			return false, fmt.Errorf("healthcheck fail(%s): unexpected result, expected %q, got: %s", cont.shortID(), hc.match, string(result))
+		}
+	}
+	return false, nil
+}
+
+func runHealthcheck(cont *container, w io.Writer) error {
+	dbApp, err := app.GetByName(cont.AppName)
+	if err != nil {
+		return nil
+	}
+	hc, err := getHealthcheckConfig(dbApp)
+	if hc == nil {
+		return err
+	}
 	maxWaitTime, _ := config.GetDuration("docker:healthcheck:max-time")
 	if maxWaitTime == 0 {
 		maxWaitTime = 120
@@ -78,38 +128,16 @@ func runHealthcheck(cont *container, w io.Writer) error {
 	maxWaitTime = maxWaitTime * time.Second
 	sleepTime := 3 * time.Second
 	startedTime := time.Now()
-	url := fmt.Sprintf("http://%s:%s/%s", cont.HostAddr, cont.HostPort, path)
 	for {
-		var lastError error = nil
-		req, err := http.NewRequest(method, url, nil)
-		if err != nil {
-			return err
-		}
-		rsp, err := timeoutHttpClient.Do(req)
-		if err != nil {
-			lastError = fmt.Errorf("healthcheck fail(%s): %s", cont.shortID(), err.Error())
-		} else {
-			defer rsp.Body.Close()
-			if status != 0 && rsp.StatusCode != status {
-				return fmt.Errorf("healthcheck fail(%s): wrong status code, expected %d, got: %d", cont.shortID(), status, rsp.StatusCode)
-			} else if matchRE != nil {
-				result, err := ioutil.ReadAll(rsp.Body)
-				if err != nil {
-					return err
-				}
-				if !matchRE.Match(result) {
-					return fmt.Errorf("healthcheck fail(%s): unexpected result, expected %q, got: %s", cont.shortID(), match, string(result))
-				}
-			}
-		}
-		if lastError == nil {
+		retry, err := hc.check(cont)
+		if err == nil {
 			fmt.Fprintf(w, " ---> healthcheck successful(%s)\n", cont.shortID())
 			return nil
 		}
-		if time.Now().Sub(startedTime) > maxWaitTime {
-			return lastError
+		if !retry || time.Now().Sub(startedTime) > maxWaitTime {
+			return err
 		}
-		fmt.Fprintf(w, " ---> %s. Trying again in %ds\n", lastError.Error(), sleepTime/time.Second)
+		fmt.Fprintf(w, " ---> %s. Trying again in %ds\n", err.Error(), sleepTime/time.Second)
 		time.Sleep(sleepTime)
 	}
 }
@@ -0,0 +1,178 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// unitsHealthchecker periodically runs the healthcheck of the apps against
+// their started web units. Units failing maxFailures consecutive checks have
+// their routes removed, and get them back once they pass the check again.
+type unitsHealthchecker struct {
+	maxFailures int
+}
+
+func (h *unitsHealthchecker) run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		h.runOnce()
+	}
+}
+
+func (h *unitsHealthchecker) runOnce() {
+	apps, err := app.List(nil)
+	if err != nil {
+		log.Errorf("Units healthcheck: couldn't list apps: %s", err)
+		return
+	}
+	for i := range apps {
+		err = h.checkApp(&apps[i])
+		if err != nil {
+			log.Errorf("Units healthcheck: failed to check the units of %q: %s", apps[i].Name, err)
+		}
+	}
+}
+
+// checkApp runs the healthcheck against the web units of the app. Apps without
+// a healthcheck and apps handled by other provisioners are ignored.
+func (h *unitsHealthchecker) checkApp(a *app.App) error {
+	prov, err := a.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	if _, ok := prov.(*dockerProvisioner); !ok {
+		return nil
+	}
+	hc, err := getHealthcheckConfig(a)
+	if hc == nil {
+		return err
+	}
+	containers, err := listContainersByApp(a.Name)
+	if err != nil {
+		return err
+	}
+	for _, c := range routableContainers(containers) {
+		if c.HostPort == "" || !(c.available() || c.Unhealthy) {
+			continue
+		}
+		err = h.checkUnit(a, hc, &c)
+		if err != nil {
+			log.Errorf("Units healthcheck: failed to check the unit %s of %q: %s", c.shortID(), a.Name, err)
+		}
+	}
+	return nil
+}
+
+func (h *unitsHealthchecker) checkUnit(a *app.App, hc *healthcheckConfig, c *container) error {
+	_, checkErr := hc.check(c)
+	if checkErr == nil {
+		if c.Unhealthy {
+			return h.recoverUnit(a, c)
+		}
+		if c.HealthcheckFailures > 0 {
+			return c.setHealthcheckFailures(0)
+		}
+		return nil
+	}
+	if c.Unhealthy {
+		if c.Status != provision.StatusError.String() {
+			return c.setStatus(provision.StatusError.String())
+		}
+		return nil
+	}
+	err := c.setHealthcheckFailures(c.HealthcheckFailures + 1)
+	if err != nil {
+		return err
+	}
+	if c.HealthcheckFailures < h.maxFailures {
+		return nil
+	}
+	return h.removeUnit(a, c, checkErr)
+}
+
+// removeUnit removes the route of the unit, marking it as unhealthy. Locked
+// apps are skipped, the unit is removed in a later check.
+func (h *unitsHealthchecker) removeUnit(a *app.App, c *container, checkErr error) error {
+	locked, err := app.AcquireApplicationLock(a.Name, app.InternalAppName, "units-healthcheck")
+	if err != nil || !locked {
+		return err
+	}
+	defer app.ReleaseApplicationLock(a.Name)
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = r.RemoveRoute(a.Name, c.getAddress())
+	if err != nil && err != router.ErrRouteNotFound {
+		return err
+	}
+	failures := c.HealthcheckFailures
+	err = c.setUnhealthy(true)
+	if err != nil {
+		r.AddRoute(a.Name, c.getAddress())
+		return err
+	}
+	err = c.setStatus(provision.StatusError.String())
+	if err != nil {
+		log.Errorf("Units healthcheck: failed to set the status of the unit %s: %s", c.shortID(), err)
+	}
+	a.Log(fmt.Sprintf("unit %s failed %d consecutive healthchecks, its route was removed: %s",
+		c.shortID(), failures, checkErr), "tsuru", "healthcheck")
+	return nil
+}
+
+// recoverUnit adds back the route of an unhealthy unit that passed the
+// healthcheck.
+func (h *unitsHealthchecker) recoverUnit(a *app.App, c *container) error {
+	locked, err := app.AcquireApplicationLock(a.Name, app.InternalAppName, "units-healthcheck")
+	if err != nil || !locked {
+		return err
+	}
+	defer app.ReleaseApplicationLock(a.Name)
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = r.AddRoute(a.Name, c.getAddress())
+	if err != nil {
+		return err
+	}
+	err = c.setUnhealthy(false)
+	if err != nil {
+		r.RemoveRoute(a.Name, c.getAddress())
+		return err
+	}
+	err = c.setStatus(provision.StatusStarted.String())
+	if err != nil {
+		log.Errorf("Units healthcheck: failed to set the status of the unit %s: %s", c.shortID(), err)
+	}
+	a.Log(fmt.Sprintf("unit %s passed the healthcheck, its route was added back", c.shortID()), "tsuru", "healthcheck")
+	return nil
+}
+
+func (c *container) setHealthcheckFailures(failures int) error {
+	c.HealthcheckFailures = failures
+	coll := collection()
+	defer coll.Close()
+	return coll.Update(bson.M{"id": c.ID}, bson.M{"$set": bson.M{"healthcheckfailures": failures}})
+}
+
+// setUnhealthy marks the unit as removed from the router by the units
+// healthchecker, resetting its count of failures.
+func (c *container) setUnhealthy(unhealthy bool) error {
+	c.Unhealthy = unhealthy
+	c.HealthcheckFailures = 0
+	coll := collection()
+	defer coll.Close()
+	return coll.Update(bson.M{"id": c.ID}, bson.M{"$set": bson.M{"unhealthy": unhealthy, "healthcheckfailures": 0}})
+}
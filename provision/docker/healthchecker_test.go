@@ -0,0 +1,159 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+// createHealthcheckApp creates an app with a healthcheck and a started web
+// unit answering in the given server, with its route in the router.
+func (s *S) createHealthcheckApp(c *gocheck.C, server *httptest.Server) (*app.App, *container) {
+	a := app.App{Name: "myapp", CustomData: map[string]interface{}{
+		"healthcheck": map[string]interface{}{
+			"path": "/health",
+		},
+	}}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	url, _ := url.Parse(server.URL)
+	host, port, _ := net.SplitHostPort(url.Host)
+	cont := container{ID: "c1", AppName: a.Name, ProcessName: "web", HostAddr: host, HostPort: port,
+		Status: provision.StatusStarted.String()}
+	coll := collection()
+	defer coll.Close()
+	err = coll.Insert(cont)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, cont.getAddress())
+	c.Assert(err, gocheck.IsNil)
+	return &a, &cont
+}
+
+func (s *S) TestUnitsHealthcheckerRemovesUnhealthyUnits(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	a, cont := s.createHealthcheckApp(c, server)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	defer s.storage.Logs(a.Name).DropCollection()
+	checker := unitsHealthchecker{maxFailures: 2}
+	err := checker.checkApp(a)
+	c.Assert(err, gocheck.IsNil)
+	dbCont, err := getContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.HealthcheckFailures, gocheck.Equals, 1)
+	c.Assert(dbCont.Unhealthy, gocheck.Equals, false)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, cont.getAddress()), gocheck.Equals, true)
+	err = checker.checkApp(a)
+	c.Assert(err, gocheck.IsNil)
+	dbCont, err = getContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.Unhealthy, gocheck.Equals, true)
+	c.Assert(dbCont.HealthcheckFailures, gocheck.Equals, 0)
+	c.Assert(dbCont.Status, gocheck.Equals, provision.StatusError.String())
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, cont.getAddress()), gocheck.Equals, false)
+	var logs []app.Applog
+	err = s.storage.Logs(a.Name).Find(bson.M{"unit": "healthcheck"}).All(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Matches, "unit c1 failed 2 consecutive healthchecks, its route was removed: .*wrong status code.*")
+	c.Assert(logs[0].Unit, gocheck.Equals, "healthcheck")
+}
+
+func (s *S) TestUnitsHealthcheckerRecoversUnits(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	a, cont := s.createHealthcheckApp(c, server)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	defer s.storage.Logs(a.Name).DropCollection()
+	err := rtesting.FakeRouter.RemoveRoute(a.Name, cont.getAddress())
+	c.Assert(err, gocheck.IsNil)
+	err = cont.setUnhealthy(true)
+	c.Assert(err, gocheck.IsNil)
+	err = cont.setStatus(provision.StatusError.String())
+	c.Assert(err, gocheck.IsNil)
+	checker := unitsHealthchecker{maxFailures: 2}
+	err = checker.checkApp(a)
+	c.Assert(err, gocheck.IsNil)
+	dbCont, err := getContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.Unhealthy, gocheck.Equals, false)
+	c.Assert(dbCont.Status, gocheck.Equals, provision.StatusStarted.String())
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, cont.getAddress()), gocheck.Equals, true)
+	var logs []app.Applog
+	err = s.storage.Logs(a.Name).Find(bson.M{"unit": "healthcheck"}).All(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "unit c1 passed the healthcheck, its route was added back")
+}
+
+func (s *S) TestUnitsHealthcheckerResetsFailures(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	a, cont := s.createHealthcheckApp(c, server)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	err := cont.setHealthcheckFailures(1)
+	c.Assert(err, gocheck.IsNil)
+	checker := unitsHealthchecker{maxFailures: 2}
+	err = checker.checkApp(a)
+	c.Assert(err, gocheck.IsNil)
+	dbCont, err := getContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.HealthcheckFailures, gocheck.Equals, 0)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, cont.getAddress()), gocheck.Equals, true)
+}
+
+func (s *S) TestUnitsHealthcheckerSkipsLockedApps(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	a, cont := s.createHealthcheckApp(c, server)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	locked, err := app.AcquireApplicationLock(a.Name, "someone", "/deploy")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(locked, gocheck.Equals, true)
+	checker := unitsHealthchecker{maxFailures: 1}
+	err = checker.checkApp(a)
+	c.Assert(err, gocheck.IsNil)
+	dbCont, err := getContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.Unhealthy, gocheck.Equals, false)
+	c.Assert(dbCont.HealthcheckFailures, gocheck.Equals, 1)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, cont.getAddress()), gocheck.Equals, true)
+}
+
+func (s *S) TestUnitsHealthcheckerIgnoresAppsWithoutHealthcheck(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	a, cont := s.createHealthcheckApp(c, server)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	a.CustomData = nil
+	checker := unitsHealthchecker{maxFailures: 1}
+	err := checker.checkApp(a)
+	c.Assert(err, gocheck.IsNil)
+	dbCont, err := getContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.HealthcheckFailures, gocheck.Equals, 0)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, cont.getAddress()), gocheck.Equals, true)
+}
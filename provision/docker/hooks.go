@@ -0,0 +1,199 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+)
+
+// appHooks holds the deploy hooks declared in the tsuru.yaml of an app.
+type appHooks struct {
+	Build         []string
+	RestartBefore []string
+	RestartAfter  []string
+}
+
+// appDeployHooks returns the hooks declared in the tsuru.yaml of the app. The
+// unit agent reads the file from the built image and sends its content to
+// tsuru, where it's stored in the custom data of the app.
+func appDeployHooks(appName string) appHooks {
+	var hooks appHooks
+	dbApp, err := app.GetByName(appName)
+	if err != nil {
+		return hooks
+	}
+	data, _ := dbApp.CustomData["hooks"].(map[string]interface{})
+	hooks.Build = hookCommands(data["build"])
+	if restart, ok := data["restart"].(map[string]interface{}); ok {
+		hooks.RestartBefore = hookCommands(restart["before"])
+		hooks.RestartAfter = hookCommands(restart["after"])
+	}
+	return hooks
+}
+
+// hookCommands converts the value of a hook in tsuru.yaml, which may be a
+// single command or a list of commands, to a list of commands.
+func hookCommands(value interface{}) []string {
+	var commands []string
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			commands = append(commands, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if cmd, ok := item.(string); ok && cmd != "" {
+				commands = append(commands, cmd)
+			}
+		}
+	}
+	return commands
+}
+
+// runHookContainer runs the given hook commands in a new container, created
+// from the given image, streaming the output of the commands to w. The
+// container is returned so it can be committed, and it's removed when the
+// hook fails.
+func runHookContainer(a provision.App, imageID string, commands []string, w io.Writer, nodes ...string) (*container, error) {
+	user, _ := config.GetString("docker:ssh:user")
+	var env []string
+	for _, e := range a.Envs() {
+		env = append(env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+	config := docker.Config{
+		Image: imageID,
+		Cmd:   hookCmds(commands),
+		User:  user,
+		Env:   env,
+	}
+	opts := docker.CreateContainerOptions{Config: &config}
+	addr, cont, err := dockerCluster().CreateContainerSchedulerOpts(opts, a.GetName(), nodes...)
+	if err != nil {
+		log.Errorf("error on creating hook container for app %s - %s", a.GetName(), err)
+		return nil, err
+	}
+	c := &container{ID: cont.ID, AppName: a.GetName(), HostAddr: urlToHost(addr), User: user}
+	err = dockerCluster().StartContainer(c.ID, nil)
+	if err == nil {
+		err = c.logs(w)
+	}
+	var status int
+	if err == nil {
+		status, err = dockerCluster().WaitContainer(c.ID)
+	}
+	if err == nil && status != 0 {
+		err = fmt.Errorf("hook failed with exit status %d", status)
+	}
+	if err != nil {
+		removeHookContainer(c)
+		return nil, err
+	}
+	return c, nil
+}
+
+func removeHookContainer(c *container) {
+	err := dockerCluster().RemoveContainer(docker.RemoveContainerOptions{ID: c.ID})
+	if err != nil {
+		log.Errorf("Failed to remove hook container %s: %s", c.ID, err)
+	}
+}
+
+// runBuildHooks runs the build hooks of the app on top of the result of the
+// given build container, and returns the container that must be committed as
+// the image of the app. When the app doesn't declare build hooks, the build
+// container itself is returned. Otherwise, the hooks run in a container
+// created from an intermediate image, committed from the build container, and
+// the ID of the image is also returned, so it can be removed after the image
+// of the app is committed.
+func runBuildHooks(c *container, a provision.App, w io.Writer) (*container, string, error) {
+	commands := appDeployHooks(a.GetName()).Build
+	if len(commands) == 0 {
+		return c, "", nil
+	}
+	fmt.Fprintf(w, "\n---- Running build hooks ----\n")
+	node, err := hostToNodeAddress(c.HostAddr)
+	if err != nil {
+		return nil, "", err
+	}
+	image, err := dockerCluster().CommitContainer(docker.CommitContainerOptions{Container: c.ID})
+	if err != nil {
+		log.Errorf("error on commit container %s - %s", c.ID, err)
+		return nil, "", err
+	}
+	hookCont, err := runHookContainer(a, image.ID, commands, w, node)
+	if err != nil {
+		removeHookImage(image.ID)
+		return nil, "", err
+	}
+	return hookCont, image.ID, nil
+}
+
+func removeHookImage(imageID string) {
+	err := removeImage(imageID)
+	if err != nil {
+		log.Errorf("Failed to remove hook image %s: %s", imageID, err)
+	}
+}
+
+// runRestartBeforeHooks runs the restart:before hooks of the app once, in a
+// container created from the given image, before the units of the app are
+// replaced.
+func runRestartBeforeHooks(a provision.App, imageID string, w io.Writer) error {
+	commands := appDeployHooks(a.GetName()).RestartBefore
+	if len(commands) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\n---- Running restart:before hooks ----\n")
+	c, err := runHookContainer(a, imageID, commands, w)
+	if err != nil {
+		return err
+	}
+	removeHookContainer(c)
+	return nil
+}
+
+// runRestartAfterHooks runs the restart:after hooks of the app in the given
+// unit, after it starts.
+func runRestartAfterHooks(c *container, w io.Writer) error {
+	commands := appDeployHooks(c.AppName).RestartAfter
+	if len(commands) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, " ---> Running restart:after hooks in unit %s...\n", c.shortID())
+	execOpts := docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          hookCmds(commands),
+		Container:    c.ID,
+	}
+	exec, err := dockerCluster().CreateExec(execOpts)
+	if err != nil {
+		return err
+	}
+	startOpts := docker.StartExecOptions{
+		OutputStream: w,
+		ErrorStream:  w,
+		RawTerminal:  true,
+	}
+	err = dockerCluster().StartExec(exec.ID, c.ID, startOpts)
+	if err != nil {
+		return err
+	}
+	execData, err := dockerCluster().InspectExec(exec.ID, c.ID)
+	if err != nil {
+		return err
+	}
+	if execData.ExitCode != 0 {
+		return fmt.Errorf("restart:after hook failed in unit %s with exit status %d", c.shortID(), execData.ExitCode)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"bytes"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/testing"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestAppDeployHooks(c *gocheck.C) {
+	a := app.App{Name: "myapp", CustomData: map[string]interface{}{
+		"hooks": map[string]interface{}{
+			"build": []interface{}{"python manage.py collectstatic", "python manage.py compress"},
+			"restart": map[string]interface{}{
+				"before": []interface{}{"python manage.py migrate"},
+				"after":  "python manage.py clear_cache",
+			},
+		},
+	}}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.storage.Apps().RemoveAll(bson.M{"name": a.Name})
+	expected := appHooks{
+		Build:         []string{"python manage.py collectstatic", "python manage.py compress"},
+		RestartBefore: []string{"python manage.py migrate"},
+		RestartAfter:  []string{"python manage.py clear_cache"},
+	}
+	c.Assert(appDeployHooks(a.Name), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAppDeployHooksWithoutHooks(c *gocheck.C) {
+	a := app.App{Name: "myapp"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.storage.Apps().RemoveAll(bson.M{"name": a.Name})
+	c.Assert(appDeployHooks(a.Name), gocheck.DeepEquals, appHooks{})
+	c.Assert(appDeployHooks("unknown"), gocheck.DeepEquals, appHooks{})
+}
+
+func (s *S) TestRunHookContainer(c *gocheck.C) {
+	err := newImage("tsuru/python", "")
+	c.Assert(err, gocheck.IsNil)
+	a := testing.NewFakeApp("myapp", "python", 1)
+	var buf bytes.Buffer
+	cont, err := runHookContainer(a, "tsuru/python", []string{"python manage.py migrate"}, &buf)
+	c.Assert(err, gocheck.IsNil)
+	defer removeHookContainer(cont)
+	c.Assert(cont.AppName, gocheck.Equals, "myapp")
+	dockerContainer, err := dockerCluster().InspectContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dockerContainer.Config.Cmd, gocheck.DeepEquals, hookCmds([]string{"python manage.py migrate"}))
+}
+
+func (s *S) TestRunBuildHooksWithoutHooks(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 1)
+	cont := &container{ID: "build", AppName: a.GetName()}
+	var buf bytes.Buffer
+	result, hookImage, err := runBuildHooks(cont, a, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.Equals, cont)
+	c.Assert(hookImage, gocheck.Equals, "")
+	c.Assert(buf.String(), gocheck.Equals, "")
+}
+
+func (s *S) TestRunRestartBeforeHooks(c *gocheck.C) {
+	err := newImage("tsuru/python", "")
+	c.Assert(err, gocheck.IsNil)
+	dbApp := app.App{Name: "myapp", CustomData: map[string]interface{}{
+		"hooks": map[string]interface{}{
+			"restart": map[string]interface{}{"before": []interface{}{"python manage.py migrate"}},
+		},
+	}}
+	err = s.storage.Apps().Insert(dbApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.storage.Apps().RemoveAll(bson.M{"name": dbApp.Name})
+	client, err := docker.NewClient(s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	before, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, gocheck.IsNil)
+	a := testing.NewFakeApp("myapp", "python", 1)
+	var buf bytes.Buffer
+	err = runRestartBeforeHooks(a, "tsuru/python", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*---- Running restart:before hooks ----.*")
+	after, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(after, gocheck.HasLen, len(before))
+}
+
+func (s *S) TestRunRestartAfterHooksWithoutHooks(c *gocheck.C) {
+	cont := &container{ID: "unit", AppName: "myapp"}
+	var buf bytes.Buffer
+	err := runRestartAfterHooks(cont, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "")
+}
@@ -5,13 +5,194 @@
 package docker
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/config"
 	"github.com/tsuru/docker-cluster/cluster"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
+const (
+	appImagesCollection     = "docker_app_images"
+	defaultImageHistorySize = 10
+)
+
+var errImageNotFound = errors.New("image not found in the history of the app")
+
+// appImages stores the images generated by the deploys of an app. Each deploy
+// generates a new version of the image of the app, and the last images are
+// kept, so the app can be rolled back to them.
+type appImages struct {
+	AppName string `bson:"_id"`
+	Count   int
+	Current string
+	Images  []string
+}
+
+func appImagesColl() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(appImagesCollection), nil
+}
+
+func imageHistorySize() int {
+	size, err := config.GetInt("docker:image-history-size")
+	if err != nil || size < 1 {
+		return defaultImageHistorySize
+	}
+	return size
+}
+
+// splitImageName splits the name of an image in its repository and tag.
+func splitImageName(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	if colon := strings.LastIndex(name, ":"); colon > slash {
+		return name[:colon], name[colon+1:]
+	}
+	return name, ""
+}
+
+func getAppImages(appName string) (*appImages, error) {
+	coll, err := appImagesColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var images appImages
+	err = coll.FindId(appName).One(&images)
+	if err == mgo.ErrNotFound {
+		return &appImages{AppName: appName}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &images, nil
+}
+
+// appNewImageName returns the name of the image that will be generated by the
+// next deploy of the app, tagged with the number of the version.
+func appNewImageName(appName string) (string, error) {
+	coll, err := appImagesColl()
+	if err != nil {
+		return "", err
+	}
+	defer coll.Close()
+	var images appImages
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"count": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	_, err = coll.FindId(appName).Apply(change, &images)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:v%d", assembleImageName(appName, ""), images.Count), nil
+}
+
+// appCurrentImageName returns the image that new units of the app should run.
+// Apps deployed before the versioning of images run the untagged image.
+func appCurrentImageName(appName string) (string, error) {
+	images, err := getAppImages(appName)
+	if err != nil {
+		return "", err
+	}
+	if images.Current == "" {
+		return assembleImageName(appName, ""), nil
+	}
+	return images.Current, nil
+}
+
+// listAppImages returns the images kept in the history of the app, from the
+// oldest to the newest one.
+func listAppImages(appName string) ([]string, error) {
+	images, err := getAppImages(appName)
+	if err != nil {
+		return nil, err
+	}
+	return images.Images, nil
+}
+
+func setAppCurrentImage(appName, image string) error {
+	coll, err := appImagesColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(appName, bson.M{"$set": bson.M{"current": image}})
+	return err
+}
+
+// appendAppImageName adds the given image to the history of the app, in case
+// it's not there yet. The oldest images are removed from the history, and from
+// the cluster, when the history exceeds the configured size.
+func appendAppImageName(appName, image string) error {
+	images, err := getAppImages(appName)
+	if err != nil {
+		return err
+	}
+	for _, img := range images.Images {
+		if img == image {
+			return nil
+		}
+	}
+	images.Images = append(images.Images, image)
+	var removed []string
+	if size := imageHistorySize(); len(images.Images) > size {
+		removed = images.Images[:len(images.Images)-size]
+		images.Images = images.Images[len(images.Images)-size:]
+	}
+	coll, err := appImagesColl()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(appName, bson.M{"$set": bson.M{"images": images.Images}})
+	if err != nil {
+		return err
+	}
+	for _, img := range removed {
+		if img == images.Current {
+			continue
+		}
+		err = removeImage(img)
+		if err != nil {
+			log.Debugf("Ignored error removing old image %q: %s", img, err)
+		}
+	}
+	return nil
+}
+
+// deleteAllAppImageNames removes the history of images of the app, returning
+// the images that were kept in it.
+func deleteAllAppImageNames(appName string) ([]string, error) {
+	images, err := getAppImages(appName)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := appImagesColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(appName)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	return images.Images, nil
+}
+
 func migrateImages() error {
 	registry, _ := config.GetString("docker:registry")
 	if registry != "" {
@@ -48,3 +229,12 @@ func migrateImages() error {
 	}
 	return nil
 }
+
+// migrateProcessNames sets the process of the containers created before the
+// introduction of process types. These containers run the web process.
+func migrateProcessNames() error {
+	return updateContainers(
+		bson.M{"processname": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"processname": provision.WebProcessName}},
+	)
+}
@@ -105,3 +105,101 @@ func (s *S) TestMigrateImagesWithRegistry(c *gocheck.C) {
 	c.Assert(tags1, gocheck.DeepEquals, []string{"localhost:3030/tsuru/app-app1", "localhost:3030/tsuru/app1"})
 	c.Assert(tags2, gocheck.DeepEquals, []string{"localhost:3030/tsuru/app-app2", "localhost:3030/tsuru/app2"})
 }
+
+func (s *S) TestMigrateProcessNames(c *gocheck.C) {
+	coll := collection()
+	defer coll.Close()
+	err := coll.Insert(
+		bson.M{"id": "legacy", "appname": "myapp"},
+		container{ID: "worker", AppName: "myapp", ProcessName: "worker"},
+	)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveAll(bson.M{"appname": "myapp"})
+	err = migrateProcessNames()
+	c.Assert(err, gocheck.IsNil)
+	legacy, err := getContainer("legacy")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(legacy.ProcessName, gocheck.Equals, "web")
+	worker, err := getContainer("worker")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(worker.ProcessName, gocheck.Equals, "worker")
+}
+
+func (s *S) TestSplitImageName(c *gocheck.C) {
+	var tests = []struct {
+		name, repository, tag string
+	}{
+		{"tsuru/app-myapp", "tsuru/app-myapp", ""},
+		{"tsuru/app-myapp:v3", "tsuru/app-myapp", "v3"},
+		{"localhost:3030/tsuru/app-myapp", "localhost:3030/tsuru/app-myapp", ""},
+		{"localhost:3030/tsuru/app-myapp:v3", "localhost:3030/tsuru/app-myapp", "v3"},
+	}
+	for _, t := range tests {
+		repository, tag := splitImageName(t.name)
+		c.Check(repository, gocheck.Equals, t.repository)
+		c.Check(tag, gocheck.Equals, t.tag)
+	}
+}
+
+func (s *S) TestAppNewImageName(c *gocheck.C) {
+	img1, err := appNewImageName("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(img1, gocheck.Equals, "tsuru/app-myapp:v1")
+	img2, err := appNewImageName("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(img2, gocheck.Equals, "tsuru/app-myapp:v2")
+	img3, err := appNewImageName("otherapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(img3, gocheck.Equals, "tsuru/app-otherapp:v1")
+}
+
+func (s *S) TestAppCurrentImageName(c *gocheck.C) {
+	img, err := appCurrentImageName("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(img, gocheck.Equals, "tsuru/app-myapp")
+	err = setAppCurrentImage("myapp", "tsuru/app-myapp:v2")
+	c.Assert(err, gocheck.IsNil)
+	img, err = appCurrentImageName("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(img, gocheck.Equals, "tsuru/app-myapp:v2")
+}
+
+func (s *S) TestAppendAppImageName(c *gocheck.C) {
+	err := appendAppImageName("myapp", "tsuru/app-myapp:v1")
+	c.Assert(err, gocheck.IsNil)
+	err = appendAppImageName("myapp", "tsuru/app-myapp:v2")
+	c.Assert(err, gocheck.IsNil)
+	err = appendAppImageName("myapp", "tsuru/app-myapp:v1")
+	c.Assert(err, gocheck.IsNil)
+	images, err := listAppImages("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(images, gocheck.DeepEquals, []string{"tsuru/app-myapp:v1", "tsuru/app-myapp:v2"})
+}
+
+func (s *S) TestAppendAppImageNameLimitsHistory(c *gocheck.C) {
+	config.Set("docker:image-history-size", 2)
+	defer config.Unset("docker:image-history-size")
+	for _, img := range []string{"tsuru/app-myapp:v1", "tsuru/app-myapp:v2", "tsuru/app-myapp:v3"} {
+		err := appendAppImageName("myapp", img)
+		c.Assert(err, gocheck.IsNil)
+	}
+	images, err := listAppImages("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(images, gocheck.DeepEquals, []string{"tsuru/app-myapp:v2", "tsuru/app-myapp:v3"})
+}
+
+func (s *S) TestDeleteAllAppImageNames(c *gocheck.C) {
+	err := appendAppImageName("myapp", "tsuru/app-myapp:v1")
+	c.Assert(err, gocheck.IsNil)
+	err = setAppCurrentImage("myapp", "tsuru/app-myapp:v1")
+	c.Assert(err, gocheck.IsNil)
+	images, err := deleteAllAppImageNames("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(images, gocheck.DeepEquals, []string{"tsuru/app-myapp:v1"})
+	images, err = listAppImages("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(images, gocheck.HasLen, 0)
+	img, err := appCurrentImageName("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(img, gocheck.Equals, "tsuru/app-myapp")
+}
@@ -0,0 +1,124 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+)
+
+var statsClient = &http.Client{Timeout: 10 * time.Second}
+
+func init() {
+	app.RegisterMetricsSource("docker", dockerStatsSource{})
+}
+
+type cpuStats struct {
+	CPUUsage struct {
+		TotalUsage  uint64   `json:"total_usage"`
+		PercpuUsage []uint64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	SystemCPUUsage uint64 `json:"system_cpu_usage"`
+}
+
+type containerStats struct {
+	CPUStats    cpuStats `json:"cpu_stats"`
+	PreCPUStats cpuStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64 `json:"usage"`
+		Limit uint64 `json:"limit"`
+	} `json:"memory_stats"`
+}
+
+// cpuPercent returns the CPU usage of the container since the previous
+// reading, as a percentage of one CPU.
+func (s *containerStats) cpuPercent() float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemCPUUsage) - float64(s.PreCPUStats.SystemCPUUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * float64(len(s.CPUStats.CPUUsage.PercpuUsage)) * 100
+}
+
+func (s *containerStats) memPercent() float64 {
+	if s.MemoryStats.Limit == 0 {
+		return 0
+	}
+	return float64(s.MemoryStats.Usage) / float64(s.MemoryStats.Limit) * 100
+}
+
+// dockerStatsSource reads the CPU and memory usage of the units of apps from
+// the stats API of the Docker nodes, so the auto scale works for apps that
+// don't send metrics to Graphite. The kinds of metrics are "cpu" and "mem",
+// or "cpu_max" and "mem_max", all of them returning the usage of the busiest
+// unit, as a percentage.
+type dockerStatsSource struct{}
+
+func (dockerStatsSource) Metric(a *app.App, kind string) (float64, error) {
+	var percent func(*containerStats) float64
+	switch strings.TrimSuffix(kind, "_max") {
+	case "cpu":
+		percent = (*containerStats).cpuPercent
+	case "mem":
+		percent = (*containerStats).memPercent
+	default:
+		return 0, fmt.Errorf("unknown metric %q", kind)
+	}
+	containers, err := listContainersByApp(a.Name)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		max   float64
+		found bool
+	)
+	for _, c := range containers {
+		if !c.available() {
+			continue
+		}
+		stats, err := c.stats()
+		if err != nil {
+			log.Errorf("[docker] Failed to get stats of container %s: %s", c.shortID(), err)
+			continue
+		}
+		if value := percent(stats); !found || value > max {
+			max = value
+		}
+		found = true
+	}
+	if !found {
+		return 0, errors.New("there is no metrics")
+	}
+	return max, nil
+}
+
+func (c *container) stats() (*containerStats, error) {
+	node, err := hostToNodeAddress(c.HostAddr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := statsClient.Get(fmt.Sprintf("%s/containers/%s/stats?stream=false", node, c.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid response code: %d", resp.StatusCode)
+	}
+	var stats containerStats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+// statsHandler answers the stats API of Docker with the given CPU and memory
+// usage of each container.
+type statsHandler struct {
+	cpu map[string]uint64
+	mem map[string]uint64
+}
+
+func (h *statsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/stats")
+	cpu, ok := h.cpu[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, `{
+		"cpu_stats": {"cpu_usage": {"total_usage": %d, "percpu_usage": [0, 0]}, "system_cpu_usage": 2000},
+		"precpu_stats": {"cpu_usage": {"total_usage": 0, "percpu_usage": [0, 0]}, "system_cpu_usage": 1000},
+		"memory_stats": {"usage": %d, "limit": 1000}
+	}`, cpu, h.mem[id])
+}
+
+func (s *S) startStatsNode(c *gocheck.C, h *statsHandler) *httptest.Server {
+	server := httptest.NewServer(h)
+	var err error
+	dCluster, err = cluster.New(nil, &cluster.MapStorage{}, cluster.Node{Address: server.URL})
+	c.Assert(err, gocheck.IsNil)
+	return server
+}
+
+func (s *S) TestDockerStatsSourceCPU(c *gocheck.C) {
+	server := s.startStatsNode(c, &statsHandler{
+		cpu: map[string]uint64{"c1": 100, "c2": 250, "c3": 900},
+		mem: map[string]uint64{"c1": 200, "c2": 500, "c3": 900},
+	})
+	defer server.Close()
+	coll := collection()
+	defer coll.Close()
+	started := provision.StatusStarted.String()
+	coll.Insert(
+		container{ID: "c1", AppName: "myapp", HostAddr: "127.0.0.1", Status: started},
+		container{ID: "c2", AppName: "myapp", HostAddr: "127.0.0.1", Status: started},
+		container{ID: "c3", AppName: "myapp", HostAddr: "127.0.0.1", Status: provision.StatusStopped.String()},
+	)
+	defer coll.RemoveAll(bson.M{"appname": "myapp"})
+	a := app.App{Name: "myapp"}
+	var source dockerStatsSource
+	cpu, err := source.Metric(&a, "cpu_max")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cpu, gocheck.Equals, 50.0)
+	mem, err := source.Metric(&a, "mem")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(mem, gocheck.Equals, 50.0)
+}
+
+func (s *S) TestDockerStatsSourceSkipsFailingContainers(c *gocheck.C) {
+	server := s.startStatsNode(c, &statsHandler{
+		cpu: map[string]uint64{"c1": 100},
+		mem: map[string]uint64{"c1": 200},
+	})
+	defer server.Close()
+	coll := collection()
+	defer coll.Close()
+	started := provision.StatusStarted.String()
+	coll.Insert(
+		container{ID: "c1", AppName: "myapp", HostAddr: "127.0.0.1", Status: started},
+		container{ID: "c2", AppName: "myapp", HostAddr: "127.0.0.1", Status: started},
+	)
+	defer coll.RemoveAll(bson.M{"appname": "myapp"})
+	a := app.App{Name: "myapp"}
+	var source dockerStatsSource
+	cpu, err := source.Metric(&a, "cpu")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cpu, gocheck.Equals, 20.0)
+}
+
+func (s *S) TestDockerStatsSourceWithoutUnits(c *gocheck.C) {
+	a := app.App{Name: "myapp"}
+	var source dockerStatsSource
+	_, err := source.Metric(&a, "cpu")
+	c.Assert(err, gocheck.ErrorMatches, "there is no metrics")
+}
+
+func (s *S) TestDockerStatsSourceUnknownMetric(c *gocheck.C) {
+	a := app.App{Name: "myapp"}
+	var source dockerStatsSource
+	_, err := source.Metric(&a, "requests")
+	c.Assert(err, gocheck.ErrorMatches, `unknown metric "requests"`)
+}
+
+func (s *S) TestDockerStatsSourceIsRegistered(c *gocheck.C) {
+	a := app.App{Name: "myapp", AutoScaleConfig: &app.AutoScaleConfig{MetricsSource: "docker"}}
+	_, err := a.Metric("cpu")
+	c.Assert(err, gocheck.ErrorMatches, "there is no metrics")
+}
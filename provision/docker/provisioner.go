@@ -11,6 +11,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/url"
+	"strings"
 	"sync"
 
 	"github.com/fsouza/go-dockerclient"
@@ -23,8 +24,10 @@ import (
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/router"
+	_ "github.com/tsuru/tsuru/router/file"
 	_ "github.com/tsuru/tsuru/router/galeb"
 	_ "github.com/tsuru/tsuru/router/hipache"
+	_ "github.com/tsuru/tsuru/router/proxy"
 	_ "github.com/tsuru/tsuru/router/testing"
 )
 
@@ -40,6 +43,85 @@ func getRouterForApp(app provision.App) (router.Router, error) {
 	return router.Get(routerName)
 }
 
+// appProcesses returns the processes declared in the Procfile of the app,
+// as recorded in the database during the last deploy.
+func appProcesses(a provision.App) map[string]string {
+	dbApp, err := app.GetByName(a.GetName())
+	if err != nil {
+		return nil
+	}
+	return dbApp.Processes
+}
+
+// procfileMarker is printed before the content of the Procfile read from an
+// image, separating it from other output of the container.
+const procfileMarker = "---- tsuru procfile ----"
+
+// updateAppProcesses records the processes declared in the Procfile of the
+// given image as the processes of the app. An image without a Procfile leaves
+// the app with a single web process.
+func updateAppProcesses(a provision.App, imageID string) error {
+	var buf bytes.Buffer
+	cmd := fmt.Sprintf("echo %q; cat Procfile 2>/dev/null || true", procfileMarker)
+	cont, err := runHookContainer(a, imageID, []string{cmd}, &buf)
+	if err != nil {
+		return err
+	}
+	removeHookContainer(cont)
+	output := buf.String()
+	index := strings.Index(output, procfileMarker+"\n")
+	if index < 0 {
+		log.Errorf("Failed to read the Procfile of the image %s of app %q: %q", imageID, a.GetName(), output)
+		return nil
+	}
+	processes, err := provision.ParseProcfile(output[index+len(procfileMarker)+1:])
+	if err != nil {
+		return err
+	}
+	dbApp, err := app.GetByName(a.GetName())
+	if err != nil {
+		return err
+	}
+	return dbApp.SetProcesses(processes)
+}
+
+// resolveProcess validates the process name given to AddUnits and
+// RemoveUnits against the processes of the app.
+func resolveProcess(a provision.App, process string) (string, error) {
+	return provision.ResolveProcess(process, appProcesses(a))
+}
+
+// listContainersByProcess returns the containers of the given process, or
+// all containers of the app when the process is empty.
+func listContainersByProcess(a provision.App, process string) ([]container, error) {
+	if process == "" {
+		return listContainersByApp(a.GetName())
+	}
+	process, err := resolveProcess(a, process)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := listContainersByApp(a.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return filterByProcess(containers, process), nil
+}
+
+func filterByProcess(containers []container, process string) []container {
+	var result []container
+	for _, c := range containers {
+		name := c.ProcessName
+		if name == "" {
+			name = provision.WebProcessName
+		}
+		if name == process {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
 type dockerProvisioner struct{}
 
 func (p *dockerProvisioner) Initialize() error {
@@ -47,7 +129,11 @@ func (p *dockerProvisioner) Initialize() error {
 	if err != nil {
 		return err
 	}
-	return migrateImages()
+	err = migrateImages()
+	if err != nil {
+		return err
+	}
+	return migrateProcessNames()
 }
 
 // Provision creates a route for the container
@@ -64,8 +150,8 @@ func (p *dockerProvisioner) Provision(app provision.App) error {
 	return r.AddBackend(app.GetName())
 }
 
-func (*dockerProvisioner) Restart(a provision.App, w io.Writer) error {
-	containers, err := listContainersByApp(a.GetName())
+func (*dockerProvisioner) Restart(a provision.App, process string, w io.Writer) error {
+	containers, err := listContainersByProcess(a, process)
 	if err != nil {
 		return err
 	}
@@ -77,9 +163,12 @@ func (*dockerProvisioner) Restart(a provision.App, w io.Writer) error {
 	return err
 }
 
-func (*dockerProvisioner) Start(app provision.App) error {
-	containers, err := listContainersByApp(app.GetName())
+func (*dockerProvisioner) Start(app provision.App, process string) error {
+	containers, err := listContainersByProcess(app, process)
 	if err != nil {
+		if provision.IsProcessError(err) {
+			return err
+		}
 		return errors.New(fmt.Sprintf("Got error while getting app containers: %s", err))
 	}
 	var wg sync.WaitGroup
@@ -104,9 +193,12 @@ func (*dockerProvisioner) Start(app provision.App) error {
 	return <-errCh
 }
 
-func (p *dockerProvisioner) Stop(app provision.App) error {
-	containers, err := listContainersByApp(app.GetName())
+func (p *dockerProvisioner) Stop(app provision.App, process string) error {
+	containers, err := listContainersByProcess(app, process)
 	if err != nil {
+		if provision.IsProcessError(err) {
+			return err
+		}
 		log.Errorf("Got error while getting app containers: %s", err)
 		return nil
 	}
@@ -136,24 +228,67 @@ func (dockerProvisioner) Swap(app1, app2 provision.App) error {
 	return r.Swap(app1.GetName(), app2.GetName())
 }
 
-func (p *dockerProvisioner) GitDeploy(app provision.App, version string, w io.Writer) error {
+func (p *dockerProvisioner) GitDeploy(app provision.App, version string, w io.Writer) (string, error) {
+	dep, err := startAppDeploy(app.GetName())
+	if err != nil {
+		return "", err
+	}
+	defer dep.finish()
 	imageId, err := gitDeploy(app, version, w)
 	if err != nil {
-		return err
+		return "", err
+	}
+	err = p.deploy(app, imageId, w, dep)
+	if err != nil {
+		return "", err
 	}
-	return p.deploy(app, imageId, w)
+	return imageId, nil
 }
 
-func (p *dockerProvisioner) ArchiveDeploy(app provision.App, archiveURL string, w io.Writer) error {
+func (p *dockerProvisioner) ArchiveDeploy(app provision.App, archiveURL string, w io.Writer) (string, error) {
+	dep, err := startAppDeploy(app.GetName())
+	if err != nil {
+		return "", err
+	}
+	defer dep.finish()
 	imageId, err := archiveDeploy(app, getImage(app), archiveURL, w)
 	if err != nil {
-		return err
+		return "", err
+	}
+	err = p.deploy(app, imageId, w, dep)
+	if err != nil {
+		return "", err
+	}
+	return imageId, nil
+}
+
+// ImageDeploy deploys the app using an image built elsewhere. The image is
+// pulled and tagged in the repository of the app, so it's handled like any
+// image generated by a deploy, and can be used in rollbacks.
+func (p *dockerProvisioner) ImageDeploy(app provision.App, image string, w io.Writer) (string, error) {
+	dep, err := startAppDeploy(app.GetName())
+	if err != nil {
+		return "", err
+	}
+	defer dep.finish()
+	imageId, err := imageDeploy(app, image, w)
+	if err != nil {
+		return "", err
+	}
+	err = p.deploy(app, imageId, w, dep)
+	if err != nil {
+		return "", err
 	}
-	return p.deploy(app, imageId, w)
+	return imageId, nil
 }
 
-func (p *dockerProvisioner) UploadDeploy(app provision.App, archiveFile io.ReadCloser, w io.Writer) error {
+func (p *dockerProvisioner) UploadDeploy(app provision.App, archiveFile io.ReadCloser, w io.Writer) (string, error) {
 	defer archiveFile.Close()
+	dep, err := startAppDeploy(app.GetName())
+	if err != nil {
+		return "", err
+	}
+	defer dep.finish()
 	filePath := "/home/application/archive.tar.gz"
 	user, _ := config.GetString("docker:ssh:user")
 	options := docker.CreateContainerOptions{
@@ -171,12 +306,16 @@ func (p *dockerProvisioner) UploadDeploy(app provision.App, archiveFile io.ReadC
 	cluster := dockerCluster()
 	_, container, err := dockerCluster().CreateContainerSchedulerOpts(options, app.GetName())
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer cluster.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+	err = dep.setContainer(container.ID)
+	if err != nil {
+		return "", err
+	}
 	err = cluster.StartContainer(container.ID, nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 	var output bytes.Buffer
 	err = cluster.AttachToContainer(docker.AttachToContainerOptions{
@@ -190,35 +329,184 @@ func (p *dockerProvisioner) UploadDeploy(app provision.App, archiveFile io.ReadC
 		Stderr:       true,
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 	status, err := cluster.WaitContainer(container.ID)
+	if dep.canceled() {
+		return "", errDeployCanceled(w)
+	}
 	if err != nil {
-		return err
+		return "", err
 	}
 	if status != 0 {
 		log.Errorf("Failed to deploy container from upload: %s", &output)
-		return fmt.Errorf("container exited with status %d", status)
+		return "", fmt.Errorf("container exited with status %d", status)
 	}
 	image, err := cluster.CommitContainer(docker.CommitContainerOptions{Container: container.ID})
 	if err != nil {
-		return err
+		return "", err
 	}
 	imageId, err := archiveDeploy(app, image.ID, "file://"+filePath, w)
+	if err != nil {
+		return "", err
+	}
+	err = p.deploy(app, imageId, w, dep)
+	if err != nil {
+		return "", err
+	}
+	return imageId, nil
+}
+
+// Rollback deploys again an image kept in the history of images of the app.
+func (p *dockerProvisioner) Rollback(app provision.App, image string, w io.Writer) error {
+	images, err := listAppImages(app.GetName())
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		if img == image {
+			if w == nil {
+				w = ioutil.Discard
+			}
+			fmt.Fprintf(w, "\n---- Rolling back to image %s ----\n", image)
+			dep, err := startAppDeploy(app.GetName())
+			if err != nil {
+				return err
+			}
+			defer dep.finish()
+			return p.deploy(app, image, w, dep)
+		}
+	}
+	return errImageNotFound
+}
+
+// CancelDeploy cancels the deploy in progress of the app and stops the
+// containers building it. A deploy waiting for the build notices that the
+// containers were stopped and rolls back, removing them. After the build, the
+// deploy checks whether it was canceled between its steps, like before
+// running the restart:before hooks and before each batch of a rolling update,
+// restoring the previous image and units of the app.
+func (p *dockerProvisioner) CancelDeploy(app provision.App) error {
+	dep, err := cancelAppDeploy(app.GetName())
+	if err != nil {
+		return err
+	}
+	containers, err := listBuildingContainersByApp(app.GetName())
 	if err != nil {
 		return err
 	}
-	return p.deploy(app, imageId, w)
+	if dep == nil && len(containers) == 0 {
+		return provision.ErrNoDeployInProgress
+	}
+	if dep != nil && dep.Container != "" {
+		err = dockerCluster().StopContainer(dep.Container, 10)
+		if err != nil {
+			log.Errorf("Failed to stop the container %q: %s", dep.Container, err)
+		}
+	}
+	for _, c := range containers {
+		err = c.setStatus(provision.StatusStopped.String())
+		if err != nil {
+			return err
+		}
+		err = dockerCluster().StopContainer(c.ID, 10)
+		if err != nil {
+			log.Errorf("Failed to stop the container %q: %s", c.ID, err)
+		}
+	}
+	return nil
 }
 
-func (p *dockerProvisioner) deploy(a provision.App, imageId string, w io.Writer) error {
+// deploy replaces the containers of the app with new ones, running the given
+// image. It fails while the app has a canary in progress, and when the given
+// deploy is canceled.
+func (p *dockerProvisioner) deploy(a provision.App, imageId string, w io.Writer, dep *appDeploy) error {
+	inProgress, err := canaryInProgress(a.GetName())
+	if err != nil {
+		return err
+	}
+	if inProgress {
+		return provision.ErrCanaryInProgress
+	}
 	containers, err := listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	return p.deployContainers(a, imageId, containers, w, dep)
+}
+
+// deployContainers replaces the given containers of the app with new ones,
+// running the given image and based on the processes declared in the Procfile
+// of the image, which are recorded in the app. Each process keeps its number
+// of units, and new processes start with one unit. Containers of processes
+// that are no longer declared are removed. When a max surge or max unavailable
+// is configured, the containers are replaced in a rolling update, and the old
+// units are restored if it fails. After a successful deploy, the image is
+// added to the history of images of the app. The restart:before hooks of the
+// app run once, before any unit is replaced, and a failing hook aborts the
+// deploy. The given deploy is checked for cancellation before the hooks,
+// before the units are replaced and between the batches of a rolling update;
+// it may be nil when the replacement can't be canceled.
+func (p *dockerProvisioner) deployContainers(a provision.App, imageId string, containers []container, w io.Writer, dep *appDeploy) error {
+	err := dep.checkCanceled(w)
+	if err != nil {
+		return err
+	}
+	err = updateAppProcesses(a, imageId)
+	if err != nil {
+		return err
+	}
+	current := unitsByProcess(containers)
+	toAdd := make(map[string]int)
+	for _, process := range provision.ProcessNames(appProcesses(a)) {
+		toAdd[process] = current[process]
+		if toAdd[process] == 0 {
+			toAdd[process] = 1
+		}
+	}
+	_, _, rolling, err := rollingUpdateParams(len(containers))
+	if err != nil {
+		return err
+	}
+	previousImage, err := appCurrentImageName(a.GetName())
+	if err != nil {
+		return err
+	}
+	err = runRestartBeforeHooks(a, imageId, w)
+	if err != nil {
+		return err
+	}
+	err = dep.checkCanceled(w)
+	if err != nil {
+		return err
+	}
+	err = setAppCurrentImage(a.GetName(), imageId)
+	if err != nil {
+		return err
+	}
+	var (
+		added   []container
+		removed map[string]int
+	)
+	rolling = rolling && len(containers) > 0
 	if len(containers) == 0 {
-		_, err = runCreateUnitsPipeline(w, a, 1)
+		_, err = runCreateUnitsPipeline(w, a, toAdd)
+	} else if rolling {
+		added, removed, err = runRollingReplaceUnitsPipeline(w, a, containers, toAdd, dep)
 	} else {
-		_, err = runReplaceUnitsPipeline(w, a, containers)
+		_, err = runReplaceUnitsByProcessPipeline(w, a, containers, toAdd)
 	}
-	return err
+	if err != nil {
+		if errRestore := setAppCurrentImage(a.GetName(), previousImage); errRestore != nil {
+			log.Errorf("Failed to restore the image of the app %q: %s", a.GetName(), errRestore)
+		} else if rolling {
+			if errRestore := restoreRollingUpdate(w, a, added, removed); errRestore != nil {
+				log.Errorf("Failed to restore the units of the app %q: %s", a.GetName(), errRestore)
+			}
+		}
+		return err
+	}
+	return appendAppImageName(a.GetName(), imageId)
 }
 
 func (p *dockerProvisioner) Destroy(app provision.App) error {
@@ -246,9 +534,15 @@ func (p *dockerProvisioner) Destroy(app provision.App) error {
 	containersGroup.Wait()
 	cluster := dockerCluster()
 	imageName := assembleImageName(app.GetName(), app.GetPlatform())
-	err = cluster.RemoveImage(imageName)
+	images, err := deleteAllAppImageNames(app.GetName())
 	if err != nil {
-		log.Errorf("Failed to remove image: %s", err.Error())
+		log.Errorf("Failed to remove the history of images: %s", err.Error())
+	}
+	for _, img := range append(images, imageName) {
+		err = cluster.RemoveImage(img)
+		if err != nil {
+			log.Errorf("Failed to remove image: %s", err.Error())
+		}
 	}
 	err = cluster.RemoveFromRegistry(imageName)
 	if err != nil {
@@ -281,14 +575,23 @@ func (*dockerProvisioner) Addr(app provision.App) (string, error) {
 	return addr, nil
 }
 
-func addContainersWithHost(w io.Writer, a provision.App, units int, destinationHost ...string) ([]container, error) {
+func addContainersWithHost(w io.Writer, a provision.App, units int, process string, destinationHost ...string) ([]container, error) {
+	imageId, err := appCurrentImageName(a.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return addContainersWithImage(w, a, imageId, units, process, destinationHost...)
+}
+
+// addContainersWithImage adds units of the given process running the given
+// image, which may not be the current image of the app.
+func addContainersWithImage(w io.Writer, a provision.App, imageId string, units int, process string, destinationHost ...string) ([]container, error) {
 	if units == 0 {
 		return nil, errors.New("Cannot add 0 units")
 	}
 	if w == nil {
 		w = ioutil.Discard
 	}
-	imageId := assembleImageName(a.GetName(), a.GetPlatform())
 	wg := sync.WaitGroup{}
 	createdContainers := make(chan *container, units)
 	errors := make(chan error, units)
@@ -296,12 +599,12 @@ func addContainersWithHost(w io.Writer, a provision.App, units int, destinationH
 	if units > 1 {
 		plural = "s"
 	}
-	fmt.Fprintf(w, "\n---- Starting %d new unit%s ----\n", units, plural)
+	fmt.Fprintf(w, "\n---- Starting %d new unit%s [%s] ----\n", units, plural, process)
 	for i := 0; i < units; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			c, err := start(a, imageId, w, destinationHost...)
+			c, err := start(a, imageId, process, w, destinationHost...)
 			if err != nil {
 				errors <- err
 				return
@@ -313,11 +616,18 @@ func addContainersWithHost(w io.Writer, a provision.App, units int, destinationH
 				return
 			}
 			createdContainers <- c
-			err = runHealthcheck(c, w)
+			err = runRestartAfterHooks(c, w)
 			if err != nil {
 				errors <- err
 				return
 			}
+			if c.routable() {
+				err = runHealthcheck(c, w)
+				if err != nil {
+					errors <- err
+					return
+				}
+			}
 			fmt.Fprintf(w, " ---> Started unit %s...\n", c.shortID())
 		}()
 	}
@@ -348,7 +658,11 @@ func addContainersWithHost(w io.Writer, a provision.App, units int, destinationH
 	return result, nil
 }
 
-func (*dockerProvisioner) AddUnits(a provision.App, units uint, w io.Writer) ([]provision.Unit, error) {
+func (*dockerProvisioner) AddUnits(a provision.App, units uint, process string, w io.Writer) ([]provision.Unit, error) {
+	process, err := resolveProcess(a, process)
+	if err != nil {
+		return nil, err
+	}
 	length, err := getContainerCountForAppName(a.GetName())
 	if err != nil {
 		return nil, err
@@ -360,7 +674,7 @@ func (*dockerProvisioner) AddUnits(a provision.App, units uint, w io.Writer) ([]
 		w = ioutil.Discard
 	}
 	writer := &app.LogWriter{App: a, Writer: w}
-	conts, err := runCreateUnitsPipeline(writer, a, int(units))
+	conts, err := runCreateUnitsPipeline(writer, a, map[string]int{process: int(units)})
 	if err != nil {
 		return nil, err
 	}
@@ -371,20 +685,28 @@ func (*dockerProvisioner) AddUnits(a provision.App, units uint, w io.Writer) ([]
 	return result, nil
 }
 
-func (*dockerProvisioner) RemoveUnits(a provision.App, units uint) error {
+func (*dockerProvisioner) RemoveUnits(a provision.App, units uint, process string) error {
 	if a == nil {
 		return errors.New("remove units: app should not be nil")
 	}
 	if units < 1 {
 		return errors.New("remove units: units must be at least 1")
 	}
-	containers, err := listContainersByAppOrderedByStatus(a.GetName())
+	process, err := resolveProcess(a, process)
 	if err != nil {
 		return err
 	}
-	if units >= uint(len(containers)) {
+	allContainers, err := listContainersByAppOrderedByStatus(a.GetName())
+	if err != nil {
+		return err
+	}
+	if units >= uint(len(allContainers)) {
 		return errors.New("remove units: cannot remove all units from app")
 	}
+	containers := filterByProcess(allContainers, process)
+	if units > uint(len(containers)) {
+		return fmt.Errorf("remove units: process %q has only %d units", process, len(containers))
+	}
 	var wg sync.WaitGroup
 	for i := 0; i < int(units); i++ {
 		wg.Add(1)
@@ -489,6 +811,30 @@ func (p *dockerProvisioner) UnsetCName(app provision.App, cname string) error {
 	return r.UnsetCName(cname, app.GetName())
 }
 
+func (p *dockerProvisioner) SetCertificate(app provision.App, cname, certificate, key string) error {
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return err
+	}
+	tlsRouter, ok := r.(router.TLSRouter)
+	if !ok {
+		return router.ErrTLSNotSupported
+	}
+	return tlsRouter.AddCertificate(cname, certificate, key)
+}
+
+func (p *dockerProvisioner) UnsetCertificate(app provision.App, cname string) error {
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return err
+	}
+	tlsRouter, ok := r.(router.TLSRouter)
+	if !ok {
+		return router.ErrTLSNotSupported
+	}
+	return tlsRouter.RemoveCertificate(cname)
+}
+
 func (p *dockerProvisioner) AdminCommands() []cmd.Command {
 	return []cmd.Command{
 		&moveContainerCmd{},
@@ -505,6 +851,7 @@ func (p *dockerProvisioner) AdminCommands() []cmd.Command {
 		fixContainersCmd{},
 		&sshToContainerCmd{},
 		&listHealingHistoryCmd{},
+		&routesCheckCmd{},
 	}
 }
 
@@ -55,12 +55,12 @@ func (s *S) TestProvisionerRestart(c *gocheck.C) {
 	cont, err := s.newContainer(&newContainerOpts{AppName: app.GetName()})
 	c.Assert(err, gocheck.IsNil)
 	defer s.removeTestContainer(cont)
-	err = p.Start(app)
+	err = p.Start(app, "")
 	c.Assert(err, gocheck.IsNil)
 	dockerContainer, err := dCluster.InspectContainer(cont.ID)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(dockerContainer.State.Running, gocheck.Equals, true)
-	err = p.Restart(app, nil)
+	err = p.Restart(app, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	dbConts, err := listAllContainers()
 	c.Assert(err, gocheck.IsNil)
@@ -162,6 +162,8 @@ func (s *S) TestDeployErasesOldImages(c *gocheck.C) {
 	err = p.Provision(&a)
 	c.Assert(err, gocheck.IsNil)
 	defer p.Destroy(&a)
+	config.Set("docker:image-history-size", 1)
+	defer config.Unset("docker:image-history-size")
 	w := safe.NewBuffer(make([]byte, 2048))
 
 	err = app.Deploy(app.DeployOptions{
@@ -176,7 +178,7 @@ func (s *S) TestDeployErasesOldImages(c *gocheck.C) {
 	c.Assert(imgs, gocheck.HasLen, 2)
 	c.Assert(imgs[0].RepoTags, gocheck.HasLen, 1)
 	c.Assert(imgs[1].RepoTags, gocheck.HasLen, 1)
-	expected := []string{"tsuru/app-appdeployimagetest", "tsuru/python"}
+	expected := []string{"tsuru/app-appdeployimagetest:v1", "tsuru/python"}
 	got := []string{imgs[0].RepoTags[0], imgs[1].RepoTags[0]}
 	sort.Strings(got)
 	c.Assert(got, gocheck.DeepEquals, expected)
@@ -194,9 +196,100 @@ func (s *S) TestDeployErasesOldImages(c *gocheck.C) {
 	c.Assert(imgs[1].RepoTags, gocheck.HasLen, 1)
 	got = []string{imgs[0].RepoTags[0], imgs[1].RepoTags[0]}
 	sort.Strings(got)
+	expected = []string{"tsuru/app-appdeployimagetest:v2", "tsuru/python"}
 	c.Assert(got, gocheck.DeepEquals, expected)
 }
 
+func (s *S) TestDeployKeepsImageHistory(c *gocheck.C) {
+	h := &tsrTesting.TestHandler{}
+	gandalfServer := tsrTesting.StartGandalfTestServer(h)
+	defer gandalfServer.Close()
+	go s.stopContainers(3)
+	err := newImage("tsuru/python", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	p := dockerProvisioner{}
+	a := app.App{
+		Name:     "apphistorytest",
+		Platform: "python",
+	}
+	conn, err := db.Conn()
+	defer conn.Close()
+	err = conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Apps().Remove(bson.M{"name": a.Name})
+	err = p.Provision(&a)
+	c.Assert(err, gocheck.IsNil)
+	defer p.Destroy(&a)
+	w := safe.NewBuffer(make([]byte, 2048))
+	for i := 0; i < 2; i++ {
+		err = app.Deploy(app.DeployOptions{
+			App:          &a,
+			Version:      "master",
+			Commit:       "123",
+			OutputStream: w,
+		})
+		c.Assert(err, gocheck.IsNil)
+	}
+	images, err := listAppImages(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(images, gocheck.DeepEquals, []string{"tsuru/app-apphistorytest:v1", "tsuru/app-apphistorytest:v2"})
+	current, err := appCurrentImageName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(current, gocheck.Equals, "tsuru/app-apphistorytest:v2")
+}
+
+func (s *S) TestRollback(c *gocheck.C) {
+	h := &tsrTesting.TestHandler{}
+	gandalfServer := tsrTesting.StartGandalfTestServer(h)
+	defer gandalfServer.Close()
+	go s.stopContainers(3)
+	err := newImage("tsuru/python", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	p := dockerProvisioner{}
+	a := app.App{
+		Name:     "approllbacktest",
+		Platform: "python",
+	}
+	conn, err := db.Conn()
+	defer conn.Close()
+	err = conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Apps().Remove(bson.M{"name": a.Name})
+	err = p.Provision(&a)
+	c.Assert(err, gocheck.IsNil)
+	defer p.Destroy(&a)
+	w := safe.NewBuffer(make([]byte, 2048))
+	for i := 0; i < 2; i++ {
+		err = app.Deploy(app.DeployOptions{
+			App:          &a,
+			Version:      "master",
+			Commit:       "123",
+			OutputStream: w,
+		})
+		c.Assert(err, gocheck.IsNil)
+	}
+	err = p.Rollback(&a, "tsuru/app-approllbacktest:v1", w)
+	c.Assert(err, gocheck.IsNil)
+	containers, err := listContainersByApp(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 1)
+	c.Assert(containers[0].Image, gocheck.Equals, "tsuru/app-approllbacktest:v1")
+	current, err := appCurrentImageName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(current, gocheck.Equals, "tsuru/app-approllbacktest:v1")
+	images, err := listAppImages(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(images, gocheck.HasLen, 2)
+}
+
+func (s *S) TestRollbackImageNotInHistory(c *gocheck.C) {
+	p := dockerProvisioner{}
+	a := app.App{Name: "approllbacktest", Platform: "python"}
+	w := safe.NewBuffer(make([]byte, 2048))
+	err := p.Rollback(&a, "tsuru/app-approllbacktest:v3", w)
+	c.Assert(err, gocheck.Equals, errImageNotFound)
+}
+
 func (s *S) TestProvisionerUploadDeploy(c *gocheck.C) {
 	h := &tsrTesting.TestHandler{}
 	gandalfServer := tsrTesting.StartGandalfTestServer(h)
@@ -390,7 +483,7 @@ func (s *S) TestProvisionerAddUnits(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "c-89320", AppName: app.GetName(), Version: "a345fe", Image: "tsuru/python"})
 	defer coll.RemoveId(bson.M{"id": "c-89320"})
-	units, err := p.AddUnits(app, 3, nil)
+	units, err := p.AddUnits(app, 3, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	defer coll.RemoveAll(bson.M{"appname": app.GetName()})
 	c.Assert(units, gocheck.HasLen, 3)
@@ -399,6 +492,37 @@ func (s *S) TestProvisionerAddUnits(c *gocheck.C) {
 	c.Assert(count, gocheck.Equals, 4)
 }
 
+func (s *S) TestProvisionerAddUnitsProcess(c *gocheck.C) {
+	err := newImage("tsuru/app-myapp", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Close()
+	processes := map[string]string{"web": "python app.py", "worker": "python worker.py"}
+	err = conn.Apps().Insert(app.App{Name: "myapp", Processes: processes})
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Apps().Remove(bson.M{"name": "myapp"})
+	var p dockerProvisioner
+	a := testing.NewFakeApp("myapp", "python", 0)
+	p.Provision(a)
+	defer p.Destroy(a)
+	coll := collection()
+	defer coll.Close()
+	coll.Insert(container{ID: "c-89320", AppName: a.GetName(), ProcessName: "web", Version: "a345fe", Image: "tsuru/python"})
+	defer coll.RemoveAll(bson.M{"appname": a.GetName()})
+	units, err := p.AddUnits(a, 2, "worker", nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 2)
+	for _, u := range units {
+		c.Assert(u.ProcessName, gocheck.Equals, "worker")
+	}
+	count, err := coll.Find(bson.M{"appname": a.GetName(), "processname": "worker"}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 2)
+	_, err = p.AddUnits(a, 1, "clock", nil)
+	c.Assert(err, gocheck.FitsTypeOf, &provision.InvalidProcessError{})
+}
+
 func (s *S) TestProvisionerAddUnitsWithErrorDoesntLeaveLostUnits(c *gocheck.C) {
 	callCount := 0
 	s.server.CustomHandler("/containers/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -420,7 +544,7 @@ func (s *S) TestProvisionerAddUnitsWithErrorDoesntLeaveLostUnits(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "c-89320", AppName: app.GetName(), Version: "a345fe", Image: "tsuru/python"})
 	defer coll.RemoveId(bson.M{"id": "c-89320"})
-	_, err = p.AddUnits(app, 3, nil)
+	_, err = p.AddUnits(app, 3, "", nil)
 	c.Assert(err, gocheck.NotNil)
 	count, err := coll.Find(bson.M{"appname": app.GetName()}).Count()
 	c.Assert(err, gocheck.IsNil)
@@ -438,7 +562,7 @@ func (s *S) TestProvisionerAddZeroUnits(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "c-89320", AppName: app.GetName(), Version: "a345fe", Image: "tsuru/python"})
 	defer coll.RemoveId(bson.M{"id": "c-89320"})
-	units, err := p.AddUnits(app, 0, nil)
+	units, err := p.AddUnits(app, 0, "", nil)
 	c.Assert(units, gocheck.IsNil)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "Cannot add 0 units")
@@ -449,7 +573,7 @@ func (s *S) TestProvisionerAddUnitsWithoutContainers(c *gocheck.C) {
 	var p dockerProvisioner
 	p.Provision(app)
 	defer p.Destroy(app)
-	units, err := p.AddUnits(app, 1, nil)
+	units, err := p.AddUnits(app, 1, "", nil)
 	c.Assert(units, gocheck.IsNil)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "New units can only be added after the first deployment")
@@ -469,7 +593,7 @@ func (s *S) TestProvisionerAddUnitsWithHost(c *gocheck.C) {
 	defer coll.Close()
 	coll.Insert(container{ID: "xxxfoo", AppName: app.GetName(), Version: "123987", Image: "tsuru/python"})
 	defer coll.RemoveId(bson.M{"id": "xxxfoo"})
-	units, err := addContainersWithHost(nil, app, 1, "localhost")
+	units, err := addContainersWithHost(nil, app, 1, "web", "localhost")
 	c.Assert(err, gocheck.IsNil)
 	defer coll.RemoveAll(bson.M{"appname": app.GetName()})
 	c.Assert(units, gocheck.HasLen, 1)
@@ -505,7 +629,7 @@ func (s *S) TestProvisionerRemoveUnits(c *gocheck.C) {
 	app.BindUnit(&unit2)
 	app.BindUnit(&unit3)
 	var p dockerProvisioner
-	err = p.RemoveUnits(app, 2)
+	err = p.RemoveUnits(app, 2, "")
 	c.Assert(err, gocheck.IsNil)
 	_, err = getContainer(container1.ID)
 	c.Assert(err, gocheck.NotNil)
@@ -524,9 +648,9 @@ func (s *S) TestProvisionerRemoveUnitsPriorityOrder(c *gocheck.C) {
 	defer rtesting.FakeRouter.RemoveBackend(container.AppName)
 	app := testing.NewFakeApp(container.AppName, "python", 0)
 	var p dockerProvisioner
-	_, err = p.AddUnits(app, 3, nil)
+	_, err = p.AddUnits(app, 3, "", nil)
 	c.Assert(err, gocheck.IsNil)
-	err = p.RemoveUnits(app, 1)
+	err = p.RemoveUnits(app, 1, "")
 	c.Assert(err, gocheck.IsNil)
 	_, err = getContainer(container.ID)
 	c.Assert(err, gocheck.NotNil)
@@ -535,14 +659,14 @@ func (s *S) TestProvisionerRemoveUnitsPriorityOrder(c *gocheck.C) {
 
 func (s *S) TestProvisionerRemoveUnitsNotFound(c *gocheck.C) {
 	var p dockerProvisioner
-	err := p.RemoveUnits(nil, 1)
+	err := p.RemoveUnits(nil, 1, "")
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "remove units: app should not be nil")
 }
 
 func (s *S) TestProvisionerRemoveUnitsZeroUnits(c *gocheck.C) {
 	var p dockerProvisioner
-	err := p.RemoveUnits(testing.NewFakeApp("something", "python", 0), 0)
+	err := p.RemoveUnits(testing.NewFakeApp("something", "python", 0), 0, "")
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "remove units: units must be at least 1")
 }
@@ -555,9 +679,9 @@ func (s *S) TestProvisionerRemoveUnitsTooManyUnits(c *gocheck.C) {
 	defer rtesting.FakeRouter.RemoveBackend(container.AppName)
 	app := testing.NewFakeApp(container.AppName, "python", 0)
 	var p dockerProvisioner
-	_, err = p.AddUnits(app, 2, nil)
+	_, err = p.AddUnits(app, 2, "", nil)
 	c.Assert(err, gocheck.IsNil)
-	err = p.RemoveUnits(app, 3)
+	err = p.RemoveUnits(app, 3, "")
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "remove units: cannot remove all units from app")
 }
@@ -741,6 +865,22 @@ func (s *S) TestProvisionerIsCNameManager(c *gocheck.C) {
 	var _ provision.CNameManager = &dockerProvisioner{}
 }
 
+func (s *S) TestProvisionerIsRollbackDeployer(c *gocheck.C) {
+	var _ provision.RollbackDeployer = &dockerProvisioner{}
+}
+
+func (s *S) TestProvisionerIsImageDeployer(c *gocheck.C) {
+	var _ provision.ImageDeployer = &dockerProvisioner{}
+}
+
+func (s *S) TestProvisionerIsCanaryDeployer(c *gocheck.C) {
+	var _ provision.CanaryDeployer = &dockerProvisioner{}
+}
+
+func (s *S) TestProvisionerIsDeployCanceler(c *gocheck.C) {
+	var _ provision.DeployCanceler = &dockerProvisioner{}
+}
+
 func (s *S) TestAdminCommands(c *gocheck.C) {
 	expected := []cmd.Command{
 		&moveContainerCmd{},
@@ -757,6 +897,7 @@ func (s *S) TestAdminCommands(c *gocheck.C) {
 		fixContainersCmd{},
 		&sshToContainerCmd{},
 		&listHealingHistoryCmd{},
+		&routesCheckCmd{},
 	}
 	var p dockerProvisioner
 	c.Assert(p.AdminCommands(), gocheck.DeepEquals, expected)
@@ -804,7 +945,7 @@ func (s *S) TestProvisionerStart(c *gocheck.C) {
 	dockerContainer, err := dcli.InspectContainer(container.ID)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(dockerContainer.State.Running, gocheck.Equals, false)
-	err = p.Start(app)
+	err = p.Start(app, "")
 	c.Assert(err, gocheck.IsNil)
 	dockerContainer, err = dcli.InspectContainer(container.ID)
 	c.Assert(err, gocheck.IsNil)
@@ -832,7 +973,7 @@ func (s *S) TestProvisionerStop(c *gocheck.C) {
 	dockerContainer, err := dcli.InspectContainer(container.ID)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(dockerContainer.State.Running, gocheck.Equals, true)
-	err = p.Stop(app)
+	err = p.Stop(app, "")
 	c.Assert(err, gocheck.IsNil)
 	dockerContainer, err = dcli.InspectContainer(container.ID)
 	c.Assert(err, gocheck.IsNil)
@@ -861,7 +1002,7 @@ func (s *S) TestProvisionerStopSkipAlreadyStoppedContainers(c *gocheck.C) {
 	dockerContainer2, err := dcli.InspectContainer(container2.ID)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(dockerContainer2.State.Running, gocheck.Equals, false)
-	err = p.Stop(app)
+	err = p.Stop(app, "")
 	c.Assert(err, gocheck.IsNil)
 	dockerContainer, err = dcli.InspectContainer(container.ID)
 	c.Assert(err, gocheck.IsNil)
@@ -1141,3 +1282,82 @@ func (s *S) TestRegisterUnitBuildingContainer(c *gocheck.C) {
 	c.Assert(dbCont.IP, gocheck.Matches, `xinvalidx`)
 	c.Assert(dbCont.Status, gocheck.Equals, provision.StatusBuilding.String())
 }
+
+func (s *S) TestProvisionerCancelDeploy(c *gocheck.C) {
+	coll := collection()
+	defer coll.Close()
+	cont := container{ID: "9930c24f1c4x", AppName: "myapp", Status: provision.StatusBuilding.String()}
+	err := coll.Insert(cont, container{ID: "9930c24f1c5x", AppName: "myapp", Status: provision.StatusStarted.String()})
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveAll(bson.M{"appname": "myapp"})
+	var p dockerProvisioner
+	err = p.CancelDeploy(testing.NewFakeApp("myapp", "python", 0))
+	c.Assert(err, gocheck.IsNil)
+	dbCont, err := getContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.Status, gocheck.Equals, provision.StatusStopped.String())
+	c.Assert(dbCont.deployCanceled(), gocheck.Equals, true)
+	dbCont, err = getContainer("9930c24f1c5x")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbCont.Status, gocheck.Equals, provision.StatusStarted.String())
+	c.Assert(dbCont.deployCanceled(), gocheck.Equals, false)
+}
+
+func (s *S) TestProvisionerCancelDeployAfterBuild(c *gocheck.C) {
+	dep, err := startAppDeploy("myapp")
+	c.Assert(err, gocheck.IsNil)
+	defer dep.finish()
+	c.Assert(dep.canceled(), gocheck.Equals, false)
+	var p dockerProvisioner
+	err = p.CancelDeploy(testing.NewFakeApp("myapp", "python", 0))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dep.canceled(), gocheck.Equals, true)
+	var buf bytes.Buffer
+	err = dep.checkCanceled(&buf)
+	c.Assert(err, gocheck.Equals, provision.ErrDeployCanceled)
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*Deploy canceled.*")
+	dep.finish()
+	err = p.CancelDeploy(testing.NewFakeApp("myapp", "python", 0))
+	c.Assert(err, gocheck.Equals, provision.ErrNoDeployInProgress)
+}
+
+func (s *S) TestProvisionerCancelDeployOfReplacedDeploy(c *gocheck.C) {
+	old, err := startAppDeploy("myapp")
+	c.Assert(err, gocheck.IsNil)
+	dep, err := startAppDeploy("myapp")
+	c.Assert(err, gocheck.IsNil)
+	defer dep.finish()
+	old.finish()
+	var p dockerProvisioner
+	err = p.CancelDeploy(testing.NewFakeApp("myapp", "python", 0))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(old.canceled(), gocheck.Equals, false)
+	c.Assert(dep.canceled(), gocheck.Equals, true)
+}
+
+func (s *S) TestProvisionerCancelDeployWithoutDeployInProgress(c *gocheck.C) {
+	var p dockerProvisioner
+	err := p.CancelDeploy(testing.NewFakeApp("myapp", "python", 0))
+	c.Assert(err, gocheck.Equals, provision.ErrNoDeployInProgress)
+}
+
+func (s *S) TestUpdateAppProcessesWithoutProcfileOutput(c *gocheck.C) {
+	err := newImage("tsuru/python", "")
+	c.Assert(err, gocheck.IsNil)
+	processes := map[string]string{"web": "python app.py", "worker": "python worker.py"}
+	dbApp := app.App{Name: "myapp", Processes: processes}
+	err = s.storage.Apps().Insert(dbApp)
+	c.Assert(err, gocheck.IsNil)
+	defer s.storage.Apps().RemoveAll(bson.M{"name": dbApp.Name})
+	client, err := docker.NewClient(s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	before, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, gocheck.IsNil)
+	a := testing.NewFakeApp("myapp", "python", 1)
+	err = updateAppProcesses(a, "tsuru/python")
+	c.Assert(err, gocheck.IsNil)
+	after, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(after, gocheck.HasLen, len(before))
+	c.Assert(appProcesses(a), gocheck.DeepEquals, processes)
+}
@@ -44,6 +44,12 @@ func listContainersByApp(appName string) ([]container, error) {
 	return listContainersBy(bson.M{"appname": appName})
 }
 
+// listBuildingContainersByApp returns the containers running the build of
+// the app in a deploy.
+func listBuildingContainersByApp(appName string) ([]container, error) {
+	return listContainersBy(bson.M{"appname": appName, "status": provision.StatusBuilding.String()})
+}
+
 func listRunnableContainersByApp(appName string) ([]container, error) {
 	return listContainersBy(bson.M{
 		"appname": appName,
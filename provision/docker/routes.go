@@ -0,0 +1,181 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router"
+)
+
+// appRoutes holds the differences between the routes of an app in its router
+// and its started web units. Missing holds the addresses of units without a
+// route, and Stale holds the routes that point to no started unit.
+type appRoutes struct {
+	App     string
+	Missing []string
+	Stale   []string
+	Fixed   bool
+	Error   string
+}
+
+func (r *appRoutes) consistent() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0
+}
+
+// diffAppRoutes compares the routes of the app with the addresses of its
+// web units that are started or starting. Units removed from the router by the
+// units healthchecker are not expected to have routes. Routes are compared by
+// host:port, as routers like galeb return them without the scheme.
+func diffAppRoutes(a *app.App) (appRoutes, error) {
+	result := appRoutes{App: a.Name}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return result, err
+	}
+	routes, err := router.AppRoutes(r, a.Name)
+	if err != nil {
+		return result, err
+	}
+	containers, err := listContainersByApp(a.Name)
+	if err != nil {
+		return result, err
+	}
+	var addresses []string
+	expected := make(map[string]bool)
+	for _, c := range routableContainers(containers) {
+		if c.available() && c.HostPort != "" && !c.Unhealthy {
+			addresses = append(addresses, c.getAddress())
+			expected[router.RouteHost(c.getAddress())] = true
+		}
+	}
+	current := make(map[string]bool)
+	for _, route := range routes {
+		host := router.RouteHost(route)
+		current[host] = true
+		if !expected[host] {
+			result.Stale = append(result.Stale, route)
+		}
+	}
+	for _, address := range addresses {
+		if !current[router.RouteHost(address)] {
+			result.Missing = append(result.Missing, address)
+		}
+	}
+	return result, nil
+}
+
+// fixAppRoutes adds the missing routes of the app and removes its stale
+// routes.
+func fixAppRoutes(a *app.App, routes *appRoutes) error {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	for _, address := range routes.Missing {
+		err = r.AddRoute(a.Name, address)
+		if err != nil {
+			return err
+		}
+	}
+	for _, address := range routes.Stale {
+		err = r.RemoveRoute(a.Name, address)
+		if err != nil {
+			return err
+		}
+	}
+	routes.Fixed = true
+	return nil
+}
+
+// reconcileAppRoutes compares the routes of the app with its units, fixing
+// them when fix is true. Locked apps are skipped, as their units are likely
+// being changed.
+func reconcileAppRoutes(a *app.App, fix bool) appRoutes {
+	locked, err := app.AcquireApplicationLock(a.Name, app.InternalAppName, "reconcile-routes")
+	if err != nil {
+		return appRoutes{App: a.Name, Error: err.Error()}
+	}
+	if !locked {
+		return appRoutes{App: a.Name, Error: "the app is locked, skipped"}
+	}
+	defer app.ReleaseApplicationLock(a.Name)
+	routes, err := diffAppRoutes(a)
+	if err == nil && fix && !routes.consistent() {
+		err = fixAppRoutes(a, &routes)
+	}
+	if err != nil {
+		routes.Error = err.Error()
+	}
+	return routes
+}
+
+// reconcileRoutes compares the routes of the given app, or of all apps
+// handled by the docker provisioner when appName is empty, with their units.
+// It returns only the apps whose routes don't match their units.
+func reconcileRoutes(appName string, fix bool) ([]appRoutes, error) {
+	var apps []app.App
+	if appName != "" {
+		a, err := app.GetByName(appName)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, *a)
+	} else {
+		var err error
+		apps, err = app.List(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result := []appRoutes{}
+	for i := range apps {
+		a := &apps[i]
+		prov, err := a.GetProvisioner()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := prov.(*dockerProvisioner); !ok {
+			continue
+		}
+		routes := reconcileAppRoutes(a, fix)
+		if !routes.consistent() || routes.Error != "" {
+			result = append(result, routes)
+		}
+	}
+	return result, nil
+}
+
+func logRoutes(routes appRoutes) {
+	if routes.Error != "" {
+		log.Errorf("Routes reconciler: failed to check the routes of %q: %s", routes.App, routes.Error)
+	}
+	if routes.consistent() {
+		return
+	}
+	var fixed string
+	if routes.Fixed {
+		fixed = " (fixed)"
+	}
+	log.Errorf("Routes reconciler: the routes of %q don't match its units%s. Missing: %s. Stale: %s.",
+		routes.App, fixed, strings.Join(routes.Missing, ", "), strings.Join(routes.Stale, ", "))
+}
+
+func runRoutesReconciler(interval time.Duration, fix bool) {
+	for {
+		time.Sleep(interval)
+		result, err := reconcileRoutes("", fix)
+		if err != nil {
+			log.Errorf("Routes reconciler: %s", err)
+			continue
+		}
+		for _, routes := range result {
+			logRoutes(routes)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+// createRoutesApp creates an app with three web units, a started unit with a
+// route, a started unit without a route and a stopped unit, and a worker unit.
+// The router also has a route pointing to no unit.
+func (s *S) createRoutesApp(c *gocheck.C) *app.App {
+	a := app.App{Name: "myapp"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	coll := collection()
+	defer coll.Close()
+	err = coll.Insert(
+		container{ID: "c1", AppName: a.Name, ProcessName: "web", HostAddr: "10.0.0.1", HostPort: "49153", Status: provision.StatusStarted.String()},
+		container{ID: "c2", AppName: a.Name, ProcessName: "web", HostAddr: "10.0.0.2", HostPort: "49154", Status: provision.StatusStarted.String()},
+		container{ID: "c3", AppName: a.Name, ProcessName: "web", HostAddr: "10.0.0.3", HostPort: "49155", Status: provision.StatusStopped.String()},
+		container{ID: "c4", AppName: a.Name, ProcessName: "worker", HostAddr: "10.0.0.4", HostPort: "49156", Status: provision.StatusStarted.String()},
+	)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "http://10.0.0.1:49153")
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "http://10.0.0.9:49999")
+	c.Assert(err, gocheck.IsNil)
+	return &a
+}
+
+func (s *S) TestDiffAppRoutes(c *gocheck.C) {
+	a := s.createRoutesApp(c)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	routes, err := diffAppRoutes(a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, appRoutes{
+		App:     "myapp",
+		Missing: []string{"http://10.0.0.2:49154"},
+		Stale:   []string{"http://10.0.0.9:49999"},
+	})
+}
+
+func (s *S) TestDiffAppRoutesIgnoresRoutesThatAreNotAddresses(c *gocheck.C) {
+	a := app.App{Name: "myapp"}
+	err := rtesting.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "myapp")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := diffAppRoutes(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes.consistent(), gocheck.Equals, true)
+}
+
+func (s *S) TestDiffAppRoutesHostPortRoutes(c *gocheck.C) {
+	a := s.createRoutesApp(c)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	err := rtesting.FakeRouter.RemoveRoute(a.Name, "http://10.0.0.1:49153")
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.RemoveRoute(a.Name, "http://10.0.0.9:49999")
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "10.0.0.1:49153")
+	c.Assert(err, gocheck.IsNil)
+	err = rtesting.FakeRouter.AddRoute(a.Name, "10.0.0.9:49999")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := diffAppRoutes(a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, appRoutes{
+		App:     "myapp",
+		Missing: []string{"http://10.0.0.2:49154"},
+		Stale:   []string{"10.0.0.9:49999"},
+	})
+}
+
+func (s *S) TestDiffAppRoutesIgnoresUnhealthyUnits(c *gocheck.C) {
+	a := s.createRoutesApp(c)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont, err := getContainer("c2")
+	c.Assert(err, gocheck.IsNil)
+	err = cont.setUnhealthy(true)
+	c.Assert(err, gocheck.IsNil)
+	routes, err := diffAppRoutes(a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes.Missing, gocheck.IsNil)
+}
+
+func (s *S) TestReconcileRoutes(c *gocheck.C) {
+	a := s.createRoutesApp(c)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	result, err := reconcileRoutes("", false)
+	c.Assert(err, gocheck.IsNil)
+	var routes *appRoutes
+	for i := range result {
+		if result[i].App == a.Name {
+			routes = &result[i]
+		}
+	}
+	c.Assert(routes, gocheck.NotNil)
+	c.Assert(routes.Missing, gocheck.DeepEquals, []string{"http://10.0.0.2:49154"})
+	c.Assert(routes.Fixed, gocheck.Equals, false)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.0.0.9:49999"), gocheck.Equals, true)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.0.0.2:49154"), gocheck.Equals, false)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbApp.Lock.Locked, gocheck.Equals, false)
+}
+
+func (s *S) TestReconcileRoutesFix(c *gocheck.C) {
+	a := s.createRoutesApp(c)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	result, err := reconcileRoutes(a.Name, true)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 1)
+	c.Assert(result[0].Fixed, gocheck.Equals, true)
+	c.Assert(result[0].Error, gocheck.Equals, "")
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.0.0.9:49999"), gocheck.Equals, false)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.0.0.2:49154"), gocheck.Equals, true)
+	result, err = reconcileRoutes(a.Name, false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 0)
+}
+
+func (s *S) TestReconcileRoutesSkipsLockedApps(c *gocheck.C) {
+	a := s.createRoutesApp(c)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	locked, err := app.AcquireApplicationLock(a.Name, "someone", "/deploy")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(locked, gocheck.Equals, true)
+	result, err := reconcileRoutes(a.Name, true)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, []appRoutes{{App: "myapp", Error: "the app is locked, skipped"}})
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, "http://10.0.0.9:49999"), gocheck.Equals, true)
+}
+
+func (s *S) TestReconcileRoutesAppNotFound(c *gocheck.C) {
+	_, err := reconcileRoutes("unknown", false)
+	c.Assert(err, gocheck.Equals, app.ErrAppNotFound)
+}
@@ -22,6 +22,8 @@ import (
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -190,6 +192,35 @@ func (segregatedScheduler) chooseNode(nodes []cluster.Node, contName string, app
 	return chosenNode, err
 }
 
+// ValidatePool checks that the pool exists and that it's public, or that one
+// of the given teams is allowed to use it.
+func (p *dockerProvisioner) ValidatePool(name string, teams []string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var pool Pool
+	err = conn.Collection(schedulerCollection).FindId(name).One(&pool)
+	if err == mgo.ErrNotFound {
+		return provision.ErrPoolNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if len(pool.Teams) == 0 {
+		return nil
+	}
+	for _, team := range teams {
+		for _, poolTeam := range pool.Teams {
+			if team == poolTeam {
+				return nil
+			}
+		}
+	}
+	return provision.ErrPoolNotAllowed
+}
+
 func poolsForApp(app *app.App) ([]Pool, error) {
 	var pools []Pool
 	var query bson.M
@@ -199,7 +230,9 @@ func poolsForApp(app *app.App) ([]Pool, error) {
 	}
 	defer conn.Close()
 	if app != nil {
-		if app.TeamOwner != "" {
+		if app.Pool != "" {
+			query = bson.M{"_id": app.Pool}
+		} else if app.TeamOwner != "" {
 			query = bson.M{"teams": app.TeamOwner}
 		} else {
 			query = bson.M{"teams": bson.M{"$in": app.Teams}}
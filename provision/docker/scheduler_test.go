@@ -17,6 +17,7 @@ import (
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/cmd/testing"
+	"github.com/tsuru/tsuru/provision"
 	"gopkg.in/mgo.v2/bson"
 	"launchpad.net/gocheck"
 )
@@ -166,6 +167,39 @@ func (s *S) TestSchedulerScheduleTeamOwner(c *gocheck.C) {
 	c.Check(node.Address, gocheck.Equals, "http://url0:1234")
 }
 
+func (s *S) TestSchedulerScheduleAppPool(c *gocheck.C) {
+	a1 := app.App{
+		Name:      "impius",
+		TeamOwner: "tsuruteam",
+		Pool:      "pool2",
+	}
+	cont1 := container{ID: "1", Name: "impius1", AppName: a1.Name}
+	err := s.storage.Apps().Insert(a1)
+	c.Assert(err, gocheck.IsNil)
+	defer s.storage.Apps().RemoveAll(bson.M{"name": a1.Name})
+	coll := s.storage.Collection(schedulerCollection)
+	p1 := Pool{Name: "pool1", Teams: []string{"tsuruteam"}}
+	p2 := Pool{Name: "pool2"}
+	err = coll.Insert(p1, p2)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveAll(bson.M{"_id": bson.M{"$in": []string{p1.Name, p2.Name}}})
+	contColl := collection()
+	err = contColl.Insert(cont1)
+	c.Assert(err, gocheck.IsNil)
+	defer contColl.RemoveAll(bson.M{"name": cont1.Name})
+	var scheduler segregatedScheduler
+	clusterInstance, err := cluster.New(&scheduler, &cluster.MapStorage{})
+	c.Assert(err, gocheck.IsNil)
+	_, err = clusterInstance.Register("http://url0:1234", map[string]string{"pool": "pool1"})
+	c.Assert(err, gocheck.IsNil)
+	_, err = clusterInstance.Register("http://url1:1234", map[string]string{"pool": "pool2"})
+	c.Assert(err, gocheck.IsNil)
+	opts := docker.CreateContainerOptions{Name: cont1.Name}
+	node, err := scheduler.Schedule(clusterInstance, opts, a1.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(node.Address, gocheck.Equals, "http://url1:1234")
+}
+
 func (s *S) TestSchedulerNoFallback(c *gocheck.C) {
 	app := app.App{Name: "bill", Teams: []string{"jean"}}
 	err := s.storage.Apps().Insert(app)
@@ -396,6 +430,19 @@ func (s *S) TestRemovePool(c *gocheck.C) {
 	c.Assert(p, gocheck.Equals, 0)
 }
 
+func (s *S) TestValidatePool(c *gocheck.C) {
+	var p dockerProvisioner
+	coll := s.storage.Collection(schedulerCollection)
+	err := coll.Insert(Pool{Name: "public"}, Pool{Name: "private", Teams: []string{"ateam"}})
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId("public")
+	defer coll.RemoveId("private")
+	c.Assert(p.ValidatePool("public", []string{"other"}), gocheck.IsNil)
+	c.Assert(p.ValidatePool("private", []string{"other", "ateam"}), gocheck.IsNil)
+	c.Assert(p.ValidatePool("private", []string{"other"}), gocheck.Equals, provision.ErrPoolNotAllowed)
+	c.Assert(p.ValidatePool("unknown", []string{"ateam"}), gocheck.Equals, provision.ErrPoolNotFound)
+}
+
 func (s *S) TestAddTeamToPool(c *gocheck.C) {
 	var seg segregatedScheduler
 	coll := s.storage.Collection(schedulerCollection)
@@ -100,6 +100,14 @@ func (s *S) SetUpTest(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	defer healingColl.Close()
 	healingColl.RemoveAll(nil)
+	imagesColl, err := appImagesColl()
+	c.Assert(err, gocheck.IsNil)
+	defer imagesColl.Close()
+	imagesColl.RemoveAll(nil)
+	canaryColl, err := canariesColl()
+	c.Assert(err, gocheck.IsNil)
+	defer canaryColl.Close()
+	canaryColl.RemoveAll(nil)
 }
 
 func clearClusterStorage() error {
@@ -0,0 +1,275 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+)
+
+type changeUnitsPipelineArgs struct {
+	app      provision.App
+	writer   io.Writer
+	toRemove []unit
+	toAdd    map[string]int
+}
+
+var addNewUnits = action.Action{
+	Name: "add-new-units",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		processes := make([]string, 0, len(args.toAdd))
+		for process := range args.toAdd {
+			processes = append(processes, process)
+		}
+		sort.Strings(processes)
+		var units []unit
+		for _, process := range processes {
+			added, err := addUnits(args.writer, args.app, args.toAdd[process], process)
+			if err != nil {
+				for _, u := range units {
+					provUnit := u.asUnit()
+					args.app.UnbindUnit(&provUnit)
+					u.remove(args.app)
+				}
+				return nil, err
+			}
+			units = append(units, added...)
+		}
+		return units, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		for _, u := range ctx.FWResult.([]unit) {
+			err := u.remove(args.app)
+			if err != nil {
+				log.Errorf("Error removing added unit %s: %s", u.Name, err)
+			}
+		}
+	},
+	MinParams: 1,
+}
+
+var addNewRoutes = action.Action{
+	Name: "add-new-routes",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		newUnits := ctx.Previous.([]unit)
+		r, err := getRouterForApp(args.app)
+		if err != nil {
+			return nil, err
+		}
+		routable := routableUnits(newUnits)
+		fmt.Fprintf(args.writer, "\n---- Adding routes to %d new units ----\n", len(routable))
+		for i, u := range routable {
+			err = r.AddRoute(u.AppName, u.getAddress())
+			if err != nil {
+				for _, added := range routable[:i] {
+					r.RemoveRoute(added.AppName, added.getAddress())
+				}
+				return nil, err
+			}
+			fmt.Fprintf(args.writer, " ---> Added route to unit %s\n", u.Name)
+		}
+		return newUnits, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		r, err := getRouterForApp(args.app)
+		if err != nil {
+			log.Errorf("[add-new-routes:Backward] Error geting router: %s", err)
+			return
+		}
+		for _, u := range routableUnits(ctx.FWResult.([]unit)) {
+			err = r.RemoveRoute(u.AppName, u.getAddress())
+			if err != nil {
+				log.Errorf("[add-new-routes:Backward] Error removing route for %s: %s", u.Name, err)
+			}
+		}
+	},
+	MinParams: 1,
+}
+
+var removeOldRoutes = action.Action{
+	Name: "remove-old-routes",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		r, err := getRouterForApp(args.app)
+		if err != nil {
+			return nil, err
+		}
+		toRemove := routableUnits(args.toRemove)
+		fmt.Fprintf(args.writer, "\n---- Removing routes from %d old units ----\n", len(toRemove))
+		for i, u := range toRemove {
+			err = r.RemoveRoute(u.AppName, u.getAddress())
+			if err != nil && err != router.ErrRouteNotFound {
+				for _, removed := range toRemove[:i] {
+					r.AddRoute(removed.AppName, removed.getAddress())
+				}
+				return nil, err
+			}
+			fmt.Fprintf(args.writer, " ---> Removed route from unit %s\n", u.Name)
+		}
+		return ctx.Previous, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		r, err := getRouterForApp(args.app)
+		if err != nil {
+			log.Errorf("[remove-old-routes:Backward] Error geting router: %s", err)
+			return
+		}
+		for _, u := range routableUnits(args.toRemove) {
+			err = r.AddRoute(u.AppName, u.getAddress())
+			if err != nil {
+				log.Errorf("[remove-old-routes:Backward] Error adding back route for %s: %s", u.Name, err)
+			}
+		}
+	},
+	MinParams: 1,
+}
+
+var removeOldUnits = action.Action{
+	Name: "remove-old-units",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		fmt.Fprintf(args.writer, "\n---- Removing %d old units ----\n", len(args.toRemove))
+		for _, u := range args.toRemove {
+			provUnit := u.asUnit()
+			err := args.app.UnbindUnit(&provUnit)
+			if err != nil {
+				log.Errorf("Ignored error trying to unbind old unit %s: %s", u.Name, err)
+			}
+			err = u.remove(args.app)
+			if err != nil {
+				log.Errorf("Ignored error trying to remove old unit %s: %s", u.Name, err)
+			}
+			fmt.Fprintf(args.writer, " ---> Removed old unit %s\n", u.Name)
+		}
+		return ctx.Previous, nil
+	},
+	Backward: func(ctx action.BWContext) {
+	},
+	MinParams: 1,
+}
+
+// routableUnits filters the given units, returning only the ones that should
+// be added to the router of the app.
+func routableUnits(units []unit) []unit {
+	result := make([]unit, 0, len(units))
+	for _, u := range units {
+		if u.routable() {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// addUnits creates and starts n units of the given process, binding them to
+// the service instances of the app. All created units are removed in case of
+// failure.
+func addUnits(w io.Writer, a provision.App, n int, process string) ([]unit, error) {
+	if n < 1 {
+		return nil, errors.New("Cannot add 0 units")
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "\n---- Starting %d new units [%s] ----\n", n, process)
+	added := make([]unit, 0, n)
+	for i := 0; i < n; i++ {
+		u, err := newUnit(a, process)
+		if err == nil {
+			err = u.create(a)
+		}
+		if err == nil {
+			added = append(added, *u)
+			err = u.start(a)
+		}
+		if err == nil {
+			provUnit := u.asUnit()
+			err = a.BindUnit(&provUnit)
+		}
+		if err != nil {
+			for _, toRemove := range added {
+				provUnit := toRemove.asUnit()
+				a.UnbindUnit(&provUnit)
+				toRemove.remove(a)
+			}
+			return nil, err
+		}
+		fmt.Fprintf(w, " ---> Started unit %s...\n", u.Name)
+	}
+	return added, nil
+}
+
+func newUnit(a provision.App, process string) (*unit, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	return &unit{
+		Name:        unitName(a.GetName()),
+		AppName:     a.GetName(),
+		ProcessName: process,
+		Type:        a.GetPlatform(),
+		Host:        hostAddr(),
+		Port:        port,
+		Status:      provision.StatusCreated.String(),
+	}, nil
+}
+
+// runReplaceUnitsPipeline replaces the given units with new ones, adding the
+// given number of units of each process.
+func runReplaceUnitsPipeline(w io.Writer, a provision.App, toRemove []unit, toAdd map[string]int) ([]unit, error) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	args := changeUnitsPipelineArgs{
+		app:      a,
+		writer:   w,
+		toRemove: toRemove,
+		toAdd:    toAdd,
+	}
+	pipeline := action.NewPipeline(
+		&addNewUnits,
+		&addNewRoutes,
+		&removeOldRoutes,
+		&removeOldUnits,
+	)
+	err := pipeline.Execute(args)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.Result().([]unit), nil
+}
+
+func runCreateUnitsPipeline(w io.Writer, a provision.App, toAdd map[string]int) ([]unit, error) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	args := changeUnitsPipelineArgs{
+		app:    a,
+		writer: w,
+		toAdd:  toAdd,
+	}
+	pipeline := action.NewPipeline(
+		&addNewUnits,
+		&addNewRoutes,
+	)
+	err := pipeline.Execute(args)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.Result().([]unit), nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+
+	"github.com/tsuru/tsuru/action"
+	etesting "github.com/tsuru/tsuru/exec/testing"
+	"github.com/tsuru/tsuru/provision"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	"github.com/tsuru/tsuru/testing"
+	"gopkg.in/mgo.v2"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestAddNewUnitsName(c *gocheck.C) {
+	c.Assert(addNewUnits.Name, gocheck.Equals, "add-new-units")
+}
+
+func (s *S) TestAddNewUnitsForward(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	var buf bytes.Buffer
+	args := changeUnitsPipelineArgs{app: a, writer: &buf, toAdd: map[string]int{"web": 2}}
+	context := action.FWContext{Params: []interface{}{args}}
+	result, err := addNewUnits.Forward(context)
+	c.Assert(err, gocheck.IsNil)
+	units := result.([]unit)
+	c.Assert(units, gocheck.HasLen, 2)
+	for _, u := range units {
+		dbUnit, err := getUnit(u.Name)
+		c.Assert(err, gocheck.IsNil)
+		c.Assert(dbUnit.Status, gocheck.Equals, provision.StatusStarting.String())
+		c.Assert(dbUnit.AppName, gocheck.Equals, "myapp")
+		c.Assert(dbUnit.ProcessName, gocheck.Equals, "web")
+	}
+	c.Assert(buf.String(), gocheck.Matches, `(?s).*---- Starting 2 new units \[web\] ----.*`)
+}
+
+func (s *S) TestAddNewUnitsForwardMultipleProcesses(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	args := changeUnitsPipelineArgs{app: a, writer: &bytes.Buffer{}, toAdd: map[string]int{"worker": 1, "web": 2}}
+	context := action.FWContext{Params: []interface{}{args}}
+	result, err := addNewUnits.Forward(context)
+	c.Assert(err, gocheck.IsNil)
+	units := result.([]unit)
+	c.Assert(units, gocheck.HasLen, 3)
+	c.Assert(units[0].ProcessName, gocheck.Equals, "web")
+	c.Assert(units[1].ProcessName, gocheck.Equals, "web")
+	c.Assert(units[2].ProcessName, gocheck.Equals, "worker")
+}
+
+func (s *S) TestAddNewUnitsForwardFailure(c *gocheck.C) {
+	execut = &etesting.FailLaterExecutor{Succeeds: 3}
+	a := testing.NewFakeApp("myapp", "python", 0)
+	args := changeUnitsPipelineArgs{app: a, writer: &bytes.Buffer{}, toAdd: map[string]int{"web": 2}}
+	context := action.FWContext{Params: []interface{}{args}}
+	_, err := addNewUnits.Forward(context)
+	c.Assert(err, gocheck.NotNil)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 0)
+}
+
+func (s *S) TestAddNewUnitsBackward(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	units := s.newUnits(c, a, 2)
+	args := changeUnitsPipelineArgs{app: a}
+	context := action.BWContext{Params: []interface{}{args}, FWResult: units}
+	addNewUnits.Backward(context)
+	for _, u := range units {
+		_, err := getUnit(u.Name)
+		c.Assert(err, gocheck.Equals, mgo.ErrNotFound)
+	}
+}
+
+func (s *S) TestAddNewRoutesForward(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	units := s.newUnits(c, a, 2)
+	args := changeUnitsPipelineArgs{app: a, writer: &bytes.Buffer{}}
+	context := action.FWContext{Params: []interface{}{args}, Previous: units}
+	result, err := addNewRoutes.Forward(context)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, units)
+	for _, u := range units {
+		c.Assert(rtesting.FakeRouter.HasRoute("myapp", u.getAddress()), gocheck.Equals, true)
+	}
+}
+
+func (s *S) TestAddNewRoutesForwardIgnoresWorkers(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	web := s.newUnits(c, a, 1)
+	workers := s.newProcessUnits(c, a, 1, "worker")
+	units := append(web, workers...)
+	args := changeUnitsPipelineArgs{app: a, writer: &bytes.Buffer{}}
+	context := action.FWContext{Params: []interface{}{args}, Previous: units}
+	_, err := addNewRoutes.Forward(context)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", web[0].getAddress()), gocheck.Equals, true)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", workers[0].getAddress()), gocheck.Equals, false)
+}
+
+func (s *S) TestAddNewRoutesBackward(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	units := s.newUnits(c, a, 2)
+	for _, u := range units {
+		rtesting.FakeRouter.AddRoute("myapp", u.getAddress())
+	}
+	args := changeUnitsPipelineArgs{app: a}
+	context := action.BWContext{Params: []interface{}{args}, FWResult: units}
+	addNewRoutes.Backward(context)
+	for _, u := range units {
+		c.Assert(rtesting.FakeRouter.HasRoute("myapp", u.getAddress()), gocheck.Equals, false)
+	}
+}
+
+func (s *S) TestRemoveOldRoutesForward(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	units := s.newUnits(c, a, 2)
+	for _, u := range units {
+		rtesting.FakeRouter.AddRoute("myapp", u.getAddress())
+	}
+	args := changeUnitsPipelineArgs{app: a, writer: &bytes.Buffer{}, toRemove: units}
+	context := action.FWContext{Params: []interface{}{args}, Previous: []unit{}}
+	result, err := removeOldRoutes.Forward(context)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, []unit{})
+	for _, u := range units {
+		c.Assert(rtesting.FakeRouter.HasRoute("myapp", u.getAddress()), gocheck.Equals, false)
+	}
+}
+
+func (s *S) TestRemoveOldRoutesBackward(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	units := s.newUnits(c, a, 2)
+	args := changeUnitsPipelineArgs{app: a, toRemove: units}
+	context := action.BWContext{Params: []interface{}{args}}
+	removeOldRoutes.Backward(context)
+	for _, u := range units {
+		c.Assert(rtesting.FakeRouter.HasRoute("myapp", u.getAddress()), gocheck.Equals, true)
+	}
+}
+
+func (s *S) TestRemoveOldUnitsForward(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	units := s.newUnits(c, a, 2)
+	args := changeUnitsPipelineArgs{app: a, writer: &bytes.Buffer{}, toRemove: units}
+	context := action.FWContext{Params: []interface{}{args}, Previous: []unit{}}
+	_, err := removeOldUnits.Forward(context)
+	c.Assert(err, gocheck.IsNil)
+	remaining, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(remaining, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRunReplaceUnitsPipeline(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	old := s.newUnits(c, a, 2)
+	for _, u := range old {
+		rtesting.FakeRouter.AddRoute("myapp", u.getAddress())
+	}
+	units, err := runReplaceUnitsPipeline(nil, a, old, map[string]int{"web": 2})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 2)
+	for _, u := range old {
+		c.Assert(rtesting.FakeRouter.HasRoute("myapp", u.getAddress()), gocheck.Equals, false)
+	}
+	for _, u := range units {
+		c.Assert(rtesting.FakeRouter.HasRoute("myapp", u.getAddress()), gocheck.Equals, true)
+	}
+	current, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(current, gocheck.HasLen, 2)
+}
+
+func (s *S) TestRunReplaceUnitsPipelineNoUnits(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	units, err := runReplaceUnitsPipeline(nil, a, nil, map[string]int{"web": 1, "worker": 1})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 2)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", units[0].getAddress()), gocheck.Equals, true)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", units[1].getAddress()), gocheck.Equals, false)
+}
+
+func (s *S) TestRunCreateUnitsPipeline(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	units, err := runCreateUnitsPipeline(nil, a, map[string]int{"web": 3})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 3)
+	for _, u := range units {
+		c.Assert(rtesting.FakeRouter.HasRoute("myapp", u.getAddress()), gocheck.Equals, true)
+	}
+}
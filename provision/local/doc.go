@@ -0,0 +1,22 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package local provides a provisioner implementation that runs app units as
+// plain processes in the host running tsuru. Each unit gets its own working
+// directory, copied from the last deployed release of the app.
+//
+// It's intended for development environments and for running end-to-end
+// tests without a Docker cluster. In order to use the provisioner, import
+// this package and set "provisioner" to "local" in tsuru.conf:
+//
+//	import (
+//	    "github.com/tsuru/tsuru/provision"
+//	    _ "github.com/tsuru/tsuru/provision/local"
+//	)
+//	// ...
+//	func main() {
+//	    provisioner, err := provision.Get("local")
+//	    // Use provisioner.
+//	}
+package local
@@ -0,0 +1,494 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+	_ "github.com/tsuru/tsuru/router/file"
+	_ "github.com/tsuru/tsuru/router/galeb"
+	_ "github.com/tsuru/tsuru/router/hipache"
+	_ "github.com/tsuru/tsuru/router/proxy"
+	_ "github.com/tsuru/tsuru/router/testing"
+)
+
+func init() {
+	provision.Register("local", &localProvisioner{})
+}
+
+func getRouterForApp(app provision.App) (router.Router, error) {
+	routerName, err := app.GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	return router.Get(routerName)
+}
+
+type localProvisioner struct{}
+
+func (p *localProvisioner) Provision(a provision.App) error {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = a.Ready()
+	if err != nil {
+		return err
+	}
+	return r.AddBackend(a.GetName())
+}
+
+func (p *localProvisioner) Destroy(a provision.App) error {
+	units, err := listUnitsByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	for _, u := range units {
+		provUnit := u.asUnit()
+		err = a.UnbindUnit(&provUnit)
+		if err != nil {
+			log.Errorf("Unable to unbind unit %q: %s", u.Name, err)
+		}
+		err = u.remove(a)
+		if err != nil {
+			log.Errorf("Unable to destroy unit %q: %s", u.Name, err)
+		}
+	}
+	err = executor().Execute(exec.ExecuteOptions{
+		Cmd:    "rm",
+		Args:   []string{"-rf", filepath.Dir(releaseDir(a.GetName()))},
+		Stdout: ioutil.Discard,
+		Stderr: ioutil.Discard,
+	})
+	if err != nil {
+		log.Errorf("Failed to remove release of app %q: %s", a.GetName(), err)
+	}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	return r.RemoveBackend(a.GetName())
+}
+
+// ArchiveDeploy deploys the archive available in the given URL. Local units
+// don't run images, so the returned image is always empty.
+func (p *localProvisioner) ArchiveDeploy(a provision.App, archiveURL string, w io.Writer) (string, error) {
+	return "", p.deploy(a, func(dir string, w, stderr io.Writer) error {
+		return downloadArchive(archiveURL, dir, w, stderr)
+	}, w)
+}
+
+// downloadArchive downloads the archive in the given URL, extracting it into
+// dir. The URL is given to curl as a single argument, never reaching a shell.
+func downloadArchive(archiveURL, dir string, w, stderr io.Writer) error {
+	reader, writer := io.Pipe()
+	var curlStderr bytes.Buffer
+	curlErr := make(chan error, 1)
+	go func() {
+		err := executor().Execute(exec.ExecuteOptions{
+			Cmd:    "curl",
+			Args:   []string{"-sSL", "--url", archiveURL},
+			Stdout: writer,
+			Stderr: &curlStderr,
+		})
+		writer.CloseWithError(err)
+		curlErr <- err
+	}()
+	err := executor().Execute(exec.ExecuteOptions{
+		Cmd:    "tar",
+		Args:   []string{"-xz", "-C", dir},
+		Stdin:  reader,
+		Stdout: w,
+		Stderr: stderr,
+	})
+	reader.Close()
+	if cErr := <-curlErr; cErr != nil {
+		stderr.Write(curlStderr.Bytes())
+		return cErr
+	}
+	return err
+}
+
+// UploadDeploy deploys the uploaded archive. Local units don't run images, so
+// the returned image is always empty.
+func (p *localProvisioner) UploadDeploy(a provision.App, file io.ReadCloser, w io.Writer) (string, error) {
+	defer file.Close()
+	archivePath := filepath.Join(filepath.Dir(releaseDir(a.GetName())), "archive.tar.gz")
+	err := os.MkdirAll(filepath.Dir(archivePath), 0755)
+	if err != nil {
+		return "", err
+	}
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(archive, file)
+	archive.Close()
+	if err != nil {
+		return "", err
+	}
+	return "", p.deploy(a, func(dir string, w, stderr io.Writer) error {
+		return executor().Execute(exec.ExecuteOptions{
+			Cmd:    "tar",
+			Args:   []string{"-xzf", archivePath, "-C", dir},
+			Stdout: w,
+			Stderr: stderr,
+		})
+	}, w)
+}
+
+// deploy extracts the code of the app into a new release directory, using
+// the given extract function, runs the deploy command and replaces all units
+// of the app with new ones based on the new release. Each process declared
+// in the Procfile of the release keeps its number of units, starting with
+// one unit.
+func (p *localProvisioner) deploy(a provision.App, extract func(dir string, w, stderr io.Writer) error, w io.Writer) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	dir := releaseDir(a.GetName())
+	fmt.Fprintf(w, "\n---- Extracting application code ----\n")
+	var stderr bytes.Buffer
+	errWriter := io.MultiWriter(w, &stderr)
+	err := executor().Execute(exec.ExecuteOptions{
+		Cmd:    "rm",
+		Args:   []string{"-rf", dir},
+		Stdout: w,
+		Stderr: errWriter,
+	})
+	if err == nil {
+		err = executor().Execute(exec.ExecuteOptions{
+			Cmd:    "mkdir",
+			Args:   []string{"-p", dir},
+			Stdout: w,
+			Stderr: errWriter,
+		})
+	}
+	if err == nil {
+		err = extract(dir, w, errWriter)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract application code: %s (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+	if deployCmd, _ := config.GetString("local:deploy-cmd"); deployCmd != "" {
+		fmt.Fprintf(w, "\n---- Building application ----\n")
+		err = executor().Execute(exec.ExecuteOptions{
+			Cmd:    "/bin/bash",
+			Args:   []string{"-lc", fmt.Sprintf("cd %s && %s", dir, deployCmd)},
+			Envs:   appEnvs(a),
+			Stdout: w,
+			Stderr: w,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	processes, err := appProcesses(a.GetName())
+	if err != nil {
+		return err
+	}
+	units, err := listUnitsByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	current := unitsByProcess(units)
+	toAdd := make(map[string]int)
+	for _, process := range provision.ProcessNames(processes) {
+		toAdd[process] = current[process]
+		if toAdd[process] < 1 {
+			toAdd[process] = 1
+		}
+	}
+	_, err = runReplaceUnitsPipeline(w, a, units, toAdd)
+	return err
+}
+
+// resolveProcess validates the given process name against the Procfile of
+// the current release of the app.
+func resolveProcess(a provision.App, process string) (string, error) {
+	processes, err := appProcesses(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	return provision.ResolveProcess(process, processes)
+}
+
+// listUnitsToHandle returns the units of the given process, or all units of
+// the app when the process is empty.
+func listUnitsToHandle(a provision.App, process string) ([]unit, error) {
+	if process != "" {
+		var err error
+		process, err = resolveProcess(a, process)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return listUnitsByProcess(a.GetName(), process)
+}
+
+func (p *localProvisioner) AddUnits(a provision.App, n uint, process string, w io.Writer) ([]provision.Unit, error) {
+	if _, err := os.Stat(releaseDir(a.GetName())); err != nil {
+		return nil, errors.New("New units can only be added after the first deployment")
+	}
+	process, err := resolveProcess(a, process)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	writer := &app.LogWriter{App: a, Writer: w}
+	units, err := runCreateUnitsPipeline(writer, a, map[string]int{process: int(n)})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]provision.Unit, len(units))
+	for i, u := range units {
+		result[i] = u.asUnit()
+	}
+	return result, nil
+}
+
+func (p *localProvisioner) RemoveUnits(a provision.App, n uint, process string) error {
+	if a == nil {
+		return errors.New("remove units: app should not be nil")
+	}
+	if n < 1 {
+		return errors.New("remove units: units must be at least 1")
+	}
+	process, err := resolveProcess(a, process)
+	if err != nil {
+		return err
+	}
+	units, err := listUnitsByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	if n >= uint(len(units)) {
+		return errors.New("remove units: cannot remove all units from app")
+	}
+	units, err = listUnitsByProcess(a.GetName(), process)
+	if err != nil {
+		return err
+	}
+	if n > uint(len(units)) {
+		return fmt.Errorf("remove units: process %q has only %d units", process, len(units))
+	}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	for _, u := range units[:n] {
+		if u.routable() {
+			err = r.RemoveRoute(u.AppName, u.getAddress())
+			if err != nil && err != router.ErrRouteNotFound {
+				log.Errorf("Failed to remove route of unit %q: %s", u.Name, err)
+			}
+		}
+		provUnit := u.asUnit()
+		err = a.UnbindUnit(&provUnit)
+		if err != nil {
+			log.Errorf("Failed to unbind unit %q: %s", u.Name, err)
+		}
+		err = u.remove(a)
+		if err != nil {
+			log.Errorf("Failed to remove unit %q: %s", u.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) RemoveUnit(provUnit provision.Unit) error {
+	u, err := getUnit(provUnit.Name)
+	if err != nil {
+		return err
+	}
+	a, err := app.GetByName(u.AppName)
+	if err != nil {
+		return err
+	}
+	if u.routable() {
+		r, err := getRouterForApp(a)
+		if err != nil {
+			return err
+		}
+		err = r.RemoveRoute(u.AppName, u.getAddress())
+		if err != nil && err != router.ErrRouteNotFound {
+			log.Errorf("Failed to remove route of unit %q: %s", u.Name, err)
+		}
+	}
+	err = a.UnbindUnit(&provUnit)
+	if err != nil {
+		log.Errorf("Failed to unbind unit %q: %s", u.Name, err)
+	}
+	return u.remove(a)
+}
+
+func (p *localProvisioner) SetUnitStatus(provUnit provision.Unit, status provision.Status) error {
+	u, err := getUnit(provUnit.Name)
+	if err != nil {
+		return err
+	}
+	if u.AppName != provUnit.AppName {
+		return errors.New("wrong app name")
+	}
+	return u.setStatus(status.String())
+}
+
+func (p *localProvisioner) RegisterUnit(provUnit provision.Unit) error {
+	u, err := getUnit(provUnit.Name)
+	if err != nil {
+		return err
+	}
+	return u.setStatus(provision.StatusStarted.String())
+}
+
+func (p *localProvisioner) ExecuteCommand(stdout, stderr io.Writer, a provision.App, cmd string, args ...string) error {
+	units, err := listUnitsByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return provision.ErrEmptyApp
+	}
+	script := strings.Join(append([]string{cmd}, args...), " ")
+	for _, u := range units {
+		err = u.shell(a, stdout, stderr, script)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) ExecuteCommandOnce(stdout, stderr io.Writer, a provision.App, cmd string, args ...string) error {
+	units, err := listUnitsByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return provision.ErrEmptyApp
+	}
+	script := strings.Join(append([]string{cmd}, args...), " ")
+	return units[0].shell(a, stdout, stderr, script)
+}
+
+func (p *localProvisioner) Restart(a provision.App, process string, w io.Writer) error {
+	units, err := listUnitsToHandle(a, process)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	writer := &app.LogWriter{App: a, Writer: w}
+	for _, u := range units {
+		err = u.stop(a)
+		if err != nil {
+			return err
+		}
+		err = u.start(a)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, " ---> Restarted unit %s\n", u.Name)
+	}
+	return nil
+}
+
+func (p *localProvisioner) Start(a provision.App, process string) error {
+	units, err := listUnitsToHandle(a, process)
+	if provision.IsProcessError(err) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Got error while getting app units: %s", err)
+	}
+	for _, u := range units {
+		if u.Status != provision.StatusStopped.String() {
+			continue
+		}
+		err = u.start(a)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) Stop(a provision.App, process string) error {
+	units, err := listUnitsToHandle(a, process)
+	if provision.IsProcessError(err) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Got error while getting app units: %s", err)
+	}
+	for _, u := range units {
+		err = u.stop(a)
+		if err != nil {
+			log.Errorf("Failed to stop %q: %s", a.GetName(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) Addr(a provision.App) (string, error) {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return "", err
+	}
+	return r.Addr(a.GetName())
+}
+
+func (p *localProvisioner) Swap(app1, app2 provision.App) error {
+	r, err := getRouterForApp(app1)
+	if err != nil {
+		return err
+	}
+	return r.Swap(app1.GetName(), app2.GetName())
+}
+
+func (p *localProvisioner) Units(a provision.App) []provision.Unit {
+	units, err := listUnitsByApp(a.GetName())
+	if err != nil {
+		return nil
+	}
+	result := make([]provision.Unit, len(units))
+	for i, u := range units {
+		result[i] = u.asUnit()
+	}
+	return result
+}
+
+func (p *localProvisioner) SetCName(a provision.App, cname string) error {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	return r.SetCName(cname, a.GetName())
+}
+
+func (p *localProvisioner) UnsetCName(a provision.App, cname string) error {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	return r.UnsetCName(cname, a.GetName())
+}
@@ -0,0 +1,425 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/config"
+	etesting "github.com/tsuru/tsuru/exec/testing"
+	"github.com/tsuru/tsuru/provision"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	"github.com/tsuru/tsuru/testing"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestShouldBeRegistered(c *gocheck.C) {
+	p, err := provision.Get("local")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(p, gocheck.FitsTypeOf, &localProvisioner{})
+}
+
+func (s *S) TestImplementsDeployers(c *gocheck.C) {
+	var p interface{} = &localProvisioner{}
+	_, ok := p.(provision.ArchiveDeployer)
+	c.Assert(ok, gocheck.Equals, true)
+	_, ok = p.(provision.UploadDeployer)
+	c.Assert(ok, gocheck.Equals, true)
+	_, ok = p.(provision.CNameManager)
+	c.Assert(ok, gocheck.Equals, true)
+	_, ok = p.(provision.GitDeployer)
+	c.Assert(ok, gocheck.Equals, false)
+}
+
+func (s *S) TestProvision(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	var p localProvisioner
+	err := p.Provision(a)
+	c.Assert(err, gocheck.IsNil)
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	c.Assert(rtesting.FakeRouter.HasBackend("myapp"), gocheck.Equals, true)
+	c.Assert(a.IsReady(), gocheck.Equals, true)
+}
+
+func (s *S) TestDestroy(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	s.newUnits(c, a, 2)
+	var p localProvisioner
+	err := p.Destroy(a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasBackend("myapp"), gocheck.Equals, false)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 0)
+	fexec := execut.(*etesting.FakeExecutor)
+	appDir := filepath.Join(s.workDir, "apps", "myapp")
+	c.Assert(fexec.ExecutedCmd("rm", []string{"-rf", appDir}), gocheck.Equals, true)
+}
+
+func (s *S) TestArchiveDeploy(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	old := s.newUnits(c, a, 1)
+	var buf bytes.Buffer
+	var p localProvisioner
+	_, err := p.ArchiveDeploy(a, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
+	c.Assert(err, gocheck.IsNil)
+	dir := releaseDir("myapp")
+	fexec := execut.(*etesting.FakeExecutor)
+	c.Assert(fexec.ExecutedCmd("rm", []string{"-rf", dir}), gocheck.Equals, true)
+	c.Assert(fexec.ExecutedCmd("mkdir", []string{"-p", dir}), gocheck.Equals, true)
+	c.Assert(fexec.ExecutedCmd("curl", []string{"-sSL", "--url", "https://s3.amazonaws.com/smt/archive.tar.gz"}), gocheck.Equals, true)
+	c.Assert(fexec.ExecutedCmd("tar", []string{"-xz", "-C", dir}), gocheck.Equals, true)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 1)
+	c.Assert(units[0].Name, gocheck.Not(gocheck.Equals), old[0].Name)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", units[0].getAddress()), gocheck.Equals, true)
+}
+
+func (s *S) TestArchiveDeployDoesNotRunURLInShell(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	archiveURL := "https://example.com/$(touch /tmp/pwned)`id`.tar.gz"
+	var p localProvisioner
+	_, err := p.ArchiveDeploy(a, archiveURL, nil)
+	c.Assert(err, gocheck.IsNil)
+	fexec := execut.(*etesting.FakeExecutor)
+	c.Assert(fexec.ExecutedCmd("curl", []string{"-sSL", "--url", archiveURL}), gocheck.Equals, true)
+	for _, cmd := range fexec.GetCommands("/bin/bash") {
+		c.Assert(strings.Join(cmd.GetArgs(), " "), gocheck.Not(gocheck.Matches), ".*pwned.*")
+	}
+}
+
+func (s *S) TestArchiveDeployProcfile(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	s.newProcfile(c, a, "web: python app.py\nworker: python worker.py\n")
+	s.newUnits(c, a, 2)
+	var p localProvisioner
+	_, err := p.ArchiveDeploy(a, "https://s3.amazonaws.com/smt/archive.tar.gz", nil)
+	c.Assert(err, gocheck.IsNil)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(unitsByProcess(units), gocheck.DeepEquals, map[string]int{"web": 2, "worker": 1})
+	for _, u := range units {
+		routed := rtesting.FakeRouter.HasRoute("myapp", u.getAddress())
+		c.Assert(routed, gocheck.Equals, u.ProcessName == "web")
+	}
+}
+
+func (s *S) TestArchiveDeployRunsDeployCmd(c *gocheck.C) {
+	config.Set("local:deploy-cmd", "/var/lib/tsuru/deploy")
+	defer config.Unset("local:deploy-cmd")
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	var p localProvisioner
+	_, err := p.ArchiveDeploy(a, "https://s3.amazonaws.com/smt/archive.tar.gz", nil)
+	c.Assert(err, gocheck.IsNil)
+	args := []string{"-lc", fmt.Sprintf("cd %s && /var/lib/tsuru/deploy", releaseDir("myapp"))}
+	fexec := execut.(*etesting.FakeExecutor)
+	c.Assert(fexec.ExecutedCmd("/bin/bash", args), gocheck.Equals, true)
+}
+
+func (s *S) TestArchiveDeployFailure(c *gocheck.C) {
+	execut = &etesting.ErrorExecutor{}
+	a := testing.NewFakeApp("myapp", "python", 0)
+	var p localProvisioner
+	_, err := p.ArchiveDeploy(a, "https://s3.amazonaws.com/smt/archive.tar.gz", nil)
+	c.Assert(err, gocheck.NotNil)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 0)
+}
+
+func (s *S) TestUploadDeploy(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	var p localProvisioner
+	file := ioutil.NopCloser(bytes.NewBufferString("my file"))
+	_, err := p.UploadDeploy(a, file, nil)
+	c.Assert(err, gocheck.IsNil)
+	archivePath := filepath.Join(s.workDir, "apps", "myapp", "archive.tar.gz")
+	content, err := ioutil.ReadFile(archivePath)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(content), gocheck.Equals, "my file")
+	fexec := execut.(*etesting.FakeExecutor)
+	args := []string{"-xzf", archivePath, "-C", releaseDir("myapp")}
+	c.Assert(fexec.ExecutedCmd("tar", args), gocheck.Equals, true)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 1)
+}
+
+func (s *S) TestAddUnits(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	s.newRelease(c, a)
+	var p localProvisioner
+	units, err := p.AddUnits(a, 3, "", nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 3)
+	for _, u := range units {
+		c.Assert(u.AppName, gocheck.Equals, "myapp")
+		c.Assert(u.Status, gocheck.Equals, provision.StatusStarting)
+	}
+	dbUnits, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbUnits, gocheck.HasLen, 3)
+}
+
+func (s *S) TestAddUnitsProcess(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	s.newProcfile(c, a, "web: python app.py\nworker: python worker.py\n")
+	var p localProvisioner
+	units, err := p.AddUnits(a, 2, "worker", nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(units, gocheck.HasLen, 2)
+	for _, u := range units {
+		c.Assert(u.ProcessName, gocheck.Equals, "worker")
+	}
+	routes, err := rtesting.FakeRouter.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.HasLen, 0)
+}
+
+func (s *S) TestAddUnitsInvalidProcess(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newProcfile(c, a, "web: python app.py\n")
+	var p localProvisioner
+	_, err := p.AddUnits(a, 1, "worker", nil)
+	c.Assert(err, gocheck.FitsTypeOf, &provision.InvalidProcessError{})
+}
+
+func (s *S) TestAddUnitsWithoutDeploy(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	var p localProvisioner
+	units, err := p.AddUnits(a, 1, "", nil)
+	c.Assert(units, gocheck.IsNil)
+	c.Assert(err, gocheck.ErrorMatches, "New units can only be added after the first deployment")
+}
+
+func (s *S) TestRemoveUnits(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	units := s.newUnits(c, a, 3)
+	for _, u := range units {
+		rtesting.FakeRouter.AddRoute("myapp", u.getAddress())
+	}
+	var p localProvisioner
+	err := p.RemoveUnits(a, 2, "")
+	c.Assert(err, gocheck.IsNil)
+	remaining, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(remaining, gocheck.HasLen, 1)
+	routes, err := rtesting.FakeRouter.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{remaining[0].getAddress()})
+}
+
+func (s *S) TestRemoveUnitsAllUnits(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newUnits(c, a, 2)
+	var p localProvisioner
+	err := p.RemoveUnits(a, 2, "")
+	c.Assert(err, gocheck.ErrorMatches, "remove units: cannot remove all units from app")
+	err = p.RemoveUnits(a, 0, "")
+	c.Assert(err, gocheck.ErrorMatches, "remove units: units must be at least 1")
+}
+
+func (s *S) TestRemoveUnitsProcess(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newProcfile(c, a, "web: python app.py\nworker: python worker.py\n")
+	web := s.newUnits(c, a, 1)
+	s.newProcessUnits(c, a, 2, "worker")
+	var p localProvisioner
+	err := p.RemoveUnits(a, 3, "worker")
+	c.Assert(err, gocheck.ErrorMatches, "remove units: cannot remove all units from app")
+	err = p.RemoveUnits(a, 2, "web")
+	c.Assert(err, gocheck.ErrorMatches, `remove units: process "web" has only 1 units`)
+	err = p.RemoveUnits(a, 2, "worker")
+	c.Assert(err, gocheck.IsNil)
+	remaining, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(remaining, gocheck.HasLen, 1)
+	c.Assert(remaining[0].Name, gocheck.Equals, web[0].Name)
+}
+
+func (s *S) TestSetUnitStatus(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := s.newUnits(c, a, 1)[0]
+	var p localProvisioner
+	err := p.SetUnitStatus(u.asUnit(), provision.StatusError)
+	c.Assert(err, gocheck.IsNil)
+	dbUnit, err := getUnit(u.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbUnit.Status, gocheck.Equals, provision.StatusError.String())
+}
+
+func (s *S) TestSetUnitStatusWrongApp(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := s.newUnits(c, a, 1)[0]
+	provUnit := u.asUnit()
+	provUnit.AppName = "otherapp"
+	var p localProvisioner
+	err := p.SetUnitStatus(provUnit, provision.StatusError)
+	c.Assert(err, gocheck.ErrorMatches, "wrong app name")
+}
+
+func (s *S) TestRegisterUnit(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := s.newUnits(c, a, 1)[0]
+	u.setStatus(provision.StatusStarting.String())
+	var p localProvisioner
+	err := p.RegisterUnit(u.asUnit())
+	c.Assert(err, gocheck.IsNil)
+	dbUnit, err := getUnit(u.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbUnit.Status, gocheck.Equals, provision.StatusStarted.String())
+}
+
+func (s *S) TestExecuteCommand(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	units := s.newUnits(c, a, 2)
+	var p localProvisioner
+	err := p.ExecuteCommand(nil, nil, a, "ls", "-lh")
+	c.Assert(err, gocheck.IsNil)
+	fexec := execut.(*etesting.FakeExecutor)
+	for _, u := range units {
+		args := []string{"-lc", fmt.Sprintf("cd %s && ls -lh", u.dir())}
+		c.Assert(fexec.ExecutedCmd("/bin/bash", args), gocheck.Equals, true)
+	}
+}
+
+func (s *S) TestExecuteCommandNoUnits(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	var p localProvisioner
+	err := p.ExecuteCommand(nil, nil, a, "ls", "-lh")
+	c.Assert(err, gocheck.Equals, provision.ErrEmptyApp)
+}
+
+func (s *S) TestExecuteCommandOnce(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newUnits(c, a, 2)
+	fexec := &etesting.FakeExecutor{}
+	execut = fexec
+	var p localProvisioner
+	err := p.ExecuteCommandOnce(nil, nil, a, "ls", "-lh")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(fexec.GetCommands("/bin/bash"), gocheck.HasLen, 1)
+}
+
+func (s *S) TestRestart(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newUnits(c, a, 2)
+	fexec := &etesting.FakeExecutor{}
+	execut = fexec
+	var buf bytes.Buffer
+	var p localProvisioner
+	err := p.Restart(a, "", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(fexec.GetCommands("/bin/bash"), gocheck.HasLen, 4)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	for _, u := range units {
+		c.Assert(u.Status, gocheck.Equals, provision.StatusStarting.String())
+	}
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*---> Restarted unit myapp-.*")
+}
+
+func (s *S) TestRestartProcess(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newProcfile(c, a, "web: python app.py\nworker: python worker.py\n")
+	s.newUnits(c, a, 2)
+	workers := s.newProcessUnits(c, a, 1, "worker")
+	fexec := &etesting.FakeExecutor{}
+	execut = fexec
+	var buf bytes.Buffer
+	var p localProvisioner
+	err := p.Restart(a, "worker", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(fexec.GetCommands("/bin/bash"), gocheck.HasLen, 2)
+	c.Assert(buf.String(), gocheck.Matches, fmt.Sprintf("(?s).*---> Restarted unit %s.*", workers[0].Name))
+}
+
+func (s *S) TestRestartInvalidProcess(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newUnits(c, a, 1)
+	var p localProvisioner
+	err := p.Restart(a, "worker", nil)
+	c.Assert(err, gocheck.FitsTypeOf, &provision.InvalidProcessError{})
+	err = p.Stop(a, "worker")
+	c.Assert(err, gocheck.FitsTypeOf, &provision.InvalidProcessError{})
+}
+
+func (s *S) TestStopAndStart(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newUnits(c, a, 2)
+	var p localProvisioner
+	err := p.Stop(a, "")
+	c.Assert(err, gocheck.IsNil)
+	units, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	for _, u := range units {
+		c.Assert(u.Status, gocheck.Equals, provision.StatusStopped.String())
+	}
+	err = p.Start(a, "")
+	c.Assert(err, gocheck.IsNil)
+	units, err = listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	for _, u := range units {
+		c.Assert(u.Status, gocheck.Equals, provision.StatusStarting.String())
+	}
+}
+
+func (s *S) TestUnits(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	units := s.newUnits(c, a, 2)
+	var p localProvisioner
+	result := p.Units(a)
+	c.Assert(result, gocheck.HasLen, 2)
+	names := map[string]bool{units[0].Name: true, units[1].Name: true}
+	for _, u := range result {
+		c.Assert(names[u.Name], gocheck.Equals, true)
+	}
+}
+
+func (s *S) TestAddr(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	rtesting.FakeRouter.AddRoute("myapp", "http://127.0.0.1:8080")
+	var p localProvisioner
+	addr, err := p.Addr(a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "http://127.0.0.1:8080")
+}
+
+func (s *S) TestSetCNameAndUnsetCName(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	rtesting.FakeRouter.AddBackend("myapp")
+	defer rtesting.FakeRouter.RemoveBackend("myapp")
+	var p localProvisioner
+	err := p.SetCName(a, "myapp.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasBackend("myapp.com"), gocheck.Equals, true)
+	err = p.UnsetCName(a, "myapp.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasBackend("myapp.com"), gocheck.Equals, false)
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	etesting "github.com/tsuru/tsuru/exec/testing"
+	"github.com/tsuru/tsuru/provision"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	"launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) { gocheck.TestingT(t) }
+
+type S struct {
+	workDir string
+	storage *db.Storage
+}
+
+var _ = gocheck.Suite(&S{})
+
+func (s *S) SetUpSuite(c *gocheck.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "local_provision_tests")
+	config.Set("docker:router", "fake")
+	config.Set("local:collection", "local_units")
+	config.Set("local:host", "127.0.0.1")
+	config.Set("local:run-cmd", "/var/lib/tsuru/start")
+	config.Set("queue", "fake")
+	var err error
+	s.storage, err = db.Conn()
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) SetUpTest(c *gocheck.C) {
+	var err error
+	s.workDir, err = ioutil.TempDir("", "tsuru-local")
+	c.Assert(err, gocheck.IsNil)
+	config.Set("local:work-dir", s.workDir)
+	execut = &etesting.FakeExecutor{}
+	rtesting.FakeRouter.Reset()
+}
+
+func (s *S) TearDownTest(c *gocheck.C) {
+	execut = nil
+	os.RemoveAll(s.workDir)
+	coll := collection()
+	defer coll.Close()
+	coll.RemoveAll(nil)
+}
+
+func (s *S) TearDownSuite(c *gocheck.C) {
+	defer s.storage.Close()
+	s.storage.Apps().Database.DropDatabase()
+}
+
+// newRelease creates the release directory of the given app, as if it had
+// been deployed.
+func (s *S) newRelease(c *gocheck.C, a provision.App) {
+	err := os.MkdirAll(releaseDir(a.GetName()), 0755)
+	c.Assert(err, gocheck.IsNil)
+}
+
+// newProcfile writes a Procfile with the given content to the release
+// directory of the given app.
+func (s *S) newProcfile(c *gocheck.C, a provision.App, content string) {
+	s.newRelease(c, a)
+	err := ioutil.WriteFile(filepath.Join(releaseDir(a.GetName()), "Procfile"), []byte(content), 0644)
+	c.Assert(err, gocheck.IsNil)
+}
+
+// newUnits creates n web units of the given app directly in the database.
+func (s *S) newUnits(c *gocheck.C, a provision.App, n int) []unit {
+	return s.newProcessUnits(c, a, n, provision.WebProcessName)
+}
+
+// newProcessUnits creates n units of the given process directly in the
+// database.
+func (s *S) newProcessUnits(c *gocheck.C, a provision.App, n int, process string) []unit {
+	units := make([]unit, n)
+	for i := range units {
+		u, err := newUnit(a, process)
+		c.Assert(err, gocheck.IsNil)
+		u.Status = provision.StatusStarted.String()
+		err = u.create(a)
+		c.Assert(err, gocheck.IsNil)
+		units[i] = *u
+	}
+	return units
+}
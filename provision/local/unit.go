@@ -0,0 +1,297 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var execut exec.Executor
+
+func executor() exec.Executor {
+	if execut == nil {
+		execut = exec.OsExecutor{}
+	}
+	return execut
+}
+
+func collection() *storage.Collection {
+	name, err := config.GetString("local:collection")
+	if err != nil {
+		name = "local_units"
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("Failed to connect to the database: %s", err)
+	}
+	return conn.Collection(name)
+}
+
+func workDir() string {
+	dir, err := config.GetString("local:work-dir")
+	if err != nil {
+		dir = "/var/lib/tsuru/local"
+	}
+	return dir
+}
+
+func hostAddr() string {
+	host, err := config.GetString("local:host")
+	if err != nil {
+		host = "127.0.0.1"
+	}
+	return host
+}
+
+// releaseDir returns the directory holding the last deployed code of the
+// given app.
+func releaseDir(appName string) string {
+	return filepath.Join(workDir(), "apps", appName, "release")
+}
+
+// appProcesses returns the processes declared in the Procfile of the last
+// release of the app. Apps without a Procfile have no declared processes.
+func appProcesses(appName string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(releaseDir(appName), "Procfile"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return provision.ParseProcfile(string(content))
+}
+
+func unitName(appName string) string {
+	b := make([]byte, 5)
+	io.ReadFull(rand.Reader, b)
+	return fmt.Sprintf("%s-%x", appName, b)
+}
+
+// freePort asks the kernel for a free TCP port in the host address.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", hostAddr()+":0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// unit represents a process running an app in the local host.
+type unit struct {
+	Name             string `bson:"_id"`
+	AppName          string
+	ProcessName      string
+	Type             string
+	Host             string
+	Port             int
+	Status           string
+	LastStatusUpdate time.Time
+}
+
+func (u *unit) dir() string {
+	return filepath.Join(workDir(), "units", u.Name)
+}
+
+func (u *unit) pidFile() string {
+	return filepath.Join(u.dir(), "unit.pid")
+}
+
+func (u *unit) logFile() string {
+	return filepath.Join(u.dir(), "unit.log")
+}
+
+// routable returns true if the unit runs the web process of the app.
+func (u *unit) routable() bool {
+	return u.ProcessName == provision.WebProcessName
+}
+
+func (u *unit) getAddress() string {
+	return fmt.Sprintf("http://%s:%d", u.Host, u.Port)
+}
+
+func (u *unit) asUnit() provision.Unit {
+	return provision.Unit{
+		Name:        u.Name,
+		AppName:     u.AppName,
+		ProcessName: u.ProcessName,
+		Type:        u.Type,
+		Ip:          u.Host,
+		Status:      provision.Status(u.Status),
+	}
+}
+
+func (u *unit) env(a provision.App) []string {
+	return append(appEnvs(a), fmt.Sprintf("PORT=%d", u.Port))
+}
+
+func appEnvs(a provision.App) []string {
+	var envs []string
+	for _, env := range a.Envs() {
+		envs = append(envs, fmt.Sprintf("%s=%s", env.Name, env.Value))
+	}
+	return envs
+}
+
+// shell runs the given shell script through the configured executor, using
+// the unit directory as working directory.
+func (u *unit) shell(a provision.App, stdout, stderr io.Writer, script string) error {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	opts := exec.ExecuteOptions{
+		Cmd:    "/bin/bash",
+		Args:   []string{"-lc", fmt.Sprintf("cd %s && %s", u.dir(), script)},
+		Envs:   u.env(a),
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	return executor().Execute(opts)
+}
+
+// create copies the current release of the app to the unit directory and
+// stores the unit in the database.
+func (u *unit) create(a provision.App) error {
+	var stderr bytes.Buffer
+	opts := exec.ExecuteOptions{
+		Cmd:    "/bin/bash",
+		Args:   []string{"-c", fmt.Sprintf("rm -rf %[1]s && mkdir -p %[1]s && cp -a %[2]s/. %[1]s", u.dir(), releaseDir(a.GetName()))},
+		Stdout: ioutil.Discard,
+		Stderr: &stderr,
+	}
+	err := executor().Execute(opts)
+	if err != nil {
+		return fmt.Errorf("failed to create unit directory: %s (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+	coll := collection()
+	defer coll.Close()
+	return coll.Insert(u)
+}
+
+// start starts the unit process in background, storing its pid in the unit
+// directory. The unit runs the command of its process, as declared in the
+// Procfile of the app, or the configured run command, when the app has no
+// Procfile.
+func (u *unit) start(a provision.App) error {
+	processes, err := appProcesses(u.AppName)
+	if err != nil {
+		return err
+	}
+	runCmd, ok := processes[u.ProcessName]
+	if !ok {
+		runCmd, err = config.GetString("local:run-cmd")
+		if err != nil {
+			runCmd = "/var/lib/tsuru/start"
+		}
+	}
+	script := fmt.Sprintf("nohup %s >> %s 2>&1 < /dev/null & echo $! > %s", runCmd, u.logFile(), u.pidFile())
+	err = u.shell(a, nil, nil, script)
+	if err != nil {
+		return err
+	}
+	return u.setStatus(provision.StatusStarting.String())
+}
+
+// stop kills the unit process, if it's running.
+func (u *unit) stop(a provision.App) error {
+	if u.Status == provision.StatusStopped.String() {
+		return nil
+	}
+	script := fmt.Sprintf("[ -f %[1]s ] && kill $(cat %[1]s) && rm -f %[1]s || true", u.pidFile())
+	err := u.shell(a, nil, nil, script)
+	if err != nil {
+		log.Errorf("error on stop unit %s: %s", u.Name, err)
+	}
+	return u.setStatus(provision.StatusStopped.String())
+}
+
+// remove stops the unit process and removes its directory and database
+// entry.
+func (u *unit) remove(a provision.App) error {
+	u.stop(a)
+	opts := exec.ExecuteOptions{
+		Cmd:    "rm",
+		Args:   []string{"-rf", u.dir()},
+		Stdout: ioutil.Discard,
+		Stderr: ioutil.Discard,
+	}
+	err := executor().Execute(opts)
+	if err != nil {
+		log.Errorf("Failed to remove directory of unit %s: %s", u.Name, err)
+	}
+	coll := collection()
+	defer coll.Close()
+	return coll.RemoveId(u.Name)
+}
+
+func (u *unit) setStatus(status string) error {
+	u.Status = status
+	u.LastStatusUpdate = time.Now().In(time.UTC)
+	coll := collection()
+	defer coll.Close()
+	return coll.UpdateId(u.Name, bson.M{"$set": bson.M{
+		"status":           u.Status,
+		"laststatusupdate": u.LastStatusUpdate,
+	}})
+}
+
+func getUnit(name string) (*unit, error) {
+	coll := collection()
+	defer coll.Close()
+	var u unit
+	err := coll.FindId(name).One(&u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func listUnitsByApp(appName string) ([]unit, error) {
+	return listUnitsByProcess(appName, "")
+}
+
+// listUnitsByProcess returns the units of the given process, or all units of
+// the app when the process is empty.
+func listUnitsByProcess(appName, process string) ([]unit, error) {
+	coll := collection()
+	defer coll.Close()
+	query := bson.M{"appname": appName}
+	if process != "" {
+		query["processname"] = process
+	}
+	var units []unit
+	err := coll.Find(query).Sort("_id").All(&units)
+	return units, err
+}
+
+// unitsByProcess returns the number of units running each process.
+func unitsByProcess(units []unit) map[string]int {
+	result := make(map[string]int)
+	for _, u := range units {
+		result[u.ProcessName]++
+	}
+	return result
+}
@@ -0,0 +1,231 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
+	etesting "github.com/tsuru/tsuru/exec/testing"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/testing"
+	"gopkg.in/mgo.v2"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestCollectionDefaultName(c *gocheck.C) {
+	old, _ := config.GetString("local:collection")
+	defer config.Set("local:collection", old)
+	config.Unset("local:collection")
+	coll := collection()
+	defer coll.Close()
+	c.Assert(coll.Name, gocheck.Equals, "local_units")
+}
+
+func (s *S) TestWorkDir(c *gocheck.C) {
+	c.Assert(workDir(), gocheck.Equals, s.workDir)
+	config.Unset("local:work-dir")
+	defer config.Set("local:work-dir", s.workDir)
+	c.Assert(workDir(), gocheck.Equals, "/var/lib/tsuru/local")
+}
+
+func (s *S) TestReleaseDir(c *gocheck.C) {
+	c.Assert(releaseDir("myapp"), gocheck.Equals, filepath.Join(s.workDir, "apps", "myapp", "release"))
+}
+
+func (s *S) TestUnitName(c *gocheck.C) {
+	name := unitName("myapp")
+	c.Assert(strings.HasPrefix(name, "myapp-"), gocheck.Equals, true)
+	c.Assert(name, gocheck.HasLen, len("myapp-")+10)
+	c.Assert(unitName("myapp"), gocheck.Not(gocheck.Equals), name)
+}
+
+func (s *S) TestFreePort(c *gocheck.C) {
+	port, err := freePort()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(port > 0, gocheck.Equals, true)
+}
+
+func (s *S) TestUnitGetAddress(c *gocheck.C) {
+	u := unit{Name: "myapp-abc", Host: "127.0.0.1", Port: 8080}
+	c.Assert(u.getAddress(), gocheck.Equals, "http://127.0.0.1:8080")
+}
+
+func (s *S) TestUnitDirs(c *gocheck.C) {
+	u := unit{Name: "myapp-abc"}
+	dir := filepath.Join(s.workDir, "units", "myapp-abc")
+	c.Assert(u.dir(), gocheck.Equals, dir)
+	c.Assert(u.pidFile(), gocheck.Equals, filepath.Join(dir, "unit.pid"))
+	c.Assert(u.logFile(), gocheck.Equals, filepath.Join(dir, "unit.log"))
+}
+
+func (s *S) TestUnitAsUnit(c *gocheck.C) {
+	u := unit{
+		Name:        "myapp-abc",
+		AppName:     "myapp",
+		ProcessName: "worker",
+		Type:        "python",
+		Host:        "127.0.0.1",
+		Port:        8080,
+		Status:      provision.StatusStarted.String(),
+	}
+	expected := provision.Unit{
+		Name:        "myapp-abc",
+		AppName:     "myapp",
+		ProcessName: "worker",
+		Type:        "python",
+		Ip:          "127.0.0.1",
+		Status:      provision.StatusStarted,
+	}
+	c.Assert(u.asUnit(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestUnitRoutable(c *gocheck.C) {
+	u := unit{ProcessName: "web"}
+	c.Assert(u.routable(), gocheck.Equals, true)
+	u.ProcessName = "worker"
+	c.Assert(u.routable(), gocheck.Equals, false)
+}
+
+func (s *S) TestAppProcesses(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newProcfile(c, a, "web: python app.py\nworker: python worker.py\n")
+	processes, err := appProcesses("myapp")
+	c.Assert(err, gocheck.IsNil)
+	expected := map[string]string{"web": "python app.py", "worker": "python worker.py"}
+	c.Assert(processes, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAppProcessesWithoutProcfile(c *gocheck.C) {
+	processes, err := appProcesses("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(processes, gocheck.IsNil)
+}
+
+func (s *S) TestUnitEnv(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	a.SetEnv(bind.EnvVar{Name: "DATABASE_HOST", Value: "localhost"})
+	u := unit{Name: "myapp-abc", Port: 8080}
+	c.Assert(u.env(a), gocheck.DeepEquals, []string{"DATABASE_HOST=localhost", "PORT=8080"})
+}
+
+func (s *S) TestUnitCreate(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := unit{Name: "myapp-abc", AppName: "myapp", Port: 8080}
+	err := u.create(a)
+	c.Assert(err, gocheck.IsNil)
+	script := fmt.Sprintf("rm -rf %[1]s && mkdir -p %[1]s && cp -a %[2]s/. %[1]s", u.dir(), releaseDir("myapp"))
+	fexec := execut.(*etesting.FakeExecutor)
+	c.Assert(fexec.ExecutedCmd("/bin/bash", []string{"-c", script}), gocheck.Equals, true)
+	dbUnit, err := getUnit("myapp-abc")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbUnit.Port, gocheck.Equals, 8080)
+}
+
+func (s *S) TestUnitCreateFailure(c *gocheck.C) {
+	execut = &etesting.ErrorExecutor{}
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := unit{Name: "myapp-abc", AppName: "myapp"}
+	err := u.create(a)
+	c.Assert(err, gocheck.NotNil)
+	_, err = getUnit("myapp-abc")
+	c.Assert(err, gocheck.Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestUnitStart(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := s.newUnits(c, a, 1)[0]
+	err := u.start(a)
+	c.Assert(err, gocheck.IsNil)
+	script := fmt.Sprintf("nohup /var/lib/tsuru/start >> %s 2>&1 < /dev/null & echo $! > %s", u.logFile(), u.pidFile())
+	fexec := execut.(*etesting.FakeExecutor)
+	args := []string{"-lc", fmt.Sprintf("cd %s && %s", u.dir(), script)}
+	c.Assert(fexec.ExecutedCmd("/bin/bash", args), gocheck.Equals, true)
+	dbUnit, err := getUnit(u.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbUnit.Status, gocheck.Equals, provision.StatusStarting.String())
+}
+
+func (s *S) TestUnitStartProcfileCommand(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newProcfile(c, a, "web: python app.py\nworker: python worker.py\n")
+	u := s.newProcessUnits(c, a, 1, "worker")[0]
+	err := u.start(a)
+	c.Assert(err, gocheck.IsNil)
+	script := fmt.Sprintf("nohup python worker.py >> %s 2>&1 < /dev/null & echo $! > %s", u.logFile(), u.pidFile())
+	fexec := execut.(*etesting.FakeExecutor)
+	args := []string{"-lc", fmt.Sprintf("cd %s && %s", u.dir(), script)}
+	c.Assert(fexec.ExecutedCmd("/bin/bash", args), gocheck.Equals, true)
+}
+
+func (s *S) TestUnitStop(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := s.newUnits(c, a, 1)[0]
+	err := u.stop(a)
+	c.Assert(err, gocheck.IsNil)
+	script := fmt.Sprintf("[ -f %[1]s ] && kill $(cat %[1]s) && rm -f %[1]s || true", u.pidFile())
+	fexec := execut.(*etesting.FakeExecutor)
+	args := []string{"-lc", fmt.Sprintf("cd %s && %s", u.dir(), script)}
+	c.Assert(fexec.ExecutedCmd("/bin/bash", args), gocheck.Equals, true)
+	dbUnit, err := getUnit(u.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbUnit.Status, gocheck.Equals, provision.StatusStopped.String())
+}
+
+func (s *S) TestUnitStopAlreadyStopped(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := unit{Name: "myapp-abc", Status: provision.StatusStopped.String()}
+	err := u.stop(a)
+	c.Assert(err, gocheck.IsNil)
+	fexec := execut.(*etesting.FakeExecutor)
+	c.Assert(fexec.GetCommands("/bin/bash"), gocheck.HasLen, 0)
+}
+
+func (s *S) TestUnitRemove(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	u := s.newUnits(c, a, 1)[0]
+	err := u.remove(a)
+	c.Assert(err, gocheck.IsNil)
+	fexec := execut.(*etesting.FakeExecutor)
+	c.Assert(fexec.ExecutedCmd("rm", []string{"-rf", u.dir()}), gocheck.Equals, true)
+	_, err = getUnit(u.Name)
+	c.Assert(err, gocheck.Equals, mgo.ErrNotFound)
+}
+
+func (s *S) TestListUnitsByApp(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	other := testing.NewFakeApp("otherapp", "python", 0)
+	units := s.newUnits(c, a, 2)
+	s.newUnits(c, other, 1)
+	result, err := listUnitsByApp("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 2)
+	expected := []string{units[0].Name, units[1].Name}
+	sort.Strings(expected)
+	names := []string{result[0].Name, result[1].Name}
+	c.Assert(names, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestListUnitsByProcess(c *gocheck.C) {
+	a := testing.NewFakeApp("myapp", "python", 0)
+	s.newUnits(c, a, 2)
+	workers := s.newProcessUnits(c, a, 1, "worker")
+	result, err := listUnitsByProcess("myapp", "worker")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 1)
+	c.Assert(result[0].Name, gocheck.Equals, workers[0].Name)
+	result, err = listUnitsByProcess("myapp", "")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 3)
+}
+
+func (s *S) TestUnitsByProcess(c *gocheck.C) {
+	units := []unit{{ProcessName: "web"}, {ProcessName: "worker"}, {ProcessName: "web"}}
+	c.Assert(unitsByProcess(units), gocheck.DeepEquals, map[string]int{"web": 2, "worker": 1})
+}
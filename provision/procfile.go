@@ -0,0 +1,95 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// WebProcessName is the name of the process that receives the HTTP traffic
+// of the app. Only units of this process are added to the router.
+const WebProcessName = "web"
+
+var ErrProcessRequired = errors.New("the app has more than one process, please specify the process name")
+
+var procfileLineRE = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// InvalidProcessError is returned when a process is not declared in the
+// Procfile of the app.
+type InvalidProcessError struct {
+	Name string
+}
+
+func (e *InvalidProcessError) Error() string {
+	return fmt.Sprintf("process %q is not declared in the Procfile of the app", e.Name)
+}
+
+// IsProcessError returns true if the given error was caused by an invalid
+// process name.
+func IsProcessError(err error) bool {
+	_, ok := err.(*InvalidProcessError)
+	return ok || err == ErrProcessRequired
+}
+
+// ParseProcfile parses the content of a Procfile, returning a map of process
+// names to their commands. Blank lines and lines starting with "#" are
+// ignored.
+func ParseProcfile(content string) (map[string]string, error) {
+	processes := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := procfileLineRE.FindStringSubmatch(line)
+		if parts == nil {
+			return nil, fmt.Errorf("invalid Procfile line: %q", line)
+		}
+		processes[parts[1]] = strings.TrimSpace(parts[2])
+	}
+	return processes, nil
+}
+
+// ProcessNames returns the sorted names of the given processes. Apps without
+// a Procfile have a single process, named "web".
+func ProcessNames(processes map[string]string) []string {
+	if len(processes) == 0 {
+		return []string{WebProcessName}
+	}
+	names := make([]string, 0, len(processes))
+	for name := range processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveProcess checks that the given name is one of the processes of the
+// app. An empty name is resolved to the web process, or to the only process
+// of the app, when it doesn't declare a web process.
+func ResolveProcess(name string, processes map[string]string) (string, error) {
+	names := ProcessNames(processes)
+	if name == "" {
+		if len(names) == 1 {
+			return names[0], nil
+		}
+		for _, n := range names {
+			if n == WebProcessName {
+				return n, nil
+			}
+		}
+		return "", ErrProcessRequired
+	}
+	for _, n := range names {
+		if n == name {
+			return name, nil
+		}
+	}
+	return "", &InvalidProcessError{Name: name}
+}
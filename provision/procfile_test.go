@@ -0,0 +1,83 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provision
+
+import (
+	"launchpad.net/gocheck"
+)
+
+func (ProvisionSuite) TestParseProcfile(c *gocheck.C) {
+	content := `# processes of the app
+web: gunicorn -b 0.0.0.0:$PORT app:app
+
+worker:python worker.py
+clock_2: python clock.py --interval=10
+`
+	processes, err := ParseProcfile(content)
+	c.Assert(err, gocheck.IsNil)
+	expected := map[string]string{
+		"web":     "gunicorn -b 0.0.0.0:$PORT app:app",
+		"worker":  "python worker.py",
+		"clock_2": "python clock.py --interval=10",
+	}
+	c.Assert(processes, gocheck.DeepEquals, expected)
+}
+
+func (ProvisionSuite) TestParseProcfileEmpty(c *gocheck.C) {
+	processes, err := ParseProcfile("")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(processes, gocheck.HasLen, 0)
+}
+
+func (ProvisionSuite) TestParseProcfileInvalidLine(c *gocheck.C) {
+	_, err := ParseProcfile("web: python app.py\nworker python worker.py")
+	c.Assert(err, gocheck.ErrorMatches, `invalid Procfile line: "worker python worker.py"`)
+}
+
+func (ProvisionSuite) TestProcessNames(c *gocheck.C) {
+	names := ProcessNames(map[string]string{"worker": "python worker.py", "web": "python app.py"})
+	c.Assert(names, gocheck.DeepEquals, []string{"web", "worker"})
+}
+
+func (ProvisionSuite) TestProcessNamesWithoutProcfile(c *gocheck.C) {
+	c.Assert(ProcessNames(nil), gocheck.DeepEquals, []string{"web"})
+}
+
+func (ProvisionSuite) TestResolveProcess(c *gocheck.C) {
+	processes := map[string]string{"web": "python app.py", "worker": "python worker.py"}
+	name, err := ResolveProcess("worker", processes)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "worker")
+	name, err = ResolveProcess("", processes)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "web")
+	name, err = ResolveProcess("", nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "web")
+	name, err = ResolveProcess("", map[string]string{"worker": "python worker.py"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "worker")
+}
+
+func (ProvisionSuite) TestResolveProcessRequired(c *gocheck.C) {
+	processes := map[string]string{"clock": "python clock.py", "worker": "python worker.py"}
+	_, err := ResolveProcess("", processes)
+	c.Assert(err, gocheck.Equals, ErrProcessRequired)
+}
+
+func (ProvisionSuite) TestResolveProcessInvalid(c *gocheck.C) {
+	_, err := ResolveProcess("clock", map[string]string{"web": "python app.py"})
+	c.Assert(err, gocheck.FitsTypeOf, &InvalidProcessError{})
+	c.Assert(err, gocheck.ErrorMatches, `process "clock" is not declared in the Procfile of the app`)
+	_, err = ResolveProcess("worker", nil)
+	c.Assert(err, gocheck.FitsTypeOf, &InvalidProcessError{})
+}
+
+func (ProvisionSuite) TestIsProcessError(c *gocheck.C) {
+	c.Assert(IsProcessError(&InvalidProcessError{Name: "worker"}), gocheck.Equals, true)
+	c.Assert(IsProcessError(ErrProcessRequired), gocheck.Equals, true)
+	c.Assert(IsProcessError(ErrEmptyApp), gocheck.Equals, false)
+	c.Assert(IsProcessError(nil), gocheck.Equals, false)
+}
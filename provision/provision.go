@@ -19,6 +19,15 @@ var ErrInvalidStatus = errors.New("invalid status")
 
 var ErrEmptyApp = errors.New("no units for this app")
 
+var (
+	ErrDeployCanceled     = errors.New("deploy canceled")
+	ErrNoDeployInProgress = errors.New("there is no deploy in progress for this app")
+	ErrCanaryInProgress   = errors.New("there is a canary in progress for this app, it must be promoted or aborted first")
+	ErrNoCanaryInProgress = errors.New("there is no canary in progress for this app")
+	ErrPoolNotFound       = errors.New("pool not found")
+	ErrPoolNotAllowed     = errors.New("the teams of the app are not allowed to use this pool")
+)
+
 // Status represents the status of a unit in tsuru.
 type Status string
 
@@ -92,11 +101,12 @@ const (
 // Unit represents a provision unit. Can be a machine, container or anything
 // IP-addressable.
 type Unit struct {
-	Name    string
-	AppName string
-	Type    string
-	Ip      string
-	Status  Status
+	Name        string
+	AppName     string
+	ProcessName string
+	Type        string
+	Ip          string
+	Status      Status
 }
 
 // GetIp returns the Unit.IP.
@@ -144,7 +154,9 @@ type App interface {
 	// app.
 	Run(cmd string, w io.Writer, once bool) error
 
-	Restart(io.Writer) error
+	// Restart restarts the units of the given process, or all units of
+	// the app when the process name is empty.
+	Restart(io.Writer, string) error
 
 	Envs() map[string]bind.EnvVar
 
@@ -166,21 +178,74 @@ type CNameManager interface {
 	UnsetCName(app App, cname string) error
 }
 
-// ArchiveDeployer is a provisioner that can deploy archives.
+// CertificateManager represents a provisioner that can terminate TLS for the
+// cnames of applications, using the given certificates.
+type CertificateManager interface {
+	SetCertificate(app App, cname, certificate, key string) error
+	UnsetCertificate(app App, cname string) error
+}
+
+// ArchiveDeployer is a provisioner that can deploy archives. It returns the
+// image generated by the deploy, or an empty string when the provisioner
+// doesn't work with images.
 type ArchiveDeployer interface {
-	ArchiveDeploy(app App, archiveURL string, w io.Writer) error
+	ArchiveDeploy(app App, archiveURL string, w io.Writer) (string, error)
 }
 
 // GitDeployer is a provisioner that can deploy the application from a Git
-// repository.
+// repository. It returns the image generated by the deploy, or an empty string
+// when the provisioner doesn't work with images.
 type GitDeployer interface {
-	GitDeploy(app App, version string, w io.Writer) error
+	GitDeploy(app App, version string, w io.Writer) (string, error)
 }
 
 // UploadDeployer is a provisioner that can deploy the application from an
-// uploaded file.
+// uploaded file. It returns the image generated by the deploy, or an empty
+// string when the provisioner doesn't work with images.
 type UploadDeployer interface {
-	UploadDeploy(app App, file io.ReadCloser, w io.Writer) error
+	UploadDeploy(app App, file io.ReadCloser, w io.Writer) (string, error)
+}
+
+// ImageDeployer is a provisioner that can deploy the application from an
+// image built elsewhere, without building it. It returns the image generated
+// by the deploy, in the namespace of the application.
+type ImageDeployer interface {
+	ImageDeploy(app App, image string, w io.Writer) (string, error)
+}
+
+// RollbackDeployer is a provisioner that can deploy again an image generated
+// by a previous deploy of the application, without building it.
+type RollbackDeployer interface {
+	Rollback(app App, image string, w io.Writer) error
+}
+
+// DeployCanceler is a provisioner that can cancel the deploy of an
+// application while it's running. Deploys that get canceled must return
+// ErrDeployCanceled.
+type DeployCanceler interface {
+	CancelDeploy(app App) error
+}
+
+// CanaryDeployer is a provisioner that can deploy an image as a canary: a few
+// new units run the image next to the current units of the application,
+// receiving the given percentage of its traffic, until the canary is either
+// promoted, replacing the current units, or aborted. While the canary is in
+// progress, other deploys of the application fail with ErrCanaryInProgress.
+type CanaryDeployer interface {
+	StartCanary(app App, image string, units, weight int, w io.Writer) error
+	SetCanaryWeight(app App, weight int) error
+
+	// PromoteCanary returns the image of the promoted canary.
+	PromoteCanary(app App, w io.Writer) (string, error)
+	AbortCanary(app App, w io.Writer) error
+}
+
+// PoolManager is a provisioner that places the units of applications in pools
+// of nodes, which may be restricted to some teams. ValidatePool returns
+// ErrPoolNotFound when the pool doesn't exist, and ErrPoolNotAllowed when
+// none of the given teams can use it.
+type PoolManager interface {
+	ValidatePool(pool string, teams []string) error
 }
 
 // Provisioner is the basic interface of this package.
@@ -195,14 +260,16 @@ type Provisioner interface {
 	Destroy(App) error
 
 	// AddUnits adds units to an app. The first parameter is the app, the
-	// second is the number of units to be added.
+	// second is the number of units to be added and the third is the name
+	// of the process that the units will run. An empty process name means
+	// the default process of the app (see ResolveProcess).
 	//
 	// It returns a slice containing all added units
-	AddUnits(App, uint, io.Writer) ([]Unit, error)
+	AddUnits(App, uint, string, io.Writer) ([]Unit, error)
 
 	// RemoveUnits "undoes" AddUnits, removing the given number of units
-	// from the app.
-	RemoveUnits(App, uint) error
+	// of the given process from the app.
+	RemoveUnits(App, uint, string) error
 
 	// RemoveUnit removes a unit from the app. It receives the unit to be
 	// removed.
@@ -217,11 +284,11 @@ type Provisioner interface {
 	// ExecuteCommandOnce runs a command in one unit of the app.
 	ExecuteCommandOnce(stdout, stderr io.Writer, app App, cmd string, args ...string) error
 
-	Restart(App, io.Writer) error
-	Stop(App) error
-
-	// Start start the app units.
-	Start(App) error
+	// Restart, Stop and Start handle the units of the given process, or
+	// all units of the app when the process name is empty.
+	Restart(App, string, io.Writer) error
+	Stop(App, string) error
+	Start(App, string) error
 
 	// Addr returns the address for an app.
 	//
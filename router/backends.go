@@ -0,0 +1,170 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrBackendNotFound = errors.New("Backend not found")
+
+var ErrBackendExists = errors.New("Backend already exists")
+
+// BackendCollection is the name of a MongoDB collection storing the backends
+// of a router, identified by their names. The backends of all instances of
+// the router are stored in the same collection, with the prefix of the
+// instance in the "router" field and the cnames in the "cnames" field. The
+// other fields, like the routes, are defined by each router.
+type BackendCollection string
+
+func (c BackendCollection) collection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(string(c)), nil
+}
+
+// Get loads the backend with the given name in backend, returning
+// ErrBackendNotFound when it doesn't exist.
+func (c BackendCollection) Get(name string, backend interface{}) error {
+	coll, err := c.collection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.FindId(name).One(backend)
+	if err == mgo.ErrNotFound {
+		return ErrBackendNotFound
+	}
+	return err
+}
+
+// List loads the backends of the router instance with the given prefix in
+// backends, sorted by name.
+func (c BackendCollection) List(prefix string, backends interface{}) error {
+	coll, err := c.collection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.Find(bson.M{"router": prefix}).Sort("_id").All(backends)
+}
+
+// Update runs the update in the backend matching the query, returning
+// ErrBackendNotFound when the backend doesn't exist and notMatched when it
+// exists but doesn't match the query.
+func (c BackendCollection) Update(name string, query, update bson.M, notMatched error) error {
+	coll, err := c.collection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	query["_id"] = name
+	err = coll.Update(query, update)
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	count, err := coll.FindId(name).Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrBackendNotFound
+	}
+	return notMatched
+}
+
+// MongoBackends implements the handling of backends and cnames for routers
+// that store their routing table in MongoDB, like the file and proxy routers.
+// The addresses of the backends are subdomains of Domain.
+type MongoBackends struct {
+	Collection BackendCollection
+	// Router is the name the router is registered with, and Prefix is the
+	// prefix of the settings of the router instance.
+	Router string
+	Prefix string
+	Domain string
+}
+
+// Address returns the address of the backend with the given name.
+func (b *MongoBackends) Address(backendName string) string {
+	return fmt.Sprintf("%s.%s", backendName, b.Domain)
+}
+
+func (b *MongoBackends) AddBackend(name string) error {
+	coll, err := b.Collection.collection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.Insert(bson.M{"_id": name, "router": b.Prefix})
+	if mgo.IsDup(err) {
+		return ErrBackendExists
+	}
+	if err != nil {
+		return err
+	}
+	return Store(name, name, b.Router)
+}
+
+func (b *MongoBackends) RemoveBackend(name string) error {
+	backendName, err := Retrieve(name)
+	if err != nil {
+		return err
+	}
+	coll, err := b.Collection.collection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(backendName)
+	if err == mgo.ErrNotFound {
+		return ErrBackendNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return Remove(backendName)
+}
+
+func (b *MongoBackends) SetCName(cname, name string) error {
+	backendName, err := Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(cname, b.Domain) {
+		return fmt.Errorf("Invalid CNAME %s. You can't use tsuru's application domain.", cname)
+	}
+	return b.Collection.Update(backendName, bson.M{}, bson.M{"$addToSet": bson.M{"cnames": cname}}, nil)
+}
+
+func (b *MongoBackends) UnsetCName(cname, name string) error {
+	backendName, err := Retrieve(name)
+	if err != nil {
+		return err
+	}
+	return b.Collection.Update(backendName, bson.M{}, bson.M{"$pull": bson.M{"cnames": cname}}, nil)
+}
+
+func (b *MongoBackends) Addr(name string) (string, error) {
+	backendName, err := Retrieve(name)
+	if err != nil {
+		return "", err
+	}
+	var backend bson.M
+	err = b.Collection.Get(backendName, &backend)
+	if err != nil {
+		return "", err
+	}
+	return b.Address(backendName), nil
+}
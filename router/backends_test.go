@@ -0,0 +1,98 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+type testBackend struct {
+	Name   string `bson:"_id"`
+	Router string
+	CNames []string
+	Routes []string
+}
+
+func (s *S) testBackends() *MongoBackends {
+	return &MongoBackends{
+		Collection: "test_backends",
+		Router:     "fake",
+		Prefix:     "test",
+		Domain:     "tsuru.io",
+	}
+}
+
+func (s *S) TestMongoBackendsAddBackend(c *gocheck.C) {
+	b := s.testBackends()
+	defer s.conn.Collection("test_backends").RemoveAll(nil)
+	defer s.conn.Collection("routers").RemoveAll(nil)
+	err := b.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	var backend testBackend
+	err = b.Collection.Get("myapp", &backend)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend, gocheck.DeepEquals, testBackend{Name: "myapp", Router: "test"})
+	name, err := Retrieve("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "myapp")
+	err = b.AddBackend("myapp")
+	c.Assert(err, gocheck.Equals, ErrBackendExists)
+	addr, err := b.Addr("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp.tsuru.io")
+	err = b.RemoveBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = b.Collection.Get("myapp", &backend)
+	c.Assert(err, gocheck.Equals, ErrBackendNotFound)
+	_, err = Retrieve("myapp")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestMongoBackendsCNames(c *gocheck.C) {
+	b := s.testBackends()
+	defer s.conn.Collection("test_backends").RemoveAll(nil)
+	defer s.conn.Collection("routers").RemoveAll(nil)
+	err := b.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = b.SetCName("myapp.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = b.SetCName("other.tsuru.io", "myapp")
+	c.Assert(err, gocheck.ErrorMatches, "Invalid CNAME other.tsuru.io. You can't use tsuru's application domain.")
+	var backend testBackend
+	err = b.Collection.Get("myapp", &backend)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.CNames, gocheck.DeepEquals, []string{"myapp.com"})
+	err = b.UnsetCName("myapp.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = b.Collection.Get("myapp", &backend)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.CNames, gocheck.HasLen, 0)
+}
+
+func (s *S) TestBackendCollectionUpdate(c *gocheck.C) {
+	b := s.testBackends()
+	defer s.conn.Collection("test_backends").RemoveAll(nil)
+	defer s.conn.Collection("routers").RemoveAll(nil)
+	err := b.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = b.Collection.Update("myapp", bson.M{}, bson.M{"$addToSet": bson.M{"routes": "http://10.10.10.10"}}, nil)
+	c.Assert(err, gocheck.IsNil)
+	err = b.Collection.Update("myapp",
+		bson.M{"routes": "http://10.10.10.11"},
+		bson.M{"$pull": bson.M{"routes": "http://10.10.10.11"}},
+		ErrRouteNotFound,
+	)
+	c.Assert(err, gocheck.Equals, ErrRouteNotFound)
+	err = b.Collection.Update("otherapp", bson.M{}, bson.M{"$set": bson.M{"routes": nil}}, nil)
+	c.Assert(err, gocheck.Equals, ErrBackendNotFound)
+	var backends []testBackend
+	err = b.Collection.List("test", &backends)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backends, gocheck.DeepEquals, []testBackend{{Name: "myapp", Router: "test", Routes: []string{"http://10.10.10.10"}}})
+	err = b.Collection.List("other", &backends)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backends, gocheck.HasLen, 0)
+}
@@ -0,0 +1,321 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package file provides a router implementation that stores backends, routes
+// and cnames in MongoDB and renders them in the configuration file of a
+// reverse proxy, like Nginx or HAProxy, using a template. The proxy is
+// reloaded after each change by a configurable command.
+//
+// It does not provided any exported type, in order to use the router, you must
+// import this package and get the router intance using the function
+// router.Get.
+//
+// In order to use this router, you need to define the "<prefix>:domain",
+// "<prefix>:template" and "<prefix>:config-file" settings. The
+// "<prefix>:reload-command" setting is optional.
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const routerName = "file"
+
+var (
+	execut      exec.Executor
+	renderMutex sync.Mutex
+)
+
+func executor() exec.Executor {
+	if execut == nil {
+		execut = exec.OsExecutor{}
+	}
+	return execut
+}
+
+func init() {
+	router.Register(routerName, createRouter)
+}
+
+// fileRouter stores the routes of the backends, handled by
+// router.MongoBackends, rendering the configuration file after each change.
+type fileRouter struct {
+	router.MongoBackends
+	templatePath  string
+	configFile    string
+	reloadCommand []string
+}
+
+func createRouter(prefix string) (router.Router, error) {
+	domain, err := config.GetString(prefix + ":domain")
+	if err != nil {
+		return nil, err
+	}
+	templatePath, err := config.GetString(prefix + ":template")
+	if err != nil {
+		return nil, err
+	}
+	configFile, err := config.GetString(prefix + ":config-file")
+	if err != nil {
+		return nil, err
+	}
+	reloadCommand, _ := config.GetString(prefix + ":reload-command")
+	r := fileRouter{
+		MongoBackends: router.MongoBackends{
+			Collection: backendsColl,
+			Router:     routerName,
+			Prefix:     prefix,
+			Domain:     domain,
+		},
+		templatePath:  templatePath,
+		configFile:    configFile,
+		reloadCommand: strings.Fields(reloadCommand),
+	}
+	return &r, nil
+}
+
+// templateBackend is a backend as seen by the template of the configuration
+// file.
+type templateBackend struct {
+	Name    string
+	Address string
+	CNames  []string
+	Routes  []backendRoute
+}
+
+type templateData struct {
+	Domain   string
+	Backends []templateBackend
+}
+
+// render writes the configuration file with all backends of the router and
+// reloads the proxy. The file is written to a temporary file first, so the
+// proxy never reads a partial configuration.
+func (r *fileRouter) render() error {
+	renderMutex.Lock()
+	defer renderMutex.Unlock()
+	tmpl, err := template.ParseFiles(r.templatePath)
+	if err != nil {
+		return err
+	}
+	backends, err := listBackends(r.Prefix)
+	if err != nil {
+		return err
+	}
+	data := templateData{Domain: r.Domain}
+	for _, backend := range backends {
+		data.Backends = append(data.Backends, templateBackend{
+			Name:    backend.Name,
+			Address: r.Address(backend.Name),
+			CNames:  backend.CNames,
+			Routes:  backend.Routes,
+		})
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return err
+	}
+	tmpFile := r.configFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile, r.configFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return r.reload()
+}
+
+func (r *fileRouter) reload() error {
+	if len(r.reloadCommand) == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	err := executor().Execute(exec.ExecuteOptions{
+		Cmd:    r.reloadCommand[0],
+		Args:   r.reloadCommand[1:],
+		Stdout: &out,
+		Stderr: &out,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reload the router: %s. Output: %s", err, out.String())
+	}
+	return nil
+}
+
+func (r *fileRouter) AddBackend(name string) error {
+	err := r.MongoBackends.AddBackend(name)
+	if err != nil {
+		return err
+	}
+	return r.render()
+}
+
+func (r *fileRouter) RemoveBackend(name string) error {
+	err := r.MongoBackends.RemoveBackend(name)
+	if err != nil {
+		return err
+	}
+	return r.render()
+}
+
+func (r *fileRouter) AddRoute(name, address string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	err = backendsColl.Update(backendName,
+		bson.M{"routes.address": bson.M{"$ne": address}},
+		bson.M{"$push": bson.M{"routes": backendRoute{Address: address, Weight: 1}}},
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	return r.render()
+}
+
+func (r *fileRouter) RemoveRoute(name, address string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	err = backendsColl.Update(backendName,
+		bson.M{"routes.address": address},
+		bson.M{"$pull": bson.M{"routes": bson.M{"address": address}}},
+		router.ErrRouteNotFound,
+	)
+	if err != nil {
+		return err
+	}
+	return r.render()
+}
+
+func (r *fileRouter) SetCName(cname, name string) error {
+	err := r.MongoBackends.SetCName(cname, name)
+	if err != nil {
+		return err
+	}
+	return r.render()
+}
+
+func (r *fileRouter) UnsetCName(cname, name string) error {
+	err := r.MongoBackends.UnsetCName(cname, name)
+	if err != nil {
+		return err
+	}
+	return r.render()
+}
+
+func (r *fileRouter) Swap(backend1, backend2 string) error {
+	return router.Swap(r, backend1, backend2)
+}
+
+func (r *fileRouter) Routes(name string) ([]string, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := getBackend(backendName)
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]string, len(backend.Routes))
+	for i, route := range backend.Routes {
+		routes[i] = route.Address
+	}
+	return routes, nil
+}
+
+// SetRoutesWeight sets the weights of the routes of the backend, so the given
+// routes get the percentage of the traffic and the other routes get the rest,
+// split evenly between them.
+func (r *fileRouter) SetRoutesWeight(name string, addresses []string, weight int) error {
+	if weight < 1 || weight > 99 {
+		return router.ErrInvalidWeight
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	backend, err := getBackend(backendName)
+	if err != nil {
+		return err
+	}
+	weighted := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		weighted[address] = true
+	}
+	var selected, others int
+	for _, route := range backend.Routes {
+		if weighted[route.Address] {
+			selected++
+		} else {
+			others++
+		}
+	}
+	if selected == 0 || selected != len(weighted) {
+		return router.ErrRouteNotFound
+	}
+	selectedWeight, otherWeight := 1, 1
+	if others > 0 {
+		selectedWeight = others * weight
+		otherWeight = selected * (100 - weight)
+		d := gcd(selectedWeight, otherWeight)
+		selectedWeight /= d
+		otherWeight /= d
+	}
+	for i := range backend.Routes {
+		if weighted[backend.Routes[i].Address] {
+			backend.Routes[i].Weight = selectedWeight
+		} else {
+			backend.Routes[i].Weight = otherWeight
+		}
+	}
+	return r.setRoutes(backend)
+}
+
+func (r *fileRouter) ResetRoutesWeight(name string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	backend, err := getBackend(backendName)
+	if err != nil {
+		return err
+	}
+	for i := range backend.Routes {
+		backend.Routes[i].Weight = 1
+	}
+	return r.setRoutes(backend)
+}
+
+func (r *fileRouter) setRoutes(backend *backendData) error {
+	err := backendsColl.Update(backend.Name, bson.M{}, bson.M{"$set": bson.M{"routes": backend.Routes}}, nil)
+	if err != nil {
+		return err
+	}
+	return r.render()
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,299 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	etesting "github.com/tsuru/tsuru/exec/testing"
+	"github.com/tsuru/tsuru/router"
+	ttesting "github.com/tsuru/tsuru/testing"
+	"launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) {
+	gocheck.TestingT(t)
+}
+
+type S struct {
+	conn     *db.Storage
+	dir      string
+	executor *etesting.FakeExecutor
+}
+
+var _ = gocheck.Suite(&S{})
+
+const testTemplate = `{{range .Backends}}backend {{.Name}} {{.Address}}{{range .CNames}} {{.}}{{end}}
+{{range .Routes}}  server {{.Host}} weight={{.Weight}}
+{{end}}{{end}}`
+
+func (s *S) SetUpSuite(c *gocheck.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "router_file_tests")
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TearDownSuite(c *gocheck.C) {
+	s.conn.Collection("file_router").Database.DropDatabase()
+	s.conn.Close()
+}
+
+func (s *S) SetUpTest(c *gocheck.C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "file-router")
+	c.Assert(err, gocheck.IsNil)
+	templatePath := filepath.Join(s.dir, "proxy.conf.tmpl")
+	err = ioutil.WriteFile(templatePath, []byte(testTemplate), 0644)
+	c.Assert(err, gocheck.IsNil)
+	config.Set("file:domain", "tsuru.io")
+	config.Set("file:template", templatePath)
+	config.Set("file:config-file", filepath.Join(s.dir, "proxy.conf"))
+	config.Set("file:reload-command", "nginx -s reload")
+	s.executor = &etesting.FakeExecutor{}
+	execut = s.executor
+	ttesting.ClearAllCollections(s.conn.Collection("file_router").Database)
+}
+
+func (s *S) TearDownTest(c *gocheck.C) {
+	execut = nil
+	os.RemoveAll(s.dir)
+}
+
+func (s *S) getRouter(c *gocheck.C) router.Router {
+	r, err := createRouter("file")
+	c.Assert(err, gocheck.IsNil)
+	return r
+}
+
+func (s *S) configFile(c *gocheck.C) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "proxy.conf"))
+	c.Assert(err, gocheck.IsNil)
+	return string(data)
+}
+
+func (s *S) TestShouldBeRegistered(c *gocheck.C) {
+	r, err := router.Get("file")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(r, gocheck.FitsTypeOf, &fileRouter{})
+}
+
+func (s *S) TestCreateRouterWithoutTemplate(c *gocheck.C) {
+	config.Unset("file:template")
+	_, err := createRouter("file")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestAddBackend(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.configFile(c), gocheck.Equals, "backend myapp myapp.tsuru.io\n")
+	c.Assert(s.executor.ExecutedCmd("nginx", []string{"-s", "reload"}), gocheck.Equals, true)
+	_, err = os.Stat(filepath.Join(s.dir, "proxy.conf.tmp"))
+	c.Assert(os.IsNotExist(err), gocheck.Equals, true)
+}
+
+func (s *S) TestAddDuplicateBackend(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddBackend("myapp")
+	c.Assert(err, gocheck.ErrorMatches, "Backend already exists")
+}
+
+func (s *S) TestAddBackendWithoutReloadCommand(c *gocheck.C) {
+	config.Unset("file:reload-command")
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.executor.GetCommands("nginx"), gocheck.HasLen, 0)
+}
+
+func (s *S) TestAddBackendReloadFailure(c *gocheck.C) {
+	execut = &etesting.ErrorExecutor{}
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.ErrorMatches, "failed to reload the router: .*")
+}
+
+func (s *S) TestRemoveBackend(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.RemoveBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.configFile(c), gocheck.Equals, "")
+	_, err = router.Retrieve("myapp")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestAddRoute(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080", "http://10.10.10.11:8080"})
+	c.Assert(s.configFile(c), gocheck.Equals, `backend myapp myapp.tsuru.io
+  server 10.10.10.10:8080 weight=1
+  server 10.10.10.11:8080 weight=1
+`)
+}
+
+func (s *S) TestAddRouteBackendNotFound(c *gocheck.C) {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	defer router.Remove("myapp")
+	r := s.getRouter(c)
+	err = r.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.Equals, router.ErrBackendNotFound)
+}
+
+func (s *S) TestRemoveRoute(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.RemoveRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{})
+	c.Assert(s.configFile(c), gocheck.Equals, "backend myapp myapp.tsuru.io\n")
+}
+
+func (s *S) TestRemoveRouteNotFound(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.RemoveRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.Equals, router.ErrRouteNotFound)
+}
+
+func (s *S) TestSetCName(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetCName("myapp.example.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.configFile(c), gocheck.Equals, "backend myapp myapp.tsuru.io myapp.example.com\n")
+	err = r.UnsetCName("myapp.example.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.configFile(c), gocheck.Equals, "backend myapp myapp.tsuru.io\n")
+}
+
+func (s *S) TestSetCNameInTheDomain(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetCName("other.tsuru.io", "myapp")
+	c.Assert(err, gocheck.ErrorMatches, "Invalid CNAME other.tsuru.io. You can't use tsuru's application domain.")
+}
+
+func (s *S) TestAddr(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	addr, err := r.Addr("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp.tsuru.io")
+}
+
+func (s *S) TestSwap(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp1")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddBackend("myapp2")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp1", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp2", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.Swap("myapp1", "myapp2")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes("myapp1")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+	addr, err := r.Addr("myapp1")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp2.tsuru.io")
+	c.Assert(s.configFile(c), gocheck.Equals, `backend myapp1 myapp1.tsuru.io
+  server 10.10.10.11:8080 weight=1
+backend myapp2 myapp2.tsuru.io
+  server 10.10.10.10:8080 weight=1
+`)
+}
+
+func (s *S) TestSetRoutesWeight(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	for _, address := range []string{"http://10.0.0.1:80", "http://10.0.0.2:80", "http://10.0.0.3:80"} {
+		err = r.AddRoute("myapp", address)
+		c.Assert(err, gocheck.IsNil)
+	}
+	err = r.(router.WeightedRouter).SetRoutesWeight("myapp", []string{"http://10.0.0.3:80"}, 10)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.configFile(c), gocheck.Equals, `backend myapp myapp.tsuru.io
+  server 10.0.0.1:80 weight=9
+  server 10.0.0.2:80 weight=9
+  server 10.0.0.3:80 weight=2
+`)
+	err = r.(router.WeightedRouter).ResetRoutesWeight("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.configFile(c), gocheck.Equals, `backend myapp myapp.tsuru.io
+  server 10.0.0.1:80 weight=1
+  server 10.0.0.2:80 weight=1
+  server 10.0.0.3:80 weight=1
+`)
+}
+
+func (s *S) TestSetRoutesWeightInvalidWeight(c *gocheck.C) {
+	r := s.getRouter(c).(router.WeightedRouter)
+	err := r.SetRoutesWeight("myapp", []string{"http://10.0.0.1:80"}, 100)
+	c.Assert(err, gocheck.Equals, router.ErrInvalidWeight)
+}
+
+func (s *S) TestSetRoutesWeightRouteNotFound(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.(router.WeightedRouter).SetRoutesWeight("myapp", []string{"http://10.0.0.1:80"}, 10)
+	c.Assert(err, gocheck.Equals, router.ErrRouteNotFound)
+}
+
+func (s *S) TestRenderOnlyBackendsOfTheRouter(c *gocheck.C) {
+	otherTemplate := filepath.Join(s.dir, "other.conf.tmpl")
+	err := ioutil.WriteFile(otherTemplate, []byte(testTemplate), 0644)
+	c.Assert(err, gocheck.IsNil)
+	config.Set("routers:other:domain", "other.io")
+	config.Set("routers:other:template", otherTemplate)
+	config.Set("routers:other:config-file", filepath.Join(s.dir, "other.conf"))
+	defer config.Unset("routers:other:domain")
+	defer config.Unset("routers:other:template")
+	defer config.Unset("routers:other:config-file")
+	other, err := createRouter("routers:other")
+	c.Assert(err, gocheck.IsNil)
+	err = other.AddBackend("otherapp")
+	c.Assert(err, gocheck.IsNil)
+	r := s.getRouter(c)
+	err = r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.configFile(c), gocheck.Equals, "backend myapp myapp.tsuru.io\n")
+}
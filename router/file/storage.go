@@ -0,0 +1,52 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"net/url"
+
+	"github.com/tsuru/tsuru/router"
+)
+
+const backendsColl router.BackendCollection = "file_router"
+
+type backendRoute struct {
+	Address string
+	Weight  int
+}
+
+// Host returns the host and port of the route, as used in the upstream
+// servers of the proxies.
+func (r backendRoute) Host() string {
+	parsed, err := url.Parse(r.Address)
+	if err != nil || parsed.Host == "" {
+		return r.Address
+	}
+	return parsed.Host
+}
+
+type backendData struct {
+	Name   string `bson:"_id"`
+	Router string
+	CNames []string
+	Routes []backendRoute
+}
+
+func getBackend(name string) (*backendData, error) {
+	var backend backendData
+	err := backendsColl.Get(name, &backend)
+	if err != nil {
+		return nil, err
+	}
+	return &backend, nil
+}
+
+// listBackends returns the backends of the router with the given prefix,
+// sorted by name so the rendered configuration is stable.
+func listBackends(prefix string) ([]backendData, error) {
+	var backends []backendData
+	err := backendsColl.List(prefix, &backends)
+	return backends, err
+}
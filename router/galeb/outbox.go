@@ -0,0 +1,273 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package galeb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	addRouteOp    = "add-route"
+	removeRouteOp = "remove-route"
+	setCNameOp    = "set-cname"
+	unsetCNameOp  = "unset-cname"
+
+	retryPollInterval    = 5 * time.Second
+	retryInitialInterval = 5 * time.Second
+	retryMaxInterval     = 5 * time.Minute
+
+	// retryLease is the time a worker has to retry an operation before
+	// other workers may claim it.
+	retryLease = time.Minute
+
+	// leasePollInterval is the interval between checks of an operation
+	// claimed by a worker, while waiting for it to finish.
+	leasePollInterval = 200 * time.Millisecond
+)
+
+var opposites = map[string]string{
+	addRouteOp:    removeRouteOp,
+	removeRouteOp: addRouteOp,
+	setCNameOp:    unsetCNameOp,
+	unsetCNameOp:  setCNameOp,
+}
+
+var retryWorkerOnce sync.Once
+
+// Operation is an operation that failed in the Galeb API and is retried in
+// background. There's at most one pending operation for each route or cname
+// of a backend: an operation cancels the pending opposite operation, like
+// removing a route that is still being added. Operations claimed by a worker
+// have a lease, and can't be cancelled until the worker is done with them.
+type Operation struct {
+	Id         bson.ObjectId `bson:"_id"`
+	Prefix     string
+	Op         string
+	Backend    string
+	Arg        string
+	Attempts   int
+	LastError  string
+	CreatedAt  time.Time
+	NextRetry  time.Time
+	LeaseUntil time.Time
+}
+
+func outboxCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection("galeb_router_outbox"), nil
+}
+
+// ListOperations returns the operations waiting to be retried, oldest first.
+func ListOperations() ([]Operation, error) {
+	coll, err := outboxCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	operations := []Operation{}
+	err = coll.Find(nil).Sort("createdat").All(&operations)
+	return operations, err
+}
+
+// pendingOperation returns the pending operation on the route or cname of the
+// backend, if any.
+func pendingOperation(backend, op, arg string) (*Operation, error) {
+	coll, err := outboxCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var operation Operation
+	query := bson.M{"backend": backend, "arg": arg, "op": bson.M{"$in": []string{op, opposites[op]}}}
+	err = coll.Find(query).One(&operation)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &operation, nil
+}
+
+func enqueueOperation(prefix, backend, op, arg string, cause error) error {
+	coll, err := outboxCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	now := time.Now()
+	return coll.Insert(Operation{
+		Id:        bson.NewObjectId(),
+		Prefix:    prefix,
+		Op:        op,
+		Backend:   backend,
+		Arg:       arg,
+		Attempts:  1,
+		LastError: cause.Error(),
+		CreatedAt: now,
+		NextRetry: now.Add(retryInitialInterval),
+	})
+}
+
+func removeOperation(id bson.ObjectId) error {
+	coll, err := outboxCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.RemoveId(id)
+}
+
+// cancelOperation removes the operation unless a worker holds its lease,
+// returning whether it was removed.
+func cancelOperation(id bson.ObjectId) (bool, error) {
+	coll, err := outboxCollection()
+	if err != nil {
+		return false, err
+	}
+	defer coll.Close()
+	err = coll.Remove(bson.M{"_id": id, "leaseuntil": bson.M{"$not": bson.M{"$gt": time.Now()}}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func removeBackendOperations(backend string) error {
+	coll, err := outboxCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.RemoveAll(bson.M{"backend": backend})
+	return err
+}
+
+// retryBackoff returns the time to wait before the next attempt of an
+// operation, doubling at each attempt.
+func retryBackoff(attempts int) time.Duration {
+	interval := retryInitialInterval
+	for i := 1; i < attempts && interval < retryMaxInterval; i++ {
+		interval *= 2
+	}
+	if interval > retryMaxInterval {
+		interval = retryMaxInterval
+	}
+	return interval
+}
+
+// claimOperation claims the oldest operation due to be retried, extending its
+// next retry by the lease so other workers skip it, and holding its lease so
+// it isn't cancelled while it runs.
+func claimOperation() (*Operation, error) {
+	coll, err := outboxCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	now := time.Now()
+	var operation Operation
+	_, err = coll.Find(bson.M{"nextretry": bson.M{"$lte": now}}).Sort("createdat").Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"nextretry": now.Add(retryLease), "leaseuntil": now.Add(retryLease)}},
+		ReturnNew: true,
+	}, &operation)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &operation, nil
+}
+
+func retryOperation(operation *Operation) error {
+	r, err := createRouter(operation.Prefix)
+	if err != nil {
+		return err
+	}
+	gRouter := r.(*galebRouter)
+	data, err := getGalebData(operation.Backend)
+	if err != nil {
+		return err
+	}
+	switch operation.Op {
+	case addRouteOp:
+		return gRouter.addRoute(data, operation.Arg)
+	case removeRouteOp:
+		return gRouter.removeRoute(data, operation.Arg)
+	case setCNameOp:
+		return gRouter.setCName(data, operation.Arg)
+	case unsetCNameOp:
+		return gRouter.unsetCName(data, operation.Arg)
+	}
+	return nil
+}
+
+// retryOperations retries the operations that are due, returning the number
+// of operations that succeeded.
+func retryOperations() (int, error) {
+	var succeeded int
+	for {
+		operation, err := claimOperation()
+		if err != nil || operation == nil {
+			return succeeded, err
+		}
+		err = retryOperation(operation)
+		if err == nil || err == mgo.ErrNotFound {
+			// A missing backend was removed after the operation failed,
+			// so there's nothing left to do.
+			succeeded++
+			if err := removeOperation(operation.Id); err != nil {
+				return succeeded, err
+			}
+			continue
+		}
+		log.Errorf("[galeb] Failed to retry %s %s of %s (attempt %d): %s",
+			operation.Op, operation.Arg, operation.Backend, operation.Attempts+1, err)
+		coll, collErr := outboxCollection()
+		if collErr != nil {
+			return succeeded, collErr
+		}
+		update := bson.M{
+			"$inc": bson.M{"attempts": 1},
+			"$set": bson.M{
+				"lasterror":  err.Error(),
+				"nextretry":  time.Now().Add(retryBackoff(operation.Attempts + 1)),
+				"leaseuntil": time.Time{},
+			},
+		}
+		updateErr := coll.UpdateId(operation.Id, update)
+		coll.Close()
+		if updateErr != nil && updateErr != mgo.ErrNotFound {
+			return succeeded, updateErr
+		}
+	}
+}
+
+func runRetryWorker() {
+	for {
+		time.Sleep(retryPollInterval)
+		_, err := retryOperations()
+		if err != nil {
+			log.Errorf("[galeb] Failed to retry operations: %s", err)
+		}
+	}
+}
+
+func startRetryWorker() {
+	retryWorkerOnce.Do(func() {
+		go runRetryWorker()
+	})
+}
@@ -0,0 +1,250 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package galeb
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) retryRouter(c *gocheck.C) *galebRouter {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	data := galebData{
+		Name:          "myapp",
+		BackendPoolId: "mybackendpoolid",
+	}
+	err = data.save()
+	c.Assert(err, gocheck.IsNil)
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	r := gRouter.(*galebRouter)
+	r.retry = true
+	return r
+}
+
+func (s *S) TestAddRouteFailureIsRetried(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "http://10.9.2.1:44001/")
+	c.Assert(err, gocheck.IsNil)
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 1)
+	c.Assert(operations[0].Prefix, gocheck.Equals, "galeb")
+	c.Assert(operations[0].Op, gocheck.Equals, addRouteOp)
+	c.Assert(operations[0].Backend, gocheck.Equals, "myapp")
+	c.Assert(operations[0].Arg, gocheck.Equals, "10.9.2.1:44001")
+	c.Assert(operations[0].Attempts, gocheck.Equals, 1)
+	c.Assert(operations[0].LastError, gocheck.Not(gocheck.Equals), "")
+	data, err := getGalebData("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(data.Reals, gocheck.HasLen, 0)
+}
+
+func (s *S) TestAddRouteFailureWithoutRetry(c *gocheck.C) {
+	r := s.retryRouter(c)
+	r.retry = false
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.NotNil)
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 0)
+}
+
+func (s *S) TestSetCNameFailureIsRetried(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.SetCName("my.cname.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 1)
+	c.Assert(operations[0].Op, gocheck.Equals, setCNameOp)
+	c.Assert(operations[0].Arg, gocheck.Equals, "my.cname.com")
+}
+
+func (s *S) TestRemoveRouteCancelsPendingAddRoute(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	err = r.RemoveRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.handler.Url, gocheck.DeepEquals, []string{"/api/backend/"})
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRemoveRouteWaitsForClaimedAddRoute(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	coll, err := outboxCollection()
+	c.Assert(err, gocheck.IsNil)
+	defer coll.Close()
+	err = coll.Update(bson.M{"backend": "myapp"}, bson.M{"$set": bson.M{"nextretry": time.Now()}})
+	c.Assert(err, gocheck.IsNil)
+	operation, err := claimOperation()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operation, gocheck.NotNil)
+	done := make(chan error)
+	go func() {
+		done <- r.RemoveRoute("myapp", "10.9.2.1:44001")
+	}()
+	select {
+	case err = <-done:
+		c.Fatalf("RemoveRoute didn't wait for the claimed operation: %v", err)
+	case <-time.After(2 * leasePollInterval):
+	}
+	count, err := coll.FindId(operation.Id).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 1)
+	s.handler.ConditionalContent = map[string]interface{}{
+		"/api/backend/": `{"_links":{"self":"` + s.server.URL + `/api/backend1"}}`,
+	}
+	s.handler.RspCode = http.StatusCreated
+	err = retryOperation(operation)
+	c.Assert(err, gocheck.IsNil)
+	err = removeOperation(operation.Id)
+	c.Assert(err, gocheck.IsNil)
+	s.handler.RspCode = http.StatusNoContent
+	select {
+	case err = <-done:
+		c.Assert(err, gocheck.IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("RemoveRoute didn't run after the claimed operation finished")
+	}
+	c.Assert(s.handler.Url, gocheck.DeepEquals, []string{"/api/backend/", "/api/backend/", "/api/backend1"})
+	data, err := getGalebData("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(data.Reals, gocheck.HasLen, 0)
+}
+
+func (s *S) TestCancelOperationClaimed(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	coll, err := outboxCollection()
+	c.Assert(err, gocheck.IsNil)
+	defer coll.Close()
+	err = coll.Update(bson.M{"backend": "myapp"}, bson.M{"$set": bson.M{"nextretry": time.Now()}})
+	c.Assert(err, gocheck.IsNil)
+	operation, err := claimOperation()
+	c.Assert(err, gocheck.IsNil)
+	cancelled, err := cancelOperation(operation.Id)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cancelled, gocheck.Equals, false)
+	err = coll.UpdateId(operation.Id, bson.M{"$set": bson.M{"nextretry": time.Now()}})
+	c.Assert(err, gocheck.IsNil)
+	succeeded, err := retryOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(succeeded, gocheck.Equals, 0)
+	cancelled, err = cancelOperation(operation.Id)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cancelled, gocheck.Equals, true)
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 0)
+}
+
+func (s *S) TestAddRouteWithPendingAddRoute(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.handler.Url, gocheck.DeepEquals, []string{"/api/backend/"})
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 1)
+}
+
+func (s *S) TestRetryOperations(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	coll, err := outboxCollection()
+	c.Assert(err, gocheck.IsNil)
+	defer coll.Close()
+	err = coll.Update(bson.M{"backend": "myapp"}, bson.M{"$set": bson.M{"nextretry": time.Now()}})
+	c.Assert(err, gocheck.IsNil)
+	s.handler.ConditionalContent = map[string]interface{}{
+		"/api/backend/": `{"_links":{"self":"backend1"}}`,
+	}
+	s.handler.RspCode = http.StatusCreated
+	succeeded, err := retryOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(succeeded, gocheck.Equals, 1)
+	data, err := getGalebData("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(data.Reals, gocheck.DeepEquals, []galebRealData{
+		{Real: "10.9.2.1:44001", BackendId: "backend1"},
+	})
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRetryOperationsFailureBacksOff(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	coll, err := outboxCollection()
+	c.Assert(err, gocheck.IsNil)
+	defer coll.Close()
+	err = coll.Update(bson.M{"backend": "myapp"}, bson.M{"$set": bson.M{"nextretry": time.Now()}})
+	c.Assert(err, gocheck.IsNil)
+	succeeded, err := retryOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(succeeded, gocheck.Equals, 0)
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 1)
+	c.Assert(operations[0].Attempts, gocheck.Equals, 2)
+	c.Assert(operations[0].NextRetry.After(time.Now().Add(retryInitialInterval)), gocheck.Equals, true)
+}
+
+func (s *S) TestRetryOperationsNotDue(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	succeeded, err := retryOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(succeeded, gocheck.Equals, 0)
+	c.Assert(s.handler.Url, gocheck.HasLen, 1)
+}
+
+func (s *S) TestRemoveBackendRemovesOperations(c *gocheck.C) {
+	r := s.retryRouter(c)
+	s.handler.RspCode = http.StatusServiceUnavailable
+	err := r.AddRoute("myapp", "10.9.2.1:44001")
+	c.Assert(err, gocheck.IsNil)
+	s.handler.RspCode = http.StatusNoContent
+	err = r.RemoveBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	operations, err := ListOperations()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(operations, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRetryBackoff(c *gocheck.C) {
+	c.Assert(retryBackoff(1), gocheck.Equals, retryInitialInterval)
+	c.Assert(retryBackoff(2), gocheck.Equals, 2*retryInitialInterval)
+	c.Assert(retryBackoff(3), gocheck.Equals, 4*retryInitialInterval)
+	c.Assert(retryBackoff(100), gocheck.Equals, retryMaxInterval)
+}
@@ -2,17 +2,28 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Package galeb provides a router implementation that manages backends,
+// routes and cnames through the API of Galeb. Galeb doesn't terminate TLS for
+// the cnames of apps, so the router doesn't implement router.TLSRouter, and
+// setting or removing certificates of apps using it fails with
+// router.ErrTLSNotSupported.
+//
+// It does not provided any exported type, in order to use the router, you must
+// import this package and get the router intance using the function
+// router.Get.
 package galeb
 
 import (
 	"fmt"
 	"net"
-	"net/url"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/router"
 	galebClient "github.com/tsuru/tsuru/router/galeb/client"
 )
@@ -23,6 +34,7 @@ type galebRouter struct {
 	client *galebClient.GalebClient
 	domain string
 	prefix string
+	retry  bool
 }
 
 func init() {
@@ -63,10 +75,15 @@ func createRouter(prefix string) (router.Router, error) {
 		LoadBalancePolicy: loadBalancePolicy,
 		RuleType:          ruleType,
 	}
+	retry, _ := config.GetBool(prefix + ":retry-failed-operations")
+	if retry {
+		startRetryWorker()
+	}
 	r := galebRouter{
 		client: &client,
 		domain: domain,
 		prefix: prefix,
+		retry:  retry,
 	}
 	return &r, nil
 }
@@ -87,6 +104,13 @@ func rootRuleName(base string) string {
 	return fmt.Sprintf("tsuru-rootrule-%s", base)
 }
 
+func ruleName(base, name string, version int) string {
+	if version == 0 {
+		return fmt.Sprintf("tsuru-rule-%s-%s", base, name)
+	}
+	return fmt.Sprintf("tsuru-rule-%s-%s-v%d", base, name, version)
+}
+
 func (r *galebRouter) virtualHostName(base string) string {
 	return fmt.Sprintf("%s.%s", base, r.domain)
 }
@@ -145,6 +169,10 @@ func (r *galebRouter) RemoveBackend(name string) error {
 	if err != nil {
 		return err
 	}
+	_, err = removeRules(client, data.Rules)
+	if err != nil {
+		return err
+	}
 	err = client.RemoveResource(data.VirtualHostId)
 	if err != nil {
 		return err
@@ -167,26 +195,75 @@ func (r *galebRouter) RemoveBackend(name string) error {
 	if err != nil {
 		return err
 	}
+	err = removeBackendOperations(backendName)
+	if err != nil {
+		return err
+	}
 	return router.Remove(backendName)
 }
 
-func (r *galebRouter) AddRoute(name, address string) error {
-	backendName, err := router.Retrieve(name)
+// apiError is an error returned by the Galeb API. Operations failing with it
+// are retried in background when the "<prefix>:retry-failed-operations"
+// setting is enabled.
+type apiError struct{ err error }
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// run runs the operation on the route or cname of the backend, retrying it in
+// background if it fails in the Galeb API. Operations with a pending
+// operation on the same route or cname are not run: the pending operation is
+// cancelled when it's the opposite one, and kept otherwise. An opposite
+// operation being retried by a worker is in flight, so it's waited for
+// instead: the operation runs after it succeeds, and cancels it if it fails.
+// The data of the backend is read right before running the operation.
+func (r *galebRouter) run(backendName, op, arg string, fn func(data *galebData) error) error {
+	for {
+		pending, err := pendingOperation(backendName, op, arg)
+		if err != nil {
+			return err
+		}
+		if pending == nil {
+			break
+		}
+		if pending.Op == op {
+			return nil
+		}
+		cancelled, err := cancelOperation(pending.Id)
+		if err != nil || cancelled {
+			return err
+		}
+		time.Sleep(leasePollInterval)
+	}
+	data, err := getGalebData(backendName)
 	if err != nil {
 		return err
 	}
-	data, err := getGalebData(backendName)
+	err = fn(data)
+	if _, ok := err.(*apiError); ok && r.retry {
+		log.Errorf("[galeb] Failed to %s %s of %s, it will be retried: %s", op, arg, backendName, err)
+		return enqueueOperation(r.prefix, backendName, op, arg, err)
+	}
+	return err
+}
+
+func (r *galebRouter) AddRoute(name, address string) error {
+	backendName, err := router.Retrieve(name)
 	if err != nil {
 		return err
 	}
+	address = router.RouteHost(address)
+	return r.run(backendName, addRouteOp, address, func(data *galebData) error {
+		return r.addRoute(data, address)
+	})
+}
+
+func (r *galebRouter) addRoute(data *galebData, address string) error {
 	client, err := r.getClient()
 	if err != nil {
 		return err
 	}
-	parsed, _ := url.Parse(address)
-	if parsed != nil && parsed.Host != "" {
-		address = parsed.Host
-	}
 	host, portStr, _ := net.SplitHostPort(address)
 	port, _ := strconv.Atoi(portStr)
 	params := galebClient.BackendParams{
@@ -196,7 +273,7 @@ func (r *galebRouter) AddRoute(name, address string) error {
 	}
 	backendId, err := client.AddBackend(&params)
 	if err != nil {
-		return err
+		return &apiError{err}
 	}
 	return data.addReal(address, backendId)
 }
@@ -206,23 +283,22 @@ func (r *galebRouter) RemoveRoute(name, address string) error {
 	if err != nil {
 		return err
 	}
-	data, err := getGalebData(backendName)
-	if err != nil {
-		return err
-	}
+	address = router.RouteHost(address)
+	return r.run(backendName, removeRouteOp, address, func(data *galebData) error {
+		return r.removeRoute(data, address)
+	})
+}
+
+func (r *galebRouter) removeRoute(data *galebData, address string) error {
 	client, err := r.getClient()
 	if err != nil {
 		return err
 	}
-	parsed, _ := url.Parse(address)
-	if parsed != nil && parsed.Host != "" {
-		address = parsed.Host
-	}
 	for _, real := range data.Reals {
 		if real.Real == address {
 			err = client.RemoveResource(real.BackendId)
 			if err != nil {
-				return err
+				return &apiError{err}
 			}
 			break
 		}
@@ -235,10 +311,12 @@ func (r *galebRouter) SetCName(cname, name string) error {
 	if err != nil {
 		return err
 	}
-	data, err := getGalebData(backendName)
-	if err != nil {
-		return err
-	}
+	return r.run(backendName, setCNameOp, cname, func(data *galebData) error {
+		return r.setCName(data, cname)
+	})
+}
+
+func (r *galebRouter) setCName(data *galebData, cname string) error {
 	client, err := r.getClient()
 	if err != nil {
 		return err
@@ -249,9 +327,32 @@ func (r *galebRouter) SetCName(cname, name string) error {
 	}
 	virtualHostId, err := client.AddVirtualHost(&virtualHostParams)
 	if err != nil {
+		return &apiError{err}
+	}
+	err = data.addCName(cname, virtualHostId)
+	if err != nil || len(data.Rules) == 0 {
 		return err
 	}
-	return data.addCName(cname, virtualHostId)
+	for i := range data.Rules {
+		rule := &data.Rules[i]
+		var virtualHostRuleId string
+		virtualHostRuleId, err = client.AddVirtualHostRule(&galebClient.VirtualHostRuleParams{
+			Order:       i + 1,
+			VirtualHost: virtualHostId,
+			Rule:        rule.RuleId,
+		})
+		if err != nil {
+			break
+		}
+		rule.VirtualHosts = append(rule.VirtualHosts, galebVirtualHostRuleData{
+			VirtualHostId:     virtualHostId,
+			VirtualHostRuleId: virtualHostRuleId,
+		})
+	}
+	if saveErr := data.setRules(data.Rules); err == nil {
+		err = saveErr
+	}
+	return err
 }
 
 func (r *galebRouter) UnsetCName(cname, name string) error {
@@ -259,19 +360,25 @@ func (r *galebRouter) UnsetCName(cname, name string) error {
 	if err != nil {
 		return err
 	}
-	data, err := getGalebData(backendName)
-	if err != nil {
-		return err
-	}
+	return r.run(backendName, unsetCNameOp, cname, func(data *galebData) error {
+		return r.unsetCName(data, cname)
+	})
+}
+
+func (r *galebRouter) unsetCName(data *galebData, cname string) error {
 	client, err := r.getClient()
 	if err != nil {
 		return err
 	}
 	for _, cnameData := range data.CNames {
 		if cnameData.CName == cname {
+			err = unlinkRules(client, data, cnameData.VirtualHostId)
+			if err != nil {
+				return &apiError{err}
+			}
 			err = client.RemoveResource(cnameData.VirtualHostId)
 			if err != nil {
-				return err
+				return &apiError{err}
 			}
 			break
 		}
@@ -310,3 +417,165 @@ func (r *galebRouter) Routes(name string) ([]string, error) {
 	}
 	return hosts, nil
 }
+
+// SetRules replaces the rules of the backend. Only path rules are supported,
+// each one is added as a galeb rule pointing to the backend pool of the app,
+// linked to the virtual hosts of the backend, ordered from the longest path to
+// the shortest. The new rules are added before the old ones are removed, so
+// the requests matching the rules never reach the default backend pool in the
+// meantime.
+func (r *galebRouter) SetRules(name string, rules []router.Rule) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	data, err := getGalebData(backendName)
+	if err != nil {
+		return err
+	}
+	rules = append([]router.Rule(nil), rules...)
+	sort.Sort(byPathLength(rules))
+	pools := make([]string, len(rules))
+	for i, rule := range rules {
+		if rule.Type != router.PathRule {
+			return router.ErrRuleNotSupported
+		}
+		targetName, err := router.Retrieve(rule.App)
+		if err != nil {
+			return err
+		}
+		target, err := getGalebData(targetName)
+		if err != nil {
+			return err
+		}
+		pools[i] = target.BackendPoolId
+	}
+	client, err := r.getClient()
+	if err != nil {
+		return err
+	}
+	virtualHosts := []string{data.VirtualHostId}
+	for _, cnameData := range data.CNames {
+		virtualHosts = append(virtualHosts, cnameData.VirtualHostId)
+	}
+	var version int
+	for _, rule := range data.Rules {
+		if rule.Version >= version {
+			version = rule.Version + 1
+		}
+	}
+	old := data.Rules
+	added := []galebRuleData{}
+	for i, rule := range rules {
+		var ruleData galebRuleData
+		ruleData, err = addRule(client, backendName, len(old)+i+1, version, rule, pools[i], virtualHosts)
+		if ruleData.RuleId != "" {
+			added = append(added, ruleData)
+		}
+		if err != nil {
+			break
+		}
+	}
+	var remaining []galebRuleData
+	if err != nil {
+		// The old rules are kept, and the added ones are removed.
+		var removeErr error
+		remaining, removeErr = removeRules(client, added)
+		if removeErr != nil {
+			log.Errorf("Failed to remove the rules added to the backend %q: %s", backendName, removeErr)
+		}
+		remaining = append(old, remaining...)
+	} else {
+		remaining, err = removeRules(client, old)
+		remaining = append(remaining, added...)
+	}
+	// Rules that couldn't be removed are stored, so they're removed by the
+	// next call.
+	if saveErr := data.setRules(remaining); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+type byPathLength []router.Rule
+
+func (r byPathLength) Len() int           { return len(r) }
+func (r byPathLength) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byPathLength) Less(i, j int) bool { return len(r[i].Path) > len(r[j].Path) }
+
+// addRule adds a galeb rule for the path rule and links it to the virtual
+// hosts. The returned data holds the resources created before a failure.
+func addRule(client *galebClient.GalebClient, backendName string, order, version int, rule router.Rule, pool string, virtualHosts []string) (galebRuleData, error) {
+	ruleData := galebRuleData{Name: rule.Name, Version: version}
+	var err error
+	ruleData.RuleId, err = client.AddRule(&galebClient.RuleParams{
+		Name:        ruleName(backendName, rule.Name, version),
+		Match:       rule.Path,
+		BackendPool: pool,
+	})
+	if err != nil {
+		return ruleData, err
+	}
+	for _, virtualHost := range virtualHosts {
+		virtualHostRuleId, err := client.AddVirtualHostRule(&galebClient.VirtualHostRuleParams{
+			Order:       order,
+			VirtualHost: virtualHost,
+			Rule:        ruleData.RuleId,
+		})
+		if err != nil {
+			return ruleData, err
+		}
+		ruleData.VirtualHosts = append(ruleData.VirtualHosts, galebVirtualHostRuleData{
+			VirtualHostId:     virtualHost,
+			VirtualHostRuleId: virtualHostRuleId,
+		})
+	}
+	return ruleData, nil
+}
+
+// removeRules unlinks the rules from the virtual hosts and removes them. When
+// it fails, it returns the rules that weren't removed.
+func removeRules(client *galebClient.GalebClient, rules []galebRuleData) ([]galebRuleData, error) {
+	for i, rule := range rules {
+		for j, virtualHost := range rule.VirtualHosts {
+			err := client.RemoveResource(virtualHost.VirtualHostRuleId)
+			if err != nil {
+				rule.VirtualHosts = rule.VirtualHosts[j:]
+				return append([]galebRuleData{rule}, rules[i+1:]...), err
+			}
+		}
+		rule.VirtualHosts = nil
+		err := client.RemoveResource(rule.RuleId)
+		if err != nil {
+			return append([]galebRuleData{rule}, rules[i+1:]...), err
+		}
+	}
+	return nil, nil
+}
+
+// unlinkRules unlinks the rules of the backend from one of its virtual hosts.
+func unlinkRules(client *galebClient.GalebClient, data *galebData, virtualHostId string) error {
+	if len(data.Rules) == 0 {
+		return nil
+	}
+	var err error
+	for i := range data.Rules {
+		rule := &data.Rules[i]
+		var kept []galebVirtualHostRuleData
+		for _, virtualHost := range rule.VirtualHosts {
+			if virtualHost.VirtualHostId != virtualHostId || err != nil {
+				kept = append(kept, virtualHost)
+				continue
+			}
+			err = client.RemoveResource(virtualHost.VirtualHostRuleId)
+			if err != nil {
+				kept = append(kept, virtualHost)
+			}
+		}
+		rule.VirtualHosts = kept
+	}
+	if saveErr := data.setRules(data.Rules); err == nil {
+		err = saveErr
+	}
+	return err
+}
@@ -72,6 +72,7 @@ func (s *S) TestAddBackend(c *gocheck.C) {
 		VirtualHostId: "vh1",
 		CNames:        []galebCNameData{},
 		Reals:         []galebRealData{},
+		Rules:         []galebRuleData{},
 	})
 	result := map[string]string{}
 	err = json.Unmarshal(s.handler.Body[0], &result)
@@ -347,6 +348,20 @@ func (s *S) TestAddr(c *gocheck.C) {
 	c.Assert(addr, gocheck.Equals, "myapp.galeb.com")
 }
 
+func (s *S) TestIsNotWeightedRouter(c *gocheck.C) {
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	_, ok := gRouter.(router.WeightedRouter)
+	c.Assert(ok, gocheck.Equals, false)
+}
+
+func (s *S) TestIsNotTLSRouter(c *gocheck.C) {
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	_, ok := gRouter.(router.TLSRouter)
+	c.Assert(ok, gocheck.Equals, false)
+}
+
 func (s *S) TestShouldBeRegistered(c *gocheck.C) {
 	r, err := router.Get("galeb")
 	c.Assert(err, gocheck.IsNil)
@@ -394,3 +409,168 @@ func (s *S) TestShouldBeRegisteredAllowingPrefixes(c *gocheck.C) {
 	c.Assert(r2.client.Password, gocheck.Equals, "pass2")
 	c.Assert(r2.domain, gocheck.Equals, "domain2")
 }
+
+func (s *S) TestSetRules(c *gocheck.C) {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	err = router.Store("myapp-api", "myapp-api", routerName)
+	c.Assert(err, gocheck.IsNil)
+	data := galebData{
+		Name:          "myapp",
+		VirtualHostId: "vh1",
+		CNames:        []galebCNameData{{CName: "my.cname", VirtualHostId: "vh2"}},
+		Rules: []galebRuleData{{
+			Name:         "old",
+			RuleId:       s.server.URL + "/api/rule9",
+			VirtualHosts: []galebVirtualHostRuleData{{VirtualHostId: "vh1", VirtualHostRuleId: s.server.URL + "/api/vhr9"}},
+		}},
+	}
+	err = data.save()
+	c.Assert(err, gocheck.IsNil)
+	apiData := galebData{Name: "myapp-api", BackendPoolId: "pool2"}
+	err = apiData.save()
+	c.Assert(err, gocheck.IsNil)
+	s.handler.RspCode = http.StatusNoContent
+	s.handler.ConditionalContent = map[string]interface{}{
+		"/api/rule/":            []string{"201", `{"_links":{"self":"rule1"}}`},
+		"/api/virtualhostrule/": []string{"201", `{"_links":{"self":"vhr1"}}`},
+	}
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}}
+	err = gRouter.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.handler.Url, gocheck.DeepEquals, []string{
+		"/api/rule/", "/api/virtualhostrule/", "/api/virtualhostrule/", "/api/vhr9", "/api/rule9",
+	})
+	dbData, err := getGalebData("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbData.Rules, gocheck.DeepEquals, []galebRuleData{{
+		Name:    "api",
+		Version: 1,
+		RuleId:  "rule1",
+		VirtualHosts: []galebVirtualHostRuleData{
+			{VirtualHostId: "vh1", VirtualHostRuleId: "vhr1"},
+			{VirtualHostId: "vh2", VirtualHostRuleId: "vhr1"},
+		},
+	}})
+	result := map[string]interface{}{}
+	err = json.Unmarshal(s.handler.Body[0], &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, map[string]interface{}{
+		"name": "tsuru-rule-myapp-api-v1", "match": "/api", "backendpool": "pool2", "ruletype": "", "project": "",
+	})
+	result = map[string]interface{}{}
+	err = json.Unmarshal(s.handler.Body[2], &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, map[string]interface{}{
+		"order": float64(2), "virtualhost": "vh2", "rule": "rule1",
+	})
+}
+
+func (s *S) TestSetRulesWithoutOldRules(c *gocheck.C) {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	err = router.Store("myapp-api", "myapp-api", routerName)
+	c.Assert(err, gocheck.IsNil)
+	data := galebData{Name: "myapp", VirtualHostId: "vh1"}
+	err = data.save()
+	c.Assert(err, gocheck.IsNil)
+	apiData := galebData{Name: "myapp-api", BackendPoolId: "pool2"}
+	err = apiData.save()
+	c.Assert(err, gocheck.IsNil)
+	s.handler.ConditionalContent = map[string]interface{}{
+		"/api/rule/":            []string{"201", `{"_links":{"self":"rule1"}}`},
+		"/api/virtualhostrule/": []string{"201", `{"_links":{"self":"vhr1"}}`},
+	}
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}}
+	err = gRouter.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.handler.Url, gocheck.DeepEquals, []string{"/api/rule/", "/api/virtualhostrule/"})
+	result := map[string]interface{}{}
+	err = json.Unmarshal(s.handler.Body[0], &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result["name"], gocheck.Equals, "tsuru-rule-myapp-api")
+	result = map[string]interface{}{}
+	err = json.Unmarshal(s.handler.Body[1], &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result["order"], gocheck.Equals, float64(1))
+}
+
+func (s *S) TestSetRulesKeepsOldRulesOnFailure(c *gocheck.C) {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	err = router.Store("myapp-api", "myapp-api", routerName)
+	c.Assert(err, gocheck.IsNil)
+	oldRules := []galebRuleData{{
+		Name:         "old",
+		RuleId:       s.server.URL + "/api/rule9",
+		VirtualHosts: []galebVirtualHostRuleData{{VirtualHostId: "vh1", VirtualHostRuleId: s.server.URL + "/api/vhr9"}},
+	}}
+	data := galebData{Name: "myapp", VirtualHostId: "vh1", Rules: oldRules}
+	err = data.save()
+	c.Assert(err, gocheck.IsNil)
+	apiData := galebData{Name: "myapp-api", BackendPoolId: "pool2"}
+	err = apiData.save()
+	c.Assert(err, gocheck.IsNil)
+	s.handler.RspCode = http.StatusNoContent
+	s.handler.ConditionalContent = map[string]interface{}{
+		"/api/rule/":            []string{"201", `{"_links":{"self":"` + s.server.URL + `/api/rule1"}}`},
+		"/api/virtualhostrule/": []string{"500", "failed"},
+	}
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}}
+	err = gRouter.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(s.handler.Url, gocheck.DeepEquals, []string{"/api/rule/", "/api/virtualhostrule/", "/api/rule1"})
+	dbData, err := getGalebData("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbData.Rules, gocheck.DeepEquals, oldRules)
+}
+
+func (s *S) TestSetRulesRedirectIsNotSupported(c *gocheck.C) {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	data := galebData{Name: "myapp", VirtualHostId: "vh1"}
+	err = data.save()
+	c.Assert(err, gocheck.IsNil)
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"}}
+	err = gRouter.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.Equals, router.ErrRuleNotSupported)
+	c.Assert(s.handler.Url, gocheck.HasLen, 0)
+}
+
+func (s *S) TestUnsetCNameUnlinksRules(c *gocheck.C) {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	data := galebData{
+		Name:   "myapp",
+		CNames: []galebCNameData{{CName: "my.cname", VirtualHostId: s.server.URL + "/api/vh2"}},
+		Rules: []galebRuleData{{
+			Name:   "api",
+			RuleId: "rule1",
+			VirtualHosts: []galebVirtualHostRuleData{
+				{VirtualHostId: "vh1", VirtualHostRuleId: s.server.URL + "/api/vhr1"},
+				{VirtualHostId: s.server.URL + "/api/vh2", VirtualHostRuleId: s.server.URL + "/api/vhr2"},
+			},
+		}},
+	}
+	err = data.save()
+	c.Assert(err, gocheck.IsNil)
+	s.handler.RspCode = http.StatusNoContent
+	gRouter, err := createRouter("galeb")
+	c.Assert(err, gocheck.IsNil)
+	err = gRouter.UnsetCName("my.cname", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.handler.Url, gocheck.DeepEquals, []string{"/api/vhr2", "/api/vh2"})
+	dbData, err := getGalebData("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dbData.Rules[0].VirtualHosts, gocheck.DeepEquals, []galebVirtualHostRuleData{
+		{VirtualHostId: "vh1", VirtualHostRuleId: s.server.URL + "/api/vhr1"},
+	})
+}
@@ -16,6 +16,23 @@ type galebRealData struct {
 	BackendId string
 }
 
+// galebVirtualHostRuleData links a rule to one of the virtual hosts of a
+// backend.
+type galebVirtualHostRuleData struct {
+	VirtualHostId     string
+	VirtualHostRuleId string
+}
+
+// galebRuleData is a path rule of a backend. Version tells apart the galeb
+// rules of the same path rule while SetRules replaces them, as their names
+// must be unique.
+type galebRuleData struct {
+	Name         string
+	Version      int
+	RuleId       string
+	VirtualHosts []galebVirtualHostRuleData
+}
+
 type galebData struct {
 	Name          string `bson:"_id"`
 	BackendPoolId string
@@ -23,6 +40,7 @@ type galebData struct {
 	VirtualHostId string
 	CNames        []galebCNameData
 	Reals         []galebRealData
+	Rules         []galebRuleData
 }
 
 func (g *galebData) save() error {
@@ -73,6 +91,14 @@ func (g *galebData) removeCName(cname string) error {
 	}})
 }
 
+func (g *galebData) setRules(rules []galebRuleData) error {
+	coll, err := collection()
+	if err != nil {
+		return err
+	}
+	return coll.UpdateId(g.Name, bson.M{"$set": bson.M{"rules": rules}})
+}
+
 func (g *galebData) remove() error {
 	coll, err := collection()
 	if err != nil {
@@ -0,0 +1,170 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hipache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/log"
+)
+
+const (
+	defaultRedisServer = "localhost:6379"
+	redisMaxIdle       = 10
+	redisIdleTimeout   = 180 * time.Second
+)
+
+var errNoMaster = errors.New("no redis master found")
+
+var (
+	pools    = make(map[string]*redis.Pool)
+	poolsMut sync.Mutex
+)
+
+// redisDialer connects to the redis master used by a hipache router. The
+// master is either the only configured server, the first master in the list
+// of configured servers, or the master monitored by Redis Sentinel.
+type redisDialer struct {
+	servers   []string
+	sentinels []string
+	master    string
+}
+
+func newRedisDialer(prefix string) *redisDialer {
+	var d redisDialer
+	d.sentinels, _ = config.GetList(prefix + ":redis-sentinel-addrs")
+	if len(d.sentinels) > 0 {
+		d.master, _ = config.GetString(prefix + ":redis-sentinel-master")
+		if d.master == "" {
+			d.master = "mymaster"
+		}
+		return &d
+	}
+	d.servers, _ = config.GetList(prefix + ":redis-servers")
+	if len(d.servers) == 0 {
+		srv, err := config.GetString(prefix + ":redis-server")
+		if err != nil {
+			srv = defaultRedisServer
+		}
+		d.servers = []string{srv}
+	}
+	return &d
+}
+
+// discovers returns whether the master may change, in which case the role
+// of connections is checked before they're used.
+func (d *redisDialer) discovers() bool {
+	return len(d.sentinels) > 0 || len(d.servers) > 1
+}
+
+func (d *redisDialer) dial() (redis.Conn, error) {
+	if len(d.sentinels) > 0 {
+		return d.dialSentinelMaster()
+	}
+	if !d.discovers() {
+		return redis.Dial("tcp", d.servers[0])
+	}
+	for _, server := range d.servers {
+		conn, err := redis.Dial("tcp", server)
+		if err != nil {
+			log.Errorf("[hipache] Failed to connect to redis server %s: %s", server, err)
+			continue
+		}
+		if err = checkMaster(conn); err == nil {
+			return conn, nil
+		}
+		conn.Close()
+	}
+	return nil, errNoMaster
+}
+
+// dialSentinelMaster asks the sentinels, in order, for the address of the
+// master, and connects to it.
+func (d *redisDialer) dialSentinelMaster() (redis.Conn, error) {
+	for _, sentinel := range d.sentinels {
+		addr, err := sentinelMasterAddr(sentinel, d.master)
+		if err != nil {
+			log.Errorf("[hipache] Failed to get the redis master %q from the sentinel %s: %s", d.master, sentinel, err)
+			continue
+		}
+		conn, err := redis.Dial("tcp", addr)
+		if err != nil {
+			log.Errorf("[hipache] Failed to connect to the redis master %s: %s", addr, err)
+			continue
+		}
+		// The sentinel may not have noticed a failover yet.
+		if err = checkMaster(conn); err != nil {
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+	return nil, errNoMaster
+}
+
+func sentinelMasterAddr(sentinel, master string) (string, error) {
+	conn, err := redis.Dial("tcp", sentinel)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", master))
+	if err != nil {
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("unknown master %q", master)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
+
+// checkMaster returns an error if the connection is not to a redis master.
+func checkMaster(conn redis.Conn) error {
+	info, err := redis.String(conn.Do("INFO", "replication"))
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.TrimSpace(line) == "role:master" {
+			return nil
+		}
+	}
+	return errNoMaster
+}
+
+// testOnBorrow discards pooled connections to servers that are no longer the
+// master, so the pool reconnects to the new master after a failover.
+func (d *redisDialer) testOnBorrow(conn redis.Conn, t time.Time) error {
+	if !d.discovers() {
+		return nil
+	}
+	return checkMaster(conn)
+}
+
+// getPool returns the pool of connections of the router with the given
+// prefix, creating it in the first call.
+func getPool(prefix string) *redis.Pool {
+	poolsMut.Lock()
+	defer poolsMut.Unlock()
+	pool, ok := pools[prefix]
+	if !ok {
+		dialer := newRedisDialer(prefix)
+		pool = &redis.Pool{
+			Dial:         dialer.dial,
+			TestOnBorrow: dialer.testOnBorrow,
+			MaxIdle:      redisMaxIdle,
+			IdleTimeout:  redisIdleTimeout,
+		}
+		pools[prefix] = pool
+	}
+	return pool
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hipache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/tsuru/config"
+	"launchpad.net/gocheck"
+)
+
+// startFakeSentinel starts a server that answers the SENTINEL
+// get-master-addr-by-name command with the given address, for the master
+// named mymaster.
+func startFakeSentinel(c *gocheck.C, host, port string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gocheck.IsNil)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					var argc int
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fmt.Sscanf(line, "*%d", &argc)
+					args := make([]string, argc)
+					for i := range args {
+						reader.ReadString('\n')
+						arg, _ := reader.ReadString('\n')
+						args[i] = strings.TrimSpace(arg)
+					}
+					if len(args) == 3 && strings.ToUpper(args[0]) == "SENTINEL" && args[2] == "mymaster" {
+						fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+					} else {
+						fmt.Fprint(conn, "*-1\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func (s *S) TestGetPoolIsPerPrefix(c *gocheck.C) {
+	config.Set("routers:other:redis-server", "127.0.0.1:6380")
+	defer config.Unset("routers:other:redis-server")
+	c.Assert(getPool("hipache"), gocheck.Equals, getPool("hipache"))
+	c.Assert(getPool("hipache"), gocheck.Not(gocheck.Equals), getPool("routers:other"))
+	conn := hipacheRouter{prefix: "hipache"}.connect()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	c.Assert(err, gocheck.IsNil)
+	other := hipacheRouter{prefix: "routers:other"}.connect()
+	defer other.Close()
+	_, err = other.Do("PING")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestConnectRedisServers(c *gocheck.C) {
+	config.Set("routers:servers:redis-servers", []string{"127.0.0.1:6380", "127.0.0.1:6379"})
+	defer config.Unset("routers:servers:redis-servers")
+	conn := hipacheRouter{prefix: "routers:servers"}.connect()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestConnectRedisServersWithoutMaster(c *gocheck.C) {
+	config.Set("routers:servers:redis-servers", []string{"127.0.0.1:6380", "127.0.0.1:6381"})
+	defer config.Unset("routers:servers:redis-servers")
+	conn := hipacheRouter{prefix: "routers:servers"}.connect()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	c.Assert(err, gocheck.Equals, errNoMaster)
+}
+
+func (s *S) TestConnectRedisSentinel(c *gocheck.C) {
+	sentinel := startFakeSentinel(c, "127.0.0.1", "6379")
+	defer sentinel.Close()
+	config.Set("routers:sentinel:redis-sentinel-addrs", []string{"127.0.0.1:26380", sentinel.Addr().String()})
+	defer config.Unset("routers:sentinel:redis-sentinel-addrs")
+	conn := hipacheRouter{prefix: "routers:sentinel"}.connect()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestConnectRedisSentinelUnknownMaster(c *gocheck.C) {
+	sentinel := startFakeSentinel(c, "127.0.0.1", "6379")
+	defer sentinel.Close()
+	config.Set("routers:sentinel:redis-sentinel-addrs", []string{sentinel.Addr().String()})
+	defer config.Unset("routers:sentinel:redis-sentinel-addrs")
+	config.Set("routers:sentinel:redis-sentinel-master", "othermaster")
+	defer config.Unset("routers:sentinel:redis-sentinel-master")
+	conn := hipacheRouter{prefix: "routers:sentinel"}.connect()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	c.Assert(err, gocheck.Equals, errNoMaster)
+}
@@ -10,7 +10,9 @@
 // router.Get.
 //
 // In order to use this router, you need to define the "hipache:domain"
-// setting.
+// setting. The redis master is the "hipache:redis-server", the first master
+// in the "hipache:redis-servers" list or the "hipache:redis-sentinel-master"
+// monitored by the "hipache:redis-sentinel-addrs" sentinels.
 package hipache
 
 import (
@@ -24,8 +26,6 @@ import (
 	"github.com/tsuru/tsuru/router"
 )
 
-var pool *redis.Pool
-
 const routerName = "hipache"
 
 func init() {
@@ -37,17 +37,7 @@ func createRouter(prefix string) (router.Router, error) {
 }
 
 func (r hipacheRouter) connect() redis.Conn {
-	if pool == nil {
-		srv, err := config.GetString(r.prefix + ":redis-server")
-		if err != nil {
-			srv = "localhost:6379"
-		}
-		pool = redis.NewPool(func() (redis.Conn, error) {
-			return redis.Dial("tcp", srv)
-		}, 10)
-	}
-	pool.IdleTimeout = 180e9
-	return pool.Get()
+	return getPool(r.prefix).Get()
 }
 
 type hipacheRouter struct {
@@ -97,7 +87,7 @@ func (r hipacheRouter) RemoveBackend(name string) error {
 		return nil
 	}
 	for _, cname := range cnames {
-		_, err = conn.Do("DEL", "frontend:"+cname)
+		_, err = conn.Do("DEL", "frontend:"+cname, "tls:"+cname)
 		if err != nil {
 			return &routeError{"remove", err}
 		}
@@ -246,13 +236,61 @@ func (r hipacheRouter) UnsetCName(cname, name string) error {
 	if err != nil {
 		return &routeError{"unsetCName", err}
 	}
-	_, err = conn.Do("DEL", "frontend:"+cname)
+	_, err = conn.Do("DEL", "frontend:"+cname, "tls:"+cname)
 	if err != nil {
 		return &routeError{"unsetCName", err}
 	}
 	return nil
 }
 
+// AddCertificate stores the certificate and the private key of the cname in
+// the "tls:<cname>" hash, read by hipache compatible routers that terminate
+// TLS for each host.
+func (r hipacheRouter) AddCertificate(cname, certificate, key string) error {
+	conn := r.connect()
+	defer conn.Close()
+	_, err := conn.Do("HMSET", "tls:"+cname, "certificate", certificate, "key", key)
+	if err != nil {
+		return &routeError{"addCertificate", err}
+	}
+	return nil
+}
+
+func (r hipacheRouter) RemoveCertificate(cname string) error {
+	conn := r.connect()
+	defer conn.Close()
+	_, err := conn.Do("DEL", "tls:"+cname)
+	if err != nil {
+		return &routeError{"removeCertificate", err}
+	}
+	return nil
+}
+
+func (r hipacheRouter) ListCertificates(name string) (map[string]string, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	cnames, err := r.getCNames(backendName)
+	if err != nil {
+		return nil, err
+	}
+	conn := r.connect()
+	defer conn.Close()
+	certificates := make(map[string]string)
+	for _, cname := range cnames {
+		certificate, err := redis.String(conn.Do("HGET", "tls:"+cname, "certificate"))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, &routeError{"listCertificates", err}
+		}
+		certificates[cname] = certificate
+	}
+	return certificates, nil
+}
+
 func (r hipacheRouter) Addr(name string) (string, error) {
 	backendName, err := router.Retrieve(name)
 	if err != nil {
@@ -292,7 +330,131 @@ func (r hipacheRouter) Routes(name string) ([]string, error) {
 	if err != nil {
 		return nil, &routeError{"routes", err}
 	}
-	return routes, nil
+	return uniqueRoutes(routes), nil
+}
+
+// uniqueRoutes removes the repeated routes added by SetRoutesWeight, keeping
+// the order of the routes.
+func uniqueRoutes(routes []string) []string {
+	seen := make(map[string]bool, len(routes))
+	result := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if !seen[route] {
+			seen[route] = true
+			result = append(result, route)
+		}
+	}
+	return result
+}
+
+// SetRoutesWeight emulates weighted routes by repeating routes in the
+// frontends of the backend. Hipache picks a random route of the frontend for
+// each request, so a route that appears more times gets more requests.
+func (r hipacheRouter) SetRoutesWeight(name string, addresses []string, weight int) error {
+	if weight < 1 || weight > 99 {
+		return router.ErrInvalidWeight
+	}
+	backendName, frontend, routes, err := r.frontendRoutes(name)
+	if err != nil {
+		return err
+	}
+	if len(routes) == 0 {
+		return router.ErrRouteNotFound
+	}
+	weighted := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		weighted[address] = true
+	}
+	var selected, others []string
+	for _, route := range uniqueRoutes(routes[1:]) {
+		if weighted[route] {
+			selected = append(selected, route)
+		} else {
+			others = append(others, route)
+		}
+	}
+	if len(selected) == 0 || len(selected) != len(weighted) {
+		return router.ErrRouteNotFound
+	}
+	selectedCopies, otherCopies := 1, 1
+	if len(others) > 0 {
+		selectedCopies = len(others) * weight
+		otherCopies = len(selected) * (100 - weight)
+		d := gcd(selectedCopies, otherCopies)
+		selectedCopies /= d
+		otherCopies /= d
+	}
+	entries := []string{routes[0]}
+	for i := 0; i < otherCopies; i++ {
+		entries = append(entries, others...)
+	}
+	for i := 0; i < selectedCopies; i++ {
+		entries = append(entries, selected...)
+	}
+	return r.setFrontendsRoutes(backendName, frontend, entries)
+}
+
+func (r hipacheRouter) ResetRoutesWeight(name string) error {
+	backendName, frontend, routes, err := r.frontendRoutes(name)
+	if err != nil || len(routes) == 0 {
+		return err
+	}
+	return r.setFrontendsRoutes(backendName, frontend, uniqueRoutes(routes))
+}
+
+func (r hipacheRouter) frontendRoutes(name string) (string, string, []string, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return "", "", nil, err
+	}
+	domain, err := config.GetString(r.prefix + ":domain")
+	if err != nil {
+		return "", "", nil, &routeError{"weight", err}
+	}
+	frontend := "frontend:" + backendName + "." + domain
+	conn := r.connect()
+	defer conn.Close()
+	routes, err := redis.Strings(conn.Do("LRANGE", frontend, 0, -1))
+	if err != nil {
+		return "", "", nil, &routeError{"weight", err}
+	}
+	return backendName, frontend, routes, nil
+}
+
+// setFrontendsRoutes replaces the entries of the frontend of the backend, and
+// of the frontends of its cnames, in a single transaction for each frontend.
+func (r hipacheRouter) setFrontendsRoutes(backendName, frontend string, entries []string) error {
+	cnames, err := r.getCNames(backendName)
+	if err != nil {
+		return err
+	}
+	frontends := []string{frontend}
+	for _, cname := range cnames {
+		frontends = append(frontends, "frontend:"+cname)
+	}
+	conn := r.connect()
+	defer conn.Close()
+	for _, f := range frontends {
+		args := []interface{}{f}
+		for _, entry := range entries {
+			args = append(args, entry)
+		}
+		conn.Send("MULTI")
+		conn.Send("DEL", f)
+		conn.Send("RPUSH", args...)
+		_, err = conn.Do("EXEC")
+		if err != nil {
+			return &routeError{"weight", err}
+		}
+	}
+	return nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
 }
 
 func (r hipacheRouter) removeElement(name, address string) error {
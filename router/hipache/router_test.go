@@ -43,18 +43,13 @@ func (s *S) TearDownSuite(c *gocheck.C) {
 }
 
 func (s *S) SetUpTest(c *gocheck.C) {
-	srv, err := config.GetString("hipache:redis-server")
-	if err != nil {
-		srv = "localhost:6379"
-	}
-	pool = redis.NewPool(func() (redis.Conn, error) {
-		return redis.Dial("tcp", srv)
-	}, 10)
+	pools = make(map[string]*redis.Pool)
 	rtest := hipacheRouter{prefix: "hipache"}
 	conn = rtest.connect()
 	rtesting.ClearRedisKeys("frontend*", c)
 	rtesting.ClearRedisKeys("cname*", c)
 	rtesting.ClearRedisKeys("*.com", c)
+	rtesting.ClearRedisKeys("tls*", c)
 }
 
 func (s *S) TestConnect(c *gocheck.C) {
@@ -67,20 +62,20 @@ func (s *S) TestConnect(c *gocheck.C) {
 }
 
 func (s *S) TestConnectWhenPoolIsNil(c *gocheck.C) {
-	pool = nil
+	delete(pools, "hipache")
 	rtest := hipacheRouter{prefix: "hipache"}
 	got := rtest.connect()
 	defer got.Close()
 	_, err := got.Do("PING")
 	c.Assert(err, gocheck.IsNil)
 	got.Close()
-	c.Assert(pool, gocheck.NotNil)
+	c.Assert(pools["hipache"], gocheck.NotNil)
 }
 
 func (s *S) TestConnectWhenConnIsNilAndCannotConnect(c *gocheck.C) {
 	config.Set("hipache:redis-server", "127.0.0.1:6380")
 	defer config.Unset("hipache:redis-server")
-	pool = nil
+	delete(pools, "hipache")
 	rtest := hipacheRouter{prefix: "hipache"}
 	got := rtest.connect()
 	_, err := got.Do("PING")
@@ -176,7 +171,7 @@ func (s *S) TestAddRouteNoDomainConfigured(c *gocheck.C) {
 func (s *S) TestAddRouteConnectFailure(c *gocheck.C) {
 	config.Set("hipache:redis-server", "127.0.0.1:6380")
 	defer config.Unset("hipache:redis-server")
-	pool = nil
+	delete(pools, "hipache")
 	err := hipacheRouter{prefix: "hipache"}.AddRoute("tip", "http://www.tsuru.io")
 	c.Assert(err, gocheck.NotNil)
 	e, ok := err.(*routeError)
@@ -185,7 +180,7 @@ func (s *S) TestAddRouteConnectFailure(c *gocheck.C) {
 }
 
 func (s *S) TestAddRouteCommandFailure(c *gocheck.C) {
-	pool = redis.NewPool(fakeConnect, 5)
+	pools["hipache"] = redis.NewPool(fakeConnect, 5)
 	conn = &rtesting.FailingFakeRedisConn{}
 	err := hipacheRouter{prefix: "hipache"}.AddRoute("tip", "http://www.tsuru.io")
 	c.Assert(err, gocheck.NotNil)
@@ -240,7 +235,7 @@ func (s *S) TestRemoveRouteNoDomainConfigured(c *gocheck.C) {
 func (s *S) TestRemoveRouteConnectFailure(c *gocheck.C) {
 	config.Set("hipache:redis-server", "127.0.0.1:6380")
 	defer config.Unset("hipache:redis-server")
-	pool = nil
+	delete(pools, "hipache")
 	err := hipacheRouter{prefix: "hipache"}.RemoveRoute("tip", "tip.golang.org")
 	c.Assert(err, gocheck.NotNil)
 	e, ok := err.(*routeError)
@@ -249,7 +244,7 @@ func (s *S) TestRemoveRouteConnectFailure(c *gocheck.C) {
 }
 
 func (s *S) TestRemoveRouteCommandFailure(c *gocheck.C) {
-	pool = redis.NewPool(fakeConnect, 5)
+	pools["hipache"] = redis.NewPool(fakeConnect, 5)
 	conn = &rtesting.FailingFakeRedisConn{}
 	err := hipacheRouter{prefix: "hipache"}.RemoveRoute("tip", "tip.golang.org")
 	c.Assert(err, gocheck.NotNil)
@@ -366,6 +361,19 @@ func (s *S) TestUnsetCName(c *gocheck.C) {
 	c.Assert(0, gocheck.Equals, cnames)
 }
 
+func (s *S) TestUnsetCNameRemovesCertificate(c *gocheck.C) {
+	router := hipacheRouter{prefix: "hipache"}
+	err := router.SetCName("myapp.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = router.AddCertificate("myapp.com", "cert", "key")
+	c.Assert(err, gocheck.IsNil)
+	err = router.UnsetCName("myapp.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	exists, err := redis.Bool(conn.Do("EXISTS", "tls:myapp.com"))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(exists, gocheck.Equals, false)
+}
+
 func (s *S) TestUnsetTwoCNames(c *gocheck.C) {
 	router := hipacheRouter{prefix: "hipache"}
 	err := router.SetCName("myapp.com", "myapp")
@@ -415,7 +423,7 @@ func (s *S) TestAddrNoDomainConfigured(c *gocheck.C) {
 func (s *S) TestAddrConnectFailure(c *gocheck.C) {
 	config.Set("hipache:redis-server", "127.0.0.1:6380")
 	defer config.Unset("hipache:redis-server")
-	pool = nil
+	delete(pools, "hipache")
 	addr, err := hipacheRouter{prefix: "hipache"}.Addr("tip")
 	c.Assert(addr, gocheck.Equals, "")
 	e, ok := err.(*routeError)
@@ -424,7 +432,7 @@ func (s *S) TestAddrConnectFailure(c *gocheck.C) {
 }
 
 func (s *S) TestAddrCommandFailure(c *gocheck.C) {
-	pool = redis.NewPool(fakeConnect, 5)
+	pools["hipache"] = redis.NewPool(fakeConnect, 5)
 	conn = &rtesting.FailingFakeRedisConn{}
 	addr, err := hipacheRouter{prefix: "hipache"}.Addr("tip")
 	c.Assert(addr, gocheck.Equals, "")
@@ -467,6 +475,67 @@ func (s *S) TestRoutes(c *gocheck.C) {
 	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
 }
 
+func (s *S) TestRoutesIgnoresRepeatedRoutes(c *gocheck.C) {
+	router := hipacheRouter{prefix: "hipache"}
+	err := router.AddBackend("tip")
+	c.Assert(err, gocheck.IsNil)
+	defer router.RemoveBackend("tip")
+	_, err = conn.Do("RPUSH", "frontend:tip.golang.org", "http://10.10.10.10", "http://10.10.10.11", "http://10.10.10.11")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := router.Routes("tip")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"tip", "http://10.10.10.10", "http://10.10.10.11"})
+}
+
+func (s *S) TestSetRoutesWeight(c *gocheck.C) {
+	router := hipacheRouter{prefix: "hipache"}
+	err := router.AddBackend("tip")
+	c.Assert(err, gocheck.IsNil)
+	defer router.RemoveBackend("tip")
+	for _, route := range []string{"http://10.10.10.10", "http://10.10.10.11", "http://10.10.10.12", "http://10.10.10.13"} {
+		err = router.AddRoute("tip", route)
+		c.Assert(err, gocheck.IsNil)
+	}
+	err = router.SetCName("mycname.com", "tip")
+	c.Assert(err, gocheck.IsNil)
+	err = router.SetRoutesWeight("tip", []string{"http://10.10.10.13"}, 50)
+	c.Assert(err, gocheck.IsNil)
+	expected := []string{
+		"tip", "http://10.10.10.10", "http://10.10.10.11", "http://10.10.10.12",
+		"http://10.10.10.13", "http://10.10.10.13", "http://10.10.10.13",
+	}
+	routes, err := redis.Strings(conn.Do("LRANGE", "frontend:tip.golang.org", 0, -1))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, expected)
+	routes, err = redis.Strings(conn.Do("LRANGE", "frontend:mycname.com", 0, -1))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, expected)
+	err = router.ResetRoutesWeight("tip")
+	c.Assert(err, gocheck.IsNil)
+	routes, err = redis.Strings(conn.Do("LRANGE", "frontend:tip.golang.org", 0, -1))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, expected[:5])
+}
+
+func (s *S) TestSetRoutesWeightInvalidWeight(c *gocheck.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.SetRoutesWeight("tip", []string{"http://10.10.10.10"}, 100)
+	c.Assert(err, gocheck.Equals, router.ErrInvalidWeight)
+	err = r.SetRoutesWeight("tip", []string{"http://10.10.10.10"}, 0)
+	c.Assert(err, gocheck.Equals, router.ErrInvalidWeight)
+}
+
+func (s *S) TestSetRoutesWeightRouteNotFound(c *gocheck.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.AddBackend("tip")
+	c.Assert(err, gocheck.IsNil)
+	defer r.RemoveBackend("tip")
+	err = r.AddRoute("tip", "http://10.10.10.10")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetRoutesWeight("tip", []string{"http://10.10.10.11"}, 10)
+	c.Assert(err, gocheck.Equals, router.ErrRouteNotFound)
+}
+
 func (s *S) TestSwap(c *gocheck.C) {
 	backend1 := "b1"
 	backend2 := "b2"
@@ -484,3 +553,39 @@ func (s *S) TestSwap(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert([]string{"b2", "http://10.10.10.10"}, gocheck.DeepEquals, backend2Routes)
 }
+
+func (s *S) TestAddCertificate(c *gocheck.C) {
+	router := hipacheRouter{prefix: "hipache"}
+	err := router.AddCertificate("myapp.com", "cert", "key")
+	c.Assert(err, gocheck.IsNil)
+	values, err := redis.Strings(conn.Do("HMGET", "tls:myapp.com", "certificate", "key"))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(values, gocheck.DeepEquals, []string{"cert", "key"})
+}
+
+func (s *S) TestRemoveCertificate(c *gocheck.C) {
+	router := hipacheRouter{prefix: "hipache"}
+	err := router.AddCertificate("myapp.com", "cert", "key")
+	c.Assert(err, gocheck.IsNil)
+	err = router.RemoveCertificate("myapp.com")
+	c.Assert(err, gocheck.IsNil)
+	exists, err := redis.Bool(conn.Do("EXISTS", "tls:myapp.com"))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(exists, gocheck.Equals, false)
+}
+
+func (s *S) TestListCertificates(c *gocheck.C) {
+	router := hipacheRouter{prefix: "hipache"}
+	err := router.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	defer router.RemoveBackend("myapp")
+	err = router.SetCName("myapp.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = router.SetCName("other.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = router.AddCertificate("myapp.com", "cert", "key")
+	c.Assert(err, gocheck.IsNil)
+	certificates, err := router.ListCertificates("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificates, gocheck.DeepEquals, map[string]string{"myapp.com": "cert"})
+}
@@ -0,0 +1,227 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+)
+
+// MovedBackend is the backend of an app left in its previous router by Move.
+// It keeps answering the requests of clients that still resolve the previous
+// address of the app, and must be removed with RemoveMovedBackend after that.
+type MovedBackend struct {
+	App     string
+	Backend string
+	Kind    string
+}
+
+type movePipelineArgs struct {
+	to     Router
+	moved  MovedBackend
+	routes []string
+	cnames []string
+}
+
+// storeRouterData replaces the entry of the app in the routers collection.
+func storeRouterData(appName, backendName, kind string) error {
+	err := Remove(appName)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return Store(appName, backendName, kind)
+}
+
+var addBackendToTarget = action.Action{
+	Name: "add-backend-to-target",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(movePipelineArgs)
+		// AddBackend stores a new entry for the app, pointing to the target
+		// router, so the current entry must be removed first.
+		err := Remove(args.moved.App)
+		if err != nil {
+			return nil, err
+		}
+		err = args.to.AddBackend(args.moved.App)
+		if err != nil {
+			if err := storeRouterData(args.moved.App, args.moved.Backend, args.moved.Kind); err != nil {
+				log.Errorf("[add-backend-to-target:Forward] Error restoring the router of %s: %s", args.moved.App, err)
+			}
+			return nil, err
+		}
+		return nil, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(movePipelineArgs)
+		if err := args.to.RemoveBackend(args.moved.App); err != nil {
+			log.Errorf("[add-backend-to-target:Backward] Error removing the backend of %s: %s", args.moved.App, err)
+		}
+		if err := storeRouterData(args.moved.App, args.moved.Backend, args.moved.Kind); err != nil {
+			log.Errorf("[add-backend-to-target:Backward] Error restoring the router of %s: %s", args.moved.App, err)
+		}
+	},
+	MinParams: 1,
+}
+
+var addRoutesToTarget = action.Action{
+	Name: "add-routes-to-target",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(movePipelineArgs)
+		return nil, addRoutes(args.to, args.moved.App, args.routes)
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(movePipelineArgs)
+		restoreAddedRoutes("add-routes-to-target", args.to, args.moved.App, args.routes)
+	},
+	MinParams: 1,
+}
+
+var setCNamesInTarget = action.Action{
+	Name: "set-cnames-in-target",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(movePipelineArgs)
+		for i, cname := range args.cnames {
+			err := args.to.SetCName(cname, args.moved.App)
+			if err != nil {
+				for _, added := range args.cnames[:i] {
+					args.to.UnsetCName(added, args.moved.App)
+				}
+				return nil, err
+			}
+		}
+		return nil, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(movePipelineArgs)
+		for _, cname := range args.cnames {
+			if err := args.to.UnsetCName(cname, args.moved.App); err != nil {
+				log.Errorf("[set-cnames-in-target:Backward] Error unsetting the cname %s of %s: %s", cname, args.moved.App, err)
+			}
+		}
+	},
+	MinParams: 1,
+}
+
+// Move adds a backend for the app to the router to, with the given routes
+// and cnames, and points the app to it in the routers collection. The backend
+// of the app in its current router is kept, so it keeps answering requests,
+// and is returned to be removed later with RemoveMovedBackend. A failure in
+// any step removes the app from the router to.
+func Move(to Router, appName string, routes, cnames []string) (*MovedBackend, error) {
+	data, err := retrieveRouterData(appName)
+	if err != nil {
+		return nil, err
+	}
+	args := movePipelineArgs{
+		to:     to,
+		moved:  MovedBackend{App: appName, Backend: data["router"], Kind: data["kind"]},
+		routes: routes,
+		cnames: cnames,
+	}
+	pipeline := action.NewPipeline(&addBackendToTarget, &addRoutesToTarget, &setCNamesInTarget)
+	err = pipeline.Execute(args)
+	if err != nil {
+		return nil, err
+	}
+	return &args.moved, nil
+}
+
+// UndoMove removes the app from the router it was moved to, pointing it back
+// to its previous backend.
+func UndoMove(to Router, moved *MovedBackend) error {
+	err := to.RemoveBackend(moved.App)
+	if err != nil {
+		return err
+	}
+	return storeRouterData(moved.App, moved.Backend, moved.Kind)
+}
+
+// RemoveMovedBackend removes the backend left by Move in the previous router
+// of the app, from.
+func RemoveMovedBackend(from Router, moved *MovedBackend) error {
+	return withMovedBackend(moved, func() error {
+		return from.RemoveBackend(moved.App)
+	})
+}
+
+// SyncMovedBackend makes the routes of the backend left by Move in the
+// previous router of the app, from, match the routes of the app in its current
+// router, to. The previous backend keeps answering requests after the move, so
+// it must follow the units of the app until it's removed.
+func SyncMovedBackend(from, to Router, moved *MovedBackend) error {
+	routes, err := AppRoutes(to, moved.App)
+	if err != nil {
+		return err
+	}
+	return withMovedBackend(moved, func() error {
+		current, err := AppRoutes(from, moved.App)
+		if err != nil {
+			return err
+		}
+		expected := make(map[string]bool, len(routes))
+		for _, route := range routes {
+			expected[RouteHost(route)] = true
+		}
+		existing := make(map[string]bool, len(current))
+		for _, route := range current {
+			existing[RouteHost(route)] = true
+			if !expected[RouteHost(route)] {
+				if err := from.RemoveRoute(moved.App, route); err != nil && err != ErrRouteNotFound {
+					return err
+				}
+			}
+		}
+		for _, route := range routes {
+			if !existing[RouteHost(route)] {
+				if err := from.AddRoute(moved.App, RouteURL(route)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// withMovedBackend runs fn with the entry of the app in the routers collection
+// pointing to the backend left by Move, as routers find the backend of an app
+// in the routers collection. The entry is pointed back to the current backend
+// of the app after fn returns.
+func withMovedBackend(moved *MovedBackend, fn func() error) error {
+	current, err := retrieveRouterData(moved.App)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	// The entry of a removed app is removed again after fn returns.
+	removed := err == mgo.ErrNotFound
+	// RemoveBackend removes the entry named after the backend, which belongs
+	// to another app when the backend was swapped.
+	var other map[string]string
+	if moved.Backend != moved.App {
+		if data, err := retrieveRouterData(moved.Backend); err == nil {
+			other = data
+		}
+	}
+	err = storeRouterData(moved.App, moved.Backend, moved.Kind)
+	if err != nil {
+		return err
+	}
+	fnErr := fn()
+	if removed {
+		err = Remove(moved.App)
+		if err == mgo.ErrNotFound {
+			err = nil
+		}
+	} else {
+		err = storeRouterData(moved.App, current["router"], current["kind"])
+	}
+	if err == nil && other != nil {
+		err = storeRouterData(moved.Backend, other["router"], other["kind"])
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
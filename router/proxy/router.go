@@ -0,0 +1,114 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package proxy provides a router implementation that stores backends, routes
+// and cnames in MongoDB, and a reverse proxy that serves them, started by the
+// "tsr router" command. It requires no software other than tsuru and MongoDB,
+// which makes it suitable for small installations and local development.
+//
+// The only exported type is the Server used by "tsr router". In order to
+// use the router, you must import this package and get the router instance
+// using the function router.Get.
+//
+// In order to use this router, you need to define the "<prefix>:domain"
+// setting. The "<prefix>:listen" and "<prefix>:reload-interval" settings are
+// used by the proxy server and are optional.
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const routerName = "proxy"
+
+func init() {
+	router.Register(routerName, createRouter)
+}
+
+// proxyRouter stores the routes and rules of the backends, handled by
+// router.MongoBackends.
+type proxyRouter struct {
+	router.MongoBackends
+}
+
+func createRouter(prefix string) (router.Router, error) {
+	domain, err := config.GetString(prefix + ":domain")
+	if err != nil {
+		return nil, err
+	}
+	r := proxyRouter{router.MongoBackends{
+		Collection: backendsColl,
+		Router:     routerName,
+		Prefix:     prefix,
+		Domain:     domain,
+	}}
+	return &r, nil
+}
+
+func (r *proxyRouter) AddRoute(name, address string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	return backendsColl.Update(backendName, bson.M{}, bson.M{"$addToSet": bson.M{"routes": address}}, nil)
+}
+
+func (r *proxyRouter) RemoveRoute(name, address string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	return backendsColl.Update(backendName,
+		bson.M{"routes": address},
+		bson.M{"$pull": bson.M{"routes": address}},
+		router.ErrRouteNotFound,
+	)
+}
+
+func (r *proxyRouter) Swap(backend1, backend2 string) error {
+	return router.Swap(r, backend1, backend2)
+}
+
+func (r *proxyRouter) Routes(name string) ([]string, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := getBackend(backendName)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Routes, nil
+}
+
+// SetRules stores the rules of the backend, replacing the app of path rules
+// with the name of its backend, which must be served by the same router.
+func (r *proxyRouter) SetRules(name string, rules []router.Rule) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	stored := make([]router.Rule, len(rules))
+	for i, rule := range rules {
+		if rule.Type == router.PathRule {
+			rule.App, err = router.Retrieve(rule.App)
+			if err != nil {
+				return err
+			}
+			target, err := getBackend(rule.App)
+			if err != nil {
+				return err
+			}
+			if target.Router != r.Prefix {
+				return fmt.Errorf("The backend %s is not served by this router.", rule.App)
+			}
+		}
+		stored[i] = rule
+	}
+	return backendsColl.Update(backendName, bson.M{}, bson.M{"$set": bson.M{"rules": stored}}, nil)
+}
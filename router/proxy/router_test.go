@@ -0,0 +1,219 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/router"
+	ttesting "github.com/tsuru/tsuru/testing"
+	"launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) {
+	gocheck.TestingT(t)
+}
+
+type S struct {
+	conn *db.Storage
+}
+
+var _ = gocheck.Suite(&S{})
+
+func (s *S) SetUpSuite(c *gocheck.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "router_proxy_tests")
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TearDownSuite(c *gocheck.C) {
+	s.conn.Collection("proxy_router").Database.DropDatabase()
+	s.conn.Close()
+}
+
+func (s *S) SetUpTest(c *gocheck.C) {
+	config.Set("proxy:domain", "tsuru.io")
+	config.Set("proxy:reload-interval", 1)
+	ttesting.ClearAllCollections(s.conn.Collection("proxy_router").Database)
+}
+
+func (s *S) getRouter(c *gocheck.C) router.Router {
+	r, err := createRouter("proxy")
+	c.Assert(err, gocheck.IsNil)
+	return r
+}
+
+func (s *S) TestShouldBeRegistered(c *gocheck.C) {
+	r, err := router.Get("proxy")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(r, gocheck.FitsTypeOf, &proxyRouter{})
+}
+
+func (s *S) TestCreateRouterWithoutDomain(c *gocheck.C) {
+	config.Unset("proxy:domain")
+	_, err := createRouter("proxy")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestAddBackend(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	backend, err := getBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.Router, gocheck.Equals, "proxy")
+	name, err := router.Retrieve("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "myapp")
+	err = r.AddBackend("myapp")
+	c.Assert(err, gocheck.ErrorMatches, "Backend already exists")
+}
+
+func (s *S) TestRemoveBackend(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.RemoveBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	_, err = getBackend("myapp")
+	c.Assert(err, gocheck.Equals, router.ErrBackendNotFound)
+	_, err = router.Retrieve("myapp")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestAddRoute(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080", "http://10.10.10.11:8080"})
+}
+
+func (s *S) TestAddRouteBackendNotFound(c *gocheck.C) {
+	err := router.Store("myapp", "myapp", routerName)
+	c.Assert(err, gocheck.IsNil)
+	defer router.Remove("myapp")
+	r := s.getRouter(c)
+	err = r.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.Equals, router.ErrBackendNotFound)
+}
+
+func (s *S) TestRemoveRoute(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.RemoveRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.HasLen, 0)
+	err = r.RemoveRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.Equals, router.ErrRouteNotFound)
+}
+
+func (s *S) TestSetCName(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetCName("myapp.example.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	backend, err := getBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.CNames, gocheck.DeepEquals, []string{"myapp.example.com"})
+	err = r.UnsetCName("myapp.example.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	backend, err = getBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.CNames, gocheck.HasLen, 0)
+}
+
+func (s *S) TestSetCNameWithAppDomain(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetCName("myapp.tsuru.io", "myapp")
+	c.Assert(err, gocheck.ErrorMatches, "Invalid CNAME myapp.tsuru.io. You can't use tsuru's application domain.")
+}
+
+func (s *S) TestAddr(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	addr, err := r.Addr("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp.tsuru.io")
+}
+
+func (s *S) TestSwap(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("app1")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("app1", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddBackend("app2")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("app2", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = r.Swap("app1", "app2")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := r.Routes("app1")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+	addr, err := r.Addr("app1")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "app2.tsuru.io")
+}
+
+func (s *S) TestIsNotWeightedRouter(c *gocheck.C) {
+	_, ok := s.getRouter(c).(router.WeightedRouter)
+	c.Assert(ok, gocheck.Equals, false)
+}
+
+func (s *S) TestSetRules(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddBackend("myapp-api")
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{
+		{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"},
+		{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"},
+	}
+	err = r.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.IsNil)
+	backend, err := getBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.Rules, gocheck.DeepEquals, rules)
+	err = r.(router.RuleRouter).SetRules("myapp", nil)
+	c.Assert(err, gocheck.IsNil)
+	backend, err = getBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.Rules, gocheck.HasLen, 0)
+}
+
+func (s *S) TestSetRulesUnknownApp(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}}
+	err = r.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.NotNil)
+	backend, err := getBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backend.Rules, gocheck.HasLen, 0)
+}
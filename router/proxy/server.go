@@ -0,0 +1,289 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router"
+)
+
+const (
+	defaultListen         = "0.0.0.0:80"
+	defaultReloadInterval = 5
+	dialTimeout           = 10 * time.Second
+)
+
+var errNoRoutes = errors.New("backend has no routes")
+
+// proxyBackend is a backend loaded in the routing table of the server.
+type proxyBackend struct {
+	name      string
+	routes    []*url.URL
+	next      uint32
+	redirects []router.Rule
+	paths     []pathRule
+}
+
+// pathRule is a path rule of a backend, pointing to the backend that gets the
+// matching requests.
+type pathRule struct {
+	router.Rule
+	backend *proxyBackend
+}
+
+// byPathLength sorts path rules from the longest path to the shortest, so
+// the most specific rule matches first.
+type byPathLength []pathRule
+
+func (r byPathLength) Len() int           { return len(r) }
+func (r byPathLength) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byPathLength) Less(i, j int) bool { return len(r[i].Path) > len(r[j].Path) }
+
+// redirect returns the location of the first redirect rule of the backend
+// matching the request, if any.
+func (b *proxyBackend) redirect(req *http.Request) (string, bool) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	for _, rule := range b.redirects {
+		if rule.Host != "" && !strings.EqualFold(rule.Host, host) {
+			continue
+		}
+		location := url.URL{Scheme: scheme, Host: req.Host, Path: req.URL.Path, RawQuery: req.URL.RawQuery}
+		if rule.RedirectScheme != "" {
+			location.Scheme = rule.RedirectScheme
+			location.Host = host
+		}
+		if rule.RedirectHost != "" {
+			location.Host = rule.RedirectHost
+		}
+		sameHost := strings.EqualFold(location.Host, req.Host) || strings.EqualFold(location.Host, host)
+		if location.Scheme == scheme && sameHost {
+			continue
+		}
+		return location.String(), true
+	}
+	return "", false
+}
+
+// target returns the backend that gets the requests to the path.
+func (b *proxyBackend) target(path string) *proxyBackend {
+	for _, rule := range b.paths {
+		if rule.Match(path) {
+			return rule.backend
+		}
+	}
+	return b
+}
+
+// nextRoute returns the index of the route that should get the next request.
+func (b *proxyBackend) nextRoute() int {
+	return int((atomic.AddUint32(&b.next, 1) - 1) % uint32(len(b.routes)))
+}
+
+// roundRobinTransport sends each request to the next route of the backend,
+// trying the following routes when the connection to a route fails.
+type roundRobinTransport struct {
+	backend   *proxyBackend
+	transport http.RoundTripper
+}
+
+func (t *roundRobinTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	routes := t.backend.routes
+	if len(routes) == 0 {
+		return nil, errNoRoutes
+	}
+	start := t.backend.nextRoute()
+	var err error
+	for i := range routes {
+		route := routes[(start+i)%len(routes)]
+		req.URL.Scheme = route.Scheme
+		req.URL.Host = route.Host
+		var resp *http.Response
+		resp, err = t.transport.RoundTrip(req)
+		if err == nil {
+			return resp, nil
+		}
+		if !isConnectionError(err) {
+			return nil, err
+		}
+		log.Errorf("Proxy router: failed to connect to %s, route of %s: %s", route.Host, t.backend.name, err)
+	}
+	return nil, err
+}
+
+// isConnectionError returns true when the request failed before being sent,
+// so it can safely be sent to another route.
+func isConnectionError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// Server is a reverse proxy that serves the backends of a proxy router. The
+// routing table is loaded from the database and reloaded periodically, so
+// changes made by the router are applied without restarting the server.
+type Server struct {
+	prefix    string
+	domain    string
+	listen    string
+	interval  time.Duration
+	transport http.RoundTripper
+	mu        sync.RWMutex
+	backends  []backendData
+	hosts     map[string]*proxyBackend
+}
+
+// NewServer returns a server for the proxy router with the given config
+// prefix.
+func NewServer(prefix string) (*Server, error) {
+	domain, err := config.GetString(prefix + ":domain")
+	if err != nil {
+		return nil, err
+	}
+	listen, err := config.GetString(prefix + ":listen")
+	if err != nil {
+		listen = defaultListen
+	}
+	interval, err := config.GetDuration(prefix + ":reload-interval")
+	if err != nil || interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	s := Server{
+		prefix:    prefix,
+		domain:    domain,
+		listen:    listen,
+		interval:  interval * time.Second,
+		transport: &http.Transport{Dial: (&net.Dialer{Timeout: dialTimeout}).Dial},
+	}
+	return &s, nil
+}
+
+// Reload loads the routing table from the database, returning whether it
+// changed since the last load.
+func (s *Server) Reload() (bool, error) {
+	backends, err := listBackends(s.prefix)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	changed := !reflect.DeepEqual(backends, s.backends)
+	s.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	hosts := make(map[string]*proxyBackend)
+	byName := make(map[string]*proxyBackend, len(backends))
+	for _, backend := range backends {
+		b := proxyBackend{name: backend.Name}
+		for _, route := range backend.Routes {
+			u, err := url.Parse(route)
+			if err != nil || u.Host == "" {
+				log.Errorf("Proxy router: ignoring invalid route %q of %s", route, backend.Name)
+				continue
+			}
+			b.routes = append(b.routes, u)
+		}
+		byName[backend.Name] = &b
+		hosts[backend.Name+"."+s.domain] = &b
+		for _, cname := range backend.CNames {
+			hosts[strings.ToLower(cname)] = &b
+		}
+	}
+	for _, backend := range backends {
+		b := byName[backend.Name]
+		for _, rule := range backend.Rules {
+			switch rule.Type {
+			case router.RedirectRule:
+				b.redirects = append(b.redirects, rule)
+			case router.PathRule:
+				target, ok := byName[rule.App]
+				if !ok {
+					log.Errorf("Proxy router: ignoring rule %q of %s, the backend %s was not found", rule.Name, backend.Name, rule.App)
+					continue
+				}
+				b.paths = append(b.paths, pathRule{Rule: rule, backend: target})
+			}
+		}
+		sort.Sort(byPathLength(b.paths))
+	}
+	s.mu.Lock()
+	s.backends = backends
+	s.hosts = hosts
+	s.mu.Unlock()
+	return true, nil
+}
+
+func (s *Server) watch() {
+	for {
+		time.Sleep(s.interval)
+		changed, err := s.Reload()
+		if err != nil {
+			log.Errorf("Proxy router: failed to reload the routing table: %s", err)
+		} else if changed {
+			log.Debugf("Proxy router: routing table reloaded")
+		}
+	}
+}
+
+func (s *Server) backend(host string) *proxyBackend {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hosts[strings.ToLower(host)]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	backend := s.backend(req.Host)
+	if backend == nil {
+		http.Error(w, "no backend found for "+req.Host, http.StatusNotFound)
+		return
+	}
+	if location, ok := backend.redirect(req); ok {
+		http.Redirect(w, req, location, http.StatusMovedPermanently)
+		return
+	}
+	backend = backend.target(req.URL.Path)
+	if len(backend.routes) == 0 {
+		http.Error(w, "no routes available for "+req.Host, http.StatusServiceUnavailable)
+		return
+	}
+	proxy := httputil.ReverseProxy{
+		Director:  func(*http.Request) {},
+		Transport: &roundRobinTransport{backend: backend, transport: s.transport},
+	}
+	proxy.ServeHTTP(w, req)
+}
+
+// ListenAndServe loads the routing table and serves the proxy in the address
+// defined by the "<prefix>:listen" setting, reloading the table in the
+// interval defined by "<prefix>:reload-interval".
+func (s *Server) ListenAndServe() error {
+	_, err := s.Reload()
+	if err != nil {
+		return err
+	}
+	go s.watch()
+	return http.ListenAndServe(s.listen, s)
+}
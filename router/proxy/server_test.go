@@ -0,0 +1,221 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/router"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) startUnit(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.Host)
+	}))
+}
+
+func (s *S) getServer(c *gocheck.C) *Server {
+	server, err := NewServer("proxy")
+	c.Assert(err, gocheck.IsNil)
+	_, err = server.Reload()
+	c.Assert(err, gocheck.IsNil)
+	return server
+}
+
+func (s *S) request(server *Server, host string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Host = host
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *S) TestNewServer(c *gocheck.C) {
+	server, err := NewServer("proxy")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(server.domain, gocheck.Equals, "tsuru.io")
+	c.Assert(server.listen, gocheck.Equals, "0.0.0.0:80")
+	c.Assert(server.interval, gocheck.Equals, time.Second)
+}
+
+func (s *S) TestNewServerWithoutDomain(c *gocheck.C) {
+	config.Unset("proxy:domain")
+	_, err := NewServer("proxy")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestServerRoundRobin(c *gocheck.C) {
+	unit1 := s.startUnit("unit1")
+	defer unit1.Close()
+	unit2 := s.startUnit("unit2")
+	defer unit2.Close()
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", unit1.URL)
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", unit2.URL)
+	c.Assert(err, gocheck.IsNil)
+	server := s.getServer(c)
+	var bodies []string
+	for i := 0; i < 4; i++ {
+		recorder := s.request(server, "myapp.tsuru.io")
+		c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+		bodies = append(bodies, recorder.Body.String())
+	}
+	c.Assert(bodies, gocheck.DeepEquals, []string{
+		"unit1 myapp.tsuru.io", "unit2 myapp.tsuru.io",
+		"unit1 myapp.tsuru.io", "unit2 myapp.tsuru.io",
+	})
+}
+
+func (s *S) TestServerCName(c *gocheck.C) {
+	unit := s.startUnit("unit1")
+	defer unit.Close()
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", unit.URL)
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetCName("myapp.example.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	server := s.getServer(c)
+	recorder := s.request(server, "MyApp.example.com:8080")
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "unit1 MyApp.example.com:8080")
+}
+
+func (s *S) TestServerRetriesOnConnectionFailure(c *gocheck.C) {
+	dead := s.startUnit("dead")
+	dead.Close()
+	unit := s.startUnit("unit1")
+	defer unit.Close()
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", dead.URL)
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", unit.URL)
+	c.Assert(err, gocheck.IsNil)
+	server := s.getServer(c)
+	for i := 0; i < 2; i++ {
+		recorder := s.request(server, "myapp.tsuru.io")
+		c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+		c.Assert(recorder.Body.String(), gocheck.Equals, "unit1 myapp.tsuru.io")
+	}
+}
+
+func (s *S) TestServerUnknownHost(c *gocheck.C) {
+	server := s.getServer(c)
+	recorder := s.request(server, "unknown.tsuru.io")
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestServerBackendWithoutRoutes(c *gocheck.C) {
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	server := s.getServer(c)
+	recorder := s.request(server, "myapp.tsuru.io")
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusServiceUnavailable)
+}
+
+func (s *S) TestServerReload(c *gocheck.C) {
+	unit := s.startUnit("unit1")
+	defer unit.Close()
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	server := s.getServer(c)
+	changed, err := server.Reload()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(changed, gocheck.Equals, false)
+	err = r.AddRoute("myapp", unit.URL)
+	c.Assert(err, gocheck.IsNil)
+	changed, err = server.Reload()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(changed, gocheck.Equals, true)
+	recorder := s.request(server, "myapp.tsuru.io")
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+}
+
+func (s *S) TestServerPathRule(c *gocheck.C) {
+	unit := s.startUnit("web")
+	defer unit.Close()
+	apiUnit := s.startUnit("api")
+	defer apiUnit.Close()
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", unit.URL)
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddBackend("myapp-api")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp-api", apiUnit.URL)
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}}
+	err = r.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.IsNil)
+	server := s.getServer(c)
+	var tests = []struct {
+		path string
+		body string
+	}{
+		{"/", "web myapp.tsuru.io"},
+		{"/apis", "web myapp.tsuru.io"},
+		{"/api", "api myapp.tsuru.io"},
+		{"/api/users", "api myapp.tsuru.io"},
+	}
+	for _, t := range tests {
+		request, _ := http.NewRequest("GET", t.path, nil)
+		request.Host = "myapp.tsuru.io"
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, gocheck.Equals, http.StatusOK)
+		c.Check(recorder.Body.String(), gocheck.Equals, t.body)
+	}
+}
+
+func (s *S) TestServerRedirectRules(c *gocheck.C) {
+	unit := s.startUnit("web")
+	defer unit.Close()
+	r := s.getRouter(c)
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("myapp", unit.URL)
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetCName("example.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetCName("www.example.com", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	rules := []router.Rule{
+		{Name: "www", Type: router.RedirectRule, Host: "example.com", RedirectHost: "www.example.com"},
+		{Name: "https", Type: router.RedirectRule, RedirectScheme: "https"},
+	}
+	err = r.(router.RuleRouter).SetRules("myapp", rules)
+	c.Assert(err, gocheck.IsNil)
+	server := s.getServer(c)
+	request, _ := http.NewRequest("GET", "/users?page=2", nil)
+	request.Host = "example.com"
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusMovedPermanently)
+	c.Assert(recorder.Header().Get("Location"), gocheck.Equals, "http://www.example.com/users?page=2")
+	request.Host = "www.example.com:80"
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusMovedPermanently)
+	c.Assert(recorder.Header().Get("Location"), gocheck.Equals, "https://www.example.com/users?page=2")
+	request.Header.Set("X-Forwarded-Proto", "https")
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "web www.example.com:80")
+}
@@ -0,0 +1,38 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import "github.com/tsuru/tsuru/router"
+
+const backendsColl router.BackendCollection = "proxy_router"
+
+// backendData is the routing table entry of a backend: the proxy answers for
+// the address of the backend and its cnames, forwarding requests to its
+// routes. The app of path rules is the name of the backend that gets the
+// matching requests.
+type backendData struct {
+	Name   string `bson:"_id"`
+	Router string
+	CNames []string
+	Routes []string
+	Rules  []router.Rule
+}
+
+func getBackend(name string) (*backendData, error) {
+	var backend backendData
+	err := backendsColl.Get(name, &backend)
+	if err != nil {
+		return nil, err
+	}
+	return &backend, nil
+}
+
+// listBackends returns the backends of the router with the given prefix,
+// sorted by name so the loaded tables can be compared.
+func listBackends(prefix string) ([]backendData, error) {
+	var backends []backendData
+	err := backendsColl.List(prefix, &backends)
+	return backends, err
+}
@@ -21,6 +21,18 @@ type routerFactory func(string) (Router, error)
 
 var ErrRouteNotFound = errors.New("Route not found")
 
+// ErrWeightNotSupported is returned by routers that can't split the traffic of
+// a backend by weight.
+var ErrWeightNotSupported = errors.New("Router doesn't support weighted routes")
+
+// ErrInvalidWeight is returned when the weight of routes is not a percentage
+// between 1 and 99.
+var ErrInvalidWeight = errors.New("Weight must be between 1 and 99")
+
+// ErrTLSNotSupported is returned when the router of an app can't terminate
+// TLS for its cnames.
+var ErrTLSNotSupported = errors.New("Router doesn't support TLS certificates")
+
 var routers = make(map[string]routerFactory)
 
 // Register registers a new router.
@@ -66,6 +78,38 @@ type Router interface {
 	Routes(name string) ([]string, error)
 }
 
+// WeightedRouter is a router that can split the traffic of a backend between
+// its routes by weight.
+type WeightedRouter interface {
+	// SetRoutesWeight sends the given percentage of the traffic of a backend
+	// to the given routes, split between them, and the rest of the traffic
+	// to the other routes of the backend.
+	SetRoutesWeight(name string, addresses []string, weight int) error
+
+	// ResetRoutesWeight splits the traffic of a backend evenly between its
+	// routes again.
+	ResetRoutesWeight(name string) error
+}
+
+// TLSRouter is a router that can terminate TLS for the cnames of its backends,
+// using a certificate for each cname.
+type TLSRouter interface {
+	AddCertificate(cname, certificate, key string) error
+	RemoveCertificate(cname string) error
+
+	// ListCertificates returns the certificates of the cnames of a
+	// backend, by cname.
+	ListCertificates(name string) (map[string]string, error)
+}
+
+// RuleRouter is a router that can apply routing rules to the requests of its
+// backends.
+type RuleRouter interface {
+	// SetRules replaces the rules of a backend. Path rules send requests
+	// to the backends of other apps in the same router.
+	SetRules(name string, rules []Rule) error
+}
+
 func collection() (*storage.Collection, error) {
 	conn, err := db.Conn()
 	if err != nil {
@@ -139,49 +183,9 @@ func swapBackendName(backend1, backend2 string) error {
 		return err
 	}
 	update = bson.M{"$set": bson.M{"router": router1}}
-	return coll.Update(bson.M{"app": backend2}, update)
-}
-
-func Swap(r Router, backend1, backend2 string) error {
-	data1, err := retrieveRouterData(backend1)
+	err = coll.Update(bson.M{"app": backend2}, update)
 	if err != nil {
-		return err
-	}
-	data2, err := retrieveRouterData(backend2)
-	if err != nil {
-		return err
-	}
-	if data1["kind"] != data2["kind"] {
-		return fmt.Errorf("swap is only allowed between routers of the same kind. %q uses %q, %q uses %q",
-			backend1, data1["kind"], backend2, data2["kind"])
-	}
-	routes1, err := r.Routes(backend1)
-	if err != nil {
-		return err
-	}
-	routes2, err := r.Routes(backend2)
-	if err != nil {
-		return err
-	}
-	for _, route := range routes1 {
-		err = r.AddRoute(backend2, route)
-		if err != nil {
-			return err
-		}
-		err = r.RemoveRoute(backend1, route)
-		if err != nil {
-			return err
-		}
-	}
-	for _, route := range routes2 {
-		err = r.AddRoute(backend1, route)
-		if err != nil {
-			return err
-		}
-		err = r.RemoveRoute(backend2, route)
-		if err != nil {
-			return err
-		}
+		coll.Update(bson.M{"app": backend1}, bson.M{"$set": bson.M{"router": router1}})
 	}
-	return swapBackendName(backend1, backend2)
+	return err
 }
@@ -0,0 +1,139 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router_test
+
+import (
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/router"
+	_ "github.com/tsuru/tsuru/router/proxy"
+	rtesting "github.com/tsuru/tsuru/router/testing"
+	ttesting "github.com/tsuru/tsuru/testing"
+	"launchpad.net/gocheck"
+)
+
+type MoveSuite struct {
+	conn *db.Storage
+}
+
+var _ = gocheck.Suite(&MoveSuite{})
+
+func (s *MoveSuite) SetUpSuite(c *gocheck.C) {
+	config.Set("proxy:domain", "movetest.org")
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "router_move_tests")
+}
+
+func (s *MoveSuite) SetUpTest(c *gocheck.C) {
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, gocheck.IsNil)
+	ttesting.ClearAllCollections(s.conn.Collection("router").Database)
+	rtesting.FakeRouter.Reset()
+}
+
+func (s *MoveSuite) TearDownTest(c *gocheck.C) {
+	s.conn.Close()
+}
+
+func (s *MoveSuite) getRouters(c *gocheck.C) (router.Router, router.Router) {
+	fake, err := router.Get("fake")
+	c.Assert(err, gocheck.IsNil)
+	proxy, err := router.Get("proxy")
+	c.Assert(err, gocheck.IsNil)
+	return fake, proxy
+}
+
+func (s *MoveSuite) TestMove(c *gocheck.C) {
+	fake, proxy := s.getRouters(c)
+	err := fake.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = fake.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	moved, err := router.Move(proxy, "myapp", []string{"http://10.10.10.10:8080"}, []string{"myapp.example.com"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(moved, gocheck.DeepEquals, &router.MovedBackend{App: "myapp", Backend: "myapp", Kind: "fake"})
+	routes, err := proxy.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+	addr, err := proxy.Addr("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp.movetest.org")
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", "http://10.10.10.10:8080"), gocheck.Equals, true)
+	err = router.RemoveMovedBackend(fake, moved)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasBackend("myapp"), gocheck.Equals, false)
+	routes, err = proxy.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+}
+
+func (s *MoveSuite) TestSyncMovedBackend(c *gocheck.C) {
+	fake, proxy := s.getRouters(c)
+	err := fake.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = fake.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	moved, err := router.Move(proxy, "myapp", []string{"http://10.10.10.10:8080"}, nil)
+	c.Assert(err, gocheck.IsNil)
+	err = proxy.AddRoute("myapp", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = proxy.RemoveRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = router.SyncMovedBackend(fake, proxy, moved)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", "http://10.10.10.10:8080"), gocheck.Equals, false)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", "http://10.10.10.11:8080"), gocheck.Equals, true)
+	addr, err := proxy.Addr("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp.movetest.org")
+}
+
+func (s *MoveSuite) TestMoveRollback(c *gocheck.C) {
+	fake, proxy := s.getRouters(c)
+	err := proxy.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = proxy.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	err = proxy.AddRoute("myapp", "http://10.10.10.11:8080")
+	c.Assert(err, gocheck.IsNil)
+	rtesting.FakeRouter.FailForIp("http://10.10.10.11:8080")
+	_, err = router.Move(fake, "myapp", []string{"http://10.10.10.10:8080", "http://10.10.10.11:8080"}, nil)
+	c.Assert(err, gocheck.Equals, rtesting.ErrForcedFailure)
+	c.Assert(rtesting.FakeRouter.HasBackend("myapp"), gocheck.Equals, false)
+	routes, err := proxy.Routes("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080", "http://10.10.10.11:8080"})
+}
+
+func (s *MoveSuite) TestUndoMove(c *gocheck.C) {
+	fake, proxy := s.getRouters(c)
+	err := proxy.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = proxy.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	moved, err := router.Move(fake, "myapp", []string{"http://10.10.10.10:8080"}, nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasRoute("myapp", "http://10.10.10.10:8080"), gocheck.Equals, true)
+	err = router.UndoMove(fake, moved)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rtesting.FakeRouter.HasBackend("myapp"), gocheck.Equals, false)
+	addr, err := proxy.Addr("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addr, gocheck.Equals, "myapp.movetest.org")
+}
+
+func (s *MoveSuite) TestAppRoutes(c *gocheck.C) {
+	fake, _ := s.getRouters(c)
+	err := fake.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = fake.AddRoute("myapp", "myapp")
+	c.Assert(err, gocheck.IsNil)
+	err = fake.AddRoute("myapp", "http://10.10.10.10:8080")
+	c.Assert(err, gocheck.IsNil)
+	routes, err := router.AppRoutes(fake, "myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes, gocheck.DeepEquals, []string{"http://10.10.10.10:8080"})
+}
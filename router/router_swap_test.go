@@ -9,7 +9,7 @@ import (
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/router"
 	_ "github.com/tsuru/tsuru/router/hipache"
-	_ "github.com/tsuru/tsuru/router/testing"
+	rtesting "github.com/tsuru/tsuru/router/testing"
 	ttesting "github.com/tsuru/tsuru/testing"
 	"launchpad.net/gocheck"
 )
@@ -78,3 +78,32 @@ func (s *SwapSuite) TestSwapWithDifferentRouterKinds(c *gocheck.C) {
 	err = router.Swap(r2, backend1, backend2)
 	c.Assert(err, gocheck.ErrorMatches, `swap is only allowed between routers of the same kind. "bb1" uses "fake", "bb2" uses "hipache"`)
 }
+
+func (s *SwapSuite) TestSwapRestoresRoutesOnFailure(c *gocheck.C) {
+	backend1 := "bf1"
+	backend2 := "bf2"
+	r, err := router.Get("fake")
+	c.Assert(err, gocheck.IsNil)
+	defer rtesting.FakeRouter.Reset()
+	r.AddBackend(backend1)
+	r.AddRoute(backend1, "http://127.0.0.1")
+	r.AddRoute(backend1, "http://127.0.0.2")
+	r.AddBackend(backend2)
+	r.AddRoute(backend2, "http://10.10.10.10")
+	r.AddRoute(backend2, "http://10.10.10.11")
+	rtesting.FakeRouter.FailForIp("http://10.10.10.11")
+	err = router.Swap(r, backend1, backend2)
+	c.Assert(err, gocheck.Equals, rtesting.ErrForcedFailure)
+	routes1, err := r.Routes(backend1)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes1, gocheck.DeepEquals, []string{"http://127.0.0.1", "http://127.0.0.2"})
+	routes2, err := r.Routes(backend2)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(routes2, gocheck.DeepEquals, []string{"http://10.10.10.10", "http://10.10.10.11"})
+	name1, err := router.Retrieve(backend1)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name1, gocheck.Equals, backend1)
+	name2, err := router.Retrieve(backend2)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name2, gocheck.Equals, backend2)
+}
@@ -90,3 +90,14 @@ func (s *S) TestSwapBackendName(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(name, gocheck.Equals, "routername")
 }
+
+func (s *S) TestRouteHost(c *gocheck.C) {
+	c.Assert(RouteHost("http://10.10.10.10:8080"), gocheck.Equals, "10.10.10.10:8080")
+	c.Assert(RouteHost("10.10.10.10:8080"), gocheck.Equals, "10.10.10.10:8080")
+	c.Assert(RouteHost("myapp"), gocheck.Equals, "myapp")
+}
+
+func (s *S) TestRouteURL(c *gocheck.C) {
+	c.Assert(RouteURL("http://10.10.10.10:8080"), gocheck.Equals, "http://10.10.10.10:8080")
+	c.Assert(RouteURL("10.10.10.10:8080"), gocheck.Equals, "http://10.10.10.10:8080")
+}
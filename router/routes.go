@@ -0,0 +1,51 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/url"
+	"strings"
+)
+
+// AppRoutes returns the routes of the backend of the app in the given router.
+// Entries holding the name of the backend, like the first entry of hipache
+// frontends, aren't routes and are left out.
+func AppRoutes(r Router, appName string) ([]string, error) {
+	backendName, err := Retrieve(appName)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := r.Routes(appName)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if route != backendName {
+			result = append(result, route)
+		}
+	}
+	return result, nil
+}
+
+// RouteHost returns the host:port of the given route. Some routers, like
+// galeb, return their routes as host:port, while units are routed by URL, so
+// routes must be compared by their hosts.
+func RouteHost(route string) string {
+	parsed, _ := url.Parse(route)
+	if parsed != nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return route
+}
+
+// RouteURL returns the given route as an URL, adding the http scheme to
+// host:port routes.
+func RouteURL(route string) string {
+	if strings.Contains(route, "://") {
+		return route
+	}
+	return "http://" + route
+}
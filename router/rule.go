@@ -0,0 +1,104 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// PathRule sends the requests whose path starts with the path of the
+	// rule to the backend of another app.
+	PathRule = "path"
+
+	// RedirectRule redirects the requests to another scheme or host.
+	RedirectRule = "redirect"
+)
+
+// ErrRulesNotSupported is returned when the router of an app doesn't support
+// routing rules.
+var ErrRulesNotSupported = errors.New("Router doesn't support routing rules")
+
+// ErrRuleNotSupported is returned by routers that support only some types of
+// rules.
+var ErrRuleNotSupported = errors.New("Router doesn't support this type of rule")
+
+var ruleNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// RuleValidationError is returned by Rule.Validate when the rule is invalid.
+type RuleValidationError struct{ reason string }
+
+func (e RuleValidationError) Error() string {
+	return "invalid rule: " + e.reason
+}
+
+// Rule is a routing rule of a backend, applied to the requests to its address
+// and cnames before they're sent to its routes.
+type Rule struct {
+	Name string
+	Type string
+
+	// Path is the path prefix matched by path rules, and App is the app
+	// that gets the matching requests.
+	Path string
+	App  string
+
+	// Host restricts redirect rules to the requests to one of the cnames
+	// of the backend, all requests are redirected when it's empty.
+	Host string
+
+	// RedirectScheme and RedirectHost are the scheme and the host of the
+	// location of redirect rules, the ones of the request are kept when
+	// they're empty. The only supported redirect scheme is https, from
+	// http.
+	RedirectScheme string
+	RedirectHost   string
+}
+
+// Validate checks that the rule has the fields required by its type.
+func (r *Rule) Validate() error {
+	if !ruleNameRegexp.MatchString(r.Name) {
+		return RuleValidationError{"the name must start with a letter and contain only lowercase letters, numbers and dashes"}
+	}
+	switch r.Type {
+	case PathRule:
+		if !strings.HasPrefix(r.Path, "/") || r.Path == "/" {
+			return RuleValidationError{"the path must start with / and can't be the root path"}
+		}
+		if r.App == "" {
+			return RuleValidationError{"path rules must have an app"}
+		}
+		if r.Host != "" || r.RedirectScheme != "" || r.RedirectHost != "" {
+			return RuleValidationError{"path rules can't have a host or a redirect"}
+		}
+	case RedirectRule:
+		if r.RedirectScheme != "" && r.RedirectScheme != "https" {
+			return RuleValidationError{fmt.Sprintf("unsupported redirect scheme %q", r.RedirectScheme)}
+		}
+		if r.RedirectScheme == "" && r.RedirectHost == "" {
+			return RuleValidationError{"redirect rules must have a redirect scheme or host"}
+		}
+		if r.RedirectScheme == "" && (r.Host == "" || r.Host == r.RedirectHost) {
+			return RuleValidationError{"the redirect host must be different from the host of the rule"}
+		}
+		if r.Path != "" || r.App != "" {
+			return RuleValidationError{"redirect rules can't have a path or an app"}
+		}
+	default:
+		return RuleValidationError{fmt.Sprintf("unknown rule type %q", r.Type)}
+	}
+	return nil
+}
+
+// Match returns whether a path rule matches the path of a request.
+func (r *Rule) Match(path string) bool {
+	if !strings.HasPrefix(path, r.Path) {
+		return false
+	}
+	return len(path) == len(r.Path) || strings.HasSuffix(r.Path, "/") || path[len(r.Path)] == '/'
+}
@@ -0,0 +1,48 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import "launchpad.net/gocheck"
+
+func (s *S) TestRuleValidate(c *gocheck.C) {
+	var tests = []struct {
+		rule Rule
+		err  string
+	}{
+		{Rule{Name: "api", Type: PathRule, Path: "/api", App: "myapp-api"}, ""},
+		{Rule{Name: "https", Type: RedirectRule, RedirectScheme: "https"}, ""},
+		{Rule{Name: "www", Type: RedirectRule, Host: "example.com", RedirectHost: "www.example.com"}, ""},
+		{Rule{Name: "Api", Type: PathRule, Path: "/api", App: "myapp-api"}, "invalid rule: the name must start with a letter.*"},
+		{Rule{Name: "api", Type: "header"}, `invalid rule: unknown rule type "header"`},
+		{Rule{Name: "api", Type: PathRule, Path: "api", App: "myapp-api"}, "invalid rule: the path must start with / and can't be the root path"},
+		{Rule{Name: "api", Type: PathRule, Path: "/", App: "myapp-api"}, "invalid rule: the path must start with / and can't be the root path"},
+		{Rule{Name: "api", Type: PathRule, Path: "/api"}, "invalid rule: path rules must have an app"},
+		{Rule{Name: "api", Type: PathRule, Path: "/api", App: "myapp-api", RedirectScheme: "https"}, "invalid rule: path rules can't have a host or a redirect"},
+		{Rule{Name: "ftp", Type: RedirectRule, RedirectScheme: "ftp"}, `invalid rule: unsupported redirect scheme "ftp"`},
+		{Rule{Name: "www", Type: RedirectRule, Host: "example.com"}, "invalid rule: redirect rules must have a redirect scheme or host"},
+		{Rule{Name: "www", Type: RedirectRule, RedirectHost: "www.example.com"}, "invalid rule: the redirect host must be different from the host of the rule"},
+		{Rule{Name: "www", Type: RedirectRule, Host: "www.example.com", RedirectHost: "www.example.com"}, "invalid rule: the redirect host must be different from the host of the rule"},
+		{Rule{Name: "https", Type: RedirectRule, RedirectScheme: "https", Path: "/api"}, "invalid rule: redirect rules can't have a path or an app"},
+	}
+	for _, t := range tests {
+		err := t.rule.Validate()
+		if t.err == "" {
+			c.Check(err, gocheck.IsNil)
+		} else {
+			c.Check(err, gocheck.ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *S) TestRuleMatch(c *gocheck.C) {
+	rule := Rule{Name: "api", Type: PathRule, Path: "/api", App: "myapp-api"}
+	c.Assert(rule.Match("/api"), gocheck.Equals, true)
+	c.Assert(rule.Match("/api/users"), gocheck.Equals, true)
+	c.Assert(rule.Match("/apis"), gocheck.Equals, false)
+	c.Assert(rule.Match("/"), gocheck.Equals, false)
+	rule.Path = "/api/"
+	c.Assert(rule.Match("/api/users"), gocheck.Equals, true)
+	c.Assert(rule.Match("/api"), gocheck.Equals, false)
+}
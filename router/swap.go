@@ -0,0 +1,185 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"fmt"
+
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/log"
+)
+
+type swapPipelineArgs struct {
+	router   Router
+	backend1 string
+	backend2 string
+	routes1  []string
+	routes2  []string
+}
+
+// addRoutes adds the given routes to the backend, removing the routes that
+// were added when one of them fails.
+func addRoutes(r Router, name string, routes []string) error {
+	for i, route := range routes {
+		err := r.AddRoute(name, route)
+		if err != nil {
+			for _, added := range routes[:i] {
+				r.RemoveRoute(name, added)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRoutes removes the given routes from the backend, adding back the
+// routes that were removed when one of them fails.
+func removeRoutes(r Router, name string, routes []string) error {
+	for i, route := range routes {
+		err := r.RemoveRoute(name, route)
+		if err != nil {
+			for _, removed := range routes[:i] {
+				r.AddRoute(name, removed)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func logRoutesErrors(actionName, operation, name string, errs []error) {
+	for _, err := range errs {
+		log.Errorf("[%s:Backward] Error %s route of %s: %s", actionName, operation, name, err)
+	}
+}
+
+func restoreAddedRoutes(actionName string, r Router, name string, routes []string) {
+	var errs []error
+	for _, route := range routes {
+		if err := r.RemoveRoute(name, route); err != nil && err != ErrRouteNotFound {
+			errs = append(errs, err)
+		}
+	}
+	logRoutesErrors(actionName, "removing", name, errs)
+}
+
+func restoreRemovedRoutes(actionName string, r Router, name string, routes []string) {
+	var errs []error
+	for _, route := range routes {
+		if err := r.AddRoute(name, route); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	logRoutesErrors(actionName, "adding", name, errs)
+}
+
+var addRoutesToBackend2 = action.Action{
+	Name: "add-routes-to-backend2",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		return nil, addRoutes(args.router, args.backend2, args.routes1)
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		restoreAddedRoutes("add-routes-to-backend2", args.router, args.backend2, args.routes1)
+	},
+	MinParams: 1,
+}
+
+var addRoutesToBackend1 = action.Action{
+	Name: "add-routes-to-backend1",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		return nil, addRoutes(args.router, args.backend1, args.routes2)
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		restoreAddedRoutes("add-routes-to-backend1", args.router, args.backend1, args.routes2)
+	},
+	MinParams: 1,
+}
+
+var removeRoutesFromBackend1 = action.Action{
+	Name: "remove-routes-from-backend1",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		return nil, removeRoutes(args.router, args.backend1, args.routes1)
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		restoreRemovedRoutes("remove-routes-from-backend1", args.router, args.backend1, args.routes1)
+	},
+	MinParams: 1,
+}
+
+var removeRoutesFromBackend2 = action.Action{
+	Name: "remove-routes-from-backend2",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		return nil, removeRoutes(args.router, args.backend2, args.routes2)
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		restoreRemovedRoutes("remove-routes-from-backend2", args.router, args.backend2, args.routes2)
+	},
+	MinParams: 1,
+}
+
+var swapBackendNames = action.Action{
+	Name: "swap-backend-names",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		return nil, swapBackendName(args.backend1, args.backend2)
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(swapPipelineArgs)
+		if err := swapBackendName(args.backend1, args.backend2); err != nil {
+			log.Errorf("[swap-backend-names:Backward] Error restoring the names of %s and %s: %s", args.backend1, args.backend2, err)
+		}
+	},
+	MinParams: 1,
+}
+
+// Swap exchanges the routes of two backends of the same kind. The routes of
+// each backend are added to the other one before being removed, so both
+// backends keep answering during the swap, and a failure in any step restores
+// the original routes of both backends.
+func Swap(r Router, backend1, backend2 string) error {
+	data1, err := retrieveRouterData(backend1)
+	if err != nil {
+		return err
+	}
+	data2, err := retrieveRouterData(backend2)
+	if err != nil {
+		return err
+	}
+	if data1["kind"] != data2["kind"] {
+		return fmt.Errorf("swap is only allowed between routers of the same kind. %q uses %q, %q uses %q",
+			backend1, data1["kind"], backend2, data2["kind"])
+	}
+	routes1, err := r.Routes(backend1)
+	if err != nil {
+		return err
+	}
+	routes2, err := r.Routes(backend2)
+	if err != nil {
+		return err
+	}
+	args := swapPipelineArgs{
+		router:   r,
+		backend1: backend1,
+		backend2: backend2,
+		routes1:  append([]string(nil), routes1...),
+		routes2:  append([]string(nil), routes2...),
+	}
+	pipeline := action.NewPipeline(
+		&addRoutesToBackend2,
+		&addRoutesToBackend1,
+		&removeRoutesFromBackend1,
+		&removeRoutesFromBackend2,
+		&swapBackendNames,
+	)
+	return pipeline.Execute(args)
+}
@@ -11,7 +11,13 @@ import (
 	"github.com/tsuru/tsuru/router"
 )
 
-var FakeRouter = fakeRouter{backends: make(map[string][]string), failuresByIp: make(map[string]bool)}
+var FakeRouter = fakeRouter{
+	backends:     make(map[string][]string),
+	failuresByIp: make(map[string]bool),
+	weights:      make(map[string]weightedRoutes),
+	certificates: make(map[string]string),
+	rules:        make(map[string][]router.Rule),
+}
 
 var ErrBackendNotFound = errors.New("Backend not found")
 
@@ -25,9 +31,17 @@ func createRouter(prefix string) (router.Router, error) {
 	return &FakeRouter, nil
 }
 
+type weightedRoutes struct {
+	addresses []string
+	weight    int
+}
+
 type fakeRouter struct {
 	backends     map[string][]string
 	failuresByIp map[string]bool
+	weights      map[string]weightedRoutes
+	certificates map[string]string
+	rules        map[string][]router.Rule
 	mutex        sync.Mutex
 }
 
@@ -80,6 +94,8 @@ func (r *fakeRouter) RemoveBackend(name string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	delete(r.backends, backendName)
+	delete(r.weights, backendName)
+	delete(r.rules, backendName)
 	return nil
 }
 
@@ -164,6 +180,9 @@ func (r *fakeRouter) Reset() {
 	defer r.mutex.Unlock()
 	r.backends = make(map[string][]string)
 	r.failuresByIp = make(map[string]bool)
+	r.weights = make(map[string]weightedRoutes)
+	r.certificates = make(map[string]string)
+	r.rules = make(map[string][]router.Rule)
 }
 
 func (r *fakeRouter) Routes(name string) ([]string, error) {
@@ -180,3 +199,111 @@ func (r *fakeRouter) Routes(name string) ([]string, error) {
 func (r *fakeRouter) Swap(backend1, backend2 string) error {
 	return router.Swap(r, backend1, backend2)
 }
+
+// RoutesWeight returns the routes of the backend that got a weight in the
+// last call to SetRoutesWeight, and their weight. The weight is zero when the
+// traffic is split evenly between the routes.
+func (r *fakeRouter) RoutesWeight(name string) ([]string, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	w := r.weights[name]
+	return w.addresses, w.weight
+}
+
+func (r *fakeRouter) SetRoutesWeight(name string, addresses []string, weight int) error {
+	if weight < 1 || weight > 99 {
+		return router.ErrInvalidWeight
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if !r.HasBackend(backendName) {
+		return ErrBackendNotFound
+	}
+	for _, address := range addresses {
+		if !r.HasRoute(backendName, address) {
+			return router.ErrRouteNotFound
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.weights == nil {
+		r.weights = make(map[string]weightedRoutes)
+	}
+	r.weights[backendName] = weightedRoutes{addresses: addresses, weight: weight}
+	return nil
+}
+
+func (r *fakeRouter) ResetRoutesWeight(name string) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if !r.HasBackend(backendName) {
+		return ErrBackendNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.weights, backendName)
+	return nil
+}
+
+// HasCertificate returns true if a certificate was added for the cname.
+func (r *fakeRouter) HasCertificate(cname string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, ok := r.certificates[cname]
+	return ok
+}
+
+func (r *fakeRouter) AddCertificate(cname, certificate, key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificates[cname] = certificate
+	return nil
+}
+
+func (r *fakeRouter) RemoveCertificate(cname string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.certificates, cname)
+	return nil
+}
+
+// ListCertificates returns all certificates added to the fake router, as it
+// doesn't keep the cnames of each backend.
+func (r *fakeRouter) ListCertificates(name string) (map[string]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	certificates := make(map[string]string, len(r.certificates))
+	for cname, certificate := range r.certificates {
+		certificates[cname] = certificate
+	}
+	return certificates, nil
+}
+
+// Rules returns the rules of the backend set in the last call to SetRules.
+func (r *fakeRouter) Rules(name string) []router.Rule {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rules[name]
+}
+
+func (r *fakeRouter) SetRules(name string, rules []router.Rule) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if !r.HasBackend(backendName) {
+		return ErrBackendNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(rules) == 0 {
+		delete(r.rules, backendName)
+	} else {
+		r.rules[backendName] = rules
+	}
+	return nil
+}
@@ -171,6 +171,38 @@ func (s *S) TestRoutes(c *gocheck.C) {
 	c.Assert(routes, gocheck.DeepEquals, []string{"127.0.0.1"})
 }
 
+func (s *S) TestSetRoutesWeight(c *gocheck.C) {
+	r := fakeRouter{backends: make(map[string][]string)}
+	err := r.AddBackend("name")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("name", "127.0.0.1")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("name", "127.0.0.2")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetRoutesWeight("name", []string{"127.0.0.2"}, 10)
+	c.Assert(err, gocheck.IsNil)
+	addresses, weight := r.RoutesWeight("name")
+	c.Assert(addresses, gocheck.DeepEquals, []string{"127.0.0.2"})
+	c.Assert(weight, gocheck.Equals, 10)
+	err = r.ResetRoutesWeight("name")
+	c.Assert(err, gocheck.IsNil)
+	addresses, weight = r.RoutesWeight("name")
+	c.Assert(addresses, gocheck.IsNil)
+	c.Assert(weight, gocheck.Equals, 0)
+}
+
+func (s *S) TestSetRoutesWeightInvalid(c *gocheck.C) {
+	r := fakeRouter{backends: make(map[string][]string)}
+	err := r.AddBackend("name")
+	c.Assert(err, gocheck.IsNil)
+	err = r.AddRoute("name", "127.0.0.1")
+	c.Assert(err, gocheck.IsNil)
+	err = r.SetRoutesWeight("name", []string{"127.0.0.1"}, 100)
+	c.Assert(err, gocheck.Equals, router.ErrInvalidWeight)
+	err = r.SetRoutesWeight("name", []string{"127.0.0.2"}, 10)
+	c.Assert(err, gocheck.Equals, router.ErrRouteNotFound)
+}
+
 func (s *S) TestSwap(c *gocheck.C) {
 	instance1 := "127.0.0.1"
 	instance2 := "127.0.0.2"
@@ -212,3 +244,30 @@ func (s *S) TestSwap(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(addr, gocheck.Equals, "127.0.0.1")
 }
+
+func (s *S) TestCertificates(c *gocheck.C) {
+	r := fakeRouter{certificates: make(map[string]string)}
+	err := r.AddCertificate("myapp.com", "cert", "key")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(r.HasCertificate("myapp.com"), gocheck.Equals, true)
+	certificates, err := r.ListCertificates("myapp")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(certificates, gocheck.DeepEquals, map[string]string{"myapp.com": "cert"})
+	err = r.RemoveCertificate("myapp.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(r.HasCertificate("myapp.com"), gocheck.Equals, false)
+}
+
+func (s *S) TestSetRules(c *gocheck.C) {
+	r := fakeRouter{backends: make(map[string][]string), rules: make(map[string][]router.Rule)}
+	err := r.AddBackend("myapp")
+	c.Assert(err, gocheck.IsNil)
+	defer r.RemoveBackend("myapp")
+	rules := []router.Rule{{Name: "api", Type: router.PathRule, Path: "/api", App: "myapp-api"}}
+	err = r.SetRules("myapp", rules)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(r.Rules("myapp"), gocheck.DeepEquals, rules)
+	err = r.SetRules("myapp", nil)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(r.Rules("myapp"), gocheck.HasLen, 0)
+}
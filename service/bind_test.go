@@ -82,7 +82,7 @@ func (s *BindSuite) TestBindUnit(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	app.Provisioner.Provision(&a)
 	defer app.Provisioner.Destroy(&a)
-	app.Provisioner.AddUnits(&a, 1, nil)
+	app.Provisioner.AddUnits(&a, 1, "", nil)
 	err = instance.BindUnit(&a, a.GetUnits()[0])
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(called, gocheck.Equals, true)
@@ -101,7 +101,7 @@ func (s *BindSuite) TestBindAppFailsWhenEndpointIsDown(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	app.Provisioner.Provision(&a)
 	defer app.Provisioner.Destroy(&a)
-	app.Provisioner.AddUnits(&a, 1, nil)
+	app.Provisioner.AddUnits(&a, 1, "", nil)
 	err = instance.BindApp(&a)
 	c.Assert(err, gocheck.NotNil)
 }
@@ -153,7 +153,7 @@ func (s *BindSuite) TestBindCallTheServiceAPIAndSetsEnvironmentVariableReturnedF
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	app.Provisioner.Provision(&a)
 	defer app.Provisioner.Destroy(&a)
-	app.Provisioner.AddUnits(&a, 1, nil)
+	app.Provisioner.AddUnits(&a, 1, "", nil)
 	err = instance.BindApp(&a)
 	c.Assert(err, gocheck.IsNil)
 	newApp, err := app.GetByName(a.Name)
@@ -215,7 +215,7 @@ func (s *BindSuite) TestBindAppMultiUnits(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	app.Provisioner.Provision(&a)
 	defer app.Provisioner.Destroy(&a)
-	app.Provisioner.AddUnits(&a, 1, nil)
+	app.Provisioner.AddUnits(&a, 1, "", nil)
 	err = instance.BindApp(&a)
 	c.Assert(err, gocheck.IsNil)
 	ok := make(chan bool)
@@ -334,7 +334,7 @@ func (s *BindSuite) TestUnbindUnit(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	app.Provisioner.Provision(&a)
 	defer app.Provisioner.Destroy(&a)
-	app.Provisioner.AddUnits(&a, 1, nil)
+	app.Provisioner.AddUnits(&a, 1, "", nil)
 	err = instance.UnbindUnit(&a, a.GetUnits()[0])
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(called, gocheck.Equals, true)
@@ -366,7 +366,7 @@ func (s *BindSuite) TestUnbindMultiUnits(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	app.Provisioner.Provision(&a)
 	defer app.Provisioner.Destroy(&a)
-	app.Provisioner.AddUnits(&a, 2, nil)
+	app.Provisioner.AddUnits(&a, 2, "", nil)
 	err = instance.UnbindApp(&a)
 	c.Assert(err, gocheck.IsNil)
 	ok := make(chan bool, 1)
@@ -490,7 +490,7 @@ func (s *BindSuite) TestUnbindCallsTheUnbindMethodFromAPI(c *gocheck.C) {
 	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
 	app.Provisioner.Provision(&a)
 	defer app.Provisioner.Destroy(&a)
-	app.Provisioner.AddUnits(&a, 1, nil)
+	app.Provisioner.AddUnits(&a, 1, "", nil)
 	err = instance.UnbindApp(&a)
 	c.Assert(err, gocheck.IsNil)
 	ch := make(chan bool)
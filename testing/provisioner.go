@@ -252,7 +252,7 @@ func (a *FakeApp) SerializeEnvVars() error {
 	return nil
 }
 
-func (a *FakeApp) Restart(w io.Writer) error {
+func (a *FakeApp) Restart(w io.Writer, process string) error {
 	a.commMut.Lock()
 	a.Commands = append(a.Commands, "restart")
 	a.commMut.Unlock()
@@ -293,6 +293,8 @@ type FakeProvisioner struct {
 	outputs  chan []byte
 	failures chan failure
 	apps     map[string]provisionedApp
+	blocked  map[string]chan struct{}
+	pools    map[string][]string
 	mut      sync.RWMutex
 }
 
@@ -301,6 +303,8 @@ func NewFakeProvisioner() *FakeProvisioner {
 	p.outputs = make(chan []byte, 8)
 	p.failures = make(chan failure, 8)
 	p.apps = make(map[string]provisionedApp)
+	p.blocked = make(map[string]chan struct{})
+	p.pools = make(map[string][]string)
 	return &p
 }
 
@@ -337,6 +341,14 @@ func (p *FakeProvisioner) Stops(app provision.App) int {
 	return p.apps[app.GetName()].stops
 }
 
+// LastProcess returns the process name given in the last call to Restart,
+// Start or Stop for a given app.
+func (p *FakeProvisioner) LastProcess(app provision.App) string {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.apps[app.GetName()].lastProcess
+}
+
 // Returns the number of calls to restart.
 // GetCmds returns a list of commands executed in an app. If you don't specify
 // the command (an empty string), it will return all commands executed in the
@@ -405,6 +417,7 @@ func (p *FakeProvisioner) Reset() {
 
 	p.mut.Lock()
 	p.apps = make(map[string]provisionedApp)
+	p.pools = make(map[string][]string)
 	p.mut.Unlock()
 
 	for {
@@ -417,6 +430,34 @@ func (p *FakeProvisioner) Reset() {
 	}
 }
 
+// AddPool adds a pool that can be used by the given teams, or by all teams
+// when none is given.
+func (p *FakeProvisioner) AddPool(name string, teams ...string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.pools[name] = teams
+}
+
+func (p *FakeProvisioner) ValidatePool(pool string, teams []string) error {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	poolTeams, ok := p.pools[pool]
+	if !ok {
+		return provision.ErrPoolNotFound
+	}
+	if len(poolTeams) == 0 {
+		return nil
+	}
+	for _, team := range teams {
+		for _, poolTeam := range poolTeams {
+			if team == poolTeam {
+				return nil
+			}
+		}
+	}
+	return provision.ErrPoolNotAllowed
+}
+
 func (p *FakeProvisioner) Swap(app1, app2 provision.App) error {
 	pApp1, ok := p.apps[app1.GetName()]
 	if !ok {
@@ -432,54 +473,252 @@ func (p *FakeProvisioner) Swap(app1, app2 provision.App) error {
 	return nil
 }
 
-func (p *FakeProvisioner) GitDeploy(app provision.App, version string, w io.Writer) error {
+func (p *FakeProvisioner) GitDeploy(app provision.App, version string, w io.Writer) (string, error) {
 	if err := p.getError("GitDeploy"); err != nil {
-		return err
+		return "", err
+	}
+	if err := p.waitDeploy(app); err != nil {
+		return "", err
 	}
 	p.mut.Lock()
 	defer p.mut.Unlock()
 	pApp, ok := p.apps[app.GetName()]
 	if !ok {
-		return errNotProvisioned
+		return "", errNotProvisioned
 	}
 	w.Write([]byte("Git deploy called"))
 	pApp.version = version
+	image := pApp.newImage(app.GetName())
 	p.apps[app.GetName()] = pApp
-	return nil
+	return image, nil
 }
 
-func (p *FakeProvisioner) ArchiveDeploy(app provision.App, archiveURL string, w io.Writer) error {
+func (p *FakeProvisioner) ArchiveDeploy(app provision.App, archiveURL string, w io.Writer) (string, error) {
 	if err := p.getError("ArchiveDeploy"); err != nil {
-		return err
+		return "", err
+	}
+	if err := p.waitDeploy(app); err != nil {
+		return "", err
 	}
 	p.mut.Lock()
 	defer p.mut.Unlock()
 	pApp, ok := p.apps[app.GetName()]
 	if !ok {
-		return errNotProvisioned
+		return "", errNotProvisioned
 	}
 	w.Write([]byte("Archive deploy called"))
 	pApp.lastArchive = archiveURL
+	image := pApp.newImage(app.GetName())
 	p.apps[app.GetName()] = pApp
-	return nil
+	return image, nil
 }
 
-func (p *FakeProvisioner) UploadDeploy(app provision.App, file io.ReadCloser, w io.Writer) error {
+func (p *FakeProvisioner) UploadDeploy(app provision.App, file io.ReadCloser, w io.Writer) (string, error) {
 	if err := p.getError("UploadDeploy"); err != nil {
-		return err
+		return "", err
+	}
+	if err := p.waitDeploy(app); err != nil {
+		return "", err
 	}
 	p.mut.Lock()
 	defer p.mut.Unlock()
 	pApp, ok := p.apps[app.GetName()]
 	if !ok {
-		return errNotProvisioned
+		return "", errNotProvisioned
 	}
 	w.Write([]byte("Upload deploy called"))
 	pApp.lastFile = file
+	image := pApp.newImage(app.GetName())
+	p.apps[app.GetName()] = pApp
+	return image, nil
+}
+
+func (p *FakeProvisioner) ImageDeploy(app provision.App, image string, w io.Writer) (string, error) {
+	if err := p.getError("ImageDeploy"); err != nil {
+		return "", err
+	}
+	if err := p.waitDeploy(app); err != nil {
+		return "", err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return "", errNotProvisioned
+	}
+	w.Write([]byte("Image deploy called"))
+	pApp.lastImage = image
+	newImage := pApp.newImage(app.GetName())
+	p.apps[app.GetName()] = pApp
+	return newImage, nil
+}
+
+// BlockDeploys makes the deploys of the given app hang until they get
+// canceled by CancelDeploy.
+func (p *FakeProvisioner) BlockDeploys(app provision.App) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.blocked[app.GetName()] = make(chan struct{})
+}
+
+func (p *FakeProvisioner) waitDeploy(app provision.App) error {
+	p.mut.RLock()
+	block, ok := p.blocked[app.GetName()]
+	p.mut.RUnlock()
+	if !ok {
+		return nil
+	}
+	<-block
+	return provision.ErrDeployCanceled
+}
+
+// CancelDeploy cancels the deploys of an app blocked by BlockDeploys.
+func (p *FakeProvisioner) CancelDeploy(app provision.App) error {
+	if err := p.getError("CancelDeploy"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	block, ok := p.blocked[app.GetName()]
+	if !ok {
+		return provision.ErrNoDeployInProgress
+	}
+	select {
+	case <-block:
+		return provision.ErrNoDeployInProgress
+	default:
+		close(block)
+	}
+	return nil
+}
+
+// Rollback makes the given image the current image of the app. The image must
+// have been generated by a previous deploy of the app.
+func (p *FakeProvisioner) Rollback(app provision.App, image string, w io.Writer) error {
+	if err := p.getError("Rollback"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	found := false
+	for _, img := range pApp.images {
+		if img == image {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("image not found")
+	}
+	w.Write([]byte("Rollback called"))
+	pApp.image = image
+	p.apps[app.GetName()] = pApp
+	return nil
+}
+
+// StartCanary records the canary of the app, failing when the app already has
+// a canary in progress.
+func (p *FakeProvisioner) StartCanary(app provision.App, image string, units, weight int, w io.Writer) error {
+	if err := p.getError("StartCanary"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	if pApp.canary.image != "" {
+		return provision.ErrCanaryInProgress
+	}
+	w.Write([]byte("Start canary called"))
+	pApp.canary = fakeCanary{image: image, units: units, weight: weight}
+	p.apps[app.GetName()] = pApp
+	return nil
+}
+
+func (p *FakeProvisioner) SetCanaryWeight(app provision.App, weight int) error {
+	if err := p.getError("SetCanaryWeight"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	if pApp.canary.image == "" {
+		return provision.ErrNoCanaryInProgress
+	}
+	pApp.canary.weight = weight
 	p.apps[app.GetName()] = pApp
 	return nil
 }
 
+// PromoteCanary makes the image of the canary the current image of the app.
+func (p *FakeProvisioner) PromoteCanary(app provision.App, w io.Writer) (string, error) {
+	if err := p.getError("PromoteCanary"); err != nil {
+		return "", err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return "", errNotProvisioned
+	}
+	image := pApp.canary.image
+	if image == "" {
+		return "", provision.ErrNoCanaryInProgress
+	}
+	w.Write([]byte("Promote canary called"))
+	pApp.images = append(pApp.images, image)
+	pApp.image = image
+	pApp.canary = fakeCanary{}
+	p.apps[app.GetName()] = pApp
+	return image, nil
+}
+
+func (p *FakeProvisioner) AbortCanary(app provision.App, w io.Writer) error {
+	if err := p.getError("AbortCanary"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	if pApp.canary.image == "" {
+		return provision.ErrNoCanaryInProgress
+	}
+	w.Write([]byte("Abort canary called"))
+	pApp.canary = fakeCanary{}
+	p.apps[app.GetName()] = pApp
+	return nil
+}
+
+// Canary returns the image, the number of units and the weight of the canary
+// in progress for the given app. The image is empty when there's no canary in
+// progress.
+func (p *FakeProvisioner) Canary(app provision.App) (string, int, int) {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	canary := p.apps[app.GetName()].canary
+	return canary.image, canary.units, canary.weight
+}
+
+// Image returns the current image of the given app, generated by the last
+// deploy or set by the last rollback.
+func (p *FakeProvisioner) Image(app provision.App) string {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.apps[app.GetName()].image
+}
+
 func (p *FakeProvisioner) Provision(app provision.App) error {
 	if err := p.getError("Provision"); err != nil {
 		return err
@@ -496,7 +735,7 @@ func (p *FakeProvisioner) Provision(app provision.App) error {
 	return nil
 }
 
-func (p *FakeProvisioner) Restart(app provision.App, w io.Writer) error {
+func (p *FakeProvisioner) Restart(app provision.App, process string, w io.Writer) error {
 	if err := p.getError("Restart"); err != nil {
 		return err
 	}
@@ -507,6 +746,7 @@ func (p *FakeProvisioner) Restart(app provision.App, w io.Writer) error {
 		return errNotProvisioned
 	}
 	pApp.restarts++
+	pApp.lastProcess = process
 	p.apps[app.GetName()] = pApp
 	if w != nil {
 		fmt.Fprintf(w, "restarting app")
@@ -514,7 +754,7 @@ func (p *FakeProvisioner) Restart(app provision.App, w io.Writer) error {
 	return nil
 }
 
-func (p *FakeProvisioner) Start(app provision.App) error {
+func (p *FakeProvisioner) Start(app provision.App, process string) error {
 	p.mut.Lock()
 	defer p.mut.Unlock()
 	pApp, ok := p.apps[app.GetName()]
@@ -522,6 +762,7 @@ func (p *FakeProvisioner) Start(app provision.App) error {
 		return errNotProvisioned
 	}
 	pApp.starts++
+	pApp.lastProcess = process
 	p.apps[app.GetName()] = pApp
 	return nil
 }
@@ -536,10 +777,11 @@ func (p *FakeProvisioner) Destroy(app provision.App) error {
 	p.mut.Lock()
 	defer p.mut.Unlock()
 	delete(p.apps, app.GetName())
+	delete(p.blocked, app.GetName())
 	return nil
 }
 
-func (p *FakeProvisioner) AddUnits(app provision.App, n uint, w io.Writer) ([]provision.Unit, error) {
+func (p *FakeProvisioner) AddUnits(app provision.App, n uint, process string, w io.Writer) ([]provision.Unit, error) {
 	if err := p.getError("AddUnits"); err != nil {
 		return nil, err
 	}
@@ -554,14 +796,18 @@ func (p *FakeProvisioner) AddUnits(app provision.App, n uint, w io.Writer) ([]pr
 	}
 	name := app.GetName()
 	platform := app.GetPlatform()
+	if process == "" {
+		process = provision.WebProcessName
+	}
 	length := uint(len(pApp.units))
 	for i := uint(0); i < n; i++ {
 		unit := provision.Unit{
-			Name:    fmt.Sprintf("%s-%d", name, pApp.unitLen),
-			AppName: name,
-			Type:    platform,
-			Status:  provision.StatusStarted,
-			Ip:      fmt.Sprintf("10.10.10.%d", length+i+1),
+			Name:        fmt.Sprintf("%s-%d", name, pApp.unitLen),
+			AppName:     name,
+			ProcessName: process,
+			Type:        platform,
+			Status:      provision.StatusStarted,
+			Ip:          fmt.Sprintf("10.10.10.%d", length+i+1),
 		}
 		pApp.units = append(pApp.units, unit)
 		pApp.unitLen++
@@ -575,7 +821,10 @@ func (p *FakeProvisioner) AddUnits(app provision.App, n uint, w io.Writer) ([]pr
 	return result, nil
 }
 
-func (p *FakeProvisioner) RemoveUnits(app provision.App, n uint) error {
+// RemoveUnits removes the first n units of the given process. When the
+// process is empty, the first n units of the app are removed, regardless of
+// their processes.
+func (p *FakeProvisioner) RemoveUnits(app provision.App, n uint, process string) error {
 	if err := p.getError("RemoveUnits"); err != nil {
 		return err
 	}
@@ -588,7 +837,19 @@ func (p *FakeProvisioner) RemoveUnits(app provision.App, n uint) error {
 	if n >= uint(len(pApp.units)) {
 		return errors.New("too many units to remove")
 	}
-	pApp.units = pApp.units[int(n):]
+	remaining := make([]provision.Unit, 0, len(pApp.units))
+	removed := uint(0)
+	for _, u := range pApp.units {
+		if removed < n && (process == "" || u.ProcessName == process) {
+			removed++
+			continue
+		}
+		remaining = append(remaining, u)
+	}
+	if removed < n {
+		return errors.New("too many units to remove")
+	}
+	pApp.units = remaining
 	pApp.unitLen -= int(n)
 	p.apps[app.GetName()] = pApp
 	return nil
@@ -794,7 +1055,47 @@ func (p *FakeProvisioner) HasCName(app provision.App, cname string) bool {
 	return false
 }
 
-func (p *FakeProvisioner) Stop(app provision.App) error {
+func (p *FakeProvisioner) SetCertificate(app provision.App, cname, certificate, key string) error {
+	if err := p.getError("SetCertificate"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	if pApp.certificates == nil {
+		pApp.certificates = make(map[string]string)
+	}
+	pApp.certificates[cname] = certificate
+	p.apps[app.GetName()] = pApp
+	return nil
+}
+
+func (p *FakeProvisioner) UnsetCertificate(app provision.App, cname string) error {
+	if err := p.getError("UnsetCertificate"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	delete(pApp.certificates, cname)
+	p.apps[app.GetName()] = pApp
+	return nil
+}
+
+// Certificate returns the certificate set for the cname of the app.
+func (p *FakeProvisioner) Certificate(app provision.App, cname string) string {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.apps[app.GetName()].certificates[cname]
+}
+
+func (p *FakeProvisioner) Stop(app provision.App, process string) error {
 	p.mut.Lock()
 	defer p.mut.Unlock()
 	pApp, ok := p.apps[app.GetName()]
@@ -802,7 +1103,11 @@ func (p *FakeProvisioner) Stop(app provision.App) error {
 		return errNotProvisioned
 	}
 	pApp.stops++
+	pApp.lastProcess = process
 	for i, u := range pApp.units {
+		if process != "" && u.ProcessName != process {
+			continue
+		}
 		u.Status = provision.StatusStopped
 		pApp.units[i] = u
 	}
@@ -922,17 +1227,37 @@ func (p *ExtensibleFakeProvisioner) PlatformRemove(name string) error {
 }
 
 type provisionedApp struct {
-	units       []provision.Unit
-	app         provision.App
-	restarts    int
-	starts      int
-	stops       int
-	version     string
-	lastArchive string
-	lastFile    io.ReadCloser
-	cnames      []string
-	addr        string
-	unitLen     int
+	units        []provision.Unit
+	app          provision.App
+	restarts     int
+	starts       int
+	stops        int
+	version      string
+	lastArchive  string
+	lastFile     io.ReadCloser
+	lastImage    string
+	lastProcess  string
+	canary       fakeCanary
+	images       []string
+	image        string
+	cnames       []string
+	certificates map[string]string
+	addr         string
+	unitLen      int
+}
+
+type fakeCanary struct {
+	image  string
+	units  int
+	weight int
+}
+
+// newImage generates a new image for the app, making it the current image.
+func (a *provisionedApp) newImage(appName string) string {
+	image := fmt.Sprintf("tsuru/app-%s:v%d", appName, len(a.images)+1)
+	a.images = append(a.images, image)
+	a.image = image
+	return image
 }
 
 type provisionedPlatform struct {
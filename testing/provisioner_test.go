@@ -40,7 +40,7 @@ func (s *S) TestFakeAppReady(c *gocheck.C) {
 func (s *S) TestFakeAppRestart(c *gocheck.C) {
 	var buf bytes.Buffer
 	app := NewFakeApp("sou", "otm", 0)
-	err := app.Restart(&buf)
+	err := app.Restart(&buf, "")
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(buf.String(), gocheck.Equals, "Restarting app...")
 }
@@ -292,8 +292,8 @@ func (s *S) TestGetCmds(c *gocheck.C) {
 
 func (s *S) TestGetUnits(c *gocheck.C) {
 	list := []provision.Unit{
-		{"chain-lighting-0", "chain-lighting", "django", "10.10.10.10", provision.StatusStarted},
-		{"chain-lighting-1", "chain-lighting", "django", "10.10.10.15", provision.StatusStarted},
+		{"chain-lighting-0", "chain-lighting", "web", "django", "10.10.10.10", provision.StatusStarted},
+		{"chain-lighting-1", "chain-lighting", "web", "django", "10.10.10.15", provision.StatusStarted},
 	}
 	app := NewFakeApp("chain-lighting", "rush", 1)
 	p := NewFakeProvisioner()
@@ -309,14 +309,29 @@ func (s *S) TestVersion(c *gocheck.C) {
 	app := NewFakeApp("free", "matos", 1)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	err := p.GitDeploy(app, "master", &buf)
+	_, err := p.GitDeploy(app, "master", &buf)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(p.Version(app), gocheck.Equals, "master")
-	err = p.GitDeploy(app, "1.0", &buf)
+	_, err = p.GitDeploy(app, "1.0", &buf)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(p.Version(app), gocheck.Equals, "1.0")
 }
 
+func (s *S) TestImage(c *gocheck.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("free", "matos", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	image, err := p.GitDeploy(app, "master", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-free:v1")
+	c.Assert(p.Image(app), gocheck.Equals, "tsuru/app-free:v1")
+	image, err = p.ArchiveDeploy(app, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-free:v2")
+	c.Assert(p.Image(app), gocheck.Equals, "tsuru/app-free:v2")
+}
+
 func (s *S) TestPrepareOutput(c *gocheck.C) {
 	output := []byte("the body eletric")
 	p := NewFakeProvisioner()
@@ -339,7 +354,7 @@ func (s *S) TestGitDeploy(c *gocheck.C) {
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	err := p.GitDeploy(app, "1.0", &buf)
+	_, err := p.GitDeploy(app, "1.0", &buf)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(buf.String(), gocheck.Equals, "Git deploy called")
 	c.Assert(p.apps[app.GetName()].version, gocheck.Equals, "1.0")
@@ -349,7 +364,7 @@ func (s *S) TestGitDeployUnknownApp(c *gocheck.C) {
 	var buf bytes.Buffer
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
-	err := p.GitDeploy(app, "1.0", &buf)
+	_, err := p.GitDeploy(app, "1.0", &buf)
 	c.Assert(err, gocheck.Equals, errNotProvisioned)
 }
 
@@ -359,7 +374,7 @@ func (s *S) TestGitDeployWithPreparedFailure(c *gocheck.C) {
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
 	p.PrepareFailure("GitDeploy", err)
-	e := p.GitDeploy(app, "1.0", &buf)
+	_, e := p.GitDeploy(app, "1.0", &buf)
 	c.Assert(e, gocheck.NotNil)
 	c.Assert(e, gocheck.Equals, err)
 }
@@ -369,7 +384,7 @@ func (s *S) TestArchiveDeploy(c *gocheck.C) {
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	err := p.ArchiveDeploy(app, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
+	_, err := p.ArchiveDeploy(app, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(buf.String(), gocheck.Equals, "Archive deploy called")
 	c.Assert(p.apps[app.GetName()].lastArchive, gocheck.Equals, "https://s3.amazonaws.com/smt/archive.tar.gz")
@@ -379,7 +394,7 @@ func (s *S) TestArchiveDeployUnknownApp(c *gocheck.C) {
 	var buf bytes.Buffer
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
-	err := p.ArchiveDeploy(app, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
+	_, err := p.ArchiveDeploy(app, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
 	c.Assert(err, gocheck.Equals, errNotProvisioned)
 }
 
@@ -389,18 +404,91 @@ func (s *S) TestArchiveDeployWithPreparedFailure(c *gocheck.C) {
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
 	p.PrepareFailure("ArchiveDeploy", err)
-	e := p.ArchiveDeploy(app, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
+	_, e := p.ArchiveDeploy(app, "https://s3.amazonaws.com/smt/archive.tar.gz", &buf)
 	c.Assert(e, gocheck.NotNil)
 	c.Assert(e, gocheck.Equals, err)
 }
 
+func (s *S) TestImageDeploy(c *gocheck.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	image, err := p.ImageDeploy(app, "registry.example.com/soul:1.0", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-soul:v1")
+	c.Assert(buf.String(), gocheck.Equals, "Image deploy called")
+	c.Assert(p.apps[app.GetName()].lastImage, gocheck.Equals, "registry.example.com/soul:1.0")
+}
+
+func (s *S) TestImageDeployUnknownApp(c *gocheck.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	_, err := p.ImageDeploy(app, "registry.example.com/soul:1.0", &buf)
+	c.Assert(err, gocheck.Equals, errNotProvisioned)
+}
+
+func (s *S) TestStartCanary(c *gocheck.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	err := p.StartCanary(app, "tsuru/app-soul:v2", 2, 10, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "Start canary called")
+	image, units, weight := p.Canary(app)
+	c.Assert(image, gocheck.Equals, "tsuru/app-soul:v2")
+	c.Assert(units, gocheck.Equals, 2)
+	c.Assert(weight, gocheck.Equals, 10)
+	err = p.StartCanary(app, "tsuru/app-soul:v3", 1, 10, &buf)
+	c.Assert(err, gocheck.Equals, provision.ErrCanaryInProgress)
+	err = p.SetCanaryWeight(app, 50)
+	c.Assert(err, gocheck.IsNil)
+	_, _, weight = p.Canary(app)
+	c.Assert(weight, gocheck.Equals, 50)
+}
+
+func (s *S) TestPromoteCanary(c *gocheck.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	_, err := p.PromoteCanary(app, &buf)
+	c.Assert(err, gocheck.Equals, provision.ErrNoCanaryInProgress)
+	err = p.StartCanary(app, "tsuru/app-soul:v2", 1, 10, &buf)
+	c.Assert(err, gocheck.IsNil)
+	image, err := p.PromoteCanary(app, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(image, gocheck.Equals, "tsuru/app-soul:v2")
+	c.Assert(p.Image(app), gocheck.Equals, "tsuru/app-soul:v2")
+	image, _, _ = p.Canary(app)
+	c.Assert(image, gocheck.Equals, "")
+}
+
+func (s *S) TestAbortCanary(c *gocheck.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	err := p.AbortCanary(app, &buf)
+	c.Assert(err, gocheck.Equals, provision.ErrNoCanaryInProgress)
+	err = p.StartCanary(app, "tsuru/app-soul:v2", 1, 10, &buf)
+	c.Assert(err, gocheck.IsNil)
+	err = p.AbortCanary(app, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(p.Image(app), gocheck.Equals, "")
+	image, _, _ := p.Canary(app)
+	c.Assert(image, gocheck.Equals, "")
+}
+
 func (s *S) TestUploadDeploy(c *gocheck.C) {
 	var buf, input bytes.Buffer
 	file := ioutil.NopCloser(&input)
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	err := p.UploadDeploy(app, file, &buf)
+	_, err := p.UploadDeploy(app, file, &buf)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(buf.String(), gocheck.Equals, "Upload deploy called")
 	c.Assert(p.apps[app.GetName()].lastFile, gocheck.Equals, file)
@@ -410,7 +498,7 @@ func (s *S) TestUploadDeployUnknownApp(c *gocheck.C) {
 	var buf bytes.Buffer
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
-	err := p.UploadDeploy(app, nil, &buf)
+	_, err := p.UploadDeploy(app, nil, &buf)
 	c.Assert(err, gocheck.Equals, errNotProvisioned)
 }
 
@@ -420,11 +508,65 @@ func (s *S) TestUploadDeployWithPreparedFailure(c *gocheck.C) {
 	app := NewFakeApp("soul", "arch", 1)
 	p := NewFakeProvisioner()
 	p.PrepareFailure("UploadDeploy", err)
-	e := p.UploadDeploy(app, nil, &buf)
+	_, e := p.UploadDeploy(app, nil, &buf)
 	c.Assert(e, gocheck.NotNil)
 	c.Assert(e, gocheck.Equals, err)
 }
 
+func (s *S) TestRollback(c *gocheck.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	p.GitDeploy(app, "1.0", &buf)
+	p.GitDeploy(app, "2.0", &buf)
+	buf.Reset()
+	err := p.Rollback(app, "tsuru/app-soul:v1", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "Rollback called")
+	c.Assert(p.Image(app), gocheck.Equals, "tsuru/app-soul:v1")
+}
+
+func (s *S) TestRollbackUnknownImage(c *gocheck.C) {
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	err := p.Rollback(app, "tsuru/app-soul:v1", nil)
+	c.Assert(err, gocheck.ErrorMatches, "image not found")
+}
+
+func (s *S) TestRollbackUnknownApp(c *gocheck.C) {
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	err := p.Rollback(app, "tsuru/app-soul:v1", nil)
+	c.Assert(err, gocheck.Equals, errNotProvisioned)
+}
+
+func (s *S) TestCancelDeploy(c *gocheck.C) {
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	p.BlockDeploys(app)
+	errs := make(chan error)
+	go func() {
+		_, err := p.GitDeploy(app, "1.0", new(bytes.Buffer))
+		errs <- err
+	}()
+	err := p.CancelDeploy(app)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(<-errs, gocheck.Equals, provision.ErrDeployCanceled)
+	err = p.CancelDeploy(app)
+	c.Assert(err, gocheck.Equals, provision.ErrNoDeployInProgress)
+}
+
+func (s *S) TestCancelDeployNotBlocked(c *gocheck.C) {
+	app := NewFakeApp("soul", "arch", 1)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	err := p.CancelDeploy(app)
+	c.Assert(err, gocheck.Equals, provision.ErrNoDeployInProgress)
+}
+
 func (s *S) TestProvision(c *gocheck.C) {
 	app := NewFakeApp("kid-gloves", "rush", 1)
 	p := NewFakeProvisioner()
@@ -458,7 +600,7 @@ func (s *S) TestRestart(c *gocheck.C) {
 	app := NewFakeApp("kid-gloves", "rush", 1)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	err := p.Restart(app, nil)
+	err := p.Restart(app, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(p.Restarts(app), gocheck.Equals, 1)
 }
@@ -467,7 +609,7 @@ func (s *S) TestStart(c *gocheck.C) {
 	app := NewFakeApp("kid-gloves", "rush", 1)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	err := p.Start(app)
+	err := p.Start(app, "")
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(p.Starts(app), gocheck.Equals, 1)
 }
@@ -476,7 +618,7 @@ func (s *S) TestStop(c *gocheck.C) {
 	app := NewFakeApp("kid-gloves", "rush", 1)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	err := p.Stop(app)
+	err := p.Stop(app, "")
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(p.Stops(app), gocheck.Equals, 1)
 }
@@ -484,7 +626,7 @@ func (s *S) TestStop(c *gocheck.C) {
 func (s *S) TestRestartNotProvisioned(c *gocheck.C) {
 	app := NewFakeApp("kid-gloves", "rush", 1)
 	p := NewFakeProvisioner()
-	err := p.Restart(app, nil)
+	err := p.Restart(app, "", nil)
 	c.Assert(err, gocheck.Equals, errNotProvisioned)
 }
 
@@ -492,7 +634,7 @@ func (s *S) TestRestartWithPreparedFailure(c *gocheck.C) {
 	app := NewFakeApp("fairy-tale", "shaman", 1)
 	p := NewFakeProvisioner()
 	p.PrepareFailure("Restart", errors.New("Failed to restart."))
-	err := p.Restart(app, nil)
+	err := p.Restart(app, "", nil)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "Failed to restart.")
 }
@@ -526,7 +668,7 @@ func (s *S) TestAddUnits(c *gocheck.C) {
 	app := NewFakeApp("mystic-rhythms", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	units, err := p.AddUnits(app, 2, nil)
+	units, err := p.AddUnits(app, 2, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(p.GetUnits(app), gocheck.HasLen, 2)
 	c.Assert(units, gocheck.HasLen, 2)
@@ -537,7 +679,7 @@ func (s *S) TestAddUnitsCopiesTheUnitsSlice(c *gocheck.C) {
 	p := NewFakeProvisioner()
 	p.Provision(app)
 	defer p.Destroy(app)
-	units, err := p.AddUnits(app, 3, nil)
+	units, err := p.AddUnits(app, 3, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	units[0].Name = "something-else"
 	c.Assert(units[0].Name, gocheck.Not(gocheck.Equals), p.GetUnits(app)[1].Name)
@@ -545,7 +687,7 @@ func (s *S) TestAddUnitsCopiesTheUnitsSlice(c *gocheck.C) {
 
 func (s *S) TestAddZeroUnits(c *gocheck.C) {
 	p := NewFakeProvisioner()
-	units, err := p.AddUnits(nil, 0, nil)
+	units, err := p.AddUnits(nil, 0, "", nil)
 	c.Assert(units, gocheck.IsNil)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "Cannot add 0 units.")
@@ -554,7 +696,7 @@ func (s *S) TestAddZeroUnits(c *gocheck.C) {
 func (s *S) TestAddUnitsUnprovisionedApp(c *gocheck.C) {
 	app := NewFakeApp("mystic-rhythms", "rush", 0)
 	p := NewFakeProvisioner()
-	units, err := p.AddUnits(app, 1, nil)
+	units, err := p.AddUnits(app, 1, "", nil)
 	c.Assert(units, gocheck.IsNil)
 	c.Assert(err, gocheck.Equals, errNotProvisioned)
 }
@@ -562,7 +704,7 @@ func (s *S) TestAddUnitsUnprovisionedApp(c *gocheck.C) {
 func (s *S) TestAddUnitsFailure(c *gocheck.C) {
 	p := NewFakeProvisioner()
 	p.PrepareFailure("AddUnits", errors.New("Cannot add more units."))
-	units, err := p.AddUnits(nil, 10, nil)
+	units, err := p.AddUnits(nil, 10, "", nil)
 	c.Assert(units, gocheck.IsNil)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "Cannot add more units.")
@@ -572,9 +714,9 @@ func (s *S) TestRemoveUnits(c *gocheck.C) {
 	app := NewFakeApp("hemispheres", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	_, err := p.AddUnits(app, 5, nil)
+	_, err := p.AddUnits(app, 5, "", nil)
 	c.Assert(err, gocheck.IsNil)
-	err = p.RemoveUnits(app, 3)
+	err = p.RemoveUnits(app, 3, "")
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(p.GetUnits(app), gocheck.HasLen, 2)
 	c.Assert(p.GetUnits(app)[0].Name, gocheck.Equals, "hemispheres-3")
@@ -584,9 +726,9 @@ func (s *S) TestRemoveUnitsTooManyUnits(c *gocheck.C) {
 	app := NewFakeApp("hemispheres", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	_, err := p.AddUnits(app, 1, nil)
+	_, err := p.AddUnits(app, 1, "", nil)
 	c.Assert(err, gocheck.IsNil)
-	err = p.RemoveUnits(app, 3)
+	err = p.RemoveUnits(app, 3, "")
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "too many units to remove")
 }
@@ -594,14 +736,14 @@ func (s *S) TestRemoveUnitsTooManyUnits(c *gocheck.C) {
 func (s *S) TestRemoveUnitsUnprovisionedApp(c *gocheck.C) {
 	app := NewFakeApp("tears", "bruce", 0)
 	p := NewFakeProvisioner()
-	err := p.RemoveUnits(app, 1)
+	err := p.RemoveUnits(app, 1, "")
 	c.Assert(err, gocheck.Equals, errNotProvisioned)
 }
 
 func (s *S) TestRemoveUnitsFailure(c *gocheck.C) {
 	p := NewFakeProvisioner()
 	p.PrepareFailure("RemoveUnits", errors.New("This program has performed an illegal operation."))
-	err := p.RemoveUnits(nil, 0)
+	err := p.RemoveUnits(nil, 0, "")
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(err.Error(), gocheck.Equals, "This program has performed an illegal operation.")
 }
@@ -610,7 +752,7 @@ func (s *S) TestRemoveUnit(c *gocheck.C) {
 	app := NewFakeApp("hemispheres", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	units, err := p.AddUnits(app, 2, nil)
+	units, err := p.AddUnits(app, 2, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	err = p.RemoveUnit(units[0])
 	c.Assert(err, gocheck.IsNil)
@@ -622,7 +764,7 @@ func (s *S) TestRemoveUnitNotFound(c *gocheck.C) {
 	app := NewFakeApp("hemispheres", "rush", 0)
 	p := NewFakeProvisioner()
 	p.Provision(app)
-	units, err := p.AddUnits(app, 2, nil)
+	units, err := p.AddUnits(app, 2, "", nil)
 	c.Assert(err, gocheck.IsNil)
 	err = p.RemoveUnit(provision.Unit{Name: units[0].Name + "wat", AppName: "hemispheres"})
 	c.Assert(err, gocheck.NotNil)
@@ -771,6 +913,25 @@ func (s *S) TestHasCName(c *gocheck.C) {
 	c.Assert(p.HasCName(app, "cname.com"), gocheck.Equals, false)
 }
 
+func (s *S) TestSetCertificate(c *gocheck.C) {
+	app := NewFakeApp("jean", "mj", 0)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	err := p.SetCertificate(app, "cname.com", "cert", "key")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(p.Certificate(app, "cname.com"), gocheck.Equals, "cert")
+	err = p.UnsetCertificate(app, "cname.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(p.Certificate(app, "cname.com"), gocheck.Equals, "")
+}
+
+func (s *S) TestSetCertificateUnprovisionedApp(c *gocheck.C) {
+	app := NewFakeApp("jean", "mj", 0)
+	p := NewFakeProvisioner()
+	err := p.SetCertificate(app, "cname.com", "cert", "key")
+	c.Assert(err, gocheck.Equals, errNotProvisioned)
+}
+
 func (s *S) TestExecuteCommandOnce(c *gocheck.C) {
 	var buf bytes.Buffer
 	output := []byte("myoutput!")